@@ -0,0 +1,114 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Slab allocation.
+//
+// Lists are built one cons at a time, and allocating every
+// cons separately makes list-heavy code spend most of its
+// time in the Go allocator and the GC. Env carves conses
+// out of slabs instead: a slab is a single Go allocation
+// that holds many conses.
+//
+// Slabs are ordinary Go memory, so the GC keeps them correct:
+// a cons points into its slab and keeps the whole slab
+// alive. The price is retention; a slab is freed only after
+// all of its conses become unreachable, and unreachable
+// conses in a live slab keep their own references alive.
+// Slabs are small to keep this bounded.
+//
+// Env is not goroutine safe, so every Env has its own slabs;
+// MasterEnv collects the counters of all its Envs.
+
+// consSlabLen is the slab size, in conses.
+const consSlabLen = 128
+
+// AllocStats holds allocation counters.
+type AllocStats struct {
+	// Conses is the number of allocated conses.
+	Conses uint64
+
+	// Slabs is the number of allocated slabs;
+	// SlabBytes is their total size.
+	Slabs     uint64
+	SlabBytes uint64
+}
+
+// allocator holds Env slabs.
+//
+// conses is the current slab and nextCons points to its
+// first unused element. The index is advanced instead of
+// the slice, because slice updates need GC write barriers.
+type allocator struct {
+	conses   []lisp.Cons
+	nextCons int
+
+	stats AllocStats
+
+	// flushed is the part of stats that is
+	// already added to MasterEnv counters.
+	flushed AllocStats
+}
+
+// AllocStats returns allocation counters of env.
+func (env *Env) AllocStats() AllocStats {
+	return env.alloc.stats
+}
+
+// AllocStats returns allocation counters of all Envs
+// that are created by env.
+// Envs report their counters when they allocate slabs,
+// so objects from the current Env slabs may be missing.
+func (env *MasterEnv) AllocStats() AllocStats {
+	return AllocStats{
+		Conses:    atomic.LoadUint64(&env.allocStats.Conses),
+		Slabs:     atomic.LoadUint64(&env.allocStats.Slabs),
+		SlabBytes: atomic.LoadUint64(&env.allocStats.SlabBytes),
+	}
+}
+
+// NewCons is like lisp.NewCons, but takes
+// the cons from env slab.
+func (env *Env) NewCons(car, cdr lisp.Object) lisp.Object {
+	a := &env.alloc
+	if a.nextCons == len(a.conses) {
+		a.conses = make([]lisp.Cons, consSlabLen)
+		a.nextCons = 0
+		env.addSlab(unsafe.Sizeof(lisp.Cons{}) * consSlabLen)
+	}
+	c := &a.conses[a.nextCons]
+	a.nextCons++
+	a.stats.Conses++
+	c.Car = car
+	c.Cdr = cdr
+	return c.Object()
+}
+
+// List is like lisp.List, but takes
+// the conses from env slab.
+func (env *Env) List(vals ...lisp.Object) lisp.Object {
+	list := lisp.Nil
+	for i := len(vals) - 1; i >= 0; i-- {
+		list = env.NewCons(vals[i], list)
+	}
+	return list
+}
+
+// addSlab counts a new slab of the given size
+// and reports env counters to MasterEnv.
+func (env *Env) addSlab(size uintptr) {
+	stats := &env.alloc.stats
+	stats.Slabs++
+	stats.SlabBytes += uint64(size)
+
+	flushed := &env.alloc.flushed
+	master := &env.MasterEnv.allocStats
+	atomic.AddUint64(&master.Conses, stats.Conses-flushed.Conses)
+	atomic.AddUint64(&master.Slabs, stats.Slabs-flushed.Slabs)
+	atomic.AddUint64(&master.SlabBytes, stats.SlabBytes-flushed.SlabBytes)
+	*flushed = *stats
+}
@@ -0,0 +1,125 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"runtime"
+	"testing"
+)
+
+func TestAllocStats(t *testing.T) {
+	env, ob := newLispEnv()
+	fn, err := compileString(env, ob, `
+		(lambda (n)
+		  (let (l)
+		    (while (> n 0)
+		      (setq l (cons n l) n (1- n)))
+		    (list l (vector 1 2) (vector 3 4 5))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := env.AllocStats()
+	if _, err := env.Exec(fn, lisp.NewInt(300)); err != nil {
+		t.Fatal(err)
+	}
+	have := env.AllocStats()
+	if conses := have.Conses - before.Conses; conses != 303 {
+		t.Errorf("have %d conses, want 303", conses)
+	}
+	// Vectors are not allocated from slabs.
+	if slabs := have.Slabs - before.Slabs; slabs != 3 {
+		t.Errorf("have %d slabs, want 3", slabs)
+	}
+
+	// MasterEnv gets the counters that were
+	// reported before the last slab allocation.
+	master := env.MasterEnv.AllocStats()
+	if master.Slabs != have.Slabs || master.SlabBytes != have.SlabBytes {
+		t.Errorf("master slabs:\nhave: %+v\nenv:  %+v", master, have)
+	}
+	if master.Conses > have.Conses || master.Conses+consSlabLen < have.Conses {
+		t.Errorf("master conses: have %d, env has %d", master.Conses, have.Conses)
+	}
+}
+
+func TestAllocSlabs(t *testing.T) {
+	env := NewMasterEnv().NewEnv(0, 0)
+
+	// Conses from slabs survive collections.
+	const n = 10 * consSlabLen
+	list := lisp.Nil
+	for i := 0; i < n; i++ {
+		list = env.NewCons(lisp.NewString([]byte("x")), list)
+		list = env.NewCons(lisp.NewInt(int64(i)), list)
+	}
+	runtime.GC()
+	runtime.GC()
+	for i := n - 1; i >= 0; i-- {
+		cons := list.Cons()
+		if cons.Car.Int() != int64(i) {
+			t.Fatalf("cons %d: have %s", i, lisp.ObjectString(cons.Car))
+		}
+		cons = cons.Cdr.Cons()
+		if s := cons.Car.String(); string(s.Chars) != "x" {
+			t.Fatalf("string %d: have %q", i, s.Chars)
+		}
+		list = cons.Cdr
+	}
+	if have := env.AllocStats().Slabs; have != 2*n/consSlabLen {
+		t.Errorf("have %d slabs, want %d", have, 2*n/consSlabLen)
+	}
+}
+
+// benchmarkNewCons builds lists of 1000 elements.
+func benchmarkNewCons(b *testing.B, newCons func(car, cdr lisp.Object) lisp.Object) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		list := lisp.Nil
+		for j := 0; j < 1000; j++ {
+			list = newCons(lisp.NewInt(int64(j)), list)
+		}
+	}
+}
+
+func BenchmarkNewCons(b *testing.B) {
+	env := NewMasterEnv().NewEnv(0, 0)
+	b.Run("heap", func(b *testing.B) {
+		benchmarkNewCons(b, lisp.NewCons)
+	})
+	b.Run("slab", func(b *testing.B) {
+		benchmarkNewCons(b, env.NewCons)
+	})
+}
+
+// benchmarkBuild runs the compiled function src with
+// argument 1000 and reports GC cycles per run along
+// with the allocations.
+func benchmarkBuild(b *testing.B, src string) {
+	env, ob := newLispEnv()
+	fn, err := compileString(env, ob, src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	arg := lisp.NewInt(1000)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := env.Exec(fn, arg); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "gc/op")
+}
+
+func BenchmarkBuildList(b *testing.B) {
+	benchmarkBuild(b, `
+		(lambda (n)
+		  (let (l)
+		    (while (> n 0)
+		      (setq l (cons n l) n (1- n)))
+		    l))`)
+}
@@ -0,0 +1,230 @@
+package bcode
+
+import (
+	"crypto/sha256"
+	"emacs/lisp"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Ahead-of-time compiled functions.
+//
+// GenerateGo translates compiled functions into Go source.
+// The generated package lists them as AOTFunc values;
+// after RegisterAOT, DefineCompiledFunc binds the symbol
+// to the Go version instead of the byte code, provided that
+// the compiled function is the one that Go code was generated
+// from. Functions are matched by name and Fingerprint, so
+// a stale generated package silently falls back to byte code.
+//
+// The Go version gets the constant vector of the function that
+// is being defined: constants are interned into the obarray of
+// the loaded file and nested compiled functions keep running
+// as byte code.
+//
+// Generated code calls the exported functions below; they
+// implement opcodes like the interpreter does and check
+// for integers first.
+
+// AOTFunc is a Go version of a compiled Lisp function,
+// see GenerateGo.
+type AOTFunc struct {
+	// Name is the function symbol name.
+	Name string
+
+	// Fingerprint identifies the compiled function
+	// that Go code was generated from.
+	Fingerprint string
+
+	// Nargs is the number of function arguments.
+	Nargs int
+
+	// New returns Go function that uses consts
+	// as the constant vector.
+	New func(consts []lisp.Object) GoFuncCtx
+}
+
+// RegisterAOT makes DefineCompiledFunc (and therefore `defalias`,
+// `fset` and Load) use funcs instead of the compiled functions
+// with the same names and fingerprints.
+// Functions that are already defined are not affected.
+func (env *MasterEnv) RegisterAOT(funcs ...AOTFunc) {
+	if env.aotFuncs == nil {
+		env.aotFuncs = make(map[string][]AOTFunc)
+	}
+	for _, f := range funcs {
+		env.aotFuncs[f.Name] = append(env.aotFuncs[f.Name], f)
+	}
+}
+
+// defineAOTFunc binds fsym to the registered Go version
+// of fn, if there is one.
+// Returns false if fn has no Go version.
+func (env *MasterEnv) defineAOTFunc(fsym lisp.Object, fn *Func) bool {
+	candidates := env.aotFuncs[fsym.Symbol().Name]
+	if len(candidates) == 0 {
+		return false
+	}
+	fingerprint := Fingerprint(fn)
+	for _, f := range candidates {
+		if f.Fingerprint != fingerprint {
+			continue
+		}
+		env.DefineGoFuncCtx(fsym, f.New(fn.consts))
+		if env.goArity == nil {
+			env.goArity = make(map[int]Arity)
+		}
+		env.goArity[env.funcRef(fsym.Symbol()).goID] = Arity{Min: f.Nargs, Max: f.Nargs}
+		return true
+	}
+	return false
+}
+
+// Fingerprint returns a hash of fn code, its number of
+// arguments and printed representation of its constants.
+// Nested compiled functions are hashed recursively.
+func Fingerprint(fn *Func) string {
+	h := sha256.New()
+	writeFingerprint(h, fn)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// writeFingerprint writes fn data that Fingerprint hashes.
+func writeFingerprint(w io.Writer, fn *Func) {
+	fmt.Fprintf(w, "%d", fn.nargs)
+	if fn.optArgs != 0 || fn.rest || fn.dynamic {
+		fmt.Fprintf(w, "&%d,%t,%t", fn.optArgs, fn.rest, fn.dynamic)
+	}
+	fmt.Fprintf(w, " %q [", fn.code)
+	for i := range fn.consts {
+		if fn.consts[i].Type == lisp.TypeFunc {
+			io.WriteString(w, " #[")
+			writeFingerprint(w, objectFunc(&fn.consts[i]))
+			io.WriteString(w, "]")
+			continue
+		}
+		fmt.Fprintf(w, " %s", lisp.Prin1String(fn.consts[i]))
+	}
+	io.WriteString(w, "]")
+}
+
+// Car implements OpCar.
+func Car(x lisp.Object) (lisp.Object, error) {
+	return car(x)
+}
+
+// Cdr implements OpCdr.
+func Cdr(x lisp.Object) (lisp.Object, error) {
+	return cdr(x)
+}
+
+// Add1 implements OpAdd1.
+func Add1(x lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt {
+		return lisp.NewInt(x.Int() + 1), nil
+	}
+	return addFloat(x, 1)
+}
+
+// Sub1 implements OpSub1.
+func Sub1(x lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt {
+		return lisp.NewInt(x.Int() - 1), nil
+	}
+	return addFloat(x, -1)
+}
+
+// addFloat adds d to float x.
+func addFloat(x lisp.Object, d float64) (lisp.Object, error) {
+	if x.Type != lisp.TypeFloat {
+		return lisp.Nil, wrongTypeArgument(SymNumberOrMarkerp, x)
+	}
+	return lisp.NewFloat(x.Float() + d), nil
+}
+
+// Negate implements OpNegate.
+func Negate(x lisp.Object) (lisp.Object, error) {
+	switch x.Type {
+	case lisp.TypeInt:
+		return lisp.NewInt(-x.Int()), nil
+	case lisp.TypeFloat:
+		return lisp.NewFloat(-x.Float()), nil
+	}
+	return lisp.Nil, wrongTypeArgument(SymNumberOrMarkerp, x)
+}
+
+// Plus implements OpPlus.
+func Plus(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.NewInt(x.Int() + y.Int()), nil
+	}
+	return arith2(opAdd, x, y)
+}
+
+// Diff implements OpDiff.
+func Diff(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.NewInt(x.Int() - y.Int()), nil
+	}
+	return arith2(opSub, x, y)
+}
+
+// Mult implements OpMult.
+func Mult(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.NewInt(x.Int() * y.Int()), nil
+	}
+	return arith2(opMul, x, y)
+}
+
+// arith2 is arith for two arguments.
+func arith2(op arithOp, x, y lisp.Object) (lisp.Object, error) {
+	return arith(op, []lisp.Object{x, y})
+}
+
+// Eqlsign implements OpEqlsign.
+func Eqlsign(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.Bool(x.Int() == y.Int()), nil
+	}
+	return compare2(OpEqlsign, x, y)
+}
+
+// Gtr implements OpGtr.
+func Gtr(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.Bool(x.Int() > y.Int()), nil
+	}
+	return compare2(OpGtr, x, y)
+}
+
+// Lss implements OpLss.
+func Lss(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.Bool(x.Int() < y.Int()), nil
+	}
+	return compare2(OpLss, x, y)
+}
+
+// Leq implements OpLeq.
+func Leq(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.Bool(x.Int() <= y.Int()), nil
+	}
+	return compare2(OpLeq, x, y)
+}
+
+// Geq implements OpGeq.
+func Geq(x, y lisp.Object) (lisp.Object, error) {
+	if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+		return lisp.Bool(x.Int() >= y.Int()), nil
+	}
+	return compare2(OpGeq, x, y)
+}
+
+// compare2 compares two numbers with comparison opcode op.
+func compare2(op byte, x, y lisp.Object) (lisp.Object, error) {
+	ok, err := compare(x, []lisp.Object{y}, compareOps[op])
+	return lisp.Bool(ok), err
+}
@@ -0,0 +1,162 @@
+package bcode_test
+
+import (
+	"bytes"
+	"emacs/bcode"
+	"emacs/bcode/internal/aotcorpus"
+	"emacs/lisp"
+	"emacs/reader"
+	"io/ioutil"
+	"testing"
+)
+
+// newCorpusEnv returns Env with aotcorpus functions defined.
+// If aot is true, their Go versions are registered first.
+func newCorpusEnv(tb testing.TB, aot bool) (*bcode.Env, *lisp.Obarray, []bcode.NamedFunc) {
+	ob := lisp.NewObarray()
+	bcode.AddSymbols(ob)
+	master := bcode.NewMasterEnv()
+	master.DefineSubrs(ob)
+	if aot {
+		aotcorpus.Register(master)
+	}
+	env := master.NewEnv(0, 0)
+	funcs, err := aotcorpus.Define(env, ob)
+	if err != nil {
+		tb.Fatal(bcode.ErrorMessage(err))
+	}
+	return env, ob, funcs
+}
+
+func TestAOTGenerated(t *testing.T) {
+	_, _, funcs := newCorpusEnv(t, false)
+	var buf bytes.Buffer
+	if _, err := bcode.GenerateGo(&buf, "aotcorpus", funcs); err != nil {
+		t.Fatal(err)
+	}
+	have, err := ioutil.ReadFile("internal/aotcorpus/funcs.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(have, buf.Bytes()) {
+		t.Errorf("internal/aotcorpus/funcs.go is out of date; run go generate")
+	}
+}
+
+func TestAOT(t *testing.T) {
+	tests := []struct {
+		form string
+		want string
+	}{
+		{"(aot-length (aot-range 50))", "50"},
+		{"(aot-reverse '(1 2 3 4))", "(4 3 2 1)"},
+		{"(aot-memq 4 (aot-range 6))", "(4 5 6)"},
+		{"(aot-memq 'x '(a b))", "nil"},
+		{"(aot-assq 'c '((a . 1) (b . 2) (c . 3)))", "(c . 3)"},
+		{"(aot-plist-get '(:a 1 :b 2) :b)", "2"},
+		{"(aot-sum (aot-range 50))", "1275"},
+		{"(aot-sum '(1 2.5))", "3.5"},
+		{"(aot-max '(3 9 2))", "9"},
+		{"(aot-fib 15)", "610"},
+		{"(aot-arith 7 3)", "(10 4 21 -7 nil nil nil t)"},
+		{"(aot-arith 2 2.0)", "(4.0 0.0 4.0 -2 t nil t t)"},
+		{"(aot-first '(nil) '(2))", "2"},
+		{"(aot-first '(1) nil)", "1"},
+		{"(aot-first nil nil)", "none"},
+		{"(list (aot-nest 3) aot-level)", "(4 0)"},
+		{"(list (aot-count '(a b c)) aot-level)", "(3 3)"},
+		{"(aot-map (lambda (x) (* x x)) '(1 2 3))", "(9 4 1)"},
+		{"(aot-map 'aot-reverse '((1 2) (3 4)))", "((4 3) (2 1))"},
+
+		// Errors.
+		{"(aot-sum '(1 a))", "Wrong type argument: number-or-marker-p, a"},
+		{"(aot-length 1)", "Wrong type argument: listp, 1"},
+		{"(aot-arith 1 'x)", "Wrong type argument: number-or-marker-p, x"},
+		{"(aot-map 'undefined-f '(1))", "Symbol’s function definition is void: undefined-f"},
+	}
+
+	eval := func(env *bcode.Env, ob *lisp.Obarray, src string) string {
+		form, err := reader.ReadString(src, ob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		val, err := env.Eval(form, lisp.T)
+		if err != nil {
+			return bcode.ErrorMessage(err)
+		}
+		return lisp.Prin1String(val)
+	}
+	for _, aot := range []bool{false, true} {
+		env, ob, funcs := newCorpusEnv(t, aot)
+		for _, f := range funcs {
+			def := env.SymbolFunction(ob.Intern(f.Name))
+			if isGo := def.Type == lisp.TypeSymbol; isGo != aot {
+				t.Errorf("%s (aot=%v): have definition %s", f.Name, aot, lisp.Prin1String(def))
+			}
+		}
+		for _, test := range tests {
+			if have := eval(env, ob, test.form); have != test.want {
+				t.Errorf("%s (aot=%v):\nhave: %s\nwant: %s", test.form, aot, have, test.want)
+			}
+		}
+	}
+
+	// Go versions check the number of arguments.
+	env, ob, _ := newCorpusEnv(t, true)
+	want := "Wrong number of arguments: (1 . 1), 2"
+	if have := eval(env, ob, "(aot-fib 1 2)"); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Redefined functions do not match their Go versions.
+	eval(env, ob, "(defun aot-fib (n) n)")
+	eval(env, ob, "(byte-compile 'aot-fib)")
+	if def := env.SymbolFunction(ob.Intern("aot-fib")); def.Type != lisp.TypeFunc {
+		t.Errorf("redefined aot-fib: have definition %s", lisp.Prin1String(def))
+	}
+	if have := eval(env, ob, "(aot-fib 15)"); have != "15" {
+		t.Errorf("redefined aot-fib: have %s", have)
+	}
+}
+
+func BenchmarkAOT(b *testing.B) {
+	calls := []struct {
+		name string
+		args string
+	}{
+		{"aot-length", "((aot-range 50))"},
+		{"aot-assq", "('e '((a . 1) (b . 2) (c . 3) (d . 4) (e . 5)))"},
+		{"aot-sum", "((aot-range 50))"},
+		{"aot-range", "(50)"},
+		{"aot-fib", "(15)"},
+	}
+	for _, aot := range []bool{false, true} {
+		mode := "bytecode"
+		if aot {
+			mode = "go"
+		}
+		env, ob, _ := newCorpusEnv(b, aot)
+		for _, c := range calls {
+			form, err := reader.ReadString("(list "+c.args[1:], ob)
+			if err != nil {
+				b.Fatal(err)
+			}
+			args, err := env.Eval(form, lisp.T)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var vals []lisp.Object
+			for ; !lisp.Null(&args); args = args.Cons().Cdr {
+				vals = append(vals, args.Cons().Car)
+			}
+			fsym := ob.Intern(c.name)
+			b.Run(c.name+"/"+mode, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := env.Funcall(fsym, vals...); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"emacs/reader"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Byte code assembler.
+//
+// Input uses the same mnemonics as Disassemble output,
+// one instruction per line:
+//
+//	; (lambda (x) (if x 'a 'b))
+//	    stack-ref 0
+//	    goto-if-nil else
+//	    constant a
+//	    return
+//	else:
+//	    constant "b"
+//	    return
+//
+// Labels are symbols or numbers followed by colon.
+// Jump instructions take a label as an operand.
+// Constant operands are read by the Lisp reader, so any
+// readable object can be used; equal constants share the
+// constant vector slot.
+//
+// Instruction form is selected automatically:
+// "stack-ref 2" becomes OpStackRef2, "stack-ref 10" becomes OpStackRefB
+// and "constant" with index above 63 becomes OpConstantW.
+//
+// Leading pc numbers and "pc:label" prefixes that Disassemble
+// prints are accepted and pc values are ignored, so disassembler
+// output can be assembled back.
+
+// AsmError is an assembly error.
+type AsmError struct {
+	// Line is 1-based line number of the failed instruction.
+	Line int
+
+	Msg string
+}
+
+func (e *AsmError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Msg
+}
+
+// asmOp is an opcode that assembler can emit.
+type asmOp struct {
+	ext  bool
+	op   byte
+	info *opInfo
+}
+
+// asmOps maps mnemonics to all their forms.
+// Relative jumps are excluded: only absolute jumps are emitted.
+var asmOps = func() map[string][]asmOp {
+	ops := make(map[string][]asmOp)
+	add := func(ext bool, infos *[256]opInfo) {
+		for i := range infos {
+			info := &infos[i]
+			if info.name == "" || info.kind == argRelJump {
+				continue
+			}
+			ops[info.name] = append(ops[info.name], asmOp{ext: ext, op: byte(i), info: info})
+		}
+	}
+	add(false, &opInfos)
+	add(true, &extOpInfos)
+	ops[discardPreserveTOSInfo.name] = []asmOp{{op: OpDiscardB, info: &discardPreserveTOSInfo}}
+	return ops
+}()
+
+// asmInstr is an instruction that waits for jump targets resolution.
+type asmInstr struct {
+	line  int
+	pc    int
+	label string
+}
+
+// Assemble translates assembly text into a verified compiled
+// function of nargs arguments.
+// Symbols are interned into ob.
+//
+// Code that does not pass Verify is reported by *VerifyError.
+func Assemble(src []byte, ob *lisp.Obarray, nargs int) (*Func, error) {
+	fn, err := assemble(src, ob)
+	if err != nil {
+		return nil, err
+	}
+	return NewFunc(fn.code, fn.consts, nargs)
+}
+
+// assemble is Assemble that does not verify the result.
+// The returned function must not be executed.
+func assemble(src []byte, ob *lisp.Obarray) (*Func, error) {
+	a := assembler{
+		r:      reader.New(src, ob),
+		labels: make(map[string]int),
+	}
+	if err := a.assemble(); err != nil {
+		return nil, err
+	}
+	fn := &Func{code: a.code, consts: a.consts}
+	fn.translate()
+	return fn, nil
+}
+
+// assembler is an Assemble implementation helper.
+type assembler struct {
+	r *reader.Reader
+
+	code   []byte
+	consts []lisp.Object
+
+	// labels maps label names to their pc.
+	labels map[string]int
+
+	// jumps are instructions with unresolved targets.
+	jumps []asmInstr
+}
+
+func (a *assembler) assemble() error {
+	// instrLine is a line of the last instruction.
+	instrLine := 0
+	for {
+		line := a.r.Line()
+		o, err := a.r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return a.readError(line, err)
+		}
+		if line == instrLine {
+			return a.errorf(line, "unexpected %s", lisp.ObjectString(o))
+		}
+
+		switch o.Type {
+		case lisp.TypeInt:
+			// Disassembler pc column.
+			if a.r.Line() != line {
+				return a.errorf(line, "unexpected %s", lisp.ObjectString(o))
+			}
+			continue
+		case lisp.TypeSymbol:
+		default:
+			return a.errorf(line, "expected mnemonic, found %s", lisp.ObjectString(o))
+		}
+
+		name := o.Symbol().Name
+		if i := strings.IndexByte(name, ':'); i != -1 {
+			label := name[i+1:]
+			if i == len(name)-1 {
+				label = name[:i]
+			} else if _, err := strconv.Atoi(name[:i]); err != nil {
+				return a.errorf(line, "bad label %q", name)
+			}
+			if label == "" {
+				return a.errorf(line, "bad label %q", name)
+			}
+			if _, ok := a.labels[label]; ok {
+				return a.errorf(line, "label %q redefined", label)
+			}
+			a.labels[label] = len(a.code)
+			continue
+		}
+
+		if err := a.assembleInstr(line, name); err != nil {
+			return err
+		}
+		instrLine = line
+	}
+
+	for _, j := range a.jumps {
+		target, ok := a.labels[j.label]
+		if !ok {
+			return a.errorf(j.line, "undefined label %q", j.label)
+		}
+		if target > 0xFFFF {
+			return a.errorf(j.line, "jump target %d is out of range", target)
+		}
+		a.code[j.pc] = byte(target)
+		a.code[j.pc+1] = byte(target >> 8)
+	}
+	return nil
+}
+
+// assembleInstr encodes mnemonic name and its operand.
+func (a *assembler) assembleInstr(line int, name string) error {
+	forms, ok := asmOps[name]
+	if !ok {
+		return a.errorf(line, "unknown mnemonic %q", name)
+	}
+	kind := forms[0].info.kind
+	if kind == argNone {
+		a.emit(forms[0], operandNone, 0)
+		return nil
+	}
+
+	if a.r.Line() != line {
+		return a.errorf(line, "%s: missing operand", name)
+	}
+	arg, err := a.r.Read()
+	if err == io.EOF {
+		return a.errorf(line, "%s: missing operand", name)
+	}
+	if err != nil {
+		return a.readError(line, err)
+	}
+
+	n := 0
+	switch kind {
+	case argNumber:
+		if arg.Type != lisp.TypeInt || arg.Int() < 0 {
+			return a.errorf(line, "%s: bad operand %s", name, lisp.ObjectString(arg))
+		}
+		n = int(arg.Int())
+		switch name {
+		case "discardN":
+			if n&discardPreserveTOS != 0 {
+				return a.errorf(line, "%s: operand %d is out of range", name, n)
+			}
+		case "discardN-preserve-tos":
+			if n >= discardPreserveTOS {
+				return a.errorf(line, "%s: operand %d is out of range", name, n)
+			}
+			n |= discardPreserveTOS
+		}
+		if n == 0 && name == "stack-ref" {
+			// Like in Emacs, there is no stack-ref 0.
+			a.emit(asmOps["dup"][0], operandNone, 0)
+			return nil
+		}
+	case argConst:
+		n = a.constIndex(arg)
+	case argJump:
+		if arg.Type != lisp.TypeInt && arg.Type != lisp.TypeSymbol {
+			return a.errorf(line, "%s: bad label %s", name, lisp.ObjectString(arg))
+		}
+		a.emit(forms[0], operandW, 0)
+		a.jumps = append(a.jumps, asmInstr{
+			line:  line,
+			pc:    len(a.code) - 2,
+			label: lisp.ObjectString(arg),
+		})
+		return nil
+	}
+
+	code, ok := appendInstr(a.code, forms, n)
+	if !ok {
+		return a.errorf(line, "%s: operand %d is out of range", name, n)
+	}
+	a.code = code
+	return nil
+}
+
+// emit appends instruction to the code.
+func (a *assembler) emit(form asmOp, enc uint8, n int) {
+	a.code = appendForm(a.code, form, enc, n)
+}
+
+// appendInstr appends the shortest of instruction forms
+// that can encode operand n to code.
+// Returns false if n is out of range for all forms.
+func appendInstr(code []byte, forms []asmOp, n int) ([]byte, bool) {
+	for _, enc := range [...]uint8{operandNone, operandImplicit, operandB, operandW} {
+		for _, form := range forms {
+			if form.info.enc != enc {
+				continue
+			}
+			if enc == operandImplicit && form.info.n != n ||
+				enc == operandB && n > 0xFF ||
+				enc == operandW && n > 0xFFFF {
+				continue
+			}
+			return appendForm(code, form, enc, n), true
+		}
+	}
+	return code, false
+}
+
+// appendForm appends instruction form with operand n to code.
+func appendForm(code []byte, form asmOp, enc uint8, n int) []byte {
+	if form.ext {
+		code = append(code, OpExt)
+	}
+	code = append(code, form.op)
+	switch enc {
+	case operandB:
+		code = append(code, byte(n))
+	case operandW:
+		code = append(code, byte(n), byte(n>>8))
+	}
+	return code
+}
+
+// constIndex returns x index inside constant vector,
+// adding it to the vector if necessary.
+func (a *assembler) constIndex(x lisp.Object) int {
+	for i := range a.consts {
+		c := &a.consts[i]
+		if lisp.Eq(c, &x) {
+			return i
+		}
+		if c.Type == lisp.TypeString && x.Type == lisp.TypeString &&
+			string(c.String().Chars) == string(x.String().Chars) {
+			return i
+		}
+	}
+	a.consts = append(a.consts, x)
+	return len(a.consts) - 1
+}
+
+// readError converts reader error to AsmError.
+// Syntax errors are returned as is, they already carry line number.
+func (a *assembler) readError(line int, err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return a.errorf(line, "unexpected end of input")
+	}
+	return err
+}
+
+func (a *assembler) errorf(line int, format string, args ...interface{}) error {
+	return &AsmError{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
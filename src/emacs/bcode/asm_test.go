@@ -0,0 +1,269 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		src    string
+		code   []byte
+		consts string
+	}{
+		{
+			`
+			; (if x 'a "b")
+			    varref x
+			    goto-if-nil else
+			    constant a
+			    return
+			else:
+			    constant "b"
+			    return`,
+			[]byte{
+				OpVarRef0,
+				OpGotoIfNilW, 6, 0,
+				OpConstant1,
+				OpReturn,
+				OpConstant2,
+				OpReturn,
+			},
+			`x a "b"`,
+		},
+		{
+			`
+			stack-ref 0
+			stack-ref 5
+			stack-ref 6
+			stack-ref 300
+			stack-set 1
+			discardN 2
+			discardN-preserve-tos 3
+			listN 10
+			call 5
+			call 6
+			unbind 1
+			go-call 2
+			go-call 7
+			stop`,
+			[]byte{
+				OpDup,
+				OpStackRef5,
+				OpStackRefB, 6,
+				OpStackRefW, 44, 1,
+				OpStackSetB, 1,
+				OpDiscardB, 2,
+				OpDiscardB, 0x83,
+				OpListB, 10,
+				OpCall5,
+				OpCallB, 6,
+				OpUnbind1,
+				OpExt, OpExtGoCall2,
+				OpExt, OpExtGoCallB, 7,
+				OpExt, OpExtStop,
+			},
+			``,
+		},
+		{
+			// Backward jumps and numeric labels.
+			`1: constant 1.5
+			    constant (1 2)
+			    constant 1.5
+			    constant "s"
+			    constant "s"
+			    goto 1`,
+			[]byte{
+				OpConstant0,
+				OpConstant1,
+				OpConstant0,
+				OpConstant2,
+				OpConstant2,
+				OpGotoW, 0, 0,
+			},
+			`1.5 (1 . (2 . nil)) "s"`,
+		},
+	}
+
+	for i, test := range tests {
+		fn, err := assemble([]byte(test.src), lisp.NewObarray())
+		if err != nil {
+			t.Errorf("tests[%d]: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(fn.code, test.code) {
+			t.Errorf("tests[%d]: code mismatch:\nhave: %v\nwant: %v", i, fn.code, test.code)
+		}
+		if have := lisp.ObjectSliceString(fn.consts); have != test.consts {
+			t.Errorf("tests[%d]: consts mismatch:\nhave: %s\nwant: %s", i, have, test.consts)
+		}
+	}
+}
+
+func TestAssembleLongForms(t *testing.T) {
+	var src strings.Builder
+	for i := 0; i < 70; i++ {
+		src.WriteString("constant " + strings.Repeat("x", i+1) + "\n")
+	}
+	src.WriteString("varref x\nvarref y\n")
+	fn, err := assemble([]byte(src.String()), lisp.NewObarray())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := fn.code[60:]
+	want := []byte{
+		OpConstant60, OpConstant61, OpConstant62, OpConstant63,
+		OpConstantW, 64, 0,
+		OpConstantW, 65, 0,
+		OpConstantW, 66, 0,
+		OpConstantW, 67, 0,
+		OpConstantW, 68, 0,
+		OpConstantW, 69, 0,
+		OpVarRef0,
+		OpVarRefB, 70,
+	}
+	if !bytes.Equal(code, want) {
+		t.Errorf("code mismatch:\nhave: %v\nwant: %v", code, want)
+	}
+}
+
+func TestAssembleDisassembly(t *testing.T) {
+	fn := Func{
+		code: []byte{
+			OpVarRef0,
+			OpGotoIfNilW, 8, 0,
+			OpStackRef2,
+			OpConstant1,
+			OpCall1,
+			OpReturn,
+			OpConstant2,
+			OpExt, OpExtGoCallB, 9,
+			OpGotoW, 0, 0,
+		},
+		consts: []lisp.Object{
+			lisp.NewSymbol("x"),
+			lisp.NewSymbol("f"),
+			lisp.List(lisp.NewInt(1), lisp.NewString([]byte("s"))),
+		},
+	}
+	var buf bytes.Buffer
+	if err := Disassemble(&buf, "foo", &fn); err != nil {
+		t.Fatal(err)
+	}
+	// Skip "byte code for foo:" header.
+	src := buf.Bytes()[bytes.IndexByte(buf.Bytes(), '\n'):]
+
+	fn2, err := assemble(src, lisp.NewObarray())
+	if err != nil {
+		t.Fatalf("assemble:\n%s\nerror: %v", src, err)
+	}
+	if !bytes.Equal(fn2.code, fn.code) {
+		t.Errorf("code mismatch:\nhave: %v\nwant: %v", fn2.code, fn.code)
+	}
+	have := lisp.ObjectSliceString(fn2.consts)
+	want := lisp.ObjectSliceString(fn.consts)
+	if have != want {
+		t.Errorf("consts mismatch:\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"nop", `line 1: unknown mnemonic "nop"`},
+		{"dup\nstack-ref", `line 2: stack-ref: missing operand`},
+		{"stack-ref x", `line 1: stack-ref: bad operand x`},
+		{"stack-ref -1", `line 1: stack-ref: bad operand -1`},
+		{"stack-ref 70000", `line 1: stack-ref: operand 70000 is out of range`},
+		{"listN 256", `line 1: listN: operand 256 is out of range`},
+		{"discardN 128", `line 1: discardN: operand 128 is out of range`},
+		{"discardN-preserve-tos 128", `line 1: discardN-preserve-tos: operand 128 is out of range`},
+		{"dup dup", `line 1: unexpected dup`},
+		{"\n\ngoto l", `line 3: undefined label "l"`},
+		{"l:\nl:", `line 2: label "l" redefined`},
+		{"x:y:", `line 1: bad label "x:y:"`},
+		{"(dup)", `line 1: expected mnemonic, found (dup . nil)`},
+		{"constant (", `line 1: unexpected end of input`},
+	}
+
+	for _, test := range tests {
+		_, err := Assemble([]byte(test.src), lisp.NewObarray(), 0)
+		if err == nil {
+			t.Errorf("assemble %q: expected error", test.src)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("assemble %q:\nhave: %v\nwant: %s", test.src, err, test.want)
+		}
+	}
+}
+
+func TestAssembleRun(t *testing.T) {
+	interp := newTestInterpreter(t)
+	ob := lisp.NewObarray()
+	ob.Add(interp.AddGoFunc("list", func(args []lisp.Object) error {
+		args[0] = lisp.List(args[1:]...)
+		return nil
+	}))
+
+	// (lambda (x) (if x (let ((y (1+ x))) (cons y y)) (list 1)))
+	fn, err := Assemble([]byte(`
+		    stack-ref 0
+		    goto-if-nil nil-case
+		    add1
+		    dup
+		    cons
+		    return
+		nil-case:
+		    constant list
+		    constant 1
+		    go-call 1
+		    return`), ob, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := interp.AddFunc("inc-pair", *fn)
+	tests := []struct {
+		arg  lisp.Object
+		want string
+	}{
+		{lisp.NewInt(3), "(4 . 4)"},
+		{lisp.Nil, "(1 . nil)"},
+	}
+	for _, test := range tests {
+		have, err := interp.Funcall(f, test.arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lisp.ObjectString(have) != test.want {
+			t.Errorf("inc-pair %s: have %s, want %s",
+				lisp.ObjectString(test.arg), lisp.ObjectString(have), test.want)
+		}
+	}
+}
+
+func TestAssembleVerifies(t *testing.T) {
+	env := NewMasterEnv().NewEnv(8, 0)
+
+	// Deeper than the env stack: verified, but
+	// rejected by the stack size check.
+	src := strings.Repeat("constant 1\n", 20) + "return"
+	fn, err := Assemble([]byte(src), lisp.NewObarray(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Exec(fn); err != ErrStackOverflow {
+		t.Errorf("exec: have error %v, want %v", err, ErrStackOverflow)
+	}
+
+	// Stack underflow is a verification error.
+	_, err = Assemble([]byte("discard\ndiscard\nreturn"), lisp.NewObarray(), 0)
+	want := "pc 0: discard: stack underflow (depth 0, needs 1)"
+	if err == nil || err.Error() != want {
+		t.Errorf("assemble: have error %v, want %s", err, want)
+	}
+}
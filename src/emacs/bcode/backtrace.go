@@ -0,0 +1,93 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Backtraces of Lisp errors.
+//
+// Active calls are not tracked during normal execution.
+// Instead, when an error leaves a function, the call
+// is appended to the backtrace of that error.
+// When the error reaches the outermost caller, the backtrace
+// lists all calls that were active when it was signalled.
+
+// Frame describes a single function call.
+type Frame struct {
+	// Function is the called function: a symbol, or
+	// a compiled function object for anonymous functions.
+	Function lisp.Object
+
+	// Args holds call arguments.
+	// Arguments of functions that were not created by
+	// NewFunc or MakeByteCode are unknown and omitted.
+	Args []lisp.Object
+}
+
+// String returns frame printed like Emacs backtrace line,
+// without indentation: "FUNCTION(ARGS...)".
+func (f Frame) String() string {
+	s := lisp.Prin1String(f.Function) + "("
+	for i, arg := range f.Args {
+		if i != 0 {
+			s += " "
+		}
+		s += lisp.Prin1String(arg)
+	}
+	return s + ")"
+}
+
+// Backtrace returns calls that were active when err was
+// signalled, innermost call first.
+//
+// Returns nil if err did not leave any function call
+// since the last outermost Funcall, Apply or Exec.
+func (env *Env) Backtrace(err error) []Frame {
+	if err == nil || err != env.backtraceErr {
+		return nil
+	}
+	return env.backtrace
+}
+
+// addFrame appends a call to err backtrace.
+func (env *Env) addFrame(err error, fn lisp.Object, args []lisp.Object) {
+	if err == ErrEOF {
+		return
+	}
+	if err != env.backtraceErr {
+		env.backtrace = nil
+		env.backtraceErr = err
+	}
+	env.backtrace = append(env.backtrace, Frame{
+		Function: fn,
+		Args:     append([]lisp.Object(nil), args...),
+	})
+}
+
+// traceError adds calls of run that fails with err
+// to the backtrace and returns err.
+// depth is the innermost active frame, fn is its function.
+func (env *Env) traceError(fn *Func, base, depth int, err error) error {
+	if err == ErrEOF {
+		return err
+	}
+	for ; depth >= base; depth-- {
+		frame := &env.frames[depth]
+		callee := fn.Object()
+		var args []lisp.Object
+		// Frame made by eval has no callee slot.
+		if frame.fp != 0 {
+			if sym := env.stack[frame.fp-1]; sym.Type == lisp.TypeSymbol && !lisp.Null(&sym) {
+				callee = sym
+			}
+			end := frame.fp + fn.nargs
+			if end > uint32(len(env.stack)) {
+				end = uint32(len(env.stack))
+			}
+			args = env.stack[frame.fp:end]
+		}
+		env.addFrame(err, callee, args)
+		fn = frame.fn
+	}
+	return err
+}
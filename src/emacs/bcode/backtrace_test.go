@@ -0,0 +1,65 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+const backtraceElc = `
+(defalias 'inner #[257 "\300\1!\207" [nope] 3])
+(defalias 'outer #[0 "\300\301!\207" [inner 5] 2])
+(defalias 'go-outer #[0 "\300\301!\207" [go-fail 7] 2])
+(defalias 'protected #[0 "\0\11\7\0\300 \60\301\100\210\0\12\207" [outer nil] 2])
+`
+
+func TestBacktrace(t *testing.T) {
+	env, ob := newLispEnv()
+	env.DefineGoFunc(ob.Intern("go-fail"), func(args []lisp.Object) error {
+		return signal(SymError, lisp.NewString([]byte("failed")))
+	})
+	if err := env.Load([]byte(backtraceElc), "backtrace.elc", ob); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fn   string
+		args []lisp.Object
+		want []string
+	}{
+		{"outer", nil, []string{"inner(5)", "outer()"}},
+		{"inner", []lisp.Object{lisp.NewInt(1)}, []string{"inner(1)"}},
+		{"go-outer", nil, []string{"go-fail(7)", "go-outer()"}},
+		// Errors that unwind handlers rethrow keep their backtrace.
+		{"protected", nil, []string{"inner(5)", "outer()", "protected()"}},
+	}
+	for _, test := range tests {
+		_, err := env.Funcall(ob.Intern(test.fn), test.args...)
+		if err == nil {
+			t.Errorf("%s: no error", test.fn)
+			continue
+		}
+		var have []string
+		for _, f := range env.Backtrace(err) {
+			have = append(have, f.String())
+		}
+		if len(have) != len(test.want) {
+			t.Errorf("%s: have backtrace %q, want %q", test.fn, have, test.want)
+			continue
+		}
+		for i := range have {
+			if have[i] != test.want[i] {
+				t.Errorf("%s: have backtrace %q, want %q", test.fn, have, test.want)
+				break
+			}
+		}
+	}
+
+	// Errors that do not leave any calls have no backtrace.
+	_, err := env.Funcall(ob.Intern("undefined"))
+	if bt := env.Backtrace(err); bt != nil {
+		t.Errorf("void-function: have backtrace %v, want none", bt)
+	}
+	if bt := env.Backtrace(nil); bt != nil {
+		t.Errorf("nil error: have backtrace %v, want none", bt)
+	}
+}
@@ -2,15 +2,88 @@ package bcode
 
 import (
 	"emacs/lisp"
+	"io"
+	"sync"
 )
 
 // MasterEnv holds data that is shared by multiple Env objects.
+//
+// Variable values, special declarations, symbol properties and
+// interpreted function definitions may be updated by Envs
+// that run in parallel. Compiled and Go function tables are not
+// synchronized: DefineGoFunc, DefineGoFuncCtx, DefineCompiledFunc,
+// Fset and the Lisp functions that call them (fset, defalias,
+// defun, defmacro, byte-compile) must not run in parallel
+// with evaluation in other Envs.
 type MasterEnv struct {
-	// funcs is a list of defined Lisp functions.
-	funcs []Func
+	// allocStats collects Env allocation counters.
+	// It is accessed atomically, so it goes first
+	// to be 64-bit aligned.
+	allocStats AllocStats
+
+	// funcPages holds the bindings of symbols to compiled
+	// and Go functions, indexed by symbol FuncID; see funcRef.
+	// Bindings belong to the MasterEnv, not to the symbol,
+	// so symbols can be shared by environments with
+	// different function tables.
+	funcPages []*funcPage
 
 	// goFuncs is a list of defined foreign (Go) functions.
-	goFuncs []GoFunc
+	// GoFunc functions are stored in GoFuncCtx form.
+	goFuncs []GoFuncCtx
+
+	// goArity maps goFuncs indexes to their arity.
+	// Functions that are not listed accept any number of arguments.
+	goArity map[int]Arity
+
+	// aotFuncs maps function names to their Go versions,
+	// see RegisterAOT.
+	aotFuncs map[string][]AOTFunc
+
+	// stdSyntaxTable is the standard syntax table.
+	// It is used by buffers that have no syntax table of their own.
+	stdSyntaxTable *lisp.SyntaxTable
+
+	// symbolsMu guards globals, specials, fdefs and plists:
+	// they are updated by evaluation in any Env.
+	symbolsMu sync.RWMutex
+
+	// globals holds symbol values that are visible
+	// when symbol is not dynamically bound.
+	globals map[*lisp.Symbol]lisp.Object
+
+	// specials holds variables that are declared special
+	// by defvar or defconst; they are always bound dynamically.
+	specials map[*lisp.Symbol]bool
+
+	// fdefs holds function definitions of symbols that are
+	// not bound to compiled or Go functions, like interpreted
+	// lambdas and closures. Such symbols have no funcRef.
+	fdefs map[*lisp.Symbol]lisp.Object
+
+	// plists holds symbol property lists, see `get` and `put`.
+	plists map[*lisp.Symbol]lisp.Object
+
+	// buffers maps names to live buffers.
+	// Access must be guarded by buffersMu.
+	buffers   map[string]*lisp.Buffer
+	buffersMu sync.Mutex
+
+	// TempBufferShow is called when `with-output-to-temp-buffer`
+	// body finishes, to display the filled buffer.
+	// Does nothing if nil.
+	//
+	// Hosts use it to capture help-style command output.
+	TempBufferShow func(buf *lisp.Buffer) error
+
+	// Output receives text that is printed when `standard-output`
+	// is t or nil (the echo area, in Emacs terms).
+	// Output is discarded if nil.
+	Output io.Writer
+
+	// Optimize enables peephole optimization of the functions
+	// that are loaded or compiled by this environment, see Optimize.
+	Optimize bool
 }
 
 // Env is a context that can be used to perform code evaluation.
@@ -32,6 +105,60 @@ type Env struct {
 	// len(frames) limits call depth.
 	frames []callFrame
 
+	// callDepth is the frames index that is used by the next
+	// (possibly nested) evaluation as its base activation record.
+	callDepth int
+
+	// evalDepth is the number of forms that the interpreter
+	// is evaluating; it counts against the call depth limit.
+	evalDepth int
+
+	// stackTop is the stack pointer at the moment of the
+	// innermost Go function call.
+	// Nested calls from Go place their data above it.
+	stackTop uint32
+
+	// buffer is the current buffer.
+	// Most buffer-related opcodes operate on it.
+	buffer *lisp.Buffer
+
+	// specpdl is a stack of dynamic variable bindings.
+	// Innermost bindings are at the end.
+	specpdl []specBinding
+
+	// lexenv is the lexical environment of interpreted code:
+	// an alist of (SYMBOL . VALUE) lexical bindings, where bare
+	// symbols mark locally special variables.
+	// Nil means that dynamic binding is used.
+	lexenv lisp.Object
+
+	// catchTags holds tags of the active `catch` forms,
+	// innermost last. `throw` to other tags signals no-catch.
+	catchTags []lisp.Object
+
+	// handlers holds the active byte code handlers,
+	// innermost last. See pushHandler.
+	handlers []handler
+
+	// matchData holds the last successful search group bounds:
+	// matchData[n*2] and matchData[n*2+1] are the start and
+	// the end of n-th group; -1 for unmatched groups.
+	matchData []int
+
+	// regexpCache holds recently compiled search patterns.
+	// regexpCacheNext is the index of the entry to be replaced.
+	regexpCache     [regexpCacheSize]regexpCacheEntry
+	regexpCacheNext int
+
+	// backtrace holds calls that were active when
+	// backtraceErr was signalled, innermost call first.
+	// See Backtrace.
+	backtrace    []Frame
+	backtraceErr error
+
+	// alloc holds cons slabs.
+	alloc allocator
+
 	// MasterEnv holds information that is not required
 	// to be bound to particular execution thread.
 	*MasterEnv
@@ -58,6 +185,16 @@ type Env struct {
 // context provided elsewhere.
 type GoFunc func(args []lisp.Object) error
 
+// GoFuncCtx is like GoFunc, but it also receives the calling Env.
+//
+// Env exported methods give access to the current buffer,
+// dynamic bindings and `standard-output`.
+// Lisp errors are reported by returning a *Signal,
+// see NewSignal.
+//
+// env must not be retained after function returns.
+type GoFuncCtx func(env *Env, args []lisp.Object) error
+
 // Func is compiled Emacs Lisp function object.
 //
 // Properties that are not related to evaluation are
@@ -68,14 +205,41 @@ type GoFunc func(args []lisp.Object) error
 type Func struct {
 	code   []byte
 	consts []lisp.Object
+
+	// insns is the pre-decoded code that is executed
+	// by the interpreter, see translate.
+	insns []insn
+
+	// maxStack is the number of stack slots that code
+	// may use above its arguments.
+	// Computed by NewFunc; unverified functions have 0.
+	maxStack uint32
+
+	// nargs is the number of argument slots that function
+	// finds on the stack when it starts execution:
+	// mandatory and optional arguments, plus the &rest list.
+	// Set by NewFunc; see setupArgs for calls with
+	// a different number of arguments.
+	nargs uint32
+
+	// optArgs is the number of &optional arguments.
+	optArgs uint32
+
+	// rest is set if the last argument slot holds
+	// the &rest list.
+	rest bool
+
+	// dynamic is set for functions with dynamic binding
+	// argument list. They can be inspected, but not called.
+	dynamic bool
 }
 
 // callFrame holds single function call activation record data.
 // Used during function return to restore interpreter state
 // that can continue execution from the point right after the invocation.
 type callFrame struct {
-	// pc holds position inside fn code before function call
-	// that spawned this frame.
+	// pc holds the index of fn instruction that made
+	// the function call that spawned this frame.
 	pc uint32
 
 	// fp holds data stack index that is used to clear
@@ -0,0 +1,136 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Dynamic variable bindings.
+//
+// Bindings are "deep": every Env has its own stack of
+// (symbol, value) records that is searched top-down before
+// falling back to the global value that is stored in MasterEnv.
+// This makes bindings invisible to other Env objects
+// that share the same MasterEnv.
+// Issue#3
+
+// Variable symbols that are used by the runtime itself.
+//
+// Should be treated as constants.
+var (
+	SymStandardOutput = lisp.NewSymbol("standard-output")
+	SymCaseFoldSearch = lisp.NewSymbol("case-fold-search")
+)
+
+// specBinding is a single dynamic binding record.
+// Records with unwind set are not bindings: they restore
+// the editor state when they are removed, like the records
+// of save-excursion do.
+type specBinding struct {
+	sym    lisp.Object
+	val    lisp.Object
+	unwind func()
+}
+
+// specpdlIndex returns current bindings stack depth.
+// It is used as an argument for unbindTo.
+func (env *Env) specpdlIndex() int {
+	return len(env.specpdl)
+}
+
+// specbind dynamically binds sym to val.
+// Binding is active until unbindTo removes it.
+func (env *Env) specbind(sym, val lisp.Object) {
+	env.specpdl = append(env.specpdl, specBinding{sym: sym, val: val})
+}
+
+// recordUnwind adds a record that calls unwind
+// when unbindTo removes it.
+func (env *Env) recordUnwind(unwind func()) {
+	env.specpdl = append(env.specpdl, specBinding{unwind: unwind})
+}
+
+// unbindTo removes all bindings that were established
+// after specpdlIndex returned count.
+// Unwind records are run innermost first.
+func (env *Env) unbindTo(count int) {
+	for i := len(env.specpdl) - 1; i >= count; i-- {
+		unwind := env.specpdl[i].unwind
+		env.specpdl[i] = specBinding{}
+		if unwind != nil {
+			unwind()
+		}
+	}
+	env.specpdl = env.specpdl[:count]
+}
+
+// lookupBinding returns the innermost binding of sym or nil.
+func (env *Env) lookupBinding(sym lisp.Object) *specBinding {
+	for i := len(env.specpdl) - 1; i >= 0; i-- {
+		if env.specpdl[i].sym.Ptr == sym.Ptr {
+			return &env.specpdl[i]
+		}
+	}
+	return nil
+}
+
+// symbolValue implements `symbol-value`.
+// Signals void-variable if sym has no value.
+func (env *Env) symbolValue(sym lisp.Object) (lisp.Object, error) {
+	if b := env.lookupBinding(sym); b != nil {
+		return b.val, nil
+	}
+	if val, ok := env.globalValue(sym); ok {
+		return val, nil
+	}
+	return lisp.Nil, signal(SymVoidVariable, sym)
+}
+
+// setSymbolValue implements `set`.
+// The innermost binding of sym is updated;
+// if there is none, the global value is set.
+func (env *Env) setSymbolValue(sym, val lisp.Object) {
+	if b := env.lookupBinding(sym); b != nil {
+		b.val = val
+		return
+	}
+	env.setGlobalValue(sym, val)
+}
+
+// globalValue returns sym value that is visible
+// when it is not dynamically bound.
+func (env *MasterEnv) globalValue(sym lisp.Object) (lisp.Object, bool) {
+	env.symbolsMu.RLock()
+	defer env.symbolsMu.RUnlock()
+	val, ok := env.globals[sym.Symbol()]
+	return val, ok
+}
+
+// setGlobalValue sets sym value that is visible
+// when it is not dynamically bound.
+func (env *MasterEnv) setGlobalValue(sym, val lisp.Object) {
+	env.symbolsMu.Lock()
+	defer env.symbolsMu.Unlock()
+	if env.globals == nil {
+		env.globals = make(map[*lisp.Symbol]lisp.Object)
+	}
+	env.globals[sym.Symbol()] = val
+}
+
+// defineSpecial marks sym as a special variable,
+// which is always bound dynamically.
+func (env *MasterEnv) defineSpecial(sym lisp.Object) {
+	env.symbolsMu.Lock()
+	defer env.symbolsMu.Unlock()
+	if env.specials == nil {
+		env.specials = make(map[*lisp.Symbol]bool)
+	}
+	env.specials[sym.Symbol()] = true
+}
+
+// declaredSpecial reports whether sym is declared special
+// by defineSpecial.
+func (env *MasterEnv) declaredSpecial(sym lisp.Object) bool {
+	env.symbolsMu.RLock()
+	defer env.symbolsMu.RUnlock()
+	return env.specials[sym.Symbol()]
+}
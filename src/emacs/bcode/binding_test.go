@@ -0,0 +1,85 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"sync"
+	"testing"
+)
+
+func TestDynamicBinding(t *testing.T) {
+	env := newTestEnv()
+	x := lisp.NewSymbol("x")
+
+	if _, err := env.symbolValue(x); err == nil {
+		t.Fatalf("unbound variable: expected void-variable error")
+	} else if sig := err.(*Signal); sig.Symbol != SymVoidVariable {
+		t.Fatalf("unbound variable: unexpected error: %v", err)
+	}
+
+	// checkValue compares x value with want.
+	checkValue := func(step, want string) {
+		val, err := env.symbolValue(x)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if have := lisp.ObjectString(val); have != want {
+			t.Errorf("%s: have %s, want %s", step, have, want)
+		}
+	}
+
+	env.setSymbolValue(x, lisp.NewInt(1))
+	checkValue("global set", "1")
+
+	count := env.specpdlIndex()
+	env.specbind(x, lisp.NewInt(2))
+	checkValue("outer binding", "2")
+	env.specbind(x, lisp.NewInt(3))
+	checkValue("inner binding", "3")
+	env.setSymbolValue(x, lisp.NewInt(4))
+	checkValue("inner set", "4")
+	env.unbindTo(count + 1)
+	checkValue("inner unbind", "2")
+
+	// Other Env that shares MasterEnv sees only global value.
+	other := Env{MasterEnv: env.MasterEnv}
+	if val, _ := other.symbolValue(x); lisp.ObjectString(val) != "1" {
+		t.Errorf("other env: have %s, want 1", lisp.ObjectString(val))
+	}
+
+	env.unbindTo(count)
+	checkValue("outer unbind", "1")
+	if env.specpdlIndex() != count {
+		t.Errorf("specpdl depth: have %d, want %d", env.specpdlIndex(), count)
+	}
+}
+
+// TestSharedGlobals updates MasterEnv from parallel Envs;
+// run it with -race.
+func TestSharedGlobals(t *testing.T) {
+	env, ob := newLispEnv()
+	if _, err := env.Eval(mustRead(t, "(fset 'shared-f (lambda () shared-x))", ob), lisp.T); err != nil {
+		t.Fatal(ErrorMessage(err))
+	}
+	forms := []lisp.Object{
+		mustRead(t, "(setq shared-x 1)", ob),
+		mustRead(t, "(defvar shared-y 2)", ob),
+		mustRead(t, "(put 'shared-x 'prop shared-x)", ob),
+		mustRead(t, "(list (shared-f) (get 'shared-x 'prop) (special-variable-p 'shared-y))", ob),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(env *Env) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for _, form := range forms {
+					if _, err := env.Eval(form, lisp.T); err != nil {
+						t.Error(ErrorMessage(err))
+						return
+					}
+				}
+			}
+		}(env.NewEnv(0, 0))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,424 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Buffer motion and indentation primitives.
+//
+// Functions follow the Emacs C implementation closely,
+// including the return value conventions.
+// Issue#4
+
+// defineBufferSubrs defines the motion, indentation
+// and skip-chars functions.
+func (env *MasterEnv) defineBufferSubrs(ob *lisp.Obarray) {
+	for _, subr := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"point", func(env *Env) int {
+			return env.buffer.Point()
+		}},
+		{"forward-char", func(env *Env, n *lisp.Object) (lisp.Object, error) {
+			return forwardChar(env.buffer, optional(n))
+		}},
+		{"forward-line", func(env *Env, n *lisp.Object) (lisp.Object, error) {
+			return forwardLine(env.buffer, optional(n))
+		}},
+		{"end-of-line", func(env *Env, n *lisp.Object) (lisp.Object, error) {
+			return endOfLine(env.buffer, optional(n))
+		}},
+		{"forward-word", func(env *Env, arg *lisp.Object) (lisp.Object, error) {
+			return forwardWord(env.buffer, env.syntaxTable(), optional(arg))
+		}},
+		{"current-column", func(env *Env) int {
+			return currentColumn(env.buffer)
+		}},
+		{"indent-to", func(env *Env, column lisp.Object, minimum *lisp.Object) (lisp.Object, error) {
+			if column.Type != lisp.TypeInt {
+				return lisp.Nil, wrongTypeArgument(SymIntegerp, column)
+			}
+			n, err := intArgOr(optional(minimum), 0)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			// At least MINIMUM columns are inserted.
+			if col := currentColumn(env.buffer) + n; int(column.Int()) < col {
+				column = lisp.NewInt(int64(col))
+			}
+			return indentTo(env.buffer, &column)
+		}},
+		{"skip-chars-forward", func(env *Env, set lisp.Object, lim *lisp.Object) (lisp.Object, error) {
+			return skipChars(env.buffer, env.syntaxTable(), true, &set, optional(lim))
+		}},
+		{"skip-chars-backward", func(env *Env, set lisp.Object, lim *lisp.Object) (lisp.Object, error) {
+			return skipChars(env.buffer, env.syntaxTable(), false, &set, optional(lim))
+		}},
+	} {
+		env.DefineFunc(ob.Intern(subr.name), subr.fn)
+	}
+}
+
+// intArgOr returns x integer value or def if x is nil.
+// Signals wrong-type-argument for other types.
+func intArgOr(x *lisp.Object, def int) (int, error) {
+	switch {
+	case x.Type == lisp.TypeInt:
+		return int(x.Int()), nil
+	case lisp.Null(x):
+		return def, nil
+	default:
+		return 0, wrongTypeArgument(SymIntegerp, *x)
+	}
+}
+
+// tabWidth returns sanitized buf.TabWidth value.
+func tabWidth(buf *lisp.Buffer) int {
+	if buf.TabWidth <= 0 || buf.TabWidth > 1000 {
+		return 8
+	}
+	return buf.TabWidth
+}
+
+// findNewline searches count newlines starting from pos.
+// Positive count searches forward, negative searches backward.
+//
+// Returns the position past the last found newline
+// (for backward search, it is the position of the newline)
+// and the number of newlines found.
+// If not all newlines are found, accessible portion bound is returned.
+func findNewline(buf *lisp.Buffer, pos, count int) (int, int) {
+	found := 0
+	if count > 0 {
+		for end := buf.PointMax(); pos < end; pos++ {
+			if buf.CharAt(pos) == '\n' {
+				found++
+				if found == count {
+					return pos + 1, found
+				}
+			}
+		}
+		return buf.PointMax(), found
+	}
+	for beg := buf.PointMin(); pos > beg; pos-- {
+		if buf.CharAt(pos-1) == '\n' {
+			found++
+			if found == -count {
+				return pos - 1, found
+			}
+		}
+	}
+	return buf.PointMin(), found
+}
+
+// forwardChar implements `forward-char`.
+// Point is moved to accessible portion bound if n chars
+// can not be skipped, then a signal is returned.
+func forwardChar(buf *lisp.Buffer, arg *lisp.Object) (lisp.Object, error) {
+	n, err := intArgOr(arg, 1)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	pos := buf.Point() + n
+	if pos < buf.PointMin() {
+		buf.SetPoint(buf.PointMin())
+		return lisp.Nil, signal(SymBeginningOfBuffer)
+	}
+	if pos > buf.PointMax() {
+		buf.SetPoint(buf.PointMax())
+		return lisp.Nil, signal(SymEndOfBuffer)
+	}
+	buf.SetPoint(pos)
+	return lisp.Nil, nil
+}
+
+// forwardLine implements `forward-line`.
+// Returns the count of lines left to move.
+func forwardLine(buf *lisp.Buffer, arg *lisp.Object) (lisp.Object, error) {
+	n, err := intArgOr(arg, 1)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	opoint := buf.Point()
+
+	var pos, shortage int
+	if n <= 0 {
+		var found int
+		pos, found = findNewline(buf, opoint, n-1)
+		if found == -(n - 1) {
+			pos++ // Move past the newline
+		}
+		shortage = -(n - 1) - found
+	} else {
+		var found int
+		pos, found = findNewline(buf, opoint, n)
+		shortage = n - found
+	}
+	buf.SetPoint(pos)
+
+	// A non-empty line at the end counts as one line successfully moved.
+	if shortage > 0 && (n <= 0 || (buf.PointMax() > buf.PointMin() &&
+		pos != opoint &&
+		buf.CharAt(pos-1) != '\n')) {
+		shortage--
+	}
+
+	if n <= 0 {
+		return lisp.NewInt(int64(-shortage)), nil
+	}
+	return lisp.NewInt(int64(shortage)), nil
+}
+
+// lineEndPosition implements `line-end-position`.
+// Returns the position of the end of line n-1 lines away.
+func lineEndPosition(buf *lisp.Buffer, n int) int {
+	if n <= 0 {
+		// Backward search already stops at the newline position.
+		pos, _ := findNewline(buf, buf.Point(), n-1)
+		return pos
+	}
+	pos, found := findNewline(buf, buf.Point(), n)
+	if found == n {
+		return pos - 1 // Stop before the newline
+	}
+	return pos
+}
+
+// endOfLine implements `end-of-line`.
+func endOfLine(buf *lisp.Buffer, arg *lisp.Object) (lisp.Object, error) {
+	n, err := intArgOr(arg, 1)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	buf.SetPoint(lineEndPosition(buf, n))
+	return lisp.Nil, nil
+}
+
+// scanWords returns the position after count words starting from pos.
+// Negative count moves backward.
+// Word constituents are chars with word syntax in st.
+// Returns 0 if buffer accessible portion bound was reached
+// before count words were scanned.
+func scanWords(buf *lisp.Buffer, st *lisp.SyntaxTable, pos, count int) int {
+	beg, end := buf.PointMin(), buf.PointMax()
+	isWordChar := func(c rune) bool {
+		return st.Class(c) == lisp.SyntaxWord
+	}
+
+	for ; count > 0; count-- {
+		for {
+			if pos == end {
+				return 0
+			}
+			c := buf.CharAt(pos)
+			pos++
+			if isWordChar(c) {
+				break
+			}
+		}
+		for pos != end && isWordChar(buf.CharAt(pos)) {
+			pos++
+		}
+	}
+
+	for ; count < 0; count++ {
+		for {
+			if pos == beg {
+				return 0
+			}
+			c := buf.CharAt(pos - 1)
+			pos--
+			if isWordChar(c) {
+				break
+			}
+		}
+		for pos != beg && isWordChar(buf.CharAt(pos-1)) {
+			pos--
+		}
+	}
+
+	return pos
+}
+
+// forwardWord implements `forward-word`.
+// Returns t if all n words were skipped;
+// otherwise point is moved to accessible portion bound and nil is returned.
+func forwardWord(buf *lisp.Buffer, st *lisp.SyntaxTable, arg *lisp.Object) (lisp.Object, error) {
+	n, err := intArgOr(arg, 1)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	pos := scanWords(buf, st, buf.Point(), n)
+	if pos == 0 {
+		if n > 0 {
+			buf.SetPoint(buf.PointMax())
+		} else {
+			buf.SetPoint(buf.PointMin())
+		}
+		return lisp.Nil, nil
+	}
+	buf.SetPoint(pos)
+	return lisp.T, nil
+}
+
+// charWidth returns c display width for column computations.
+// col is a column where c is going to be displayed.
+func charWidth(c rune, col, tabWidth int) int {
+	switch {
+	case c == '\t':
+		return (col/tabWidth+1)*tabWidth - col
+	case c < ' ' || c == 0x7F:
+		return 2 // Displayed as "^C" (`ctl-arrow' is t)
+	default:
+		return 1
+	}
+}
+
+// currentColumn returns horizontal position of point.
+// Beginning of line is column 0.
+func currentColumn(buf *lisp.Buffer) int {
+	pt := buf.Point()
+	bol := pt
+	for bol > buf.PointMin() && buf.CharAt(bol-1) != '\n' {
+		bol--
+	}
+	tw := tabWidth(buf)
+	col := 0
+	for pos := bol; pos < pt; pos++ {
+		col += charWidth(buf.CharAt(pos), col, tw)
+	}
+	return col
+}
+
+// indentTo implements `indent-to` with nil MINIMUM argument.
+// Inserts tabs (if buf.IndentTabsMode is set) and spaces
+// to reach specified column.
+// Returns the column reached.
+func indentTo(buf *lisp.Buffer, arg *lisp.Object) (lisp.Object, error) {
+	if arg.Type != lisp.TypeInt {
+		return lisp.Nil, wrongTypeArgument(SymIntegerp, *arg)
+	}
+	fromcol := currentColumn(buf)
+	mincol := fromcol
+	if column := int(arg.Int()); mincol < column {
+		mincol = column
+	}
+	if fromcol == mincol {
+		return lisp.NewInt(int64(mincol)), nil
+	}
+
+	var indent []rune
+	if buf.IndentTabsMode {
+		tw := tabWidth(buf)
+		for n := mincol/tw - fromcol/tw; n > 0; n-- {
+			indent = append(indent, '\t')
+		}
+		if len(indent) != 0 {
+			fromcol = (mincol / tw) * tw
+		}
+	}
+	for n := mincol - fromcol; n > 0; n-- {
+		indent = append(indent, ' ')
+	}
+	buf.Insert(indent)
+
+	return lisp.NewInt(int64(mincol)), nil
+}
+
+// skipChars implements `skip-chars-forward` (forward=true)
+// and `skip-chars-backward` (forward=false).
+// Point moves while it is adjacent to the chars from the set
+// and has not reached lim.
+//
+// Returns the distance traveled, negative for backward motion.
+func skipChars(buf *lisp.Buffer, st *lisp.SyntaxTable, forward bool, set, lim *lisp.Object) (lisp.Object, error) {
+	if set.Type != lisp.TypeString {
+		return lisp.Nil, wrongTypeArgument(SymStringp, *set)
+	}
+	cs, err := parseSkipChars(set.String().Chars, st)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	limit, err := skipLimit(buf, forward, lim)
+	if err != nil {
+		return lisp.Nil, err
+	}
+
+	start := buf.Point()
+	pos := start
+	if forward {
+		for pos < limit && cs.has(buf.CharAt(pos)) {
+			pos++
+		}
+	} else {
+		for pos > limit && cs.has(buf.CharAt(pos-1)) {
+			pos--
+		}
+	}
+	buf.SetPoint(pos)
+
+	return lisp.NewInt(int64(pos - start)), nil
+}
+
+// saveExcursion implements `save-excursion`.
+// The current buffer and its point are restored
+// after body returns, even on error.
+func (env *Env) saveExcursion(body func() error) error {
+	defer env.unbindTo(env.specpdlIndex())
+	env.recordExcursion()
+	return body()
+}
+
+// recordExcursion implements save-excursion opcode:
+// the current buffer and its point are restored
+// when the record is unbound.
+func (env *Env) recordExcursion() {
+	buf := env.buffer
+	pt := buf.Point()
+	env.recordUnwind(func() {
+		env.buffer = buf
+		buf.SetPoint(pt)
+	})
+}
+
+// saveCurrentBuffer implements `save-current-buffer`.
+// The current buffer is restored after body returns, even on error.
+func (env *Env) saveCurrentBuffer(body func() error) error {
+	defer env.unbindTo(env.specpdlIndex())
+	env.recordCurrentBuffer()
+	return body()
+}
+
+// recordCurrentBuffer implements save-current-buffer opcode:
+// the current buffer is restored when the record is unbound.
+func (env *Env) recordCurrentBuffer() {
+	buf := env.buffer
+	env.recordUnwind(func() { env.buffer = buf })
+}
+
+// saveRestriction implements `save-restriction`.
+// The current buffer narrowing is restored after body returns,
+// even on error. The end of accessible portion is moved by
+// the number of characters that body inserted or deleted.
+func (env *Env) saveRestriction(body func() error) error {
+	defer env.unbindTo(env.specpdlIndex())
+	env.recordRestriction()
+	return body()
+}
+
+// recordRestriction implements save-restriction opcode:
+// the current buffer narrowing is restored when the
+// record is unbound, see saveRestriction.
+func (env *Env) recordRestriction() {
+	buf := env.buffer
+	begv, zv, size := buf.PointMin(), buf.PointMax(), buf.Size()
+	narrowed := begv != 1 || zv != size+1
+	env.recordUnwind(func() {
+		if !narrowed {
+			buf.Widen()
+			return
+		}
+		buf.Narrow(begv, zv+buf.Size()-size)
+	})
+}
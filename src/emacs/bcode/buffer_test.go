@@ -0,0 +1,415 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"strings"
+	"testing"
+)
+
+// newTestBuffer returns a buffer filled with text.
+// Point is placed where "|" marker is; marker itself is removed.
+// If there is no marker, point is placed at the beginning.
+func newTestBuffer(text string) *lisp.Buffer {
+	o := lisp.NewBuffer("test")
+	buf := o.Buffer()
+	pt := strings.IndexRune(text, '|')
+	buf.Insert([]rune(strings.Replace(text, "|", "", 1)))
+	if pt == -1 {
+		buf.SetPoint(1)
+	} else {
+		buf.SetPoint(len([]rune(text[:pt])) + 1)
+	}
+	return buf
+}
+
+// bufferString returns buffer contents with "|" inserted at point.
+func bufferString(buf *lisp.Buffer) string {
+	text := []rune(buf.Text())
+	pt := buf.Point() - buf.PointMin()
+	return string(text[:pt]) + "|" + string(text[pt:])
+}
+
+// motionTest describes a single buffer motion function call.
+type motionTest struct {
+	before string
+	arg    lisp.Object
+	after  string
+	result string
+}
+
+func runMotionTests(t *testing.T, name string, tests []motionTest,
+	fn func(*lisp.Buffer, *lisp.Object) (lisp.Object, error)) {
+	for i, tt := range tests {
+		buf := newTestBuffer(tt.before)
+		res, err := fn(buf, &tt.arg)
+		if err != nil {
+			t.Errorf("%s test %d: unexpected error: %v", name, i, err)
+			continue
+		}
+		if have := bufferString(buf); have != tt.after {
+			t.Errorf("%s test %d: buffer mismatch:\nhave: %q\nwant: %q",
+				name, i, have, tt.after)
+		}
+		if have := lisp.ObjectString(res); have != tt.result {
+			t.Errorf("%s test %d: result mismatch:\nhave: %s\nwant: %s",
+				name, i, have, tt.result)
+		}
+	}
+}
+
+func TestForwardChar(t *testing.T) {
+	runMotionTests(t, "forward-char", []motionTest{
+		0: {"|abc", lisp.Nil, "a|bc", "nil"},
+		1: {"|abc", lisp.NewInt(3), "abc|", "nil"},
+		2: {"abc|", lisp.NewInt(-2), "a|bc", "nil"},
+		3: {"ab|c", lisp.NewInt(0), "ab|c", "nil"},
+	}, forwardChar)
+
+	tests := []struct {
+		before string
+		arg    int64
+		after  string
+		sym    lisp.Object
+	}{
+		{"a|bc", 10, "abc|", SymEndOfBuffer},
+		{"ab|c", -3, "|abc", SymBeginningOfBuffer},
+	}
+	for _, tt := range tests {
+		buf := newTestBuffer(tt.before)
+		arg := lisp.NewInt(tt.arg)
+		_, err := forwardChar(buf, &arg)
+		sig, ok := err.(*Signal)
+		if !ok || sig.Symbol != tt.sym {
+			t.Errorf("forward-char %d: expected %s signal, got %v",
+				tt.arg, lisp.ObjectString(tt.sym), err)
+		}
+		if have := bufferString(buf); have != tt.after {
+			t.Errorf("forward-char %d: buffer mismatch:\nhave: %q\nwant: %q",
+				tt.arg, have, tt.after)
+		}
+	}
+
+	buf := newTestBuffer("abc")
+	arg := lisp.NewFloat(1)
+	_, err := forwardChar(buf, &arg)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymWrongTypeArgument {
+		t.Errorf("forward-char 1.0: expected wrong-type-argument, got %v", err)
+	}
+}
+
+func TestForwardLine(t *testing.T) {
+	runMotionTests(t, "forward-line", []motionTest{
+		0:  {"a|b\ncd\n", lisp.Nil, "ab\n|cd\n", "0"},
+		1:  {"a|b\ncd\n", lisp.NewInt(2), "ab\ncd\n|", "0"},
+		2:  {"a|b\ncd\n", lisp.NewInt(3), "ab\ncd\n|", "1"},
+		3:  {"a|b\ncd", lisp.NewInt(2), "ab\ncd|", "0"},
+		4:  {"a|b\ncd", lisp.NewInt(5), "ab\ncd|", "3"},
+		5:  {"ab\nc|d", lisp.NewInt(0), "ab\n|cd", "0"},
+		6:  {"ab\nc|d", lisp.NewInt(-1), "|ab\ncd", "0"},
+		7:  {"ab\nc|d", lisp.NewInt(-3), "|ab\ncd", "-2"},
+		8:  {"|", lisp.NewInt(1), "|", "1"},
+		9:  {"ab|", lisp.NewInt(1), "ab|", "1"},
+		10: {"ab\n|\n", lisp.NewInt(1), "ab\n\n|", "0"},
+	}, forwardLine)
+}
+
+func TestEndOfLine(t *testing.T) {
+	runMotionTests(t, "end-of-line", []motionTest{
+		0: {"|ab\ncd", lisp.Nil, "ab|\ncd", "nil"},
+		1: {"|ab\ncd", lisp.NewInt(2), "ab\ncd|", "nil"},
+		2: {"|ab\ncd", lisp.NewInt(9), "ab\ncd|", "nil"},
+		3: {"ab\nc|d", lisp.NewInt(0), "ab|\ncd", "nil"},
+		4: {"ab\nc|d", lisp.NewInt(-5), "|ab\ncd", "nil"},
+		5: {"ab|\ncd", lisp.NewInt(1), "ab|\ncd", "nil"},
+	}, endOfLine)
+}
+
+func TestForwardWord(t *testing.T) {
+	runMotionTests(t, "forward-word", []motionTest{
+		0: {"|foo bar", lisp.Nil, "foo| bar", "t"},
+		1: {"|foo bar", lisp.NewInt(2), "foo bar|", "t"},
+		2: {"|foo bar", lisp.NewInt(3), "foo bar|", "nil"},
+		3: {"foo ba|r", lisp.NewInt(-1), "foo |bar", "t"},
+		4: {"foo ba|r", lisp.NewInt(-2), "|foo bar", "t"},
+		5: {" foo ba|r", lisp.NewInt(-3), "| foo bar", "nil"},
+		6: {"|(x1, $y)", lisp.NewInt(2), "(x1, $y|)", "t"},
+		7: {"|...", lisp.NewInt(0), "|...", "t"},
+		8: {"|ab_cd-ef", lisp.NewInt(2), "ab_cd|-ef", "t"},
+		9: {"|привет, мир", lisp.NewInt(2), "привет, мир|", "t"},
+	}, func(buf *lisp.Buffer, arg *lisp.Object) (lisp.Object, error) {
+		return forwardWord(buf, newStandardSyntaxTable(), arg)
+	})
+
+	// Word motion follows syntax table modifications.
+	st := newStandardSyntaxTable()
+	st.SetEntry('_', lisp.SyntaxEntry{Class: lisp.SyntaxWord})
+	buf := newTestBuffer("|ab_cd-ef")
+	arg := lisp.NewInt(1)
+	forwardWord(buf, st, &arg)
+	if have := bufferString(buf); have != "ab_cd|-ef" {
+		t.Errorf("forward-word with `_` as word: have %q", have)
+	}
+}
+
+func TestCurrentColumn(t *testing.T) {
+	tests := []struct {
+		text     string
+		tabWidth int
+		want     int
+	}{
+		{"|", 8, 0},
+		{"abc|", 8, 3},
+		{"abc\nd|", 8, 1},
+		{"\t|", 8, 8},
+		{"ab\t|", 4, 4},
+		{"abcd\t|", 4, 8},
+		{"\t\tx|", 2, 5},
+		{"\x01|", 8, 2},
+		{"a\t|", 0, 8},
+	}
+	for _, tt := range tests {
+		buf := newTestBuffer(tt.text)
+		buf.TabWidth = tt.tabWidth
+		have := currentColumn(buf)
+		if have != tt.want {
+			t.Errorf("current-column in %q (tab-width=%d):\nhave: %d\nwant: %d",
+				tt.text, tt.tabWidth, have, tt.want)
+		}
+	}
+}
+
+func TestIndentTo(t *testing.T) {
+	tests := []struct {
+		before  string
+		column  int64
+		useTabs bool
+		after   string
+		result  int64
+	}{
+		{"|", 3, false, "   |", 3},
+		{"|", 10, true, "\t  |", 10},
+		{"ab|", 8, true, "ab\t|", 8},
+		{"ab|", 17, true, "ab\t\t |", 17},
+		{"ab|", 6, true, "ab    |", 6},
+		{"abc|", 2, true, "abc|", 3},
+		{"x\nab|c", 5, false, "x\nab   |c", 5},
+	}
+	for _, tt := range tests {
+		buf := newTestBuffer(tt.before)
+		buf.IndentTabsMode = tt.useTabs
+		arg := lisp.NewInt(tt.column)
+		res, err := indentTo(buf, &arg)
+		if err != nil {
+			t.Errorf("indent-to %d: unexpected error: %v", tt.column, err)
+			continue
+		}
+		if have := bufferString(buf); have != tt.after {
+			t.Errorf("indent-to %d in %q: buffer mismatch:\nhave: %q\nwant: %q",
+				tt.column, tt.before, have, tt.after)
+		}
+		if res.Int() != tt.result {
+			t.Errorf("indent-to %d in %q: result mismatch:\nhave: %d\nwant: %d",
+				tt.column, tt.before, res.Int(), tt.result)
+		}
+	}
+}
+
+func TestMotionNarrowed(t *testing.T) {
+	buf := newTestBuffer("ab\ncd\nef")
+	buf.Narrow(4, 6)
+	buf.SetPoint(4)
+
+	line := lisp.NewInt(5)
+	res, _ := forwardLine(buf, &line)
+	if buf.Point() != 6 || res.Int() != 4 {
+		t.Errorf("forward-line in narrowed buffer: point=%d result=%d",
+			buf.Point(), res.Int())
+	}
+
+	word := lisp.NewInt(-5)
+	forwardWord(buf, newStandardSyntaxTable(), &word)
+	if buf.Point() != 4 {
+		t.Errorf("forward-word in narrowed buffer: point=%d", buf.Point())
+	}
+}
+
+func TestEvalMotion(t *testing.T) {
+	interp := newTestInterpreter(t)
+	interp.buffer = newTestBuffer("|foo bar\n\tbaz\n")
+
+	type (
+		consts []interface{}
+		steps  []interface{}
+	)
+	interp.LoadSteps(steps{
+		OpConstant0, `nil`,
+		OpForwardChar, `nil`,
+		OpPoint, `nil 2`,
+		OpDiscard, `nil`,
+		OpForwardWord, `t`,
+		OpPoint, `t 4`,
+		OpConstant1, `t 4 1`,
+		OpForwardLine, `t 4 0`,
+		OpConstant0, `t 4 0 nil`,
+		OpEndOfLine, `t 4 0 nil`,
+		OpCurrentColumn, `t 4 0 nil 11`,
+		OpConstant2, `t 4 0 nil 11 16`,
+		OpIndentTo, `t 4 0 nil 11 16`,
+		OpCurrentColumn, `t 4 0 nil 11 16 16`,
+		OpPoint, `t 4 0 nil 11 16 16 14`,
+	})
+	interp.Run("Motion", promoteObjects(consts{lisp.Nil, 1, 16}), nil)
+}
+
+func TestSkipChars(t *testing.T) {
+	tests := []struct {
+		before  string
+		forward bool
+		set     string
+		lim     lisp.Object
+		after   string
+		result  int64
+	}{
+		{"|  foo", true, " ", lisp.Nil, "  |foo", 2},
+		{"|foo bar", true, "a-z", lisp.Nil, "foo| bar", 3},
+		{"|foo bar", true, "^ ", lisp.Nil, "foo| bar", 3},
+		{"|foo bar", true, "a-z ", lisp.Nil, "foo bar|", 7},
+		{"|foo bar", true, "a-z", lisp.NewInt(3), "fo|o bar", 2},
+		{"|foo bar", true, "a-z", lisp.NewInt(100), "foo| bar", 3},
+		{"foo| bar", true, "a-z", lisp.NewInt(1), "foo| bar", 0},
+		{"foo bar|", false, "a-z", lisp.Nil, "foo |bar", -3},
+		{"foo bar|", false, "^f", lisp.Nil, "f|oo bar", -6},
+		{"foo bar|", false, "[:alpha:] ", lisp.NewInt(3), "fo|o bar", -5},
+		{"foo bar|", false, "[:alpha:] ", lisp.NewInt(-3), "|foo bar", -7},
+		{"x\t\n |y", false, "[:space:]", lisp.Nil, "x|\t\n y", -3},
+	}
+	st := newStandardSyntaxTable()
+	for _, tt := range tests {
+		buf := newTestBuffer(tt.before)
+		set := lisp.NewString([]byte(tt.set))
+		res, err := skipChars(buf, st, tt.forward, &set, &tt.lim)
+		if err != nil {
+			t.Errorf("skip-chars %q in %q: unexpected error: %v",
+				tt.set, tt.before, err)
+			continue
+		}
+		if have := bufferString(buf); have != tt.after {
+			t.Errorf("skip-chars %q in %q: buffer mismatch:\nhave: %q\nwant: %q",
+				tt.set, tt.before, have, tt.after)
+		}
+		if res.Int() != tt.result {
+			t.Errorf("skip-chars %q in %q: result mismatch:\nhave: %d\nwant: %d",
+				tt.set, tt.before, res.Int(), tt.result)
+		}
+	}
+
+	buf := newTestBuffer("a-b|c-d")
+	buf.Narrow(2, 6)
+	set := lisp.NewString([]byte("a-z-"))
+	res, _ := skipChars(buf, st, true, &set, &lisp.Nil)
+	if res.Int() != 2 || buf.Point() != 6 {
+		t.Errorf("skip-chars-forward in narrowed buffer: point=%d result=%d",
+			buf.Point(), res.Int())
+	}
+	res, _ = skipChars(buf, st, false, &set, &lisp.Nil)
+	if res.Int() != -4 || buf.Point() != 2 {
+		t.Errorf("skip-chars-backward in narrowed buffer: point=%d result=%d",
+			buf.Point(), res.Int())
+	}
+}
+
+func TestEvalSkipChars(t *testing.T) {
+	interp := newTestInterpreter(t)
+	interp.buffer = newTestBuffer("|  foo-bar baz")
+
+	type (
+		consts []interface{}
+		steps  []interface{}
+	)
+	interp.LoadSteps(steps{
+		OpConstant0, `" "`,
+		OpConstant2, `" " nil`,
+		OpSkipCharsForward, `2`,
+		OpConstant1, `2 "a-z-"`,
+		OpConstant2, `2 "a-z-" nil`,
+		OpSkipCharsForward, `2 7`,
+		OpConstant1, `2 7 "a-z-"`,
+		OpConstant3, `2 7 "a-z-" 6`,
+		OpSkipCharsBackward, `2 7 -4`,
+	})
+	interp.Run("SkipChars", promoteObjects(consts{
+		lisp.NewString([]byte(" ")),
+		lisp.NewString([]byte("a-z-")),
+		lisp.Nil,
+		6,
+	}), nil)
+}
+
+func TestMotionSubrs(t *testing.T) {
+	env, ob := newLispEnv()
+	env.buffer = newTestBuffer("|foo bar\n\tbaz\n")
+
+	tests := []struct {
+		form string
+		want string
+	}{
+		{"(forward-char)", "nil"},
+		{"(point)", "2"},
+		{"(forward-word)", "t"},
+		{"(point)", "4"},
+		{"(forward-line 1)", "0"},
+		{"(point)", "9"},
+		{"(end-of-line)", "nil"},
+		{"(list (point) (current-column))", "(13 11)"},
+		{"(indent-to 16)", "16"},
+		{"(list (point) (current-column))", "(14 16)"},
+		{"(indent-to 10 2)", "18"},
+		{"(forward-char 100)", "End of buffer"},
+		{"(forward-char -100)", "Beginning of buffer"},
+		{"(forward-line -10)", "-10"},
+		{"(list (forward-word -1) (point))", "(nil 1)"},
+		{"(forward-char 'a)", "Wrong type argument: integerp, a"},
+		{"(indent-to nil)", "Wrong type argument: integerp, nil"},
+		{"(funcall (byte-compile (lambda () (forward-line 1) (end-of-line) (current-column))))", "18"},
+	}
+	for _, test := range tests {
+		val, err := env.Eval(mustRead(t, test.form, ob), lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
+
+func TestSkipCharsSubrs(t *testing.T) {
+	env, ob := newLispEnv()
+	env.buffer = newTestBuffer("|  foo-bar baz")
+
+	tests := []struct {
+		form string
+		want string
+	}{
+		{`(skip-chars-forward " ")`, "2"},
+		{`(skip-chars-forward "a-z-")`, "7"},
+		{`(skip-chars-backward "a-z-" 6)`, "-4"},
+		{"(point)", "6"},
+		{`(skip-chars-forward "^z")`, "7"},
+		{`(skip-chars-backward "[:alpha:] -")`, "-12"},
+		{`(skip-chars-forward 1)`, "Wrong type argument: stringp, 1"},
+		{`(skip-chars-forward " " 'a)`, "Wrong type argument: integerp, a"},
+		{`(funcall (byte-compile (lambda () (skip-chars-forward "^r"))))`, "8"},
+	}
+	for _, test := range tests {
+		val, err := env.Eval(mustRead(t, test.form, ob), lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// charClass is a set of ISO C character classes, like [:alpha:].
+// Every class occupies a single bit.
+type charClass uint32
+
+// All character classes that are recognized inside char sets.
+const (
+	classAlnum charClass = 1 << iota
+	classAlpha
+	classASCII
+	classBlank
+	classCntrl
+	classDigit
+	classGraph
+	classLower
+	classMultibyte
+	classNonASCII
+	classPrint
+	classPunct
+	classSpace
+	classUnibyte
+	classUpper
+	classWord
+	classXDigit
+)
+
+// charClassByName maps class name (without brackets and colons)
+// to its bit.
+var charClassByName = map[string]charClass{
+	"alnum":     classAlnum,
+	"alpha":     classAlpha,
+	"ascii":     classASCII,
+	"blank":     classBlank,
+	"cntrl":     classCntrl,
+	"digit":     classDigit,
+	"graph":     classGraph,
+	"lower":     classLower,
+	"multibyte": classMultibyte,
+	"nonascii":  classNonASCII,
+	"print":     classPrint,
+	"punct":     classPunct,
+	"space":     classSpace,
+	"unibyte":   classUnibyte,
+	"upper":     classUpper,
+	"word":      classWord,
+	"xdigit":    classXDigit,
+}
+
+// has reports whether c belongs to any of the classes in cc.
+// Syntax-based classes are checked against st.
+func (cc charClass) has(st *lisp.SyntaxTable, c rune) bool {
+	if cc&classAlnum != 0 && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+		return true
+	}
+	if cc&classAlpha != 0 && unicode.IsLetter(c) {
+		return true
+	}
+	if cc&(classASCII|classUnibyte) != 0 && c < utf8.RuneSelf {
+		return true
+	}
+	if cc&classBlank != 0 && (c == '\t' || unicode.Is(unicode.Zs, c)) {
+		return true
+	}
+	if cc&classCntrl != 0 && c < ' ' {
+		return true
+	}
+	if cc&classDigit != 0 && c >= '0' && c <= '9' {
+		return true
+	}
+	if cc&classGraph != 0 && c > ' ' && c != 0x7F && unicode.IsGraphic(c) {
+		return true
+	}
+	if cc&classLower != 0 && unicode.IsLower(c) {
+		return true
+	}
+	if cc&(classMultibyte|classNonASCII) != 0 && c >= utf8.RuneSelf {
+		return true
+	}
+	if cc&classPrint != 0 && c >= ' ' && c != 0x7F && unicode.IsGraphic(c) {
+		return true
+	}
+	if cc&classPunct != 0 {
+		if c < utf8.RuneSelf {
+			if c > ' ' && c < 0x7F && !isASCIIAlnum(c) {
+				return true
+			}
+		} else if st.Class(c) != lisp.SyntaxWord {
+			return true
+		}
+	}
+	if cc&classSpace != 0 && st.Class(c) == lisp.SyntaxWhitespace {
+		return true
+	}
+	if cc&classUpper != 0 && unicode.IsUpper(c) {
+		return true
+	}
+	if cc&classWord != 0 && st.Class(c) == lisp.SyntaxWord {
+		return true
+	}
+	if cc&classXDigit != 0 && isHexDigit(c) {
+		return true
+	}
+	return false
+}
+
+// charRange is an inclusive range of chars.
+type charRange struct {
+	lo, hi rune
+}
+
+// charSet is a compiled set of chars that can be tested
+// for membership efficiently.
+//
+// ASCII chars are looked up in a bitmap;
+// other chars are matched against ranges and classes.
+type charSet struct {
+	// ascii is a bitmap of explicitly listed ASCII chars.
+	ascii [4]uint32
+
+	// ranges holds non-ASCII chars and ranges.
+	ranges []charRange
+
+	// classes is a set of [:class:] entries.
+	classes charClass
+
+	// negated is true for complemented sets, like "^a-z".
+	negated bool
+
+	// syntax is a table that is used for syntax-based classes.
+	syntax *lisp.SyntaxTable
+}
+
+// addRange adds all chars from [lo, hi] range.
+// Empty ranges (lo>hi) are ignored.
+func (cs *charSet) addRange(lo, hi rune) {
+	for ; lo <= hi && lo < utf8.RuneSelf; lo++ {
+		cs.ascii[lo/32] |= 1 << uint(lo%32)
+	}
+	if lo <= hi {
+		cs.ranges = append(cs.ranges, charRange{lo: lo, hi: hi})
+	}
+}
+
+// has reports whether c is a member of cs.
+func (cs *charSet) has(c rune) bool {
+	return cs.contains(c) != cs.negated
+}
+
+// contains is like has, but ignores negation.
+func (cs *charSet) contains(c rune) bool {
+	if c < utf8.RuneSelf && c >= 0 {
+		if cs.ascii[c/32]&(1<<uint(c%32)) != 0 {
+			return true
+		}
+	} else {
+		for _, r := range cs.ranges {
+			if c >= r.lo && c <= r.hi {
+				return true
+			}
+		}
+	}
+	return cs.classes != 0 && cs.classes.has(cs.syntax, c)
+}
+
+// parseSkipChars compiles `skip-chars-forward` set syntax into charSet.
+//
+// The syntax is like the inside of a regexp bracket expression:
+// "a-z" denotes a range, leading "^" negates the set,
+// "[:alpha:]" denotes a character class;
+// unlike brackets, "\" quotes the following char.
+// Syntax-based classes, like [:word:], use st.
+func parseSkipChars(s []byte, st *lisp.SyntaxTable) (*charSet, error) {
+	cs := &charSet{syntax: st}
+	str := []rune(string(s))
+	i := 0
+
+	if len(str) != 0 && str[0] == '^' {
+		cs.negated = true
+		i++
+	}
+
+	for i < len(str) {
+		c := str[i]
+		i++
+
+		if c == '[' && i < len(str) && str[i] == ':' {
+			if class, n, ok := scanClassName(str[i+1:]); ok {
+				cc, ok := charClassByName[class]
+				if !ok {
+					return nil, signal(SymError,
+						lisp.NewString([]byte("Invalid ISO C character class")))
+				}
+				cs.classes |= cc
+				i += n + 1
+				continue
+			}
+		}
+
+		if c == '\\' {
+			if i == len(str) {
+				break
+			}
+			c = str[i]
+			i++
+		}
+
+		if i+1 < len(str) && str[i] == '-' {
+			c2 := str[i+1]
+			i += 2
+			if c2 == '\\' && i < len(str) {
+				c2 = str[i]
+				i++
+			}
+			cs.addRange(c, c2)
+		} else {
+			cs.addRange(c, c)
+		}
+	}
+
+	return cs, nil
+}
+
+// scanClassName tries to read "name:]" class name suffix from str.
+// Returns class name and the number of consumed chars.
+func scanClassName(str []rune) (string, int, bool) {
+	end := 0
+	for end < len(str) && str[end] >= 'a' && str[end] <= 'z' {
+		end++
+	}
+	if end == 0 || end+1 >= len(str) || str[end] != ':' || str[end+1] != ']' {
+		return "", 0, false
+	}
+	return string(str[:end]), end + 2, true
+}
+
+func isASCIIAlnum(c rune) bool {
+	return (c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+func isHexDigit(c rune) bool {
+	return (c >= '0' && c <= '9') ||
+		(c >= 'a' && c <= 'f') ||
+		(c >= 'A' && c <= 'F')
+}
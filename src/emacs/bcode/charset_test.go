@@ -0,0 +1,62 @@
+package bcode
+
+import (
+	"testing"
+)
+
+func TestParseSkipChars(t *testing.T) {
+	tests := []struct {
+		set     string
+		members string
+		others  string
+	}{
+		{"", "", "a -^"},
+		{"abc", "abc", "dA-"},
+		{"a-c", "abc", "d-"},
+		{"a-", "a-", "b"},
+		{"-a", "-a", "b"},
+		{"^a-c", "dz -", "abc"},
+		{"^", "abc^", ""},
+		{"x^", "x^", "y"},
+		{`\^a`, "^a", "b"},
+		{`a\-c`, "a-c", "b"},
+		{`a-\z`, "amz", "A"},
+		{`\`, "", `\`},
+		{"c-a", "", "abc"},
+		{"[:digit:]", "059", "a:[]"},
+		{"[:alpha:]_", "aZ_λ", "1 -"},
+		{"[:space:][:punct:]", " \t\n.,!", "a1"},
+		{"^[:upper:]", "a1 ", "AZ"},
+		{"[:xdigit:]", "09afAF", "gG"},
+		{"[:ascii:]", "a\x01~", "λ"},
+		{"[:nonascii:]", "λж", "a"},
+		{"[a", "[a", ":b"},
+		{"[:", "[:", "a"},
+		{"[:alpha", "[:alph", "b"},
+		{"ж-я", "жия", "а"},
+	}
+
+	st := newStandardSyntaxTable()
+	for _, tt := range tests {
+		cs, err := parseSkipChars([]byte(tt.set), st)
+		if err != nil {
+			t.Errorf("parse %q: unexpected error: %v", tt.set, err)
+			continue
+		}
+		for _, c := range tt.members {
+			if !cs.has(c) {
+				t.Errorf("%q set: %q must be a member", tt.set, c)
+			}
+		}
+		for _, c := range tt.others {
+			if cs.has(c) {
+				t.Errorf("%q set: %q must not be a member", tt.set, c)
+			}
+		}
+	}
+
+	_, err := parseSkipChars([]byte("[:nosuchclass:]"), st)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymError {
+		t.Errorf("expected error signal for invalid class, got %v", err)
+	}
+}
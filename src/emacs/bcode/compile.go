@@ -0,0 +1,1294 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"fmt"
+)
+
+// Byte compiler.
+//
+// Compile translates (lambda ARGS . BODY) forms into compiled
+// functions, like Emacs byte compiler does for lexical-binding code:
+//
+//	- arguments and let-bound variables live on the data stack,
+//	  they are accessed by stack-ref and stack-set;
+//	- special (defvar'ed) variables are accessed by varref and
+//	  varset and bound by varbind;
+//	- calls of primitives like car or + become their opcodes;
+//	- macros and compiler macros are expanded at compile time;
+//	- constants are pooled: eq constants share one slot.
+//
+// Nested lambdas that refer to local variables of the enclosing
+// function become closures, like in Emacs: the prototype function
+// gets the captured values as its first constants and the
+// enclosing function copies it with `make-closure`.
+// Captured variables that setq changes are boxed: their slots
+// hold cons cells with the value in the car, which the function
+// and its closures share.
+//
+// catch, condition-case and unwind-protect are compiled into
+// pushcatch, pushconditioncase and pushunwind handlers.
+// save-excursion, save-restriction and save-current-buffer
+// become their opcodes that record the editor state, which
+// unbind restores after the body; save-match-data saves match
+// data in a local variable, like its Emacs macro does.
+// defvar and defconst are not compiled: they are top-level
+// forms for the interpreter.
+//
+// Generated code is checked by Verify.
+// If MasterEnv.Optimize is set, it is optimized by Optimize.
+
+// primOp is an opcode that replaces a function call.
+type primOp struct {
+	op    byte
+	nargs int
+}
+
+// primOps maps functions that have opcodes to their opcodes.
+// Calls with a different number of arguments are compiled
+// as ordinary calls.
+var primOps = map[string]primOp{
+	"car":  {OpCar, 1},
+	"cdr":  {OpCdr, 1},
+	"cons": {OpCons, 2},
+	"eq":   {OpEq, 2},
+	"not":  {OpNot, 1},
+	"null": {OpNot, 1},
+	"1+":   {OpAdd1, 1},
+	"1-":   {OpSub1, 1},
+	"+":    {OpPlus, 2},
+	"-":    {OpDiff, 2},
+	"*":    {OpMult, 2},
+	"=":    {OpEqlsign, 2},
+	">":    {OpGtr, 2},
+	"<":    {OpLss, 2},
+	"<=":   {OpLeq, 2},
+	">=":   {OpGeq, 2},
+}
+
+// Functions that compiled code calls.
+// They are added to obarray by AddSymbols.
+var (
+	symMakeClosure  = lisp.NewSymbol("make-closure")
+	symMatchData    = lisp.NewSymbol("match-data")
+	symSetMatchData = lisp.NewSymbol("set-match-data")
+)
+
+// formCompiler compiles special form with unevaluated args.
+type formCompiler func(c *compiler, args lisp.Object) error
+
+// formCompilers maps special form symbols to their compilers.
+// Special forms that are not listed can't be compiled.
+var formCompilers map[*lisp.Symbol]formCompiler
+
+func init() {
+	formCompilers = map[*lisp.Symbol]formCompiler{
+		symQuote.Symbol():                  compileQuote,
+		symFunction.Symbol():               compileFunction,
+		SymLambda.Symbol():                 compileLambdaForm,
+		symProgn.Symbol():                  compileProgn,
+		symProg1.Symbol():                  compileProg1,
+		symProg2.Symbol():                  compileProg2,
+		symIf.Symbol():                     compileIf,
+		symCond.Symbol():                   compileCond,
+		symAnd.Symbol():                    compileAnd,
+		symOr.Symbol():                     compileOr,
+		symWhile.Symbol():                  compileWhile,
+		symCatch.Symbol():                  compileCatch,
+		symConditionCase.Symbol():          compileConditionCase,
+		symUnwindProtect.Symbol():          compileUnwindProtect,
+		symSaveExcursion.Symbol():          saveFormCompiler(OpSaveExcursion),
+		symSaveRestriction.Symbol():        saveFormCompiler(OpSaveRestriction),
+		symSaveCurrentBuffer.Symbol():      saveFormCompiler(OpSaveCurrentBuffer2),
+		symSaveMatchData.Symbol():          compileSaveMatchData,
+		symLet.Symbol():                    compileLet,
+		symLetStar.Symbol():                compileLetStar,
+		symSetq.Symbol():                   compileSetq,
+		symWithOutputToTempBuffer.Symbol(): compileWithOutputToTempBuffer,
+		symInteractive.Symbol():            compileInteractive,
+	}
+}
+
+// Compile implements `byte-compile` for function values.
+// It compiles lambda, a (lambda ARGS . BODY) list, into
+// a verified function.
+// Interpreted closures that capture nothing,
+// (closure (t) ARGS . BODY), are accepted as well.
+//
+// Variables that are special at the moment of compilation
+// are bound dynamically.
+func (env *Env) Compile(lambda lisp.Object) (*Func, error) {
+	if lambda.Type != lisp.TypeCons {
+		return nil, signal(SymInvalidFunction, lambda)
+	}
+	var params, body lisp.Object
+	xs := listSlice(lambda)
+	switch {
+	case xs[0].Ptr == SymLambda.Ptr && len(xs) >= 2:
+		params, body = xs[1], nthcdr(2, lambda)
+	case xs[0].Ptr == SymClosure.Ptr && len(xs) >= 3:
+		for _, b := range listSlice(xs[1]) {
+			if b.Ptr != lisp.T.Ptr {
+				return nil, compileError("Can't compile closure that captures variables: %s",
+					lisp.Prin1String(lambda))
+			}
+		}
+		params, body = xs[2], nthcdr(3, lambda)
+	default:
+		return nil, signal(SymInvalidFunction, lambda)
+	}
+
+	// Closure conversion looks for variable references,
+	// so all macros are expanded first.
+	var forms []lisp.Object
+	for _, form := range listSlice(body) {
+		form, err := env.MacroExpandAll(form, lisp.Nil)
+		if err != nil {
+			return nil, err
+		}
+		forms = append(forms, form)
+	}
+	fn, _, err := env.compileLambda(nil, params, lisp.List(forms...))
+	return fn, err
+}
+
+// compiler holds the state of a single function compilation.
+type compiler struct {
+	env *Env
+
+	// outer is the compiler of the enclosing function;
+	// nil for the outermost function.
+	outer *compiler
+
+	code   []byte
+	consts []lisp.Object
+
+	// locals are lexical variables in scope, innermost last.
+	locals []localVar
+
+	// captured are the variables of the enclosing functions
+	// that the closure refers to.
+	captured []localVar
+
+	// depth is the current stack depth;
+	// maxDepth is the largest depth seen so far.
+	depth, maxDepth int
+
+	// labels are jump target pcs; -1 for labels
+	// that are not placed yet.
+	labels []int
+
+	// jumps are unresolved jump instructions.
+	jumps []compJump
+}
+
+// localVar is a lexical variable that lives in the stack slot.
+// Captured variables live in the constant with slot index.
+type localVar struct {
+	sym  lisp.Object
+	slot int
+
+	// captured is set for the variables of the
+	// enclosing functions.
+	captured bool
+
+	// boxed is set if the slot holds a cons cell
+	// with the variable value in its car.
+	boxed bool
+}
+
+// compJump is a jump instruction that waits for its label.
+type compJump struct {
+	// pc is the jump operand offset.
+	pc    int
+	label int
+}
+
+// compileError returns byte compiler error with message
+// formatted like fmt.Sprintf does.
+func compileError(format string, args ...interface{}) error {
+	return signal(SymError, lisp.NewString([]byte(fmt.Sprintf(format, args...))))
+}
+
+// compileLambda compiles function with params and body.
+// outer is the compiler of the enclosing function or nil.
+// Returns the function along with the variables of outer
+// that it captures; they are its first constants.
+func (env *Env) compileLambda(outer *compiler, params, body lisp.Object) (*Func, []localVar, error) {
+	c := &compiler{env: env, outer: outer}
+
+	// Arguments occupy the stack slots in order,
+	// the &rest list is the last one.
+	var args []lisp.Object
+	optArgs, optional, rest := 0, false, false
+	xs := listSlice(params)
+	for i, sym := range xs {
+		if sym.Type != lisp.TypeSymbol {
+			return nil, nil, wrongTypeArgument(SymSymbolp, sym)
+		}
+		switch {
+		case sym.Ptr == symOptional.Ptr:
+			if optional || rest {
+				return nil, nil, compileError("Misplaced &optional in lambda list")
+			}
+			optional = true
+			continue
+		case sym.Ptr == symRest.Ptr:
+			switch {
+			case rest:
+				return nil, nil, compileError("Misplaced &rest in lambda list")
+			case i == len(xs)-1:
+				return nil, nil, compileError("&rest without variable name")
+			case i != len(xs)-2:
+				return nil, nil, compileError("Garbage following &rest VAR in lambda list")
+			}
+			rest = true
+			continue
+		}
+		if _, ok := constantValue(sym); ok {
+			return nil, nil, signal(SymSettingConstant, sym)
+		}
+		if optional && !rest {
+			optArgs++
+		}
+		args = append(args, sym)
+	}
+
+	if outer != nil {
+		c.captureVars(args, body)
+	}
+
+	c.depth = len(args)
+	c.maxDepth = len(args)
+
+	// Special arguments are bound dynamically,
+	// their stack slots are not used.
+	bound := 0
+	for i, sym := range args {
+		if !env.declaredSpecial(sym) {
+			continue
+		}
+		c.emitStackRef(i)
+		c.emitFamily(-1, OpVarBind0, c.constIndex(sym))
+		bound++
+	}
+	for i, sym := range args {
+		if !env.declaredSpecial(sym) {
+			c.addLocal(sym, i, body)
+		}
+	}
+
+	// Skip docstring; (interactive ...) is compiled into nil.
+	if xs := listSlice(body); len(xs) > 1 && xs[0].Type == lisp.TypeString {
+		body = body.Cons().Cdr
+	}
+	if err := c.compileBody(body); err != nil {
+		return nil, nil, err
+	}
+	c.emitUnbind(bound)
+	c.emit(-1, OpReturn)
+	fn, err := c.finish(len(args), optArgs, rest)
+	return fn, c.captured, err
+}
+
+// captureVars finds the variables of the enclosing functions
+// that body refers to and makes them the first constants.
+// The constants are placeholders that make-closure replaces
+// with the variable values or boxes.
+// Variables that are shadowed by params are not captured.
+func (c *compiler) captureVars(params []lisp.Object, body lisp.Object) {
+	shadowed := func(sym lisp.Object) bool {
+		for _, p := range params {
+			if p.Ptr == sym.Ptr {
+				return true
+			}
+		}
+		return false
+	}
+	for _, form := range listSlice(body) {
+		walkVars(form, false, func(sym lisp.Object, closure, set bool) {
+			if shadowed(sym) || c.local(sym) != nil {
+				return
+			}
+			v := c.outer.local(sym)
+			if v == nil {
+				return
+			}
+			c.captured = append(c.captured, localVar{
+				sym:      sym,
+				slot:     len(c.consts),
+				captured: true,
+				boxed:    v.boxed,
+			})
+			c.consts = append(c.consts, lisp.NewSymbol(sym.Symbol().Name))
+		})
+	}
+}
+
+// addLocal makes sym a lexical variable that lives in slot.
+// scope lists the forms where the variable is visible;
+// if closures there capture it and it is changed by setq,
+// the slot value is boxed.
+func (c *compiler) addLocal(sym lisp.Object, slot int, scope lisp.Object) {
+	v := localVar{sym: sym, slot: slot}
+	if needsBox(sym, scope) {
+		c.emitStackRef(slot)
+		c.emitConst(lisp.Nil)
+		c.emit(-1, OpCons)
+		c.emitStackSet(slot)
+		v.boxed = true
+	}
+	c.locals = append(c.locals, v)
+}
+
+// needsBox reports whether lexical variable sym must be boxed:
+// forms capture it in a closure and change it by setq.
+func needsBox(sym, forms lisp.Object) bool {
+	captured, set := false, false
+	for _, form := range listSlice(forms) {
+		walkVars(form, false, func(v lisp.Object, closure, isSet bool) {
+			if v.Ptr == sym.Ptr {
+				captured = captured || closure
+				set = set || isSet
+			}
+		})
+	}
+	return captured && set
+}
+
+// walkVars calls visit for every variable reference that
+// form makes: for the symbols that are evaluated and the
+// ones that setq sets (set is true).
+// closure is true for the references inside closures,
+// which are lambda bodies. Macros must be expanded.
+func walkVars(form lisp.Object, closure bool, visit func(sym lisp.Object, closure, set bool)) {
+	walkBody := func(forms lisp.Object, closure bool) {
+		for _, form := range listSlice(forms) {
+			walkVars(form, closure, visit)
+		}
+	}
+	switch form.Type {
+	case lisp.TypeSymbol:
+		if _, ok := constantValue(form); !ok {
+			visit(form, closure, false)
+		}
+		return
+	case lisp.TypeCons:
+	default:
+		return
+	}
+
+	head, args := form.Cons().Car, form.Cons().Cdr
+	if isLambda(head) {
+		walkBody(nthcdr(2, head), true)
+		walkBody(args, closure)
+		return
+	}
+	if head.Type != lisp.TypeSymbol {
+		walkBody(args, closure)
+		return
+	}
+	xs := listSlice(args)
+	switch head.Symbol() {
+	case symQuote.Symbol():
+	case symFunction.Symbol():
+		if len(xs) == 1 && isLambda(xs[0]) {
+			walkBody(nthcdr(2, xs[0]), true)
+		}
+	case SymLambda.Symbol():
+		walkBody(nthcdr(1, args), true)
+	case symSetq.Symbol():
+		for i := 0; i+1 < len(xs); i += 2 {
+			if xs[i].Type == lisp.TypeSymbol {
+				visit(xs[i], closure, true)
+			}
+			walkVars(xs[i+1], closure, visit)
+		}
+	case symCond.Symbol():
+		for _, clause := range xs {
+			walkBody(clause, closure)
+		}
+	case symLet.Symbol(), symLetStar.Symbol():
+		if len(xs) == 0 {
+			return
+		}
+		for _, b := range listSlice(xs[0]) {
+			if b.Type == lisp.TypeCons {
+				walkBody(b.Cons().Cdr, closure)
+			}
+		}
+		walkBody(nthcdr(1, args), closure)
+	case symConditionCase.Symbol():
+		if len(xs) < 2 {
+			return
+		}
+		walkVars(xs[1], closure, visit)
+		for _, clause := range xs[2:] {
+			if clause.Type == lisp.TypeCons {
+				walkBody(clause.Cons().Cdr, closure)
+			}
+		}
+	default:
+		walkBody(args, closure)
+	}
+}
+
+// finish resolves jumps and builds the verified function
+// with nargs argument slots.
+func (c *compiler) finish(nargs, optArgs int, rest bool) (*Func, error) {
+	code := c.relaxJumps()
+	if len(c.consts) > 0xFFFF || len(code) > 0xFFFF {
+		return nil, compileError("Function is too large")
+	}
+	fn, err := NewFunc(code, c.consts, nargs)
+	if err != nil {
+		return nil, err
+	}
+	if int(fn.maxStack)+nargs != c.maxDepth {
+		return nil, compileError("Stack depth mismatch: compiler %d, verifier %d",
+			c.maxDepth, int(fn.maxStack)+nargs)
+	}
+	fn.optArgs = uint32(optArgs)
+	fn.rest = rest
+	if c.env.Optimize {
+		return Optimize(fn)
+	}
+	return fn, nil
+}
+
+// compRelJumps maps jump opcodes to their relative forms.
+var compRelJumps = map[byte]byte{
+	OpGotoW:                OpRgotoB,
+	OpGotoIfNilW:           OpRgotoIfNilB,
+	OpGotoIfNonNilW:        OpRgotoIfNonNilB,
+	OpGotoIfNilElsePopW:    OpRgotoIfNilElsePopB,
+	OpGotoIfNonNilElsePopW: OpRgotoIfNonNilElsePopB,
+}
+
+// relaxJumps returns the code with jumps resolved.
+//
+// Jumps are emitted in the 3-byte absolute form; the ones that
+// have a relative form are shortened to 2 bytes when their targets
+// are close enough. Shortening moves other targets closer, so
+// jumps start short and the ones that can't reach their targets
+// are widened until the layout stops changing, like Optimize does.
+func (c *compiler) relaxJumps() []byte {
+	short := make([]bool, len(c.jumps))
+	for i, j := range c.jumps {
+		_, short[i] = compRelJumps[c.code[j.pc-1]]
+	}
+	// newPC maps pc of the emitted code to the relaxed one.
+	// Jumps are sorted by pc, each short one saves a byte.
+	newPC := func(pc int) int {
+		saved := 0
+		for i, j := range c.jumps {
+			if j.pc-1 >= pc {
+				break
+			}
+			if short[i] {
+				saved++
+			}
+		}
+		return pc - saved
+	}
+	for widened := true; widened; {
+		widened = false
+		for i, j := range c.jumps {
+			if !short[i] {
+				continue
+			}
+			offset := newPC(c.labels[j.label]) - newPC(j.pc-1) + 126
+			if offset < 0 || offset > 0xFF {
+				short[i] = false
+				widened = true
+			}
+		}
+	}
+
+	code := make([]byte, 0, len(c.code))
+	pc := 0
+	for i, j := range c.jumps {
+		code = append(code, c.code[pc:j.pc-1]...)
+		op := c.code[j.pc-1]
+		target := newPC(c.labels[j.label])
+		if short[i] {
+			offset := target - newPC(j.pc-1) + 126
+			code = append(code, compRelJumps[op], byte(offset))
+		} else {
+			code = append(code, op, byte(target), byte(target>>8))
+		}
+		pc = j.pc + 2
+	}
+	return append(code, c.code[pc:]...)
+}
+
+// emit appends instruction with delta stack effect.
+func (c *compiler) emit(delta int, code ...byte) {
+	c.code = append(c.code, code...)
+	c.depth += delta
+	if c.depth > c.maxDepth {
+		c.maxDepth = c.depth
+	}
+}
+
+// emitFamily emits instruction of opcode family that starts
+// with op0 (like OpVarRef0): operands below 6 are implicit,
+// larger operands use op0+6 (8bit) and op0+7 (16bit) forms.
+func (c *compiler) emitFamily(delta int, op0 byte, n int) {
+	switch {
+	case n < 6:
+		c.emit(delta, op0+byte(n))
+	case n <= 0xFF:
+		c.emit(delta, op0+6, byte(n))
+	default:
+		c.emit(delta, op0+7, byte(n), byte(n>>8))
+	}
+}
+
+// constIndex returns x index inside constant vector,
+// adding it to the vector if necessary.
+func (c *compiler) constIndex(x lisp.Object) int {
+	for i := range c.consts {
+		if lisp.Eq(&c.consts[i], &x) {
+			return i
+		}
+	}
+	c.consts = append(c.consts, x)
+	return len(c.consts) - 1
+}
+
+// emitConst pushes constant x.
+func (c *compiler) emitConst(x lisp.Object) {
+	c.emitConstIndex(c.constIndex(x))
+}
+
+// emitConstIndex pushes the constant with index n.
+func (c *compiler) emitConstIndex(n int) {
+	if n < 64 {
+		c.emit(1, OpConstant0+byte(n))
+		return
+	}
+	c.emit(1, OpConstantW, byte(n), byte(n>>8))
+}
+
+// emitStackRef pushes the value of the stack slot.
+func (c *compiler) emitStackRef(slot int) {
+	n := c.depth - 1 - slot
+	if n == 0 {
+		c.emit(1, OpDup)
+		return
+	}
+	c.emitFamily(1, OpStackRef1-1, n)
+}
+
+// emitStackSet pops the value into the stack slot.
+func (c *compiler) emitStackSet(slot int) {
+	n := c.depth - 1 - slot
+	if n <= 0xFF {
+		c.emit(-1, OpStackSetB, byte(n))
+		return
+	}
+	c.emit(-1, OpStackSetW, byte(n), byte(n>>8))
+}
+
+// emitDiscard pops n values.
+// If preserveTOS is true, values below the top are popped.
+func (c *compiler) emitDiscard(n int, preserveTOS bool) {
+	for n > 0 {
+		k := n
+		if k >= discardPreserveTOS {
+			k = discardPreserveTOS - 1
+		}
+		switch {
+		case preserveTOS:
+			c.emit(-k, OpDiscardB, byte(k|discardPreserveTOS))
+		case k == 1:
+			c.emit(-1, OpDiscard)
+		default:
+			c.emit(-k, OpDiscardB, byte(k))
+		}
+		n -= k
+	}
+}
+
+// emitUnbind removes n dynamic bindings.
+func (c *compiler) emitUnbind(n int) {
+	if n != 0 {
+		c.emitFamily(0, OpUnbind0, n)
+	}
+}
+
+// newLabel returns a label that is placed by placeLabel.
+func (c *compiler) newLabel() int {
+	c.labels = append(c.labels, -1)
+	return len(c.labels) - 1
+}
+
+// placeLabel binds label to the current pc.
+// depth is the stack depth at label.
+func (c *compiler) placeLabel(label, depth int) {
+	c.labels[label] = len(c.code)
+	c.depth = depth
+}
+
+// emitJump emits jump instruction op to label.
+func (c *compiler) emitJump(op byte, label int) {
+	delta := -1
+	if op == OpGotoW {
+		delta = 0
+	}
+	c.jumps = append(c.jumps, compJump{pc: len(c.code) + 1, label: label})
+	c.emit(delta, op, 0, 0)
+}
+
+// local returns the innermost lexical variable sym,
+// or the captured one, or nil.
+func (c *compiler) local(sym lisp.Object) *localVar {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].sym.Ptr == sym.Ptr {
+			return &c.locals[i]
+		}
+	}
+	for i := range c.captured {
+		if c.captured[i].sym.Ptr == sym.Ptr {
+			return &c.captured[i]
+		}
+	}
+	return nil
+}
+
+// emitCell pushes the slot or the constant of variable v:
+// its value or, for boxed variables, its cons cell.
+func (c *compiler) emitCell(v *localVar) {
+	if v.captured {
+		c.emitConstIndex(v.slot)
+		return
+	}
+	c.emitStackRef(v.slot)
+}
+
+// emitLocalRef pushes the value of variable v.
+func (c *compiler) emitLocalRef(v *localVar) {
+	c.emitCell(v)
+	if v.boxed {
+		c.emit(0, OpCar)
+	}
+}
+
+// outerLocal reports whether sym is a lexical variable
+// of the enclosing functions.
+func (c *compiler) outerLocal(sym lisp.Object) bool {
+	for o := c.outer; o != nil; o = o.outer {
+		if o.local(sym) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// compileForm compiles form that pushes its value.
+func (c *compiler) compileForm(form lisp.Object) error {
+	switch form.Type {
+	case lisp.TypeSymbol:
+		if val, ok := constantValue(form); ok {
+			c.emitConst(val)
+			return nil
+		}
+		if v := c.local(form); v != nil {
+			c.emitLocalRef(v)
+			return nil
+		}
+		if c.outerLocal(form) {
+			return compileError("Can't compile closure that captures variable %s",
+				lisp.Prin1String(form))
+		}
+		c.emitFamily(1, OpVarRef0, c.constIndex(form))
+		return nil
+
+	case lisp.TypeCons:
+		cons := form.Cons()
+		if cons.Car.Type == lisp.TypeSymbol {
+			if fc, ok := formCompilers[cons.Car.Symbol()]; ok {
+				return fc(c, cons.Cdr)
+			}
+			if _, ok := specialForms[cons.Car.Symbol()]; ok {
+				return compileError("Can't compile %s form", cons.Car.Symbol().Name)
+			}
+			if _, ok := c.env.macroFunction(cons.Car); ok {
+				expanded, err := c.env.MacroExpand1(form, lisp.Nil)
+				if err != nil {
+					return err
+				}
+				return c.compileForm(expanded)
+			}
+			expanded, err := c.env.compilerMacroExpand(form)
+			if err != nil {
+				return err
+			}
+			if !lisp.Eq(&expanded, &form) {
+				return c.compileForm(expanded)
+			}
+		}
+		return c.compileCall(form)
+	}
+	c.emitConst(form)
+	return nil
+}
+
+// compileEffect compiles form for its side effects only.
+func (c *compiler) compileEffect(form lisp.Object) error {
+	if err := c.compileForm(form); err != nil {
+		return err
+	}
+	c.emitDiscard(1, false)
+	return nil
+}
+
+// compileBody compiles forms like progn does.
+func (c *compiler) compileBody(body lisp.Object) error {
+	xs := listSlice(body)
+	if len(xs) == 0 {
+		c.emitConst(lisp.Nil)
+		return nil
+	}
+	for _, form := range xs[:len(xs)-1] {
+		if err := c.compileEffect(form); err != nil {
+			return err
+		}
+	}
+	return c.compileForm(xs[len(xs)-1])
+}
+
+// compileCall compiles function call form.
+func (c *compiler) compileCall(form lisp.Object) error {
+	fn := form.Cons().Car
+	args := listSlice(form.Cons().Cdr)
+	if fn.Type == lisp.TypeSymbol {
+		if p, ok := primOps[fn.Symbol().Name]; ok && p.nargs == len(args) {
+			for _, arg := range args {
+				if err := c.compileForm(arg); err != nil {
+					return err
+				}
+			}
+			c.emit(1-len(args), p.op)
+			return nil
+		}
+		if fn.Symbol().Name == "-" && len(args) == 1 {
+			if err := c.compileForm(args[0]); err != nil {
+				return err
+			}
+			c.emit(0, OpNegate)
+			return nil
+		}
+		c.emitConst(fn)
+	} else if isLambda(fn) {
+		if err := compileFunction(c, lisp.List(fn)); err != nil {
+			return err
+		}
+	} else {
+		return signal(SymInvalidFunction, fn)
+	}
+
+	for _, arg := range args {
+		if err := c.compileForm(arg); err != nil {
+			return err
+		}
+	}
+	c.emitFamily(-len(args), OpCall0, len(args))
+	return nil
+}
+
+func compileQuote(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("quote", args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c.emitConst(xs[0])
+	return nil
+}
+
+func compileFunction(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("function", args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if !isLambda(xs[0]) {
+		c.emitConst(xs[0])
+		return nil
+	}
+	lambda := listSlice(xs[0])
+	if len(lambda) < 2 {
+		return signal(SymInvalidFunction, xs[0])
+	}
+	fn, captured, err := c.env.compileLambda(c, lambda[1], nthcdr(2, xs[0]))
+	if err != nil {
+		return err
+	}
+	if len(captured) == 0 {
+		c.emitConst(fn.Object())
+		return nil
+	}
+	c.emitConst(symMakeClosure)
+	c.emitConst(fn.Object())
+	for _, v := range captured {
+		c.emitCell(c.local(v.sym))
+	}
+	c.emitFamily(-1-len(captured), OpCall0, 1+len(captured))
+	return nil
+}
+
+// makeClosure implements `make-closure`: it returns a copy of
+// prototype with the first constants replaced by vars.
+func makeClosure(prototype *Func, vars []lisp.Object) (*Func, error) {
+	if len(vars) > len(prototype.consts) {
+		return nil, compileError("Closure vars do not fit in constvec")
+	}
+	fn := *prototype
+	fn.consts = append([]lisp.Object(nil), prototype.consts...)
+	copy(fn.consts, vars)
+	fn.translate()
+	return &fn, nil
+}
+
+func compileLambdaForm(c *compiler, args lisp.Object) error {
+	return compileFunction(c, lisp.List(lisp.NewCons(SymLambda, args)))
+}
+
+func compileProgn(c *compiler, args lisp.Object) error {
+	return c.compileBody(args)
+}
+
+func compileProg1(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("prog1", args, 1, ArityMany)
+	if err != nil {
+		return err
+	}
+	if err := c.compileForm(xs[0]); err != nil {
+		return err
+	}
+	for _, form := range xs[1:] {
+		if err := c.compileEffect(form); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileProg2(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("prog2", args, 2, ArityMany)
+	if err != nil {
+		return err
+	}
+	if err := c.compileEffect(xs[0]); err != nil {
+		return err
+	}
+	return compileProg1(c, args.Cons().Cdr)
+}
+
+func compileIf(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("if", args, 2, ArityMany)
+	if err != nil {
+		return err
+	}
+	depth := c.depth
+	elseLabel, endLabel := c.newLabel(), c.newLabel()
+	if err := c.compileForm(xs[0]); err != nil {
+		return err
+	}
+	c.emitJump(OpGotoIfNilW, elseLabel)
+	if err := c.compileForm(xs[1]); err != nil {
+		return err
+	}
+	c.emitJump(OpGotoW, endLabel)
+	c.placeLabel(elseLabel, depth)
+	if err := c.compileBody(args.Cons().Cdr.Cons().Cdr); err != nil {
+		return err
+	}
+	c.placeLabel(endLabel, depth+1)
+	return nil
+}
+
+func compileCond(c *compiler, args lisp.Object) error {
+	depth := c.depth
+	endLabel := c.newLabel()
+	for _, clause := range listSlice(args) {
+		if clause.Type != lisp.TypeCons {
+			return wrongTypeArgument(SymListp, clause)
+		}
+		if err := c.compileForm(clause.Cons().Car); err != nil {
+			return err
+		}
+		body := clause.Cons().Cdr
+		if body.Type != lisp.TypeCons {
+			// Clause without body returns the test value.
+			c.emitJump(OpGotoIfNonNilElsePopW, endLabel)
+			continue
+		}
+		nextLabel := c.newLabel()
+		c.emitJump(OpGotoIfNilW, nextLabel)
+		if err := c.compileBody(body); err != nil {
+			return err
+		}
+		c.emitJump(OpGotoW, endLabel)
+		c.placeLabel(nextLabel, depth)
+	}
+	c.emitConst(lisp.Nil)
+	c.placeLabel(endLabel, depth+1)
+	return nil
+}
+
+func compileAnd(c *compiler, args lisp.Object) error {
+	return c.compileAndOr(args, lisp.T, OpGotoIfNilElsePopW)
+}
+
+func compileOr(c *compiler, args lisp.Object) error {
+	return c.compileAndOr(args, lisp.Nil, OpGotoIfNonNilElsePopW)
+}
+
+// compileAndOr compiles `and` and `or`: every form but the last
+// one is followed by jump op that exits with its value.
+// empty is the value of the form without args.
+func (c *compiler) compileAndOr(args, empty lisp.Object, op byte) error {
+	xs := listSlice(args)
+	if len(xs) == 0 {
+		c.emitConst(empty)
+		return nil
+	}
+	endLabel := c.newLabel()
+	for _, form := range xs[:len(xs)-1] {
+		if err := c.compileForm(form); err != nil {
+			return err
+		}
+		c.emitJump(op, endLabel)
+	}
+	if err := c.compileForm(xs[len(xs)-1]); err != nil {
+		return err
+	}
+	c.placeLabel(endLabel, c.depth)
+	return nil
+}
+
+func compileWhile(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("while", args, 1, ArityMany)
+	if err != nil {
+		return err
+	}
+	depth := c.depth
+	loopLabel, endLabel := c.newLabel(), c.newLabel()
+	c.placeLabel(loopLabel, depth)
+	if err := c.compileForm(xs[0]); err != nil {
+		return err
+	}
+	c.emitJump(OpGotoIfNilW, endLabel)
+	for _, form := range xs[1:] {
+		if err := c.compileEffect(form); err != nil {
+			return err
+		}
+	}
+	c.emitJump(OpGotoW, loopLabel)
+	c.placeLabel(endLabel, depth)
+	c.emitConst(lisp.Nil)
+	return nil
+}
+
+func compileLet(c *compiler, args lisp.Object) error {
+	return c.compileLet("let", args, false)
+}
+
+func compileLetStar(c *compiler, args lisp.Object) error {
+	return c.compileLet("let*", args, true)
+}
+
+// compileLet compiles `let` and `let*`.
+// If sequential is true, each binding is made before
+// the next value is computed.
+//
+// Values are pushed to the stack, lexical variables use
+// their slots directly. Special variables are bound by
+// varbind; with parallel `let` their slots stay unused.
+func (c *compiler) compileLet(name string, args lisp.Object, sequential bool) error {
+	if _, err := argsSlice(name, args, 1, ArityMany); err != nil {
+		return err
+	}
+	depth := c.depth
+	nlocals := len(c.locals)
+	bound := 0
+	bind := func(sym lisp.Object, slot int) {
+		if c.env.declaredSpecial(sym) {
+			if slot != c.depth-1 {
+				c.emitStackRef(slot)
+			}
+			c.emitFamily(-1, OpVarBind0, c.constIndex(sym))
+			bound++
+			return
+		}
+		c.addLocal(sym, slot, args)
+	}
+
+	var syms []lisp.Object
+	for _, b := range listSlice(args.Cons().Car) {
+		sym, init, err := letBinding(b)
+		if err != nil {
+			return err
+		}
+		if sym.Type != lisp.TypeSymbol {
+			return wrongTypeArgument(SymSymbolp, sym)
+		}
+		if _, ok := constantValue(sym); ok {
+			return signal(SymSettingConstant, sym)
+		}
+		if err := c.compileForm(init); err != nil {
+			return err
+		}
+		if sequential {
+			bind(sym, c.depth-1)
+		} else {
+			syms = append(syms, sym)
+		}
+	}
+	for i, sym := range syms {
+		bind(sym, depth+i)
+	}
+
+	if err := c.compileBody(args.Cons().Cdr); err != nil {
+		return err
+	}
+	c.emitUnbind(bound)
+	c.emitDiscard(c.depth-1-depth, true)
+	c.locals = c.locals[:nlocals]
+	return nil
+}
+
+// compileCatch compiles `catch` into a pushcatch handler.
+// The handler target follows the body, so the thrown value
+// ends up where the body value would be.
+func compileCatch(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("catch", args, 1, ArityMany)
+	if err != nil {
+		return err
+	}
+	if err := c.compileForm(xs[0]); err != nil {
+		return err
+	}
+	handlerLabel := c.newLabel()
+	c.emitJump(OpPushCatch, handlerLabel)
+	if err := c.compileBody(args.Cons().Cdr); err != nil {
+		return err
+	}
+	c.emit(0, OpPopHandler)
+	c.placeLabel(handlerLabel, c.depth)
+	return nil
+}
+
+// compileConditionCase compiles `condition-case` into
+// pushconditioncase handlers, one per clause.
+// Clauses are pushed in reverse order, so the first clause is
+// the innermost handler and it is tried first, like in the
+// interpreter. The clause code pops the handlers of the
+// following clauses that remain active.
+func compileConditionCase(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("condition-case", args, 2, ArityMany)
+	if err != nil {
+		return err
+	}
+	v := xs[0]
+	if v.Type != lisp.TypeSymbol {
+		return wrongTypeArgument(SymSymbolp, v)
+	}
+	if _, ok := constantValue(v); ok && !lisp.Null(&v) {
+		return signal(SymSettingConstant, v)
+	}
+	var clauses []lisp.Object
+	for _, clause := range xs[2:] {
+		if clause.Type == lisp.TypeCons {
+			clauses = append(clauses, clause)
+		}
+	}
+
+	depth := c.depth
+	labels := make([]int, len(clauses))
+	for i := len(clauses) - 1; i >= 0; i-- {
+		labels[i] = c.newLabel()
+		c.emitConst(clauses[i].Cons().Car)
+		c.emitJump(OpPushConditionCase, labels[i])
+	}
+	if err := c.compileForm(xs[1]); err != nil {
+		return err
+	}
+	for range clauses {
+		c.emit(0, OpPopHandler)
+	}
+	endLabel := c.newLabel()
+	for i, clause := range clauses {
+		c.emitJump(OpGotoW, endLabel)
+		// The error value is on top of the stack.
+		c.placeLabel(labels[i], depth+1)
+		for range clauses[i+1:] {
+			c.emit(0, OpPopHandler)
+		}
+		if err := c.compileHandlerBody(v, depth, clause.Cons().Cdr); err != nil {
+			return err
+		}
+	}
+	c.placeLabel(endLabel, depth+1)
+	return nil
+}
+
+// compileHandlerBody compiles condition-case clause body
+// with v bound to the error value at slot.
+func (c *compiler) compileHandlerBody(v lisp.Object, slot int, body lisp.Object) error {
+	switch {
+	case lisp.Null(&v):
+		c.emitDiscard(1, false)
+		return c.compileBody(body)
+	case c.env.declaredSpecial(v):
+		c.emitFamily(-1, OpVarBind0, c.constIndex(v))
+		if err := c.compileBody(body); err != nil {
+			return err
+		}
+		c.emitUnbind(1)
+		return nil
+	}
+	c.addLocal(v, slot, body)
+	err := c.compileBody(body)
+	c.locals = c.locals[:len(c.locals)-1]
+	if err != nil {
+		return err
+	}
+	c.emitDiscard(1, true)
+	return nil
+}
+
+// compileUnwindProtect compiles `unwind-protect` into
+// a pushunwind handler that targets the unwind forms.
+// They follow the body, so they run after the body value or
+// the pending error is pushed; rethrow then signals the error.
+func compileUnwindProtect(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("unwind-protect", args, 1, ArityMany)
+	if err != nil {
+		return err
+	}
+	handlerLabel := c.newLabel()
+	c.emit(0, OpExt)
+	c.jumps = append(c.jumps, compJump{pc: len(c.code) + 1, label: handlerLabel})
+	c.emit(0, OpExtPushUnwind, 0, 0)
+	if err := c.compileForm(xs[0]); err != nil {
+		return err
+	}
+	c.emit(0, OpPopHandler)
+	c.placeLabel(handlerLabel, c.depth)
+	for _, form := range xs[1:] {
+		if err := c.compileEffect(form); err != nil {
+			return err
+		}
+	}
+	c.emit(0, OpExt, OpExtRethrow)
+	return nil
+}
+
+// saveFormCompiler returns compiler of save-excursion and
+// similar forms: op records the editor state that is restored
+// by unbind after the body.
+func saveFormCompiler(op byte) formCompiler {
+	return func(c *compiler, args lisp.Object) error {
+		c.emit(0, op)
+		if err := c.compileBody(args); err != nil {
+			return err
+		}
+		c.emitUnbind(1)
+		return nil
+	}
+}
+
+// compileSaveMatchData compiles save-match-data like
+// (let ((saved (match-data)))
+//
+//	(unwind-protect (progn BODY...) (set-match-data saved t)))
+//
+// saved is uninterned, so body can't refer to it.
+func compileSaveMatchData(c *compiler, args lisp.Object) error {
+	saved := lisp.NewSymbol("saved-match-data")
+	form := lisp.List(symLet,
+		lisp.List(lisp.List(saved, lisp.List(symMatchData))),
+		lisp.List(symUnwindProtect, lisp.NewCons(symProgn, args),
+			lisp.List(symSetMatchData, saved, lisp.T)))
+	return c.compileForm(form)
+}
+
+func compileSetq(c *compiler, args lisp.Object) error {
+	xs := listSlice(args)
+	if len(xs)%2 != 0 {
+		return signal(SymWrongNumberOfArguments,
+			symSetq, lisp.NewInt(int64(len(xs))))
+	}
+	if len(xs) == 0 {
+		c.emitConst(lisp.Nil)
+		return nil
+	}
+	for i := 0; i < len(xs); i += 2 {
+		sym := xs[i]
+		if sym.Type != lisp.TypeSymbol {
+			return wrongTypeArgument(SymSymbolp, sym)
+		}
+		// The last value is the setq result.
+		last := i == len(xs)-2
+		v := c.local(sym)
+		if v != nil && v.boxed {
+			c.emitCell(v)
+			if err := c.compileForm(xs[i+1]); err != nil {
+				return err
+			}
+			c.emit(-1, OpSetcar)
+			if !last {
+				c.emitDiscard(1, false)
+			}
+			continue
+		}
+		if err := c.compileForm(xs[i+1]); err != nil {
+			return err
+		}
+		if last {
+			c.emit(1, OpDup)
+		}
+		if v != nil && v.captured {
+			return compileError("Can't compile closure that sets captured variable %s",
+				lisp.Prin1String(sym))
+		}
+		if v != nil {
+			c.emitStackSet(v.slot)
+			continue
+		}
+		if _, ok := constantValue(sym); ok {
+			return signal(SymSettingConstant, sym)
+		}
+		if c.outerLocal(sym) {
+			return compileError("Can't compile closure that captures variable %s",
+				lisp.Prin1String(sym))
+		}
+		c.emitFamily(-1, OpVarSet0, c.constIndex(sym))
+	}
+	return nil
+}
+
+// compileWithOutputToTempBuffer compiles `with-output-to-temp-buffer`
+// into the temp-output-buffer-setup and temp-output-buffer-show pair.
+func compileWithOutputToTempBuffer(c *compiler, args lisp.Object) error {
+	xs, err := argsSlice("with-output-to-temp-buffer", args, 1, ArityMany)
+	if err != nil {
+		return err
+	}
+	if err := c.compileForm(xs[0]); err != nil {
+		return err
+	}
+	c.emit(0, OpTempOutputBufferSetup)
+	if err := c.compileBody(args.Cons().Cdr); err != nil {
+		return err
+	}
+	c.emit(-1, OpTempOutputBufferShow)
+	return nil
+}
+
+func compileInteractive(c *compiler, args lisp.Object) error {
+	c.emitConst(lisp.Nil)
+	return nil
+}
@@ -0,0 +1,528 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"emacs/reader"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// compileString compiles lambda form src.
+func compileString(env *Env, ob *lisp.Obarray, src string) (*Func, error) {
+	lambda, err := reader.ReadString(src, ob)
+	if err != nil {
+		return nil, err
+	}
+	return env.Compile(lambda)
+}
+
+// mustRead reads a single form from src.
+func mustRead(t *testing.T, src string, ob *lisp.Obarray) lisp.Object {
+	form, err := reader.ReadString(src, ob)
+	if err != nil {
+		t.Fatalf("read %s: %v", src, err)
+	}
+	return form
+}
+
+func TestCompile(t *testing.T) {
+	env, ob := newLispEnv()
+	for _, form := range []string{
+		"(defvar dyn 10)",
+		"(defalias 'get-dyn (lambda () dyn))",
+		"(defalias 'sq (lambda (x) (* x x)))",
+		"(defalias 'thrower (lambda (x) (throw 'done x)))",
+		"(defalias 'car-of (lambda (x) (car x)))",
+		"(byte-compile 'thrower)",
+		"(byte-compile 'car-of)",
+		"(defmacro quoted-x () ''x)",
+	} {
+		if _, err := env.Eval(mustRead(t, form, ob), lisp.T); err != nil {
+			t.Fatalf("%s: %v", form, err)
+		}
+	}
+
+	tests := []struct {
+		src  string
+		args string
+		want string
+	}{
+		{"(lambda ())", "()", "nil"},
+		{"(lambda () \"doc\")", "()", `"doc"`},
+		{"(lambda () \"doc\" 1)", "()", "1"},
+		{"(lambda (x) x)", "(1)", "1"},
+		{"(lambda (x y) (cons y x))", "(1 2)", "(2 . 1)"},
+		{"(lambda (a b c) (list a b c))", "(1 2 3)", "(1 2 3)"},
+		{"(lambda () (interactive) 'v)", "()", "v"},
+		{"(lambda () '(a b) #'car :key t)", "()", "t"},
+
+		// Special forms.
+		{"(lambda (x) (if x 'yes 'no))", "(t)", "yes"},
+		{"(lambda (x) (if x 'yes 'no 'really-no))", "(nil)", "really-no"},
+		{"(lambda (x) (if x 'yes))", "(nil)", "nil"},
+		{"(lambda (x) (cond ((eq x 1) 'one) ((eq x 2) 'two) (t 'many)))", "(2)", "two"},
+		{"(lambda (x) (cond ((eq x 1) 'one) ((car x))))", "((a))", "a"},
+		{"(lambda (x) (cond ((eq x 1) 'one)))", "(3)", "nil"},
+		{"(lambda (x y) (and x y))", "(1 2)", "2"},
+		{"(lambda (x y) (and x y))", "(nil 2)", "nil"},
+		{"(lambda () (and))", "()", "t"},
+		{"(lambda (x y) (or x y))", "(nil 2)", "2"},
+		{"(lambda () (or))", "()", "nil"},
+		{"(lambda (x) (prog1 x (setq x 2)))", "(1)", "1"},
+		{"(lambda (x) (prog2 (setq x 2) x 3))", "(1)", "2"},
+		{"(lambda (x) (progn (setq x (1+ x)) (setq x (1+ x))))", "(1)", "3"},
+		{"(lambda (x y) (setq x y y x))", "(1 2)", "2"},
+		{"(lambda () (setq))", "()", "nil"},
+		{"(lambda (n) (let ((s 0)) (while (> n 0) (setq s (+ s n) n (1- n))) s))", "(100)", "5050"},
+		{"(lambda (x) (let ((x 2) (y x)) (list x y)))", "(1)", "(2 1)"},
+		{"(lambda (x) (let* ((x 2) (y x)) (list x y)))", "(1)", "(2 2)"},
+		{"(lambda () (let (a (b)) (list a b)))", "()", "(nil nil)"},
+		{"(lambda (x) (let ((y 1)) (let ((z 2)) (setq x (+ y z)))) x)", "(0)", "3"},
+
+		// Special variables.
+		{"(lambda () dyn)", "()", "10"},
+		{"(lambda () (let ((dyn 20)) (get-dyn)))", "()", "20"},
+		{"(lambda (x) (let ((a 1) (dyn x) (b 2)) (list a (get-dyn) b)))", "(5)", "(1 5 2)"},
+		{"(lambda (x) (let* ((dyn x) (y (get-dyn))) y))", "(6)", "6"},
+		{"(lambda (dyn) (get-dyn))", "(7)", "7"},
+		{"(lambda () (let ((dyn 1)) (setq dyn 2) (get-dyn)))", "()", "2"},
+		{"(lambda () (get-dyn))", "()", "10"},
+
+		// Calls.
+		{"(lambda (x) (sq (sq x)))", "(3)", "81"},
+		{"(lambda () (+ 1 2 3))", "()", "6"},
+		{"(lambda (x) (- x))", "(3)", "-3"},
+		{"(lambda (x) (- x 1))", "(3)", "2"},
+		{"(lambda (x) (list (= x 1) (< x 2) (> x 2) (<= x 1) (>= x 2)))", "(1)", "(t t nil t nil)"},
+		{"(lambda (x) (not x))", "(nil)", "t"},
+		{"(lambda (x) (null x))", "(1)", "nil"},
+		{"(lambda (x) (cdr (car x)))", "(((a . b)))", "b"},
+		{"(lambda (x) (funcall (lambda (y) (* y 2)) x))", "(4)", "8"},
+		{"(lambda (x) ((lambda (y) (+ y 1)) x))", "(4)", "5"},
+		{"(lambda () (funcall (function sq) 5))", "()", "25"},
+
+		// Handlers.
+		{"(lambda () (catch 'done (throw 'done 1)))", "()", "1"},
+		{"(lambda (x) (catch 'done (+ x 1)))", "(1)", "2"},
+		{"(lambda (x) (catch 'done (thrower x) 'not-reached))", "(1)", "1"},
+		{"(lambda (x) (catch 'outer (catch 'inner (throw 'outer x)) 'not-reached))", "(1)", "1"},
+		{"(lambda (x) (catch x (throw 'done 1)))", "(other)", "No catch for tag: done, 1"},
+		{"(lambda () (catch 'done (let ((dyn 3)) (throw 'done (get-dyn)))))", "()", "3"},
+		{"(lambda () (catch 'done (let ((dyn 3)) (throw 'done nil))) (get-dyn))", "()", "10"},
+		{"(lambda (n) (let ((s 0)) (while (> n 0) (setq s (+ s (catch 'c (throw 'c n))) n (1- n))) s))", "(10)", "55"},
+		{"(lambda (x) (condition-case nil (car x) (error 'bad)))", "((1))", "1"},
+		{"(lambda (x) (condition-case nil (car x) (error 'bad)))", "(1)", "bad"},
+		{"(lambda (x) (condition-case e (car-of x) (wrong-type-argument (list 'wta (cdr e)))))", "(1)", "(wta (listp 1))"},
+		{"(lambda (x) (condition-case e (car-of x) (arith-error 'arith) ((void-variable wrong-type-argument) (car e))))", "(1)", "wrong-type-argument"},
+		{"(lambda (x) (condition-case nil (car x) (wrong-type-argument 'first) (error 'second)))", "(1)", "first"},
+		{"(lambda (x) (list (condition-case nil (car x) (wrong-type-argument 'first) (error 'second)) (condition-case nil (car-of x) (arith-error 'none))))", "(1)", "Wrong type argument: listp, 1"},
+		{"(lambda (x) (let ((y 1)) (condition-case e (car x) (error (list y (car e) x)))))", "(2)", "(1 wrong-type-argument 2)"},
+		{"(lambda () (condition-case dyn (car 1) (error (car (get-dyn)))))", "()", "wrong-type-argument"},
+		{"(lambda () (list (condition-case dyn (car 1) (error (get-dyn))) (get-dyn)))", "()", "((wrong-type-argument listp 1) 10)"},
+		{"(lambda () (catch 'done (condition-case nil (throw 'done 1) (error 'caught))))", "()", "1"},
+		{"(lambda () (condition-case e (throw 'nowhere 1) (no-catch e)))", "()", "(no-catch nowhere 1)"},
+		{"(lambda () (condition-case e (condition-case nil (car 1) (error (car 2))) (error (cdr e))))", "()", "(listp 2)"},
+		{"(lambda (x) (condition-case nil x))", "(1)", "1"},
+
+		// Unwind forms.
+		{"(lambda () (unwind-protect (catch 'done (throw 'done 1))))", "()", "1"},
+		{"(lambda (x) (unwind-protect '(x y)))", "(1)", "(x y)"},
+		{"(lambda (x) (unwind-protect (quoted-x)))", "(1)", "x"},
+		{"(lambda (x) (unwind-protect x (setq x 2)))", "(1)", "1"},
+		{"(lambda (x) (let (l) (unwind-protect (setq l (cons 1 l)) (setq l (cons 2 l))) l))", "(0)", "(2 1)"},
+		{"(lambda (x) (let (l) (list (catch 'done (unwind-protect (throw 'done x) (setq l 'unwound))) l)))", "(1)", "(1 unwound)"},
+		{"(lambda (x) (let (l) (condition-case e (unwind-protect (car-of x) (setq l 'unwound)) (error (list (car e) l)))))", "(1)", "(wrong-type-argument unwound)"},
+		{"(lambda (x) (let ((n 0)) (catch 'done (unwind-protect (unwind-protect (thrower x) (setq n (1+ n))) (setq n (* n 10)))) n))", "(1)", "10"},
+		{"(lambda (x) (unwind-protect (car x) (car-of 2)))", "(1)", "Wrong type argument: listp, 2"},
+		{"(lambda (x) (unwind-protect (car x)))", "(1)", "Wrong type argument: listp, 1"},
+		{"(lambda (x) (save-excursion (setq x (1+ x))) x)", "(1)", "2"},
+		{"(lambda (x) (save-current-buffer (list x)))", "(1)", "(1)"},
+		{"(lambda (x) (save-restriction (save-match-data x)))", "(1)", "1"},
+		{"(lambda (x) (save-excursion))", "(1)", "nil"},
+		{"(lambda (x) (insert \"abc\") (let ((p (point))) (list (save-excursion (forward-char -2) (- p (point))) (condition-case nil (save-excursion (forward-char -1) (car x)) (error (- p (point)))))))", "(1)", "(2 0)"},
+		{"(lambda (s) (string-match \"b\" s) (save-match-data (string-match \"c\" s)) (match-beginning 0))", "(\"abc\")", "1"},
+		{"(lambda (s) (string-match \"b\" s) (condition-case nil (save-match-data (string-match \"c\" s) (car s)) (error (match-beginning 0))))", "(\"abc\")", "1"},
+
+		// Optional and rest arguments.
+		{"(lambda (x &optional y) (list x y))", "(1)", "(1 nil)"},
+		{"(lambda (x &optional y) (list x y))", "(1 2)", "(1 2)"},
+		{"(lambda (x &optional y) (list x y))", "()", "Wrong number of arguments: (1 . 2), 0"},
+		{"(lambda (&rest xs) xs)", "(1 2 3)", "(1 2 3)"},
+		{"(lambda (&rest xs) xs)", "()", "nil"},
+		{"(lambda (x &optional y &rest zs) (list x y zs))", "(1 2 3 4)", "(1 2 (3 4))"},
+		{"(lambda (&optional dyn) (get-dyn))", "()", "nil"},
+
+		// Closures.
+		{"(lambda (x) (funcall (lambda () x)))", "(1)", "1"},
+		{"(lambda (x) ((lambda () x)))", "(1)", "1"},
+		{"(lambda (x) (funcall (lambda (y) (+ x y)) 2))", "(1)", "3"},
+		{"(lambda (x) (funcall (lambda (x) x) 2))", "(1)", "2"},
+		{"(lambda (x) (funcall (funcall (lambda () (lambda () x)))))", "(5)", "5"},
+		{"(lambda (x) (funcall (lambda () (setq x (1+ x)))) x)", "(1)", "2"},
+		{"(lambda (x) (let ((add (lambda (y) (+ x y)))) (setq x 10) (funcall add 1)))", "(1)", "11"},
+		{"(lambda (n) (let ((f (lambda () (setq n (1+ n))))) (funcall f) (funcall f) n))", "(0)", "2"},
+		{"(lambda (x) (let ((y 1)) (funcall (lambda () (funcall (lambda () (setq y (+ x y)))))) y))", "(2)", "3"},
+		{"(lambda (l) (let (fs) (while l (let ((x (car l))) (setq fs (cons (lambda () x) fs))) (setq l (cdr l))) (list (funcall (car fs)) (funcall (car (cdr fs))))))", "((1 2))", "(2 1)"},
+		{"(lambda (x) (condition-case e (car x) (error (funcall (lambda () (car e))))))", "(1)", "wrong-type-argument"},
+		{"(lambda (x) (funcall (lambda (&rest ys) (cons x ys)) 2 3))", "(1)", "(1 2 3)"},
+		{"(lambda (dyn) (funcall (lambda () dyn)))", "(1)", "1"},
+		{"(lambda (x) (funcall (lambda () (eq x 'a))))", "(a)", "t"},
+
+		// Runtime errors.
+		{"(lambda (x) (car x))", "(1)", "Wrong type argument: listp, 1"},
+		{"(lambda (x) (1+ x))", "(a)", "Wrong type argument: number-or-marker-p, a"},
+		{"(lambda () undefined-var)", "()", "Symbol’s value as variable is void: undefined-var"},
+		{"(lambda () (undefined-func))", "()", "Symbol’s function definition is void: undefined-func"},
+	}
+	for _, test := range tests {
+		fn, err := compileString(env, ob, test.src)
+		if err != nil {
+			t.Errorf("compile %s: %v", test.src, ErrorMessage(err))
+			continue
+		}
+		args := listSlice(mustRead(t, test.args, ob))
+		val, err := env.Exec(fn, args...)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("%s %s:\nhave: %s\nwant: %s", test.src, test.args, have, test.want)
+		}
+	}
+}
+
+func TestCompileDisassembly(t *testing.T) {
+	env, ob := newLispEnv()
+	if _, err := env.Eval(mustRead(t, "(defvar dyn nil)", ob), lisp.T); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{
+			"(lambda (x y) (if x (+ x y) 'none))",
+			[]string{
+				"0\tstack-ref 1",
+				"1\trgoto-if-nil 1",
+				"3\tstack-ref 1",
+				"4\tstack-ref 1",
+				"5\tplus",
+				"6\trgoto\t  2",
+				"8:1\tconstant  none",
+				"9:2\treturn",
+			},
+		},
+		{
+			"(lambda (x) (let ((y (f x))) (setq dyn y) (g y y)))",
+			[]string{
+				"0\tconstant  f",
+				"1\tstack-ref 1",
+				"2\tcall\t  1",
+				"3\tdup",
+				"4\tdup",
+				"5\tvarset\t  dyn",
+				"6\tdiscard",
+				"7\tconstant  g",
+				"8\tstack-ref 1",
+				"9\tstack-ref 2",
+				"10\tcall\t  2",
+				"11\tdiscardN-preserve-tos 1",
+				"13\treturn",
+			},
+		},
+		{
+			"(lambda (dyn) (while dyn (setq dyn (cdr dyn))))",
+			[]string{
+				"0\tdup",
+				"1\tvarbind\t  dyn",
+				"2:2\tvarref\t  dyn",
+				"3\trgoto-if-nil 1",
+				"5\tvarref\t  dyn",
+				"6\tcdr",
+				"7\tdup",
+				"8\tvarset\t  dyn",
+				"9\tdiscard",
+				"10\trgoto\t  2",
+				"12:1\tconstant  nil",
+				"13\tunbind\t  1",
+				"14\treturn",
+			},
+		},
+		{
+			"(lambda (x) (catch 'done (car x)))",
+			[]string{
+				"0\tconstant  done",
+				"1\tpushcatch 1",
+				"4\tdup",
+				"5\tcar",
+				"6\tpophandler",
+				"7:1\treturn",
+			},
+		},
+		{
+			"(lambda (x) (condition-case e (car x) (error e)))",
+			[]string{
+				"0\tconstant  error",
+				"1\tpushconditioncase 1",
+				"4\tdup",
+				"5\tcar",
+				"6\tpophandler",
+				"7\trgoto\t  2",
+				"9:1\tdup",
+				"10\tdiscardN-preserve-tos 1",
+				"12:2\treturn",
+			},
+		},
+		{
+			"(lambda (x) (unwind-protect (car x) (f)))",
+			[]string{
+				"0\tpushunwind 1",
+				"4\tdup",
+				"5\tcar",
+				"6\tpophandler",
+				"7:1\tconstant  f",
+				"8\tcall\t  0",
+				"9\tdiscard",
+				"10\trethrow",
+				"12\treturn",
+			},
+		},
+		{
+			"(lambda (x) (save-excursion (car x)))",
+			[]string{
+				"0\tsave-excursion",
+				"1\tdup",
+				"2\tcar",
+				"3\tunbind\t  1",
+				"4\treturn",
+			},
+		},
+		{
+			"(lambda (x) (lambda () (setq x 1)))",
+			[]string{
+				"0\tdup",
+				"1\tconstant  nil",
+				"2\tcons",
+				"3\tstack-set 1",
+				"5\tconstant  make-closure",
+				"6\tconstant  <compiled-function>",
+				"   0\tconstant  x",
+				"   1\tconstant  1",
+				"   2\tsetcar",
+				"   3\treturn",
+				"7\tstack-ref 2",
+				"8\tcall\t  2",
+				"9\treturn",
+			},
+		},
+	}
+	for _, test := range tests {
+		fn, err := compileString(env, ob, test.src)
+		if err != nil {
+			t.Errorf("compile %s: %v", test.src, ErrorMessage(err))
+			continue
+		}
+		var buf bytes.Buffer
+		if err := Disassemble(&buf, "", fn); err != nil {
+			t.Fatal(err)
+		}
+		have := strings.TrimPrefix(strings.TrimSuffix(buf.String(), "\n"), "byte code:\n")
+		want := strings.Join(test.want, "\n")
+		if have != want {
+			t.Errorf("%s:\nhave:\n%s\nwant:\n%s", test.src, have, want)
+		}
+	}
+}
+
+func TestCompileLarge(t *testing.T) {
+	env, ob := newLispEnv()
+
+	// More than 64 constants and deep stack
+	// require the long instruction forms.
+	var src, want bytes.Buffer
+	src.WriteString("(lambda (x) (list x")
+	want.WriteString("(1")
+	for i := 0; i < 300; i++ {
+		src.WriteString(" '" + lisp.ObjectString(lisp.NewInt(int64(i))) + "s x")
+		want.WriteString(" " + lisp.ObjectString(lisp.NewInt(int64(i))) + "s 1")
+	}
+	src.WriteString("))")
+	want.WriteString(")")
+
+	fn, err := compileString(env, ob, src.String())
+	if err != nil {
+		t.Fatal(ErrorMessage(err))
+	}
+	val, err := env.Exec(fn, lisp.NewInt(1))
+	if err != nil {
+		t.Fatal(ErrorMessage(err))
+	}
+	if have := lisp.Prin1String(val); have != want.String() {
+		t.Errorf("have: %s\nwant: %s", have, want.String())
+	}
+}
+
+func TestCompileJumps(t *testing.T) {
+	env, ob := newLispEnv()
+
+	// Bodies grow past the reach of relative jumps,
+	// so both jump forms are used around the boundary.
+	forms := make(map[string]bool)
+	for n := 1; n < 80; n++ {
+		src := "(lambda (x y) (while (and y (< x 1000)) (if x (progn" +
+			strings.Repeat(" (setq x (1+ x))", n) + ") (setq x 0)) (setq y (cdr y))) x)"
+		fn, err := compileString(env, ob, src)
+		if err != nil {
+			t.Fatalf("n=%d: %s", n, ErrorMessage(err))
+		}
+		for _, ins := range disassembleInstrs(fn) {
+			forms[ins] = true
+		}
+		val, err := env.Exec(fn, lisp.NewInt(0), mustRead(t, "(a b)", ob))
+		if err != nil {
+			t.Fatalf("n=%d: %s", n, ErrorMessage(err))
+		}
+		if have, want := lisp.Prin1String(val), strconv.Itoa(2*n); have != want {
+			t.Errorf("n=%d: have %s, want %s", n, have, want)
+		}
+	}
+	for _, name := range []string{"goto", "rgoto", "goto-if-nil", "rgoto-if-nil"} {
+		if !forms[name] {
+			t.Errorf("%s is not used", name)
+		}
+	}
+}
+
+// disassembleInstrs returns the instruction names of fn.
+func disassembleInstrs(fn *Func) []string {
+	var names []string
+	for pc := 0; pc < len(fn.code); {
+		ins, ok := decodeInstr(fn.code, uint32(pc))
+		if !ok || ins.info == nil {
+			break
+		}
+		names = append(names, ins.info.name)
+		pc += int(ins.width)
+	}
+	return names
+}
+
+func TestCompileErrors(t *testing.T) {
+	env, ob := newLispEnv()
+	if _, err := env.Eval(mustRead(t, "(defmacro get-x () 'x)", ob), lisp.T); err != nil {
+		t.Fatal(ErrorMessage(err))
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"1", "Invalid function: 1"},
+		{"(foo)", "Invalid function: (foo)"},
+		{"(lambda)", "Invalid function: (lambda)"},
+		{"(closure ((x . 1) t) () x)", "Can't compile closure that captures variables: (closure ((x . 1) t) nil x)"},
+		{"(lambda (&rest) 1)", "&rest without variable name"},
+		{"(lambda (&rest a b) 1)", "Garbage following &rest VAR in lambda list"},
+		{"(lambda (&optional &optional x) 1)", "Misplaced &optional in lambda list"},
+		{"(lambda (1) 1)", "Wrong type argument: symbolp, 1"},
+		{"(lambda (nil) 1)", "Attempt to set a constant symbol: nil"},
+		{"(lambda () (let ((t 1)) t))", "Attempt to set a constant symbol: t"},
+		{"(lambda () (setq nil 1))", "Attempt to set a constant symbol: nil"},
+		{"(lambda (x) (setq x))", "Wrong number of arguments: setq, 1"},
+		{"(lambda () (if))", "Wrong number of arguments: if, 0"},
+		{"(lambda () (cond x))", "Wrong type argument: listp, x"},
+		{"(lambda () ((foo) 1))", "Invalid function: (foo)"},
+		{"(lambda (x) (defvar v x))", "Can't compile defvar form"},
+		{"(lambda () (lambda () (defvar v)))", "Can't compile defvar form"},
+		{"(lambda () (defconst c 1))", "Can't compile defconst form"},
+		{"(lambda () (condition-case 1 nil))", "Wrong type argument: symbolp, 1"},
+		{"(lambda () (condition-case t nil (error 1)))", "Attempt to set a constant symbol: t"},
+		{"(lambda () (catch))", "Wrong number of arguments: catch, 0"},
+	}
+	for _, test := range tests {
+		_, err := compileString(env, ob, test.src)
+		if err == nil {
+			t.Errorf("compile %s: no error", test.src)
+			continue
+		}
+		if have := ErrorMessage(err); have != test.want {
+			t.Errorf("compile %s:\nhave: %s\nwant: %s", test.src, have, test.want)
+		}
+	}
+}
+
+func TestByteCompile(t *testing.T) {
+	env, ob := newLispEnv()
+	for _, test := range []struct {
+		form string
+		want string
+	}{
+		{"(defalias 'fact (lambda (n) (if (<= n 1) 1 (* n (fact (1- n))))))", "fact"},
+		{"(byte-compile 'fact)", "#<compiled-function>"},
+		{"(funcall (symbol-function 'fact) 10)", "3628800"},
+		{"(fact 20)", "2432902008176640000"},
+		{"(funcall (byte-compile (lambda (x) (+ x 1))) 1)", "2"},
+		{"(byte-compile 'car)", "car"},
+		{"(defun f7 (x) (condition-case nil (car x) (error 'bad)))", "f7"},
+		{"(byte-compile 'f7)", "#<compiled-function>"},
+		{"(list (f7 '(1)) (f7 1))", "(1 bad)"},
+		{"(defalias 'f2 (lambda (a b) (list a b)))", "f2"},
+		{"(byte-compile 'f2)", "#<compiled-function>"},
+		{"(f2 1 2)", "(1 2)"},
+		{"(f2)", "Wrong number of arguments: (2 . 2), 0"},
+		{"(f2 1)", "Wrong number of arguments: (2 . 2), 1"},
+		{"(f2 1 2 3)", "Wrong number of arguments: (2 . 2), 3"},
+		{"(funcall (byte-compile (lambda () (f2 1))))", "Wrong number of arguments: (2 . 2), 1"},
+		{"(funcall (byte-compile (lambda () (f2 1 2 3))))", "Wrong number of arguments: (2 . 2), 3"},
+		{"(apply 'f2 '(1))", "Wrong number of arguments: (2 . 2), 1"},
+	} {
+		val, err := env.Eval(mustRead(t, test.form, ob), lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
+
+func TestCompileSeparateEnvs(t *testing.T) {
+	env1, ob1 := newLispEnv()
+	// The second env has one more function, so its function
+	// table layout differs from the first one.
+	master2 := NewMasterEnv()
+	ob2 := lisp.NewObarray()
+	AddSymbols(ob2)
+	master2.DefineFunc(ob2.Intern("extra"), func() int { return 0 })
+	master2.DefineSubrs(ob2)
+	env2 := master2.NewEnv(0, 0)
+
+	for _, test := range []struct {
+		form string
+		want string
+	}{
+		{"(funcall '+ 1 2)", "3"},
+		{"(funcall (byte-compile (lambda () (let ((x 1)) (funcall (lambda () x))))))", "1"},
+		{"(funcall (byte-compile (lambda (y) (let ((x 1)) (funcall (lambda () (setq x (+ x y)))) x))) 2)", "3"},
+	} {
+		for i, e := range []struct {
+			env *Env
+			ob  *lisp.Obarray
+		}{{env1, ob1}, {env2, ob2}} {
+			val, err := e.env.Eval(mustRead(t, test.form, e.ob), lisp.T)
+			have := lisp.Prin1String(val)
+			if err != nil {
+				have = ErrorMessage(err)
+			}
+			if have != test.want {
+				t.Errorf("env%d: eval %s:\nhave: %s\nwant: %s", i+1, test.form, have, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"sync/atomic"
+)
+
+// Go functions registration and the API that is available
+// to GoFuncCtx functions through their Env argument.
+// Issue#5
+
+// DefineGoFunc makes fn callable through fsym by
+// OpExtGoCall opcodes.
+func (env *MasterEnv) DefineGoFunc(fsym lisp.Object, fn GoFunc) {
+	env.DefineGoFuncCtx(fsym, func(_ *Env, args []lisp.Object) error {
+		return fn(args)
+	})
+}
+
+// DefineGoFuncCtx is like DefineGoFunc, but for functions
+// that need the calling Env.
+func (env *MasterEnv) DefineGoFuncCtx(fsym lisp.Object, fn GoFuncCtx) {
+	if len(env.goFuncs) == 0 {
+		// Functions with ID=0 must be unassigned.
+		env.goFuncs = append(env.goFuncs, nil)
+	}
+	env.setFuncRef(fsym.Symbol(), funcRef{goID: len(env.goFuncs)})
+	env.goFuncs = append(env.goFuncs, fn)
+}
+
+// DefineCompiledFunc makes fn callable through fsym by
+// OpCall opcodes and Funcall.
+// fn should be created by NewFunc.
+//
+// If fn has a Go version that is registered by RegisterAOT,
+// fsym is bound to it instead.
+func (env *MasterEnv) DefineCompiledFunc(fsym lisp.Object, fn *Func) {
+	if env.defineAOTFunc(fsym, fn) {
+		return
+	}
+	// fn may be in use, so only the copy is translated.
+	def := *fn
+	if def.insns == nil {
+		def.translate()
+	}
+	env.setFuncRef(fsym.Symbol(), funcRef{fn: &def})
+}
+
+// funcRef is the compiled or Go function
+// that is bound to a symbol.
+// Zero funcRef means that there is no such function.
+type funcRef struct {
+	// fn is the compiled Lisp function.
+	fn *Func
+
+	// goID is the goFuncs index of the Go function.
+	goID int
+}
+
+// bound reports whether ref is not zero.
+func (ref funcRef) bound() bool {
+	return ref.fn != nil || ref.goID != 0
+}
+
+// Function bindings are found by symbol FuncID.
+//
+// FuncIDs are unique in the process, so a symbol can be
+// shared by environments: each of them has its own binding
+// at the same index. Bindings are kept in fixed-size pages,
+// so an environment pays only for the pages it uses.
+
+// funcPageBits is log2 of funcPage length.
+const funcPageBits = 8
+
+// funcPage is a page of MasterEnv function bindings.
+type funcPage [1 << funcPageBits]funcRef
+
+// emptyFuncPage is shared by the pages without bindings;
+// it is never written.
+var emptyFuncPage funcPage
+
+// lastFuncID is the last assigned FuncID.
+// It is accessed atomically.
+var lastFuncID int64
+
+// The predefined symbols are shared by all environments,
+// their FuncIDs are assigned before any of them can run.
+func init() {
+	for _, sym := range predefinedSymbols {
+		assignFuncID(sym.Symbol())
+	}
+	assignFuncID(lisp.Nil.Symbol())
+	assignFuncID(lisp.T.Symbol())
+}
+
+// assignFuncID gives sym a FuncID if it has none yet.
+//
+// Other symbols get their FuncIDs when they are bound to
+// a function for the first time, so a symbol that is shared
+// by several MasterEnvs must not be bound by them in parallel.
+func assignFuncID(sym *lisp.Symbol) {
+	if sym.FuncID == 0 {
+		sym.FuncID = int(atomic.AddInt64(&lastFuncID, 1))
+	}
+}
+
+// funcRef returns the function binding of sym.
+func (env *MasterEnv) funcRef(sym *lisp.Symbol) funcRef {
+	id := sym.FuncID
+	if page := id >> funcPageBits; page < len(env.funcPages) {
+		return env.funcPages[page][id&(len(funcPage{})-1)]
+	}
+	return funcRef{}
+}
+
+// setFuncRef binds sym to the function that ref identifies.
+// Zero ref removes the binding.
+func (env *MasterEnv) setFuncRef(sym *lisp.Symbol, ref funcRef) {
+	if !ref.bound() && sym.FuncID == 0 {
+		return
+	}
+	assignFuncID(sym)
+	page := sym.FuncID >> funcPageBits
+	for len(env.funcPages) <= page {
+		env.funcPages = append(env.funcPages, &emptyFuncPage)
+	}
+	if env.funcPages[page] == &emptyFuncPage {
+		env.funcPages[page] = new(funcPage)
+	}
+	env.funcPages[page][sym.FuncID&(len(funcPage{})-1)] = ref
+}
+
+// Fset implements `fset`: it makes def the function
+// definition of fsym.
+//
+// def can be a compiled function object, a symbol
+// (fsym becomes its alias), an interpreted function or nil,
+// which makes fsym void. Other values are stored as is
+// and signal invalid-function when called.
+func (env *MasterEnv) Fset(fsym, def lisp.Object) {
+	sym := fsym.Symbol()
+	env.symbolsMu.Lock()
+	delete(env.fdefs, sym)
+	env.symbolsMu.Unlock()
+	env.setFuncRef(sym, funcRef{})
+
+	switch {
+	case def.Type == lisp.TypeFunc:
+		env.DefineCompiledFunc(fsym, objectFunc(&def))
+		return
+	case lisp.Null(&def):
+		return
+	case def.Type == lisp.TypeSymbol:
+		// Alias shares the current definition.
+		target := def.Symbol()
+		if ref := env.funcRef(target); ref.bound() {
+			env.setFuncRef(sym, ref)
+			return
+		}
+		if d, ok := env.fdef(target); ok {
+			def = d
+		}
+	}
+	env.symbolsMu.Lock()
+	defer env.symbolsMu.Unlock()
+	if env.fdefs == nil {
+		env.fdefs = make(map[*lisp.Symbol]lisp.Object)
+	}
+	env.fdefs[sym] = def
+}
+
+// fdef returns the definition of sym that is stored by Fset.
+func (env *MasterEnv) fdef(sym *lisp.Symbol) (lisp.Object, bool) {
+	env.symbolsMu.RLock()
+	defer env.symbolsMu.RUnlock()
+	def, ok := env.fdefs[sym]
+	return def, ok
+}
+
+// fdefCount returns the number of definitions stored by Fset.
+// Alias chains that are longer must contain a loop.
+func (env *MasterEnv) fdefCount() int {
+	env.symbolsMu.RLock()
+	defer env.symbolsMu.RUnlock()
+	return len(env.fdefs)
+}
+
+// SymbolFunction implements `symbol-function`.
+// Returns nil if fsym has no function definition.
+// Go functions have no Lisp representation,
+// they are returned as fsym itself.
+func (env *MasterEnv) SymbolFunction(fsym lisp.Object) lisp.Object {
+	sym := fsym.Symbol()
+	ref := env.funcRef(sym)
+	switch {
+	case !ref.bound():
+		if def, ok := env.fdef(sym); ok {
+			return def
+		}
+		return lisp.Nil
+	case ref.fn == nil:
+		return fsym
+	default:
+		return ref.fn.Object()
+	}
+}
+
+// CurrentBuffer returns the current buffer.
+func (env *Env) CurrentBuffer() *lisp.Buffer {
+	return env.buffer
+}
+
+// SetCurrentBuffer makes buf the current buffer.
+func (env *Env) SetCurrentBuffer(buf *lisp.Buffer) {
+	env.buffer = buf
+}
+
+// SymbolValue returns sym value, respecting dynamic bindings.
+// Signals void-variable if sym has no value.
+func (env *Env) SymbolValue(sym lisp.Object) (lisp.Object, error) {
+	return env.symbolValue(sym)
+}
+
+// SetSymbolValue sets sym value.
+// If sym is dynamically bound, only the innermost
+// binding is affected.
+func (env *Env) SetSymbolValue(sym, val lisp.Object) {
+	env.setSymbolValue(sym, val)
+}
+
+// Bind dynamically binds sym to val.
+//
+// Returns bindings depth before the call;
+// pass it to UnbindTo to remove the binding:
+//
+//	depth := env.Bind(sym, val)
+//	defer env.UnbindTo(depth)
+func (env *Env) Bind(sym, val lisp.Object) int {
+	depth := env.specpdlIndex()
+	env.specbind(sym, val)
+	return depth
+}
+
+// UnbindTo removes all bindings that were made
+// after depth was returned by Bind.
+func (env *Env) UnbindTo(depth int) {
+	env.unbindTo(depth)
+}
+
+// BindingDepth returns the number of active dynamic bindings.
+// UnbindTo(BindingDepth() - n) removes n innermost bindings.
+func (env *Env) BindingDepth() int {
+	return env.specpdlIndex()
+}
+
+// Binding is a single dynamic variable binding.
+type Binding struct {
+	Symbol lisp.Object
+	Value  lisp.Object
+}
+
+// Bindings returns a copy of currently active dynamic bindings.
+// The innermost binding is the last one.
+func (env *Env) Bindings() []Binding {
+	var bindings []Binding
+	for _, b := range env.specpdl {
+		if b.unwind == nil {
+			bindings = append(bindings, Binding{Symbol: b.sym, Value: b.val})
+		}
+	}
+	return bindings
+}
+
+// Reset drops the evaluation state that is left behind
+// when evaluation is abandoned by a Go panic: dynamic
+// bindings, active catch tags and handlers, and the call
+// frames. Errors unwind this state themselves.
+//
+// Reset must not be called while evaluation is active.
+func (env *Env) Reset() {
+	env.unbindTo(0)
+	env.catchTags = env.catchTags[:0]
+	env.handlers = env.handlers[:0]
+	env.callDepth = 0
+	env.evalDepth = 0
+	env.stackTop = 0
+	env.lexenv = lisp.Nil
+}
+
+// WriteOutput prints s to `standard-output`.
+//
+// If it is a buffer, s is inserted at its point;
+// if it is t or nil (or unbound), s is written to env.Output.
+func (env *Env) WriteOutput(s string) error {
+	return env.writeOutput(env.outputStream(&lisp.Nil), s)
+}
+
+// outputStream returns the destination that printcharfun
+// argument of the printing functions selects: nil stands
+// for the `standard-output` value, which is t if unbound.
+func (env *Env) outputStream(printcharfun *lisp.Object) lisp.Object {
+	if !lisp.Null(printcharfun) {
+		return *printcharfun
+	}
+	out, err := env.symbolValue(SymStandardOutput)
+	if err != nil {
+		return lisp.T
+	}
+	return out
+}
+
+// writeOutput prints s to out, see WriteOutput.
+func (env *Env) writeOutput(out lisp.Object, s string) error {
+	switch {
+	case out.Type == lisp.TypeBuffer:
+		out.Buffer().Insert([]rune(s))
+		return nil
+	case lisp.Null(&out) || lisp.Eq(&out, &lisp.T):
+		if env.Output == nil {
+			return nil
+		}
+		_, err := env.Output.Write([]byte(s))
+		return err
+	default:
+		return wrongTypeArgument(SymBufferp, out)
+	}
+}
@@ -0,0 +1,120 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"testing"
+)
+
+func TestGoFuncCtx(t *testing.T) {
+	interp := newTestInterpreter(t)
+	interp.buffer = newTestBuffer("abc|")
+	var echo bytes.Buffer
+	interp.Output = &echo
+
+	fill := lisp.NewSymbol("fill-column")
+	interp.setGlobalValue(fill, lisp.NewInt(70))
+
+	goPoint := interp.AddGoFuncCtx("point", func(env *Env, args []lisp.Object) error {
+		args[0] = lisp.NewInt(int64(env.CurrentBuffer().Point()))
+		return nil
+	})
+	// with-fill is like (let ((fill-column ARG)) (princ fill-column) fill-column).
+	goWithFill := interp.AddGoFuncCtx("with-fill", func(env *Env, args []lisp.Object) error {
+		depth := env.Bind(fill, args[1])
+		defer env.UnbindTo(depth)
+		if n := len(env.Bindings()); n != 1 {
+			return NewSignal(SymError, lisp.NewInt(int64(n)))
+		}
+		val, err := env.SymbolValue(fill)
+		if err != nil {
+			return err
+		}
+		args[0] = val
+		return env.WriteOutput(lisp.ObjectString(val))
+	})
+	goFail := interp.AddGoFuncCtx("fail", func(env *Env, args []lisp.Object) error {
+		return NewSignal(SymArgsOutOfRange, args[1])
+	})
+
+	type (
+		consts []interface{}
+		steps  []interface{}
+	)
+	interp.LoadSteps(steps{
+		OpConstant0, `point`,
+		OpExt, OpExtGoCall0, `4`,
+		OpConstant1, `4 with-fill`,
+		OpConstant3, `4 with-fill 10`,
+		OpExt, OpExtGoCall1, `4 10`,
+	})
+	interp.Run("GoFuncCtx", promoteObjects(consts{goPoint, goWithFill, goFail, 10}), nil)
+
+	if val, _ := interp.symbolValue(fill); lisp.ObjectString(val) != "70" {
+		t.Errorf("binding is not removed: fill-column=%s", lisp.ObjectString(val))
+	}
+	if echo.String() != "10" {
+		t.Errorf("standard-output: have %q, want %q", echo.String(), "10")
+	}
+
+	// Output goes to the buffer that is bound to standard-output.
+	out := lisp.NewBuffer("out")
+	depth := interp.Bind(SymStandardOutput, out)
+	if err := interp.WriteOutput("xyz"); err != nil {
+		t.Fatalf("WriteOutput: unexpected error: %v", err)
+	}
+	interp.UnbindTo(depth)
+	if have := bufferString(out.Buffer()); have != "xyz|" {
+		t.Errorf("output buffer: have %q, want %q", have, "xyz|")
+	}
+
+	fn := Func{
+		code:   []byte{OpConstant2, OpConstant3, OpExt, OpExtGoCall1, OpExt, OpExtStop},
+		consts: promoteObjects(consts{goPoint, goWithFill, goFail, 10}),
+	}
+	fn.translate()
+	_, err := eval(&interp.Env, &fn, 0)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymArgsOutOfRange {
+		t.Errorf("signal from Go function: unexpected error: %v", err)
+	} else if have := lisp.ObjectString(sig.Data); have != "(10 . nil)" {
+		t.Errorf("signal data: have %s, want (10 . nil)", have)
+	}
+}
+
+func TestNewEnvBuffer(t *testing.T) {
+	master := NewMasterEnv()
+	a, b := master.NewEnv(0, 0), master.NewEnv(0, 0)
+	if a.CurrentBuffer() == b.CurrentBuffer() {
+		t.Fatalf("Envs share the current buffer")
+	}
+	if name := a.CurrentBuffer().Name; name != "*scratch*" {
+		t.Errorf("buffer name: have %q, want %q", name, "*scratch*")
+	}
+	a.CurrentBuffer().Insert([]rune("a"))
+	if n := b.CurrentBuffer().Size(); n != 0 {
+		t.Errorf("insertion is visible in other Env: size %d", n)
+	}
+}
+
+func TestSharedSymbolFunctions(t *testing.T) {
+	f, g := lisp.NewSymbol("f"), lisp.NewSymbol("g")
+	env1, env2 := NewMasterEnv().NewEnv(0, 0), NewMasterEnv().NewEnv(0, 0)
+	env1.DefineFunc(f, func() int { return 1 })
+	// Function tables of env2 have different layout.
+	env2.DefineFunc(g, func() int { return 0 })
+	env2.DefineFunc(f, func(x int) int { return x + 2 })
+
+	if val, err := env1.Funcall(f); err != nil || lisp.ObjectString(val) != "1" {
+		t.Errorf("env1: have %s, %v; want 1", lisp.ObjectString(val), err)
+	}
+	if val, err := env2.Funcall(f, lisp.NewInt(1)); err != nil || lisp.ObjectString(val) != "3" {
+		t.Errorf("env2: have %s, %v; want 3", lisp.ObjectString(val), err)
+	}
+	if _, err := env1.Funcall(g); err == nil {
+		t.Errorf("env1: g is bound by env2")
+	}
+	env2.Fset(f, lisp.Nil)
+	if val, err := env1.Funcall(f); err != nil || lisp.ObjectString(val) != "1" {
+		t.Errorf("env1 after fset in env2: have %s, %v; want 1", lisp.ObjectString(val), err)
+	}
+}
@@ -0,0 +1,266 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"io"
+	"strconv"
+	"unsafe"
+)
+
+// Byte code disassembler.
+//
+// Output mimics Emacs `disassemble` command:
+//
+//	byte code for foo:
+//	0	varref	  x
+//	1	goto-if-nil 1
+//	4	constant  a
+//	5	return
+//	6:1	constant  b
+//	7	return
+//
+// Jump targets are replaced with numbered labels; "6:1" line
+// marks label 1. Compiled function constants are disassembled
+// recursively, with extra indentation.
+
+//go:generate go run gen_opinfo.go
+
+// Operand encodings.
+const (
+	operandNone     = iota
+	operandImplicit // Operand is a part of opcode, like in OpStackRef2
+	operandB        // 8bit operand
+	operandW        // 16bit operand
+)
+
+// Operand kinds.
+const (
+	argNone    = iota
+	argNumber  // Stack offset, arguments count and so on
+	argConst   // Constant vector index
+	argJump    // Absolute jump target
+	argRelJump // Jump target relative to the instruction
+)
+
+// opInfo describes opcode for the tools that
+// inspect byte code, like disassembler.
+type opInfo struct {
+	// name is Emacs opcode mnemonic.
+	name string
+
+	// enc is operand encoding.
+	enc uint8
+
+	// kind tells how operand is interpreted.
+	kind uint8
+
+	// n is implicit operand value.
+	n int
+}
+
+// discardPreserveTOSInfo describes OpDiscardB with
+// discardPreserveTOS operand flag; Emacs prints it
+// as a separate instruction.
+var discardPreserveTOSInfo = opInfo{
+	name: "discardN-preserve-tos",
+	enc:  operandB,
+	kind: argNumber,
+}
+
+// instr is a decoded instruction.
+type instr struct {
+	pc    uint32
+	width uint32
+
+	// info is nil for unknown opcodes.
+	info *opInfo
+
+	// arg is operand value.
+	// For jumps it is an absolute target pc.
+	arg int
+}
+
+// decodeInstr decodes instruction at pc.
+// Returns false if instruction is truncated.
+// Unknown opcodes are decoded as 1-byte instructions with nil info.
+func decodeInstr(code []byte, pc uint32) (instr, bool) {
+	ins := instr{pc: pc, width: 1}
+	op := code[pc]
+	info := &opInfos[op]
+	prefix := uint32(1)
+	if op == OpExt {
+		if int(pc+1) >= len(code) {
+			return ins, false
+		}
+		info = &extOpInfos[code[pc+1]]
+		prefix = 2
+	}
+	if info.name == "" {
+		return ins, true
+	}
+
+	ins.info = info
+	switch info.enc {
+	case operandNone:
+		ins.width = prefix
+	case operandImplicit:
+		ins.width = prefix
+		ins.arg = info.n
+	case operandB:
+		ins.width = prefix + 1
+		if int(pc+ins.width) > len(code) {
+			return ins, false
+		}
+		ins.arg = int(code[pc+prefix])
+	case operandW:
+		ins.width = prefix + 2
+		if int(pc+ins.width) > len(code) {
+			return ins, false
+		}
+		ins.arg = int(code[pc+prefix]) + int(code[pc+prefix+1])<<8
+	}
+	if info.kind == argRelJump {
+		ins.arg += int(pc) + 1 - 127
+	}
+	return ins, true
+}
+
+// Object returns fn wrapped into Object.
+func (fn *Func) Object() lisp.Object {
+	return lisp.NewRef(lisp.TypeFunc, unsafe.Pointer(fn))
+}
+
+// objectFunc returns compiled function value of o.
+// UB if o.Type is not TypeFunc.
+func objectFunc(o *lisp.Object) *Func {
+	return (*Func)(o.Ptr)
+}
+
+// Disassemble writes fn disassembly to w.
+// If name is not empty, it is printed in the header.
+func Disassemble(w io.Writer, name string, fn *Func) error {
+	var d disassembler
+	if name != "" {
+		d.write("byte code for " + name + ":\n")
+	} else {
+		d.write("byte code:\n")
+	}
+	d.disassemble(fn, 0)
+	_, err := w.Write(d.Bytes())
+	return err
+}
+
+// disassembler is a Disassemble implementation helper.
+type disassembler struct {
+	bytes.Buffer
+
+	// col is current output column.
+	col int
+}
+
+// nestedIndent is an indentation of nested functions disassembly.
+const nestedIndent = 3
+
+// disassemble prints fn instructions indented by indent columns.
+func (d *disassembler) disassemble(fn *Func, indent int) {
+	// Assign labels in the order of jump instructions.
+	labels := make(map[int]int)
+	for pc := uint32(0); int(pc) < len(fn.code); {
+		ins, ok := decodeInstr(fn.code, pc)
+		if !ok {
+			break
+		}
+		if ins.info != nil && (ins.info.kind == argJump || ins.info.kind == argRelJump) {
+			if _, ok := labels[ins.arg]; !ok {
+				labels[ins.arg] = len(labels) + 1
+			}
+		}
+		pc += ins.width
+	}
+
+	for pc := uint32(0); int(pc) < len(fn.code); {
+		ins, ok := decodeInstr(fn.code, pc)
+
+		d.indentTo(indent, 0)
+		d.write(strconv.Itoa(int(pc)))
+		if label, ok := labels[int(pc)]; ok {
+			d.write(":" + strconv.Itoa(label))
+		}
+		d.write("\t")
+
+		if !ok {
+			d.write("<truncated>\n")
+			break
+		}
+		if ins.info == nil {
+			d.write("<unknown " + strconv.Itoa(int(fn.code[pc])) + ">\n")
+			pc += ins.width
+			continue
+		}
+
+		if ins.info.name == "discardN" && ins.arg&discardPreserveTOS != 0 {
+			ins.info = &discardPreserveTOSInfo
+			ins.arg &^= discardPreserveTOS
+		}
+
+		d.write(ins.info.name)
+		if ins.info.kind != argNone {
+			d.indentTo(18, 1)
+		}
+		var nested *Func
+		switch ins.info.kind {
+		case argNumber:
+			d.write(strconv.Itoa(ins.arg))
+		case argJump, argRelJump:
+			d.write(strconv.Itoa(labels[ins.arg]))
+		case argConst:
+			if ins.arg >= len(fn.consts) {
+				d.write("<bad constant " + strconv.Itoa(ins.arg) + ">")
+				break
+			}
+			c := fn.consts[ins.arg]
+			if c.Type == lisp.TypeFunc {
+				d.write("<compiled-function>")
+				nested = objectFunc(&c)
+			} else {
+				d.write(lisp.Prin1String(c))
+			}
+		}
+		d.write("\n")
+
+		if nested != nil {
+			d.disassemble(nested, indent+nestedIndent)
+		}
+		pc += ins.width
+	}
+}
+
+// write appends s to the output, keeping track of the column.
+func (d *disassembler) write(s string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\n':
+			d.col = 0
+		case '\t':
+			d.col = (d.col/8 + 1) * 8
+		default:
+			d.col++
+		}
+	}
+	d.WriteString(s)
+}
+
+// indentTo works like Emacs `indent-to`: it inserts tabs and spaces
+// to reach column col, but inserts at least min spaces.
+func (d *disassembler) indentTo(col, min int) {
+	if col < d.col+min {
+		col = d.col + min
+	}
+	for (d.col/8+1)*8 <= col {
+		d.write("\t")
+	}
+	for d.col < col {
+		d.write(" ")
+	}
+}
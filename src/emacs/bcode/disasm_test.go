@@ -0,0 +1,222 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	inner := Func{
+		code:   []byte{OpStackRef1, OpConstant0, OpCall1, OpReturn},
+		consts: []lisp.Object{lisp.NewSymbol("inner-fn")},
+	}
+	x := lisp.NewSymbol("x")
+	tests := []struct {
+		name string
+		fn   Func
+		want []string
+	}{
+		{
+			"foo",
+			Func{
+				code: []byte{
+					OpVarRef0,
+					OpGotoIfNilW, 6, 0,
+					OpConstant1,
+					OpReturn,
+					OpConstant2,
+					OpReturn,
+				},
+				consts: []lisp.Object{x, lisp.NewSymbol("a"), lisp.NewString([]byte("b"))},
+			},
+			[]string{
+				"byte code for foo:",
+				"0\tvarref\t  x",
+				"1\tgoto-if-nil 1",
+				"4\tconstant  a",
+				"5\treturn",
+				"6:1\tconstant  \"b\"",
+				"7\treturn",
+			},
+		},
+		{
+			"",
+			Func{
+				code: []byte{
+					OpStackRef2,
+					OpStackRefB, 10,
+					OpDup,
+					OpGotoW, 10, 0,
+					OpGotoIfNonNilElsePopW, 0, 0,
+					OpConstantW, 1, 0,
+					OpListB, 3,
+					OpDiscardB, 2,
+					OpStringEqlsign,
+					OpExt, OpExtGoCall2,
+					OpExt, OpExtGoCallB, 7,
+					OpExt, OpExtStop,
+				},
+				consts: []lisp.Object{lisp.Nil, lisp.List(lisp.NewInt(1))},
+			},
+			[]string{
+				"byte code:",
+				"0:2\tstack-ref 2",
+				"1\tstack-ref 10",
+				"3\tdup",
+				"4\tgoto\t  1",
+				"7\tgoto-if-non-nil-else-pop 2",
+				"10:1\tconstant  (1)",
+				"13\tlistN\t  3",
+				"15\tdiscardN  2",
+				"17\tstring=",
+				"18\tgo-call\t  2",
+				"20\tgo-call\t  7",
+				"23\tstop",
+			},
+		},
+		{
+			"outer",
+			Func{
+				code:   []byte{OpConstant0, OpConstant1, OpCall1, OpReturn},
+				consts: []lisp.Object{inner.Object(), lisp.NewInt(5)},
+			},
+			[]string{
+				"byte code for outer:",
+				"0\tconstant  <compiled-function>",
+				"   0\tstack-ref 1",
+				"   1\tconstant  inner-fn",
+				"   2\tcall\t  1",
+				"   3\treturn",
+				"1\tconstant  5",
+				"2\tcall\t  1",
+				"3\treturn",
+			},
+		},
+		{
+			"preserve-tos",
+			Func{
+				code: []byte{OpDiscardB, 0x81, OpDiscardB, 1, OpReturn},
+			},
+			[]string{
+				"byte code for preserve-tos:",
+				"0\tdiscardN-preserve-tos 1",
+				"2\tdiscardN  1",
+				"4\treturn",
+			},
+		},
+		{
+			// Emacs output for
+			// (lambda (x) (catch 'tag (throw 'tag x))).
+			"catch",
+			Func{
+				code: []byte{
+					OpConstant0,
+					OpPushCatch, 9, 0,
+					OpConstant1,
+					OpConstant0,
+					OpStackRef2,
+					OpCall2,
+					OpPopHandler,
+					OpReturn,
+				},
+				consts: []lisp.Object{lisp.NewSymbol("tag"), lisp.NewSymbol("throw")},
+			},
+			[]string{
+				"byte code for catch:",
+				"0\tconstant  tag",
+				"1\tpushcatch 1",
+				"4\tconstant  throw",
+				"5\tconstant  tag",
+				"6\tstack-ref 2",
+				"7\tcall\t  2",
+				"8\tpophandler",
+				"9:1\treturn",
+			},
+		},
+		{
+			// Emacs output for
+			// (lambda (x) (condition-case nil (car x) (error 'bad))).
+			"condition-case",
+			Func{
+				code: []byte{
+					OpConstant0,
+					OpPushConditionCase, 8, 0,
+					OpDup,
+					OpCar,
+					OpPopHandler,
+					OpReturn,
+					OpDiscard,
+					OpConstant1,
+					OpReturn,
+				},
+				consts: []lisp.Object{lisp.List(lisp.NewSymbol("error")), lisp.NewSymbol("bad")},
+			},
+			[]string{
+				"byte code for condition-case:",
+				"0\tconstant  (error)",
+				"1\tpushconditioncase 1",
+				"4\tdup",
+				"5\tcar",
+				"6\tpophandler",
+				"7\treturn",
+				"8:1\tdiscard",
+				"9\tconstant  bad",
+				"10\treturn",
+			},
+		},
+		{
+			"bad",
+			Func{
+				code:   []byte{0112, OpConstant3, OpConstantW, 1},
+				consts: []lisp.Object{lisp.Nil},
+			},
+			[]string{
+				"byte code for bad:",
+				"0\t<unknown 74>",
+				"1\tconstant  <bad constant 3>",
+				"2\t<truncated>",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Disassemble(&buf, tt.name, &tt.fn); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		want := strings.Join(tt.want, "\n") + "\n"
+		if have := buf.String(); have != want {
+			t.Errorf("%s:\nhave:\n%s\nwant:\n%s", tt.name, have, want)
+		}
+	}
+}
+
+func TestOpInfos(t *testing.T) {
+	// All opcodes that eval implements must be known to the tools.
+	ops := []byte{
+		OpStackRef1, OpStackRefW, OpCall0, OpCall1, OpCons, OpDiscard,
+		OpReturn, OpAdd1, OpPoint, OpConstantW, OpGotoW, OpGotoIfNilW,
+		OpDup, OpDiscardB, OpConstant0, OpConstant63,
+	}
+	for _, op := range ops {
+		if opInfos[op].name == "" {
+			t.Errorf("opcode %d has no opInfo", op)
+		}
+	}
+
+	// Mnemonics must be unique, except for opcode families.
+	seen := make(map[string]*opInfo)
+	for i := range opInfos {
+		info := &opInfos[i]
+		if info.name == "" {
+			continue
+		}
+		if prev := seen[info.name]; prev != nil && prev.enc == info.enc && info.enc != operandImplicit {
+			t.Errorf("duplicated mnemonic %q", info.name)
+		}
+		seen[info.name] = info
+	}
+}
@@ -0,0 +1,48 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Default Env limits.
+const (
+	// DefaultStackSize is the number of data stack slots.
+	DefaultStackSize = 4096
+
+	// DefaultMaxCallDepth limits nested calls,
+	// like Emacs `max-lisp-eval-depth` does.
+	DefaultMaxCallDepth = 1600
+)
+
+// NewMasterEnv returns MasterEnv without any functions defined.
+func NewMasterEnv() *MasterEnv {
+	return &MasterEnv{
+		// Functions with ID=0 must be unassigned.
+		goFuncs: make([]GoFuncCtx, 1),
+
+		stdSyntaxTable: newStandardSyntaxTable(),
+	}
+}
+
+// NewEnv returns Env that shares env definitions.
+// Current buffer is a fresh "*scratch*" buffer that belongs
+// to the new Env only; it is not registered as a named buffer.
+//
+// stackSize and maxCallDepth limit data stack and call depth;
+// zero values select DefaultStackSize and DefaultMaxCallDepth.
+func (env *MasterEnv) NewEnv(stackSize, maxCallDepth int) *Env {
+	if stackSize == 0 {
+		stackSize = DefaultStackSize
+	}
+	if maxCallDepth == 0 {
+		maxCallDepth = DefaultMaxCallDepth
+	}
+	scratch := lisp.NewBuffer("*scratch*")
+	return &Env{
+		MasterEnv: env,
+		stack:     make([]lisp.Object, stackSize),
+		frames:    make([]callFrame, maxCallDepth),
+		buffer:    scratch.Buffer(),
+		lexenv:    lisp.Nil,
+	}
+}
@@ -1,6 +1,7 @@
 package bcode
 
 import (
+	"emacs/lisp"
 	"errors"
 )
 
@@ -25,4 +26,289 @@ var (
 	// It may as well require call trace attached,
 	// that is currently unimplemented (Issue#12).
 	ErrBadOpcode = errors.New("found unexpected opcode")
+
+	// ErrStackOverflow reports that function stack usage,
+	// as computed by Verify, does not fit into Env data stack.
+	ErrStackOverflow = errors.New("data stack overflow")
 )
+
+// Error symbols that can be signalled by the runtime itself.
+//
+// Should be treated as constants.
+var (
+	SymError             = lisp.NewSymbol("error")
+	SymWrongTypeArgument = lisp.NewSymbol("wrong-type-argument")
+	SymArgsOutOfRange    = lisp.NewSymbol("args-out-of-range")
+	SymBeginningOfBuffer = lisp.NewSymbol("beginning-of-buffer")
+	SymEndOfBuffer       = lisp.NewSymbol("end-of-buffer")
+	SymScanError         = lisp.NewSymbol("scan-error")
+	SymInvalidRegexp     = lisp.NewSymbol("invalid-regexp")
+	SymSearchFailed      = lisp.NewSymbol("search-failed")
+	SymVoidVariable      = lisp.NewSymbol("void-variable")
+	SymVoidFunction      = lisp.NewSymbol("void-function")
+	SymInvalidFunction   = lisp.NewSymbol("invalid-function")
+
+	SymWrongNumberOfArguments = lisp.NewSymbol("wrong-number-of-arguments")
+
+	SymExcessiveLispNesting = lisp.NewSymbol("excessive-lisp-nesting")
+
+	SymEndOfFile         = lisp.NewSymbol("end-of-file")
+	SymInvalidReadSyntax = lisp.NewSymbol("invalid-read-syntax")
+	SymSettingConstant   = lisp.NewSymbol("setting-constant")
+
+	SymCyclicFunctionIndirection = lisp.NewSymbol("cyclic-function-indirection")
+
+	SymNoCatch = lisp.NewSymbol("no-catch")
+
+	SymArithError    = lisp.NewSymbol("arith-error")
+	SymRangeError    = lisp.NewSymbol("range-error")
+	SymOverflowError = lisp.NewSymbol("overflow-error")
+	SymFileError     = lisp.NewSymbol("file-error")
+	SymFileMissing   = lisp.NewSymbol("file-missing")
+)
+
+// errorSymbols lists the error symbols above.
+var errorSymbols = []lisp.Object{
+	SymError,
+	SymWrongTypeArgument,
+	SymArgsOutOfRange,
+	SymBeginningOfBuffer,
+	SymEndOfBuffer,
+	SymScanError,
+	SymInvalidRegexp,
+	SymSearchFailed,
+	SymVoidVariable,
+	SymVoidFunction,
+	SymInvalidFunction,
+	SymWrongNumberOfArguments,
+	SymExcessiveLispNesting,
+	SymEndOfFile,
+	SymInvalidReadSyntax,
+	SymSettingConstant,
+	SymCyclicFunctionIndirection,
+	SymNoCatch,
+	SymArithError,
+	SymRangeError,
+	SymOverflowError,
+	SymFileError,
+	SymFileMissing,
+}
+
+// SymErrorConditions is the property of error symbols that
+// lists the conditions that condition-case handlers match:
+// the error symbol itself, its parents and `error`.
+var SymErrorConditions = lisp.NewSymbol("error-conditions")
+
+// errorParents holds parents of the error symbols that
+// are not direct children of `error`.
+var errorParents = map[*lisp.Symbol]lisp.Object{
+	SymRangeError.Symbol():    SymArithError,
+	SymOverflowError.Symbol(): SymRangeError,
+	SymFileMissing.Symbol():   SymFileError,
+}
+
+// errCallDepth is returned when call depth exceeds
+// the number of available call frames.
+var errCallDepth = signal(SymExcessiveLispNesting)
+
+// Type predicate symbols that are used as wrong-type-argument data.
+var (
+	SymIntegerp        = lisp.NewSymbol("integerp")
+	SymNumberp         = lisp.NewSymbol("numberp")
+	SymStringp         = lisp.NewSymbol("stringp")
+	SymCharacterp      = lisp.NewSymbol("characterp")
+	SymSymbolp         = lisp.NewSymbol("symbolp")
+	SymListp           = lisp.NewSymbol("listp")
+	SymConsp           = lisp.NewSymbol("consp")
+	SymVectorp         = lisp.NewSymbol("vectorp")
+	SymNumberOrMarkerp = lisp.NewSymbol("number-or-marker-p")
+	SymBufferp         = lisp.NewSymbol("bufferp")
+	SymUserPtrp        = lisp.NewSymbol("user-ptrp")
+	SymSyntaxTablep    = lisp.NewSymbol("syntax-table-p")
+
+	SymByteCodeFunctionp = lisp.NewSymbol("byte-code-function-p")
+	SymCharOrStringp     = lisp.NewSymbol("char-or-string-p")
+)
+
+// Signal is an Emacs Lisp error that is raised by `signal`.
+//
+// Unlike other errors, it can be handled by Lisp code (Issue#7).
+type Signal struct {
+	// Symbol is an error symbol, like `end-of-buffer`.
+	Symbol lisp.Object
+
+	// Data is a list of additional error information.
+	Data lisp.Object
+}
+
+// Error returns signal printed in `(symbol . data)` form.
+func (s *Signal) Error() string {
+	return lisp.ObjectString(lisp.NewCons(s.Symbol, s.Data))
+}
+
+// NewSignal returns a new signal error for sym with data list.
+// It is the Go counterpart of Emacs Lisp `signal` function.
+func NewSignal(sym lisp.Object, data ...lisp.Object) *Signal {
+	return &Signal{Symbol: sym, Data: lisp.List(data...)}
+}
+
+// signal is a shorter NewSignal alias for internal use.
+func signal(sym lisp.Object, data ...lisp.Object) *Signal {
+	return NewSignal(sym, data...)
+}
+
+// Throw is a non-local exit that is raised by `throw`.
+//
+// Like Signal, it propagates through both Lisp and Go
+// function calls until it is caught by `catch` (Issue#7).
+type Throw struct {
+	Tag   lisp.Object
+	Value lisp.Object
+}
+
+// Error returns throw printed in `(no-catch tag value)` form.
+func (t *Throw) Error() string {
+	return "(no-catch " + lisp.ObjectString(t.Tag) + " " + lisp.ObjectString(t.Value) + ")"
+}
+
+// wrongTypeArgument returns `wrong-type-argument` signal
+// that reports that x does not satisfy pred.
+func wrongTypeArgument(pred, x lisp.Object) *Signal {
+	return signal(SymWrongTypeArgument, pred, x)
+}
+
+// errorMessages holds `error-message` property values
+// of the error symbols.
+var errorMessages = map[*lisp.Symbol]string{
+	SymError.Symbol():                  "error",
+	SymWrongTypeArgument.Symbol():      "Wrong type argument",
+	SymArgsOutOfRange.Symbol():         "Args out of range",
+	SymBeginningOfBuffer.Symbol():      "Beginning of buffer",
+	SymEndOfBuffer.Symbol():            "End of buffer",
+	SymScanError.Symbol():              "Scan error",
+	SymInvalidRegexp.Symbol():          "Invalid regexp",
+	SymSearchFailed.Symbol():           "Search failed",
+	SymVoidVariable.Symbol():           "Symbol’s value as variable is void",
+	SymVoidFunction.Symbol():           "Symbol’s function definition is void",
+	SymInvalidFunction.Symbol():        "Invalid function",
+	SymWrongNumberOfArguments.Symbol(): "Wrong number of arguments",
+	SymExcessiveLispNesting.Symbol():   "Lisp nesting exceeds ‘max-lisp-eval-depth’",
+	SymEndOfFile.Symbol():              "End of file during parsing",
+	SymInvalidReadSyntax.Symbol():      "Invalid read syntax",
+	SymSettingConstant.Symbol():        "Attempt to set a constant symbol",
+
+	SymCyclicFunctionIndirection.Symbol(): "Symbol’s chain of function indirections contains a loop",
+
+	SymNoCatch.Symbol():       "No catch for tag",
+	SymArithError.Symbol():    "Arithmetic error",
+	SymRangeError.Symbol():    "Arithmetic range error",
+	SymOverflowError.Symbol(): "Arithmetic overflow error",
+	SymFileError.Symbol():     "File error",
+	SymFileMissing.Symbol():   "No such file or directory",
+}
+
+// defineErrors sets `error-conditions` property
+// of errorSymbols.
+func (env *MasterEnv) defineErrors() {
+	for _, sym := range errorSymbols {
+		conditions := []lisp.Object{sym}
+		for parent, ok := errorParents[sym.Symbol()]; ok; parent, ok = errorParents[parent.Symbol()] {
+			conditions = append(conditions, parent)
+		}
+		if sym.Ptr != SymError.Ptr {
+			conditions = append(conditions, SymError)
+		}
+		env.put(sym, SymErrorConditions, lisp.List(conditions...))
+	}
+}
+
+// ErrorMessage implements `error-message-string`.
+// It formats signals and throws like Emacs does in
+// batch mode and echo area; other errors are returned
+// by their Error method.
+func ErrorMessage(err error) string {
+	switch err := err.(type) {
+	case *Throw:
+		return "No catch for tag: " + lisp.Prin1String(err.Tag) +
+			", " + lisp.Prin1String(err.Value)
+	case *Signal:
+		msg, ok := errorMessages[err.Symbol.Symbol()]
+		if !ok {
+			msg = "peculiar error"
+		}
+		data := err.Data
+		// `error` message is its first argument.
+		if err.Symbol.Symbol() == SymError.Symbol() && data.Type == lisp.TypeCons {
+			if car := data.Cons().Car; car.Type == lisp.TypeString {
+				msg = string(car.String().Chars)
+				data = data.Cons().Cdr
+			}
+		}
+		sep := ": "
+		for ; data.Type == lisp.TypeCons; data = data.Cons().Cdr {
+			msg += sep + lisp.Prin1String(data.Cons().Car)
+			sep = ", "
+		}
+		return msg
+	}
+	return err.Error()
+}
+
+// AddSymbols makes symbols that are predefined by this
+// package readable: after the call, ob interns the same
+// error and type predicate symbols that runtime uses.
+// The symbols are shared by all obarrays; function
+// definitions are kept by MasterEnv, so environments
+// that bind these symbols to functions do not interfere.
+func AddSymbols(ob *lisp.Obarray) {
+	for _, sym := range predefinedSymbols {
+		ob.Add(sym)
+	}
+}
+
+// predefinedSymbols lists the symbols that AddSymbols adds.
+var predefinedSymbols = collectPredefinedSymbols()
+
+func collectPredefinedSymbols() []lisp.Object {
+	syms := []lisp.Object{
+		SymErrorConditions,
+
+		SymIntegerp,
+		SymNumberp,
+		SymStringp,
+		SymCharacterp,
+		SymSymbolp,
+		SymListp,
+		SymConsp,
+		SymVectorp,
+		SymNumberOrMarkerp,
+		SymBufferp,
+		SymUserPtrp,
+		SymSyntaxTablep,
+		SymByteCodeFunctionp,
+		SymCharOrStringp,
+
+		SymStandardOutput,
+		SymCaseFoldSearch,
+		symMany,
+
+		SymLambda,
+		SymClosure,
+		symOptional,
+		symRest,
+		symMakeClosure,
+		symMatchData,
+		symSetMatchData,
+
+		SymMacro,
+		SymCompilerMacro,
+	}
+	syms = append(syms, specialFormSymbols...)
+	syms = append(syms, errorSymbols...)
+	for _, sym := range typeSymbols {
+		if sym.Type == lisp.TypeSymbol {
+			syms = append(syms, sym)
+		}
+	}
+	return syms
+}
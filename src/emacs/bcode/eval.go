@@ -4,39 +4,74 @@ import (
 	"emacs/lisp"
 )
 
-// fetchB returns 8bit instruction argument at pc offset in code.
-func fetchB(pc uint32, code []byte) uint32 {
-	return uint32(code[pc+1])
-}
-
-// fetchW returns 16bit instruction argument at pc offset in code.
-func fetchW(pc uint32, code []byte) uint32 {
-	return uint32(code[pc+1]) +
-		uint32(code[pc+2])<<8
-}
-
 // evalExt runs single instruction that is prefixed by OpExt byte.
 //
 // Moved outside of normal eval to preserve the code density
 // of code that gets executed more frequently.
-func evalExt(env *Env, fn *Func, sp, pc uint32) (uint32, error) {
-	switch fn.code[pc] {
+func evalExt(env *Env, in *insn, sp uint32) (uint32, error) {
+	switch in.ext {
 	case OpExtStop:
 		return sp, ErrEOF
-
-	case OpExtGoCall0, OpExtGoCall1, OpExtGoCall2, OpExtGoCall3, OpExtGoCall4, OpExtGoCall5:
-		op := uint32(fn.code[pc])
-		fsym := env.stack[sp-op].Symbol()
-		err := env.goFuncs[fsym.FuncID](env.stack[sp-op : sp])
-		if err != nil {
-			return sp, err
-		}
-		return sp - op + 1, nil
+	case OpExtGoCallW:
+		fsym := env.stack[sp-in.arg-1].Symbol()
+		return callGoFunc(env, sp, in.arg, env.funcRef(fsym).goID)
 	}
 
 	return sp, nil
 }
 
+// callGoFunc calls Go function id with nargs arguments.
+// Function symbol and arguments are taken from the stack top;
+// they are replaced by the call result.
+func callGoFunc(env *Env, sp, nargs uint32, id int) (uint32, error) {
+	fp := sp - nargs - 1
+	env.stackTop = sp
+	err := env.goFuncs[id](env, env.stack[fp:sp])
+	if err != nil {
+		env.addFrame(err, env.stack[fp], env.stack[fp+1:sp])
+		return sp, err
+	}
+	return fp + 1, nil
+}
+
+// compareOps maps comparison opcodes to
+// the compare function predicates.
+var compareOps = [256]func(c int) bool{
+	OpEqlsign: func(c int) bool { return c == 0 },
+	OpGtr:     func(c int) bool { return c > 0 },
+	OpLss:     func(c int) bool { return c < 0 },
+	OpLeq:     func(c int) bool { return c <= 0 },
+	OpGeq:     func(c int) bool { return c >= 0 },
+}
+
+// calleeRef returns the function binding of callee
+// symbol; it is zero for other callees.
+func (env *Env) calleeRef(callee *lisp.Object) funcRef {
+	if callee.Type != lisp.TypeSymbol {
+		return funcRef{}
+	}
+	return env.funcRef(callee.Symbol())
+}
+
+// callNonCompiled performs OpCall for callees that are
+// not compiled Lisp functions: Go functions (ref is
+// their binding) are called directly, other callees
+// are called by Funcall.
+func callNonCompiled(env *Env, sp, nargs uint32, ref funcRef) (uint32, error) {
+	fp := sp - nargs - 1
+	callee := env.stack[fp]
+	if ref.goID != 0 {
+		return callGoFunc(env, sp, nargs, ref.goID)
+	}
+	env.stackTop = sp
+	val, err := env.Funcall(callee, env.stack[fp+1:sp]...)
+	if err != nil {
+		return sp, err
+	}
+	env.stack[fp] = val
+	return fp + 1, nil
+}
+
 // eval is main byte code evaluating routine.
 //
 // Input arguments:
@@ -44,404 +79,493 @@ func evalExt(env *Env, fn *Func, sp, pc uint32) (uint32, error) {
 //   fn - "main" function, evaluation entry point.
 //   sp - stack pointer (position inside env.stack).
 //
+// fn must be translated: Funcs are executed by many
+// goroutines, so they are never modified after creation.
+//
 // Returns new stack pointer value along with error.
 // Successful evaluation yields ErrEOF error value, not nil error.
 //
 // Does not catch Go panics.
 func eval(env *Env, fn *Func, sp uint32) (uint32, error) {
-	stack := env.stack
-	frames := env.frames
-	funcs := env.funcs
-
-	// Zero frame always forces OpReturn to set pc to
-	// trailing {OpExt,OpExtStop} that valid fn code should have.
-	callDepth := 0
-	frames[0].pc = uint32(len(fn.code) - 2)
-	frames[0].fp = 0
-	frames[0].fn = fn
-
 	if safetyCheck {
 		// Check that byte code really has trailing {OpExt,OpExtStop}.
-		pc := frames[0].pc
-		if fn.code[pc+0] != OpExt || fn.code[pc+1] != OpExtStop {
+		n := len(fn.code)
+		if n < 2 || fn.code[n-2] != OpExt || fn.code[n-1] != OpExtStop {
 			return sp, ErrStopByte
 		}
 	}
+	// Zero frame always forces OpReturn to continue from
+	// trailing {OpExt,OpExtStop} that valid fn code should have;
+	// it is followed by the insnBad instruction.
+	frame := callFrame{
+		pc: uint32(len(fn.insns) - 3),
+		fp: 0,
+		fn: fn,
+	}
+
+	return run(env, fn, sp, frame)
+}
+
+// run executes fn from its first instruction.
+//
+// frame becomes the activation record of fn; it is stored
+// at env.callDepth index, so nested run calls do not clobber
+// frames of the code that is already being evaluated.
+// Evaluation stops when {OpExt,OpExtStop} is executed.
+//
+// run executes pre-decoded fn.insns, see translate.
+// pc is an instruction index.
+//
+// Errors caught by the handlers that fn and its callees push
+// resume execution at the handler target, see handleError.
+// Handlers are removed when run returns.
+func run(env *Env, fn *Func, sp uint32, frame callFrame) (uint32, error) {
+	base := env.callDepth
+	if base >= len(env.frames) {
+		return sp, errCallDepth
+	}
+	if sp+fn.maxStack > uint32(len(env.stack)) {
+		return sp, ErrStackOverflow
+	}
+	env.frames[base] = frame
 
-	pc := uint32(0)
+	hbase, catchTags := len(env.handlers), len(env.catchTags)
+	pc, callDepth := uint32(0), base
+	for {
+		var err error
+		sp, err = exec(env, fn, sp, pc, base, callDepth)
+		h, val, ok := env.handleError(hbase, err)
+		if !ok {
+			env.handlers = env.handlers[:hbase]
+			env.catchTags = env.catchTags[:catchTags]
+			return sp, err
+		}
+		fn, pc, callDepth, sp = h.fn, h.target, h.callDepth, h.sp
+		env.stack[sp] = val
+		sp++
+	}
+}
 
+// exec is the run interpreter loop.
+// It executes fn from pc; callDepth is the frame of fn
+// and base is the frame of the function run was called for.
+func exec(env *Env, fn *Func, sp, pc uint32, base, callDepth int) (uint32, error) {
+	stack := env.stack
 	for {
-		switch fn.code[pc] {
+		switch fn.insns[pc].op {
 		default:
-			return sp, ErrBadOpcode
+			return sp, env.traceError(fn, base, callDepth, ErrBadOpcode)
 
-		case OpExt:
+		case insnExt:
+			// Go functions may re-enter the interpreter;
+			// nested evaluation must start above our frames.
+			env.callDepth = callDepth + 1
 			var err error
-			sp, err = evalExt(env, fn, sp, pc+1)
+			sp, err = evalExt(env, &fn.insns[pc], sp)
+			env.callDepth = base
 			if err != nil {
-				return sp, err
+				return sp, env.traceError(fn, base, callDepth, err)
 			}
-			pc += extOpWidth[fn.code[pc+1]]
+			pc++
 
-		case OpStackRef1:
-			stack[sp] = stack[sp-2]
+		case insnStackRef:
+			stack[sp] = stack[sp-fn.insns[pc].arg-1]
 			sp++
 			pc++
-		case OpStackRef2:
-			stack[sp] = stack[sp-3]
+
+		case insnCall:
+			nargs := fn.insns[pc].arg
+			ref := env.calleeRef(&stack[sp-nargs-1])
+			callee := ref.fn
+			if callee == nil {
+				env.callDepth = callDepth + 1
+				var err error
+				sp, err = callNonCompiled(env, sp, nargs, ref)
+				env.callDepth = base
+				if err != nil {
+					return sp, env.traceError(fn, base, callDepth, err)
+				}
+				pc++
+				break
+			}
+			if nargs != callee.nargs || callee.rest || callee.dynamic {
+				var err error
+				if sp, err = env.setupArgs(callee, sp, nargs); err != nil {
+					return sp, env.traceError(fn, base, callDepth, err)
+				}
+				nargs = callee.nargs
+			}
+			if callDepth+1 == len(env.frames) {
+				return sp, env.traceError(fn, base, callDepth, errCallDepth)
+			}
+			callDepth++
+			// OpReturn resumes execution at frame pc+1.
+			env.frames[callDepth].pc = pc
+			env.frames[callDepth].fp = sp - nargs
+			env.frames[callDepth].fn = fn
+			fn = callee
+			pc = 0
+			if sp+fn.maxStack > uint32(len(stack)) {
+				return sp, env.traceError(fn, base, callDepth, ErrStackOverflow)
+			}
+
+		case insnVarRef:
+			var err error
+			stack[sp], err = env.symbolValue(*fn.insns[pc].obj)
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			sp++
 			pc++
-		case OpStackRef3:
-			stack[sp] = stack[sp-4]
-			sp++
+		case insnVarSet:
+			sp--
+			env.setSymbolValue(*fn.insns[pc].obj, stack[sp])
 			pc++
-		case OpStackRef4:
-			stack[sp] = stack[sp-5]
-			sp++
+		case insnVarBind:
+			sp--
+			env.specbind(*fn.insns[pc].obj, stack[sp])
 			pc++
-		case OpStackRef5:
-			stack[sp] = stack[sp-6]
-			sp++
+		case insnUnbind:
+			env.unbindTo(env.specpdlIndex() - int(fn.insns[pc].arg))
 			pc++
-		case OpStackRefB:
-			n := fetchB(pc, fn.code)
-			stack[sp] = stack[sp-n-1]
-			sp++
-			pc += 2
-		case OpStackRefW:
-			n := fetchW(pc, fn.code)
-			stack[sp] = stack[sp-n-1]
-			sp++
-			pc += 3
 
-		case OpCall0:
-			callDepth++
-			frames[callDepth].pc = pc
-			frames[callDepth].fp = sp - 0
-			frames[callDepth].fn = fn
-			fn = &funcs[stack[sp-1].Symbol().FuncID]
-			pc = 0
-		case OpCall1:
-			callDepth++
-			frames[callDepth].pc = pc
-			frames[callDepth].fp = sp - 1
-			frames[callDepth].fn = fn
-			fn = &funcs[stack[sp-2].Symbol().FuncID]
-			pc = 0
+		case insnEq:
+			sp--
+			stack[sp-1] = lisp.Bool(lisp.Eq(&stack[sp-1], &stack[sp]))
+			pc++
+		case insnNot:
+			stack[sp-1] = lisp.Bool(lisp.Null(&stack[sp-1]))
+			pc++
+		case insnCar:
+			var err error
+			stack[sp-1], err = car(stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
+			pc++
+		case insnCdr:
+			var err error
+			stack[sp-1], err = cdr(stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
+			pc++
 
-		case OpCons:
+		case insnCons:
 			sp--
-			car := stack[sp]
-			cdr := stack[sp-1]
-			stack[sp-1] = lisp.NewCons(car, cdr)
+			stack[sp-1] = env.NewCons(stack[sp-1], stack[sp])
+			pc++
+
+		case insnSetcar:
+			sp--
+			var err error
+			stack[sp-1], err = setcar(stack[sp-1], stack[sp])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
 
-		case OpDiscard:
+		case insnDiscard:
 			sp--
 			pc++
 
-		case OpReturn:
-			frame := &frames[callDepth]
+		case insnReturn:
+			frame := &env.frames[callDepth]
 			stack[frame.fp-1] = stack[sp-1]
 			sp = frame.fp
 			fn = frame.fn
 			pc = frame.pc + 1
 			callDepth--
 
-		case OpAdd1:
-			// Issue#11
+		case insnAdd1:
 			x := &stack[sp-1]
 			switch x.Type {
 			case lisp.TypeInt:
 				x.SetInt(x.Int() + 1)
 			case lisp.TypeFloat:
 				x.SetFloat(x.Float() + 1)
+			default:
+				return sp, env.traceError(fn, base, callDepth, wrongTypeArgument(SymNumberOrMarkerp, *x))
 			}
 			pc++
 
-		case OpConstantW:
-			stack[sp] = fn.consts[fetchW(pc, fn.code)]
-			sp++
-			pc += 3
-
-		case OpGotoW:
-			pc = fetchW(pc, fn.code)
-
-		case OpGotoIfNilW:
-			sp--
-			top := &stack[sp]
-			if lisp.Null(top) {
-				pc = fetchW(pc, fn.code)
-			} else {
-				pc += 3
+		case insnSub1:
+			x := &stack[sp-1]
+			switch x.Type {
+			case lisp.TypeInt:
+				x.SetInt(x.Int() - 1)
+			case lisp.TypeFloat:
+				x.SetFloat(x.Float() - 1)
+			default:
+				return sp, env.traceError(fn, base, callDepth, wrongTypeArgument(SymNumberOrMarkerp, *x))
 			}
-
-		case OpDup:
-			stack[sp] = stack[sp-1]
-			sp++
-			pc++
-
-		case OpDiscardB:
-			n := fetchB(pc, fn.code)
-			sp -= n
-			pc += 2
-
-		case OpConstant0:
-			stack[sp] = fn.consts[0]
-			sp++
-			pc++
-		case OpConstant1:
-			stack[sp] = fn.consts[1]
-			sp++
-			pc++
-		case OpConstant2:
-			stack[sp] = fn.consts[2]
-			sp++
-			pc++
-		case OpConstant3:
-			stack[sp] = fn.consts[3]
-			sp++
-			pc++
-		case OpConstant4:
-			stack[sp] = fn.consts[4]
-			sp++
-			pc++
-		case OpConstant5:
-			stack[sp] = fn.consts[5]
-			sp++
-			pc++
-		case OpConstant6:
-			stack[sp] = fn.consts[6]
-			sp++
-			pc++
-		case OpConstant7:
-			stack[sp] = fn.consts[7]
-			sp++
-			pc++
-		case OpConstant8:
-			stack[sp] = fn.consts[8]
-			sp++
-			pc++
-		case OpConstant9:
-			stack[sp] = fn.consts[9]
-			sp++
-			pc++
-		case OpConstant10:
-			stack[sp] = fn.consts[10]
-			sp++
-			pc++
-		case OpConstant11:
-			stack[sp] = fn.consts[11]
-			sp++
-			pc++
-		case OpConstant12:
-			stack[sp] = fn.consts[12]
-			sp++
-			pc++
-		case OpConstant13:
-			stack[sp] = fn.consts[13]
-			sp++
-			pc++
-		case OpConstant14:
-			stack[sp] = fn.consts[14]
-			sp++
-			pc++
-		case OpConstant15:
-			stack[sp] = fn.consts[15]
-			sp++
-			pc++
-		case OpConstant16:
-			stack[sp] = fn.consts[16]
-			sp++
-			pc++
-		case OpConstant17:
-			stack[sp] = fn.consts[17]
-			sp++
-			pc++
-		case OpConstant18:
-			stack[sp] = fn.consts[18]
-			sp++
-			pc++
-		case OpConstant19:
-			stack[sp] = fn.consts[19]
-			sp++
-			pc++
-		case OpConstant20:
-			stack[sp] = fn.consts[20]
-			sp++
-			pc++
-		case OpConstant21:
-			stack[sp] = fn.consts[21]
-			sp++
-			pc++
-		case OpConstant22:
-			stack[sp] = fn.consts[22]
-			sp++
 			pc++
-		case OpConstant23:
-			stack[sp] = fn.consts[23]
-			sp++
-			pc++
-		case OpConstant24:
-			stack[sp] = fn.consts[24]
-			sp++
-			pc++
-		case OpConstant25:
-			stack[sp] = fn.consts[25]
-			sp++
-			pc++
-		case OpConstant26:
-			stack[sp] = fn.consts[26]
-			sp++
-			pc++
-		case OpConstant27:
-			stack[sp] = fn.consts[27]
-			sp++
-			pc++
-		case OpConstant28:
-			stack[sp] = fn.consts[28]
-			sp++
-			pc++
-		case OpConstant29:
-			stack[sp] = fn.consts[29]
-			sp++
-			pc++
-		case OpConstant30:
-			stack[sp] = fn.consts[30]
-			sp++
-			pc++
-		case OpConstant31:
-			stack[sp] = fn.consts[31]
-			sp++
-			pc++
-		case OpConstant32:
-			stack[sp] = fn.consts[32]
-			sp++
-			pc++
-		case OpConstant33:
-			stack[sp] = fn.consts[33]
-			sp++
-			pc++
-		case OpConstant34:
-			stack[sp] = fn.consts[34]
-			sp++
-			pc++
-		case OpConstant35:
-			stack[sp] = fn.consts[35]
-			sp++
+		case insnPlus, insnDiff, insnMult:
+			op := opAdd
+			switch fn.insns[pc].op {
+			case insnDiff:
+				op = opSub
+			case insnMult:
+				op = opMul
+			}
+			sp--
+			var err error
+			stack[sp-1], err = arith(op, stack[sp-1:sp+1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant36:
-			stack[sp] = fn.consts[36]
-			sp++
+		case insnNegate:
+			x := &stack[sp-1]
+			switch x.Type {
+			case lisp.TypeInt:
+				x.SetInt(-x.Int())
+			case lisp.TypeFloat:
+				x.SetFloat(-x.Float())
+			default:
+				return sp, env.traceError(fn, base, callDepth, wrongTypeArgument(SymNumberOrMarkerp, *x))
+			}
 			pc++
-		case OpConstant37:
-			stack[sp] = fn.consts[37]
-			sp++
+		case insnCompare:
+			sp--
+			ok, err := compare(stack[sp-1], stack[sp:sp+1], compareOps[fn.insns[pc].arg])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
+			stack[sp-1] = lisp.Bool(ok)
 			pc++
-		case OpConstant38:
-			stack[sp] = fn.consts[38]
+
+		case insnPoint:
+			stack[sp] = lisp.NewInt(int64(env.buffer.Point()))
 			sp++
 			pc++
-		case OpConstant39:
-			stack[sp] = fn.consts[39]
+		case insnCurrentColumn:
+			stack[sp] = lisp.NewInt(int64(currentColumn(env.buffer)))
 			sp++
 			pc++
-		case OpConstant40:
-			stack[sp] = fn.consts[40]
-			sp++
+		case insnIndentTo:
+			var err error
+			stack[sp-1], err = indentTo(env.buffer, &stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant41:
-			stack[sp] = fn.consts[41]
-			sp++
+		case insnForwardChar:
+			var err error
+			stack[sp-1], err = forwardChar(env.buffer, &stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant42:
-			stack[sp] = fn.consts[42]
-			sp++
+		case insnForwardWord:
+			var err error
+			stack[sp-1], err = forwardWord(env.buffer, env.syntaxTable(), &stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant43:
-			stack[sp] = fn.consts[43]
-			sp++
+		case insnSkipCharsForward:
+			var err error
+			sp--
+			stack[sp-1], err = skipChars(env.buffer, env.syntaxTable(), true, &stack[sp-1], &stack[sp])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant44:
-			stack[sp] = fn.consts[44]
-			sp++
+		case insnSkipCharsBackward:
+			var err error
+			sp--
+			stack[sp-1], err = skipChars(env.buffer, env.syntaxTable(), false, &stack[sp-1], &stack[sp])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant45:
-			stack[sp] = fn.consts[45]
-			sp++
+		case insnCharSyntax:
+			var err error
+			stack[sp-1], err = charSyntax(env.syntaxTable(), &stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant46:
-			stack[sp] = fn.consts[46]
-			sp++
+		case insnForwardLine:
+			var err error
+			stack[sp-1], err = forwardLine(env.buffer, &stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant47:
-			stack[sp] = fn.consts[47]
-			sp++
+		case insnEndOfLine:
+			var err error
+			stack[sp-1], err = endOfLine(env.buffer, &stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant48:
-			stack[sp] = fn.consts[48]
-			sp++
+		case insnSaveExcursion:
+			env.recordExcursion()
 			pc++
-		case OpConstant49:
-			stack[sp] = fn.consts[49]
-			sp++
+		case insnSaveRestriction:
+			env.recordRestriction()
 			pc++
-		case OpConstant50:
-			stack[sp] = fn.consts[50]
-			sp++
+		case insnSaveCurrentBuffer:
+			env.recordCurrentBuffer()
 			pc++
-		case OpConstant51:
-			stack[sp] = fn.consts[51]
-			sp++
+		case insnTempOutputBufferSetup:
+			var err error
+			stack[sp-1], err = env.tempOutputBufferSetup(&stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant52:
-			stack[sp] = fn.consts[52]
-			sp++
+		case insnTempOutputBufferShow:
+			var err error
+			sp--
+			stack[sp-1], err = env.tempOutputBufferShow(&stack[sp], &stack[sp-1])
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant53:
-			stack[sp] = fn.consts[53]
-			sp++
+		case insnMatchBeginning:
+			var err error
+			stack[sp-1], err = env.matchBound(&stack[sp-1], 0)
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant54:
-			stack[sp] = fn.consts[54]
-			sp++
+		case insnMatchEnd:
+			var err error
+			stack[sp-1], err = env.matchBound(&stack[sp-1], 1)
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
 			pc++
-		case OpConstant55:
-			stack[sp] = fn.consts[55]
-			sp++
+
+		case insnPushCatch, insnPushConditionCase:
+			sp--
+			env.pushHandler(handler{
+				catch:     fn.insns[pc].op == insnPushCatch,
+				tag:       stack[sp],
+				fn:        fn,
+				target:    fn.insns[pc].arg,
+				callDepth: callDepth,
+				sp:        sp,
+			})
 			pc++
-		case OpConstant56:
-			stack[sp] = fn.consts[56]
-			sp++
+
+		case insnPushUnwind:
+			env.pushHandler(handler{
+				unwind:    true,
+				fn:        fn,
+				target:    fn.insns[pc].arg,
+				callDepth: callDepth,
+				sp:        sp,
+			})
+			pc++
+		case insnRethrow:
+			// The error already has the frames of the
+			// calls it left, they are not added again.
+			if err := env.rethrow(&stack[sp-1]); err != nil {
+				return sp, err
+			}
 			pc++
-		case OpConstant57:
-			stack[sp] = fn.consts[57]
-			sp++
+
+		case insnPopHandler:
+			// Handlers of callers can't be popped.
+			if len(env.handlers) == 0 || env.handlers[len(env.handlers)-1].callDepth != callDepth {
+				return sp, env.traceError(fn, base, callDepth, ErrBadOpcode)
+			}
+			env.popHandler()
 			pc++
-		case OpConstant58:
-			stack[sp] = fn.consts[58]
+
+		case insnConstant:
+			stack[sp] = *fn.insns[pc].obj
 			sp++
 			pc++
-		case OpConstant59:
-			stack[sp] = fn.consts[59]
+
+		case insnGoto:
+			pc = fn.insns[pc].arg
+
+		case insnGotoIfNil:
+			sp--
+			if lisp.Null(&stack[sp]) {
+				pc = fn.insns[pc].arg
+			} else {
+				pc++
+			}
+		case insnGotoIfNonNil:
+			sp--
+			if !lisp.Null(&stack[sp]) {
+				pc = fn.insns[pc].arg
+			} else {
+				pc++
+			}
+		case insnGotoIfNilElsePop:
+			if lisp.Null(&stack[sp-1]) {
+				pc = fn.insns[pc].arg
+			} else {
+				sp--
+				pc++
+			}
+		case insnGotoIfNonNilElsePop:
+			if !lisp.Null(&stack[sp-1]) {
+				pc = fn.insns[pc].arg
+			} else {
+				sp--
+				pc++
+			}
+
+		case insnDup:
+			stack[sp] = stack[sp-1]
 			sp++
 			pc++
-		case OpConstant60:
-			stack[sp] = fn.consts[60]
-			sp++
+
+		case insnStackSet:
+			n := fn.insns[pc].arg
+			sp--
+			stack[sp-n] = stack[sp]
 			pc++
-		case OpConstant61:
-			stack[sp] = fn.consts[61]
-			sp++
+
+		case insnDiscardN:
+			n := fn.insns[pc].arg
+			if n&discardPreserveTOS != 0 {
+				n &^= discardPreserveTOS
+				stack[sp-n-1] = stack[sp-1]
+			}
+			sp -= n
 			pc++
-		case OpConstant62:
-			stack[sp] = fn.consts[62]
+
+		// Superinstructions, see fuseInsns.
+		// They skip the rest of the fused insns;
+		// the operands are read from those insns.
+		case insnStackRefCar:
+			var err error
+			stack[sp], err = car(stack[sp-fn.insns[pc].arg-1])
 			sp++
-			pc++
-		case OpConstant63:
-			stack[sp] = fn.consts[63]
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
+			pc += 2
+		case insnStackRefCdr:
+			var err error
+			stack[sp], err = cdr(stack[sp-fn.insns[pc].arg-1])
 			sp++
-			pc++
+			if err != nil {
+				return sp, env.traceError(fn, base, callDepth, err)
+			}
+			pc += 2
+		case insnStackRefGotoIfNil:
+			if lisp.Null(&stack[sp-fn.insns[pc].arg-1]) {
+				pc = fn.insns[pc+1].arg
+			} else {
+				pc += 2
+			}
+		case insnDupStackSet:
+			stack[sp-fn.insns[pc+1].arg] = stack[sp-1]
+			pc += 2
+		case insnDupStackSetDiscard:
+			sp--
+			stack[sp-fn.insns[pc+1].arg+1] = stack[sp]
+			pc += 3
 		}
 	}
 }
@@ -37,6 +37,7 @@ func BenchmarkEvalConstant(b *testing.B) {
 		consts: consts,
 	}
 
+	main.translate()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, err := eval(&env.Env, &main, 0)
@@ -73,6 +74,7 @@ func BenchmarkEvalStack(b *testing.B) {
 
 	main := Func{code: code}
 
+	main.translate()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, err := eval(&env.Env, &main, 1)
@@ -143,6 +145,7 @@ func BenchmarkEvalCall(b *testing.B) {
 		consts: []lisp.Object{nopA},
 	}
 
+	main.translate()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, err := eval(&env.Env, &main, 0)
@@ -4,6 +4,7 @@ import (
 	"emacs/lisp"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -31,41 +32,52 @@ func (env *testEnv) AddFunc(name string, fn Func) lisp.Object {
 	}
 
 	fsym := lisp.NewSymbol(name)
-	fsym.Symbol().FuncID = len(env.funcs)
-
 	env.symbols[name] = fsym
-	env.funcs = append(env.funcs, fn)
+	fn.translate()
+	env.setFuncRef(fsym.Symbol(), funcRef{fn: &fn})
 
 	return fsym
 }
 
-// AddFunc binds name to fn and returns associated Lisp symbol.
+// AddGoFunc binds name to fn and returns associated Lisp symbol.
 // Function is expected to be non-nil Go function.
 func (env *testEnv) AddGoFunc(name string, fn GoFunc) lisp.Object {
+	fsym := env.newFsym(name)
+	env.DefineGoFunc(fsym, fn)
+	return fsym
+}
+
+// AddGoFuncCtx is like AddGoFunc, but for GoFuncCtx functions.
+func (env *testEnv) AddGoFuncCtx(name string, fn GoFuncCtx) lisp.Object {
+	fsym := env.newFsym(name)
+	env.DefineGoFuncCtx(fsym, fn)
+	return fsym
+}
+
+// newFsym returns a new symbol for name that is not bound yet.
+func (env *testEnv) newFsym(name string) lisp.Object {
 	if _, ok := env.symbols[name]; ok {
 		panic(fmt.Sprintf("`%s` fsym is already bound", name))
 	}
-
 	fsym := lisp.NewSymbol(name)
-	fsym.Symbol().FuncID = len(env.goFuncs)
-
 	env.symbols[name] = fsym
-	env.goFuncs = append(env.goFuncs, fn)
-
 	return fsym
 }
 
 func newTestEnv() *testEnv {
 	master := MasterEnv{
 		// Functions with ID=0 must be unassigned.
-		goFuncs: make([]GoFunc, 1),
-		funcs:   make([]Func, 1),
+		goFuncs: make([]GoFuncCtx, 1),
+
+		stdSyntaxTable: newStandardSyntaxTable(),
 	}
+	buf := lisp.NewBuffer("*scratch*")
 	return &testEnv{
 		Env: Env{
 			MasterEnv: &master,
 			stack:     make([]lisp.Object, 128),
 			frames:    make([]callFrame, 32),
+			buffer:    buf.Buffer(),
 		},
 		symbols: make(map[string]lisp.Object),
 	}
@@ -104,6 +116,7 @@ func (interp *testInterpreter) Run(name string, consts, args []lisp.Object) {
 				code:   append(interp.stepsCode[i], OpExt, OpExtStop),
 				consts: consts,
 			}
+			fn.translate()
 
 			var err error
 			stackDepth, err = eval(env, &fn, stackDepth)
@@ -141,7 +154,7 @@ func (interp *testInterpreter) LoadSteps(toks []interface{}) {
 
 			case OpExtGoCallW:
 				b1 := byte(toks[i+2].(int) & 0x00FF)
-				b2 := byte(toks[i+2].(int) & 0xFF00)
+				b2 := byte(toks[i+2].(int) >> 8)
 				code = append(code, []byte{OpExt, op, b1, b2})
 				state = append(state, toks[i+3].(string))
 				i += 4
@@ -185,7 +198,7 @@ func (interp *testInterpreter) LoadSteps(toks []interface{}) {
 			OpConstantW,
 			OpStackSetW:
 			b1 := byte(toks[i+1].(int) & 0x00FF)
-			b2 := byte(toks[i+1].(int) & 0xFF00)
+			b2 := byte(toks[i+1].(int) >> 8)
 			code = append(code, []byte{op, b1, b2})
 			state = append(state, toks[i+2].(string))
 			i += 3
@@ -225,6 +238,7 @@ func TestEval(t *testing.T) {
 			OpAdd1,
 			OpReturn,
 		},
+		nargs: 1,
 	})
 
 	// Go functions.
@@ -284,6 +298,17 @@ func TestEval(t *testing.T) {
 			},
 		},
 
+		{
+			"DiscardPreserveTOS",
+			consts{},
+			args{1, 2, 3, 4},
+			steps{
+				OpDiscardB, 0x82, `1 4`,
+				OpDiscardB, 0x80, `1 4`,
+				OpDiscardB, 0x81, `4`,
+			},
+		},
+
 		{
 			"StackRef",
 			consts{},
@@ -360,6 +385,72 @@ func TestEval(t *testing.T) {
 	}
 }
 
+func TestEvalGoCallN(t *testing.T) {
+	interp := newTestInterpreter(t)
+	interp.stack = make([]lisp.Object, 1024)
+
+	goConcat := interp.AddGoFunc("concat", func(args []lisp.Object) error {
+		var chars []byte
+		for _, arg := range args[1:] {
+			chars = append(chars, arg.String().Chars...)
+		}
+		args[0] = lisp.NewString(chars)
+		return nil
+	})
+	goList := interp.AddGoFunc("list", func(args []lisp.Object) error {
+		args[0] = lisp.List(args[1:]...)
+		return nil
+	})
+
+	type steps []interface{}
+	consts := []lisp.Object{
+		goConcat,
+		goList,
+		lisp.NewString([]byte("ab")),
+		lisp.NewInt(7),
+	}
+
+	// pushArgs returns steps that push fsym and n copies of arg,
+	// along with the final stack state.
+	pushArgs := func(fsymOp, argOp byte, n int, fsym, arg string) (steps, string) {
+		state := fsym
+		toks := steps{fsymOp, state}
+		for i := 0; i < n; i++ {
+			state += " " + arg
+			toks = append(toks, argOp, state)
+		}
+		return toks, state
+	}
+
+	toks, _ := pushArgs(OpConstant0, OpConstant2, 200, "concat", `"ab"`)
+	want := `"` + strings.Repeat("ab", 200) + `"`
+	toks = append(toks,
+		OpExt, OpExtGoCallB, 200, want,
+		OpDup, want+" "+want)
+	interp.LoadSteps(toks)
+	interp.Run("GoCallB", consts, nil)
+
+	toks, _ = pushArgs(OpConstant1, OpConstant3, 300, "list", "7")
+	vals := make([]lisp.Object, 300)
+	for i := range vals {
+		vals[i] = lisp.NewInt(7)
+	}
+	want = lisp.ObjectString(lisp.List(vals...))
+	toks = append(toks,
+		OpExt, OpExtGoCallW, 300, want,
+		OpDup, want+" "+want)
+	interp.LoadSteps(toks)
+	interp.Run("GoCallW", consts, nil)
+
+	interp.LoadSteps(steps{
+		OpConstant1, `list`,
+		OpExt, OpExtGoCallB, 0, `nil`,
+		OpConstant0, `nil concat`,
+		OpExt, OpExtGoCallW, 0, `nil ""`,
+	})
+	interp.Run("GoCallNoArgs", consts, nil)
+}
+
 // promoteObject replaces value of primitive type with valid lisp.Object.
 func promoteObject(x interface{}) lisp.Object {
 	switch x := x.(type) {
@@ -0,0 +1,187 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Calling Lisp functions from Go.
+//
+// Go functions may call both compiled Lisp functions and
+// other Go functions through Funcall and Apply.
+// Nested calls use the data stack above the caller arguments
+// and the call frames above the currently active ones,
+// so they can be arbitrary deep as long as frames are available.
+// Errors (signals and throws) propagate in both directions.
+
+// funcallStop is used as a return address of the functions
+// that are called by Funcall.
+// OpReturn continues execution from pc=1, which stops the evaluation.
+var funcallStop = Func{code: []byte{OpExt, OpExtStop, OpExt, OpExtStop}}
+
+func init() {
+	funcallStop.translate()
+}
+
+// Funcall implements `funcall`.
+// It calls fn with args and returns its result.
+//
+// fn is a function symbol, a compiled function object,
+// or an interpreted function: (lambda ...) or (closure ...) list.
+// Signals invalid-function if fn is not a function and
+// void-function if fn is a symbol without function definition.
+// Bindings that are not removed by the callee
+// (like after the error) are removed before return.
+func (env *Env) Funcall(fn lisp.Object, args ...lisp.Object) (lisp.Object, error) {
+	switch fn.Type {
+	case lisp.TypeSymbol:
+		ref := env.funcRef(fn.Symbol())
+		if !ref.bound() {
+			return env.funcallDef(fn, args)
+		}
+		if ref.fn == nil {
+			return env.call(fn, args, func(fp, sp uint32) error {
+				env.callDepth++
+				_, err := callGoFunc(env, sp, uint32(len(args)), ref.goID)
+				return err
+			})
+		}
+		return env.exec(fn, ref.fn, args)
+	case lisp.TypeFunc:
+		return env.exec(lisp.Nil, objectFunc(&fn), args)
+	case lisp.TypeCons:
+		return env.funcallLambda(fn, fn, args)
+	}
+	return lisp.Nil, signal(SymInvalidFunction, fn)
+}
+
+// Exec calls compiled function fn with args and returns its result.
+// Unlike Funcall, fn does not have to be bound to a symbol.
+func (env *Env) Exec(fn *Func, args ...lisp.Object) (lisp.Object, error) {
+	return env.exec(lisp.Nil, fn, args)
+}
+
+// exec calls compiled function fn with args.
+// callee is the function symbol, it is used in backtraces.
+func (env *Env) exec(callee lisp.Object, fn *Func, args []lisp.Object) (lisp.Object, error) {
+	return env.call(callee, args, func(fp, sp uint32) error {
+		if len(args) != int(fn.nargs) || fn.rest || fn.dynamic {
+			var err error
+			if sp, err = env.setupArgs(fn, sp, uint32(len(args))); err != nil {
+				return err
+			}
+		}
+		frame := callFrame{pc: 0, fp: fp + 1, fn: &funcallStop}
+		if _, err := run(env, fn, sp, frame); err != ErrEOF {
+			return err
+		}
+		return nil
+	})
+}
+
+// arity returns the number of arguments that fn accepts.
+func (fn *Func) arity() Arity {
+	nonrest := int(fn.nargs)
+	if fn.rest {
+		nonrest--
+	}
+	arity := Arity{Min: nonrest - int(fn.optArgs), Max: nonrest}
+	if fn.rest {
+		arity.Max = ArityMany
+	}
+	return arity
+}
+
+// setupArgs turns nargs arguments on the stack top into
+// fn argument slots: missing &optional arguments are set
+// to nil and the remaining arguments are collected into
+// the &rest list.
+// Returns the new stack pointer.
+func (env *Env) setupArgs(fn *Func, sp, nargs uint32) (uint32, error) {
+	if fn.dynamic {
+		return sp, signal(SymError,
+			lisp.NewString([]byte("Dynamic binding functions are not supported")))
+	}
+	arity := fn.arity()
+	if !arity.accepts(int(nargs)) {
+		return sp, WrongNumberOfArguments(arity, int(nargs))
+	}
+	if nargs < fn.nargs && sp+fn.nargs-nargs > uint32(len(env.stack)) {
+		return sp, ErrStackOverflow
+	}
+	nonrest := fn.nargs
+	if fn.rest {
+		nonrest--
+	}
+	for ; nargs < nonrest; nargs++ {
+		env.stack[sp] = lisp.Nil
+		sp++
+	}
+	if fn.rest {
+		start := sp - (nargs - nonrest)
+		rest := lisp.Nil
+		for i := sp; i > start; i-- {
+			rest = env.NewCons(env.stack[i-1], rest)
+		}
+		env.stack[start] = rest
+		sp = start + 1
+	}
+	return sp, nil
+}
+
+// call places callee and args above the current stack top,
+// then invokes exec with callee slot index and stack pointer.
+// Returns the value that is left in the callee slot.
+func (env *Env) call(callee lisp.Object, args []lisp.Object, exec func(fp, sp uint32) error) (lisp.Object, error) {
+	if env.callDepth >= len(env.frames) {
+		return lisp.Nil, errCallDepth
+	}
+	if env.callDepth == 0 {
+		// Outermost call: errors of the previous calls
+		// are no longer interesting.
+		env.backtraceErr = nil
+	}
+
+	fp := env.stackTop
+	sp := fp + 1 + uint32(len(args))
+	if int(sp) > len(env.stack) {
+		return lisp.Nil, ErrStackOverflow
+	}
+	env.stack[fp] = callee
+	copy(env.stack[fp+1:], args)
+
+	stackTop := env.stackTop
+	callDepth := env.callDepth
+	specpdl := env.specpdlIndex()
+	defer func() {
+		env.stackTop = stackTop
+		env.callDepth = callDepth
+		env.unbindTo(specpdl)
+	}()
+
+	if err := exec(fp, sp); err != nil {
+		return lisp.Nil, err
+	}
+	return env.stack[fp], nil
+}
+
+// Apply implements `apply`.
+// It is like Funcall, but the last argument is a list
+// of the remaining arguments.
+//
+// Signals wrong-type-argument if the last argument
+// is not a proper list.
+func (env *Env) Apply(fn lisp.Object, args ...lisp.Object) (lisp.Object, error) {
+	if len(args) == 0 {
+		return env.Funcall(fn)
+	}
+	spread := args[len(args)-1]
+	args = append([]lisp.Object(nil), args[:len(args)-1]...)
+	for tail := spread; !lisp.Null(&tail); {
+		if tail.Type != lisp.TypeCons {
+			return lisp.Nil, wrongTypeArgument(SymListp, spread)
+		}
+		args = append(args, tail.Cons().Car)
+		tail = tail.Cons().Cdr
+	}
+	return env.Funcall(fn, args...)
+}
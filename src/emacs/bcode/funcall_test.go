@@ -0,0 +1,256 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+func TestFuncall(t *testing.T) {
+	interp := newTestInterpreter(t)
+
+	add2 := interp.AddFunc("add2", Func{
+		code:  []byte{OpAdd1, OpAdd1, OpReturn},
+		nargs: 1,
+	})
+	goAdd10 := interp.AddGoFunc("add10", func(args []lisp.Object) error {
+		args[0] = lisp.NewInt(args[1].Int() + 10)
+		return nil
+	})
+	// mapcar calls its first argument for every element of the list.
+	goMapcar := interp.AddGoFuncCtx("mapcar", func(env *Env, args []lisp.Object) error {
+		var res []lisp.Object
+		for tail := args[2]; !lisp.Null(&tail); tail = tail.Cons().Cdr {
+			val, err := env.Funcall(args[1], tail.Cons().Car)
+			if err != nil {
+				return err
+			}
+			res = append(res, val)
+		}
+		args[0] = lisp.List(res...)
+		return nil
+	})
+	// mapAdd2 is (lambda (xs) (mapcar #'add2 xs)).
+	mapAdd2 := interp.AddFunc("map-add2", Func{
+		code: []byte{
+			OpConstant0,
+			OpConstant1,
+			OpStackRef2,
+			OpExt, OpExtGoCall2,
+			OpReturn,
+		},
+		consts: []lisp.Object{goMapcar, add2},
+		nargs:  1,
+	})
+
+	type (
+		consts []interface{}
+		steps  []interface{}
+	)
+	xs := lisp.List(lisp.NewInt(1), lisp.NewInt(2), lisp.NewInt(3))
+	interp.LoadSteps(steps{
+		OpConstant0, `map-add2`,
+		OpConstant1, `map-add2 (1 . (2 . (3 . nil)))`,
+		OpCall1, `(3 . (4 . (5 . nil)))`,
+		OpConstant2, `(3 . (4 . (5 . nil))) mapcar`,
+		OpConstant3, `(3 . (4 . (5 . nil))) mapcar add10`,
+		OpConstant1, `(3 . (4 . (5 . nil))) mapcar add10 (1 . (2 . (3 . nil)))`,
+		OpExt, OpExtGoCall2, `(3 . (4 . (5 . nil))) (11 . (12 . (13 . nil)))`,
+	})
+	interp.Run("Funcall", promoteObjects(consts{mapAdd2, xs, goMapcar, goAdd10}), nil)
+
+	tests := []struct {
+		fn   lisp.Object
+		args []lisp.Object
+		want string
+	}{
+		{add2, []lisp.Object{lisp.NewInt(1)}, "3"},
+		{goAdd10, []lisp.Object{lisp.NewInt(1)}, "11"},
+		{mapAdd2, []lisp.Object{xs}, "(3 . (4 . (5 . nil)))"},
+		{goMapcar, []lisp.Object{mapAdd2, lisp.List(xs, xs)},
+			"((3 . (4 . (5 . nil))) . ((3 . (4 . (5 . nil))) . nil))"},
+	}
+	for _, tt := range tests {
+		res, err := interp.Funcall(tt.fn, tt.args...)
+		if err != nil {
+			t.Errorf("(funcall %s): unexpected error: %v", lisp.ObjectString(tt.fn), err)
+			continue
+		}
+		if have := lisp.ObjectString(res); have != tt.want {
+			t.Errorf("(funcall %s):\nhave: %s\nwant: %s",
+				lisp.ObjectString(tt.fn), have, tt.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	interp := newTestInterpreter(t)
+
+	add2 := interp.AddFunc("add2", Func{
+		code:  []byte{OpAdd1, OpAdd1, OpReturn},
+		nargs: 1,
+	})
+	goList := interp.AddGoFunc("list", func(args []lisp.Object) error {
+		args[0] = lisp.List(args[1:]...)
+		return nil
+	})
+
+	one, two := lisp.NewInt(1), lisp.NewInt(2)
+	tests := []struct {
+		fn   lisp.Object
+		args []lisp.Object
+		want string
+	}{
+		{add2, []lisp.Object{lisp.List(one)}, "3"},
+		{goList, nil, "nil"},
+		{goList, []lisp.Object{lisp.Nil}, "nil"},
+		{goList, []lisp.Object{one, lisp.List(two, two)}, "(1 . (2 . (2 . nil)))"},
+		{goList, []lisp.Object{one, two, lisp.Nil}, "(1 . (2 . nil))"},
+	}
+	for _, tt := range tests {
+		res, err := interp.Apply(tt.fn, tt.args...)
+		if err != nil {
+			t.Errorf("(apply %s): unexpected error: %v", lisp.ObjectString(tt.fn), err)
+			continue
+		}
+		if have := lisp.ObjectString(res); have != tt.want {
+			t.Errorf("(apply %s):\nhave: %s\nwant: %s",
+				lisp.ObjectString(tt.fn), have, tt.want)
+		}
+	}
+
+	_, err := interp.Apply(goList, one, two)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymWrongTypeArgument {
+		t.Errorf("apply with non-list: unexpected error: %v", err)
+	}
+}
+
+func TestFuncallErrors(t *testing.T) {
+	interp := newTestInterpreter(t)
+
+	fill := lisp.NewSymbol("fill-column")
+	interp.setGlobalValue(fill, lisp.NewInt(70))
+
+	// fail leaves its binding active on purpose.
+	goFail := interp.AddGoFuncCtx("fail", func(env *Env, args []lisp.Object) error {
+		env.Bind(fill, lisp.NewInt(0))
+		return NewSignal(SymArgsOutOfRange, args[1])
+	})
+	callFail := interp.AddFunc("call-fail", Func{
+		code: []byte{
+			OpConstant0,
+			OpStackRef1,
+			OpExt, OpExtGoCall1,
+			OpReturn,
+		},
+		consts: []lisp.Object{goFail},
+		nargs:  1,
+	})
+	goThrow := interp.AddGoFunc("throw", func(args []lisp.Object) error {
+		return &Throw{Tag: args[1], Value: args[2]}
+	})
+
+	var recLisp lisp.Object
+	goRec := interp.AddGoFuncCtx("rec-go", func(env *Env, args []lisp.Object) error {
+		_, err := env.Funcall(recLisp)
+		return err
+	})
+	recLisp = interp.AddFunc("rec-lisp", Func{
+		code:   []byte{OpConstant0, OpExt, OpExtGoCall0, OpReturn},
+		consts: []lisp.Object{goRec},
+	})
+
+	tests := []struct {
+		fn   lisp.Object
+		args []lisp.Object
+		want string
+	}{
+		{lisp.NewInt(1), nil, "(invalid-function . (1 . nil))"},
+		{lisp.NewSymbol("unbound"), nil, "(void-function . (unbound . nil))"},
+		{callFail, []lisp.Object{lisp.NewInt(5)}, "(args-out-of-range . (5 . nil))"},
+		{callFail, nil, "(wrong-number-of-arguments . ((1 . 1) . (0 . nil)))"},
+		{callFail, []lisp.Object{lisp.NewInt(5), lisp.NewInt(6)}, "(wrong-number-of-arguments . ((1 . 1) . (2 . nil)))"},
+		{goThrow, []lisp.Object{fill, lisp.T}, "(no-catch fill-column t)"},
+		{recLisp, nil, "(excessive-lisp-nesting . nil)"},
+		{goRec, nil, "(excessive-lisp-nesting . nil)"},
+	}
+	for _, tt := range tests {
+		_, err := interp.Funcall(tt.fn, tt.args...)
+		if err == nil {
+			t.Errorf("(funcall %s): expected error", lisp.ObjectString(tt.fn))
+			continue
+		}
+		if have := err.Error(); have != tt.want {
+			t.Errorf("(funcall %s):\nhave: %s\nwant: %s",
+				lisp.ObjectString(tt.fn), have, tt.want)
+		}
+
+		// Env state must be restored after the error.
+		if interp.callDepth != 0 || interp.stackTop != 0 {
+			t.Errorf("(funcall %s): callDepth=%d stackTop=%d, want 0",
+				lisp.ObjectString(tt.fn), interp.callDepth, interp.stackTop)
+		}
+		if n := len(interp.Bindings()); n != 0 {
+			t.Errorf("(funcall %s): %d bindings are not removed",
+				lisp.ObjectString(tt.fn), n)
+		}
+	}
+}
+
+func TestFuncallLimits(t *testing.T) {
+	master := NewMasterEnv()
+	fn, err := NewFunc([]byte{OpReturn}, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arg := lisp.NewInt(1)
+
+	// The callee slot and the argument do not fit into the stack.
+	env := master.NewEnv(1, 0)
+	if _, err := env.Exec(fn, arg); err != ErrStackOverflow {
+		t.Errorf("small stack: have error %v, want %v", err, ErrStackOverflow)
+	}
+
+	env = master.NewEnv(0, 1)
+	env.callDepth = 1
+	_, err = env.Exec(fn, arg)
+	if err == nil || err.Error() != "(excessive-lisp-nesting . nil)" {
+		t.Errorf("call depth: have error %v, want excessive-lisp-nesting", err)
+	}
+	env.callDepth = 0
+	if _, err := env.Exec(fn, arg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCallNonCompiled(t *testing.T) {
+	interp := newTestInterpreter(t)
+	ob := lisp.NewObarray()
+	ob.Add(interp.AddGoFunc("list", func(args []lisp.Object) error {
+		args[0] = lisp.List(args[1:]...)
+		return nil
+	}))
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"constant list\nconstant 1\ncall 1\nreturn", "(1 . nil)"},
+		{"constant list\ncall 0\nreturn", "nil"},
+		{"constant undefined\ncall 0\nreturn", "(void-function . (undefined . nil))"},
+		{"constant 5\nconstant 1\ncall 1\nreturn", "(invalid-function . (5 . nil))"},
+	}
+	for _, test := range tests {
+		fn, err := Assemble([]byte(test.src), ob, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := interp.Exec(fn)
+		result := lisp.ObjectString(have)
+		if err != nil {
+			result = err.Error()
+		}
+		if result != test.want {
+			t.Errorf("%q: have %s, want %s", test.src, result, test.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+//go:build ignore
+// +build ignore
+
+// gen_opinfo generates opinfo.go from opcode.go constants.
+//
+// Mnemonics are derived from Go constant names
+// by following Emacs naming: OpGotoIfNilW => "goto-if-nil",
+// OpStackRef2 => "stack-ref" with implicit operand 2,
+// OpListB => "listN" and so on.
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// overrides maps name prefixes (without "Op" and width suffix)
+// to mnemonics that do not follow naming rules.
+var overrides = map[string]string{
+	"VarRef":            "varref",
+	"VarSet":            "varset",
+	"VarBind":           "varbind",
+	"PopHandler":        "pophandler",
+	"PushConditionCase": "pushconditioncase",
+	"PushCatch":         "pushcatch",
+	"PushUnwind":        "pushunwind",
+	"NthCdr":            "nthcdr",
+	"StringEqlsign":     "string=",
+	"StringLss":         "string<",
+	"Interactivep":      "interactive-p",
+	"SaveCurrentBuffer": "save-current-buffer-OBSOLETE",
+
+	"SaveCurrentBuffer2": "save-current-buffer",
+}
+
+// constOperands lists name prefixes which operand is a constant index.
+var constOperands = map[string]bool{
+	"VarRef":   true,
+	"VarSet":   true,
+	"VarBind":  true,
+	"Constant": true,
+}
+
+// handlerOperands lists opcodes that have 16bit
+// handler address operand, like Emacs FETCH2 does.
+var handlerOperands = map[string]bool{
+	"PushConditionCase": true,
+	"PushCatch":         true,
+	"PushUnwind":        true,
+}
+
+type opcode struct {
+	goName string
+	value  int64
+}
+
+func main() {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "opcode.go", nil, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	info := types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check("bcode", fset, []*ast.File{f}, &info); err != nil {
+		log.Fatal(err)
+	}
+
+	var ops, extOps []opcode
+	for id, obj := range info.Defs {
+		c, ok := obj.(*types.Const)
+		if !ok || !strings.HasPrefix(id.Name, "Op") || id.Name == "OpExt" {
+			continue
+		}
+		val, _ := constant.Int64Val(c.Val())
+		if strings.HasPrefix(id.Name, "OpExt") {
+			extOps = append(extOps, opcode{id.Name, val})
+		} else {
+			ops = append(ops, opcode{id.Name, val})
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gen_opinfo.go; DO NOT EDIT.\n\n")
+	buf.WriteString("package bcode\n\n")
+	writeTable(&buf, "opInfos", "Op", ops)
+	buf.WriteString("\n")
+	writeTable(&buf, "extOpInfos", "OpExt", extOps)
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("opinfo.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeTable(buf *bytes.Buffer, name, prefix string, ops []opcode) {
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].value < ops[j].value
+	})
+	names := make(map[string]bool)
+	for _, op := range ops {
+		names[strings.TrimPrefix(op.goName, prefix)] = true
+	}
+
+	buf.WriteString("// " + name + " describes opcodes that are defined by " + prefix + "* constants.\n")
+	buf.WriteString("var " + name + " = [256]opInfo{\n")
+	for _, op := range ops {
+		base := strings.TrimPrefix(op.goName, prefix)
+		enc := "operandNone"
+		n := 0
+		switch {
+		case isFamilyMember(base, names):
+			i := strings.IndexFunc(base, unicode.IsDigit)
+			n, _ = strconv.Atoi(base[i:])
+			base = base[:i]
+			enc = "operandImplicit"
+		case handlerOperands[base]:
+			enc = "operandW"
+		case strings.HasSuffix(base, "W"):
+			base = strings.TrimSuffix(base, "W")
+			enc = "operandW"
+		case strings.HasSuffix(base, "B"):
+			base = strings.TrimSuffix(base, "B")
+			enc = "operandB"
+		}
+
+		kind := "argNumber"
+		switch {
+		case constOperands[base]:
+			kind = "argConst"
+		case strings.HasPrefix(base, "Goto"), handlerOperands[base]:
+			kind = "argJump"
+		case strings.HasPrefix(base, "Rgoto"):
+			kind = "argRelJump"
+		}
+
+		mnemonic, ok := overrides[base]
+		if !ok {
+			mnemonic = kebab(base)
+		}
+		if enc == "operandB" && kind == "argNumber" && !names[base+"W"] {
+			// Emacs names byte-listN, byte-concatN and so on.
+			mnemonic += "N"
+		}
+		if enc == "operandNone" {
+			kind = "argNone"
+		}
+
+		buf.WriteString("\t" + op.goName + ": {")
+		buf.WriteString("name: " + strconv.Quote(mnemonic) + ", ")
+		buf.WriteString("enc: " + enc + ", ")
+		buf.WriteString("kind: " + kind)
+		if enc == "operandImplicit" {
+			buf.WriteString(", n: " + strconv.Itoa(n))
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("}\n")
+}
+
+// isFamilyMember reports whether name is like StackRef2 or
+// Constant10: it ends with a digit and the same family has
+// W-operand member.
+func isFamilyMember(name string, names map[string]bool) bool {
+	i := strings.IndexFunc(name, unicode.IsDigit)
+	if i <= 0 {
+		return false
+	}
+	return names[name[:i]+"W"]
+}
+
+// kebab converts CamelCase to lower-case-with-dashes.
+func kebab(s string) string {
+	var buf []rune
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				buf = append(buf, '-')
+			}
+			r = unicode.ToLower(r)
+		}
+		buf = append(buf, r)
+	}
+	return string(buf)
+}
@@ -0,0 +1,397 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"fmt"
+	"reflect"
+)
+
+// Registration of ordinary Go functions.
+//
+// DefineFunc accepts any Go function whose parameter and result
+// types can be mapped to Lisp values and generates GoFuncCtx
+// adapter for it. Adapter checks the number of arguments,
+// converts them to Go values and converts the result back.
+
+// Arity describes how many arguments function accepts.
+type Arity struct {
+	Min int
+
+	// Max is ArityMany for functions with &rest arguments.
+	Max int
+}
+
+// ArityMany is Arity.Max value for functions that
+// accept unlimited number of arguments.
+const ArityMany = -1
+
+// symMany is printed as a maximal arity of &rest functions.
+var symMany = lisp.NewSymbol("many")
+
+// Object returns arity printed like `func-arity` result.
+func (a Arity) Object() lisp.Object {
+	max := symMany
+	if a.Max != ArityMany {
+		max = lisp.NewInt(int64(a.Max))
+	}
+	return lisp.NewCons(lisp.NewInt(int64(a.Min)), max)
+}
+
+// WrongNumberOfArguments returns wrong-number-of-arguments
+// signal for a call with nargs arguments of a function
+// that accepts arity arguments.
+func WrongNumberOfArguments(arity Arity, nargs int) error {
+	return signal(SymWrongNumberOfArguments, arity.Object(), lisp.NewInt(int64(nargs)))
+}
+
+// accepts reports whether nargs is within a arity bounds.
+func (a Arity) accepts(nargs int) bool {
+	return nargs >= a.Min && (a.Max == ArityMany || nargs <= a.Max)
+}
+
+// GoFuncArity returns arity of Go function bound to fsym.
+// Functions that were not defined by DefineFunc
+// accept any number of arguments.
+func (env *MasterEnv) GoFuncArity(fsym lisp.Object) Arity {
+	if a, ok := env.goArity[env.funcRef(fsym.Symbol()).goID]; ok {
+		return a
+	}
+	return Arity{Min: 0, Max: ArityMany}
+}
+
+// DefineFunc makes Go function fn callable through fsym.
+//
+// Supported parameter types are:
+//
+//	lisp.Object - any value
+//	int, int64 - integer
+//	rune - character
+//	float64 - number (integers are converted)
+//	string - string
+//	bool - any value; only nil is false
+//	*lisp.Buffer - buffer
+//	*lisp.Symbol - symbol
+//	*lisp.UserPtr - user-ptr
+//	other pointers (like *os.File) - user-ptr that wraps a value of that type
+//
+// The first parameter can also be *Env; it receives the calling Env.
+//
+// Trailing parameters of pointers to the types above (like *int or *lisp.Object)
+// are optional. They are nil when argument is omitted or nil.
+// Variadic parameter accepts the rest arguments.
+//
+// Function may return nothing, one value, an error,
+// or a value with an error. Results of the same types
+// as parameters are supported (except *lisp.Symbol).
+// Other pointer results are wrapped into user-ptr objects,
+// so the value can be passed back into *lisp.UserPtr param.
+// Functions without results return nil.
+//
+// Calls with wrong number of arguments signal wrong-number-of-arguments;
+// arguments of unexpected types signal wrong-type-argument.
+//
+// Panics if fn signature is not supported.
+func (env *MasterEnv) DefineFunc(fsym lisp.Object, fn interface{}) {
+	adapter, arity := makeGoFunc(fsym, reflect.ValueOf(fn))
+	env.DefineGoFuncCtx(fsym, adapter)
+	if env.goArity == nil {
+		env.goArity = make(map[int]Arity)
+	}
+	env.goArity[env.funcRef(fsym.Symbol()).goID] = arity
+}
+
+// argConverter converts Lisp value to Go value of specific type.
+type argConverter func(x *lisp.Object) (reflect.Value, error)
+
+// resultConverter converts Go value of specific type to Lisp value.
+type resultConverter func(v reflect.Value) lisp.Object
+
+var (
+	envType     = reflect.TypeOf((*Env)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	objectType  = reflect.TypeOf(lisp.Object{})
+	bufferType  = reflect.TypeOf((*lisp.Buffer)(nil))
+	symbolType  = reflect.TypeOf((*lisp.Symbol)(nil))
+	userPtrType = reflect.TypeOf((*lisp.UserPtr)(nil))
+	runeType    = reflect.TypeOf(rune(0))
+)
+
+// makeGoFunc returns GoFuncCtx adapter for fn along with its arity.
+func makeGoFunc(fsym lisp.Object, fn reflect.Value) (GoFuncCtx, Arity) {
+	name := fsym.Symbol().Name
+	typ := fn.Type()
+	if typ.Kind() != reflect.Func {
+		panic(fmt.Sprintf("`%s`: %s is not a function", name, typ))
+	}
+
+	params := make([]reflect.Type, typ.NumIn())
+	for i := range params {
+		params[i] = typ.In(i)
+	}
+	withEnv := len(params) != 0 && params[0] == envType
+	if withEnv {
+		params = params[1:]
+	}
+	var rest argConverter
+	if typ.IsVariadic() {
+		elem := params[len(params)-1].Elem()
+		params = params[:len(params)-1]
+		rest = newArgConverter(elem)
+		if rest == nil {
+			panic(fmt.Sprintf("`%s`: unsupported variadic param type %s", name, elem))
+		}
+	}
+
+	arity := Arity{Max: len(params)}
+	if rest != nil {
+		arity.Max = ArityMany
+	}
+	convs := make([]argConverter, len(params))
+	for i, p := range params {
+		if conv := newOptionalArgConverter(p); conv != nil {
+			convs[i] = conv
+			continue
+		}
+		if arity.Min != i {
+			panic(fmt.Sprintf("`%s`: param %d (%s) follows optional params", name, i, p))
+		}
+		convs[i] = newArgConverter(p)
+		if convs[i] == nil {
+			panic(fmt.Sprintf("`%s`: unsupported param type %s", name, p))
+		}
+		arity.Min++
+	}
+
+	var result resultConverter
+	withError := false
+	switch {
+	case typ.NumOut() == 0:
+	case typ.NumOut() == 1 && typ.Out(0) == errorType:
+		withError = true
+	case typ.NumOut() == 1:
+		result = newResultConverter(typ.Out(0))
+	case typ.NumOut() == 2 && typ.Out(1) == errorType:
+		withError = true
+		result = newResultConverter(typ.Out(0))
+	default:
+		panic(fmt.Sprintf("`%s`: unsupported results of %s", name, typ))
+	}
+	if result == nil && (typ.NumOut() == 2 || typ.NumOut() == 1 && !withError) {
+		panic(fmt.Sprintf("`%s`: unsupported result type %s", name, typ.Out(0)))
+	}
+
+	adapter := func(env *Env, args []lisp.Object) error {
+		nargs := len(args) - 1
+		if !arity.accepts(nargs) {
+			return WrongNumberOfArguments(arity, nargs)
+		}
+
+		in := make([]reflect.Value, 0, len(params)+1+nargs)
+		if withEnv {
+			in = append(in, reflect.ValueOf(env))
+		}
+		for i, conv := range convs {
+			x := &lisp.Nil
+			if i < nargs {
+				x = &args[i+1]
+			}
+			v, err := conv(x)
+			if err != nil {
+				return err
+			}
+			in = append(in, v)
+		}
+		for i := len(convs); i < nargs; i++ {
+			v, err := rest(&args[i+1])
+			if err != nil {
+				return err
+			}
+			in = append(in, v)
+		}
+
+		out := fn.Call(in)
+		if withError {
+			if err := out[len(out)-1]; !err.IsNil() {
+				return err.Interface().(error)
+			}
+		}
+		args[0] = lisp.Nil
+		if result != nil {
+			args[0] = result(out[0])
+		}
+		return nil
+	}
+	return adapter, arity
+}
+
+// newArgConverter returns converter for typ values
+// or nil if typ is not supported.
+func newArgConverter(typ reflect.Type) argConverter {
+	switch typ {
+	case objectType:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			return reflect.ValueOf(*x), nil
+		}
+	case bufferType:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			if x.Type != lisp.TypeBuffer {
+				return reflect.Value{}, wrongTypeArgument(SymBufferp, *x)
+			}
+			return reflect.ValueOf(x.Buffer()), nil
+		}
+	case symbolType:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			if x.Type != lisp.TypeSymbol {
+				return reflect.Value{}, wrongTypeArgument(SymSymbolp, *x)
+			}
+			return reflect.ValueOf(x.Symbol()), nil
+		}
+	case userPtrType:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			if x.Type != lisp.TypeUserPtr {
+				return reflect.Value{}, wrongTypeArgument(SymUserPtrp, *x)
+			}
+			return reflect.ValueOf(x.UserPtr()), nil
+		}
+	case runeType:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			ch, err := charArg(x)
+			return reflect.ValueOf(ch), err
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int64:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			if x.Type != lisp.TypeInt {
+				return reflect.Value{}, wrongTypeArgument(SymIntegerp, *x)
+			}
+			v := reflect.New(typ).Elem()
+			v.SetInt(x.Int())
+			return v, nil
+		}
+	case reflect.Float64:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			v := reflect.New(typ).Elem()
+			switch x.Type {
+			case lisp.TypeInt:
+				v.SetFloat(float64(x.Int()))
+			case lisp.TypeFloat:
+				v.SetFloat(x.Float())
+			default:
+				return reflect.Value{}, wrongTypeArgument(SymNumberp, *x)
+			}
+			return v, nil
+		}
+	case reflect.String:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			if x.Type != lisp.TypeString {
+				return reflect.Value{}, wrongTypeArgument(SymStringp, *x)
+			}
+			v := reflect.New(typ).Elem()
+			v.SetString(string(x.String().Chars))
+			return v, nil
+		}
+	case reflect.Bool:
+		return func(x *lisp.Object) (reflect.Value, error) {
+			v := reflect.New(typ).Elem()
+			v.SetBool(!lisp.Null(x))
+			return v, nil
+		}
+	case reflect.Ptr:
+		// Value that is wrapped into user-ptr.
+		return func(x *lisp.Object) (reflect.Value, error) {
+			if x.Type != lisp.TypeUserPtr {
+				return reflect.Value{}, wrongTypeArgument(SymUserPtrp, *x)
+			}
+			v := reflect.ValueOf(x.UserPtr().Value)
+			if !v.IsValid() || v.Type() != typ {
+				return reflect.Value{}, wrongTypeArgument(SymUserPtrp, *x)
+			}
+			return v, nil
+		}
+	}
+
+	return nil
+}
+
+// newOptionalArgConverter returns converter for optional
+// argument of typ or nil if typ can't be used as optional.
+// Optional param type is a pointer to supported value type.
+func newOptionalArgConverter(typ reflect.Type) argConverter {
+	if typ.Kind() != reflect.Ptr || typ == bufferType || typ == symbolType || typ == userPtrType {
+		return nil
+	}
+	conv := newArgConverter(typ.Elem())
+	if conv == nil || typ.Elem().Kind() == reflect.Ptr {
+		return nil
+	}
+	return func(x *lisp.Object) (reflect.Value, error) {
+		if lisp.Null(x) {
+			return reflect.Zero(typ), nil
+		}
+		v, err := conv(x)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(typ.Elem())
+		p.Elem().Set(v)
+		return p, nil
+	}
+}
+
+// newResultConverter returns converter for typ values
+// or nil if typ is not supported.
+func newResultConverter(typ reflect.Type) resultConverter {
+	switch typ {
+	case objectType:
+		return func(v reflect.Value) lisp.Object {
+			return v.Interface().(lisp.Object)
+		}
+	case bufferType:
+		return func(v reflect.Value) lisp.Object {
+			if v.IsNil() {
+				return lisp.Nil
+			}
+			return v.Interface().(*lisp.Buffer).Object()
+		}
+	case userPtrType:
+		return func(v reflect.Value) lisp.Object {
+			if v.IsNil() {
+				return lisp.Nil
+			}
+			return v.Interface().(*lisp.UserPtr).Object()
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return func(v reflect.Value) lisp.Object {
+			return lisp.NewInt(v.Int())
+		}
+	case reflect.Float64:
+		return func(v reflect.Value) lisp.Object {
+			return lisp.NewFloat(v.Float())
+		}
+	case reflect.String:
+		return func(v reflect.Value) lisp.Object {
+			return lisp.NewString([]byte(v.String()))
+		}
+	case reflect.Bool:
+		return func(v reflect.Value) lisp.Object {
+			return lisp.Bool(v.Bool())
+		}
+	case reflect.Ptr:
+		if typ == symbolType {
+			return nil
+		}
+		return func(v reflect.Value) lisp.Object {
+			if v.IsNil() {
+				return lisp.Nil
+			}
+			return lisp.NewUserPtr(v.Interface(), typ.String(), nil)
+		}
+	}
+
+	return nil
+}
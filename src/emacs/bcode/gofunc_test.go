@@ -0,0 +1,223 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDefineFunc(t *testing.T) {
+	env := newTestEnv()
+	env.buffer = newTestBuffer("ab|c")
+
+	define := func(name string, fn interface{}) lisp.Object {
+		fsym := env.newFsym(name)
+		env.DefineFunc(fsym, fn)
+		return fsym
+	}
+	repeat := define("repeat", func(s string, n int) string {
+		return strings.Repeat(s, n)
+	})
+	join := define("join", func(sep string, parts ...string) string {
+		return strings.Join(parts, sep)
+	})
+	pad := define("pad", func(s string, width *int, ch *rune) string {
+		w, c := 4, ' '
+		if width != nil {
+			w = *width
+		}
+		if ch != nil {
+			c = *ch
+		}
+		for len(s) < w {
+			s += string(c)
+		}
+		return s
+	})
+	half := define("half", func(x float64) float64 {
+		return x / 2
+	})
+	not := define("not", func(x bool) bool {
+		return !x
+	})
+	point := define("point", func(env *Env) int {
+		return env.CurrentBuffer().Point()
+	})
+	bufferName := define("buffer-name", func(buf *lisp.Buffer) string {
+		return buf.Name
+	})
+	symbolName := define("symbol-name", func(sym *lisp.Symbol) string {
+		return sym.Name
+	})
+	identity := define("identity", func(x lisp.Object) lisp.Object {
+		return x
+	})
+	ignore := define("ignore", func(args ...lisp.Object) {})
+	check := define("check", func(ok bool) error {
+		if !ok {
+			return errors.New("check failed")
+		}
+		return nil
+	})
+	div := define("div", func(x, y int64) (int64, error) {
+		if y == 0 {
+			return 0, NewSignal(lisp.NewSymbol("arith-error"))
+		}
+		return x / y, nil
+	})
+
+	str := func(s string) lisp.Object { return lisp.NewString([]byte(s)) }
+	num := func(x int64) lisp.Object { return lisp.NewInt(x) }
+	tests := []struct {
+		fn   lisp.Object
+		args []lisp.Object
+		want string
+	}{
+		{repeat, []lisp.Object{str("ab"), num(3)}, `"ababab"`},
+		{join, []lisp.Object{str(",")}, `""`},
+		{join, []lisp.Object{str(","), str("a"), str("b")}, `"a,b"`},
+		{pad, []lisp.Object{str("a")}, `"a   "`},
+		{pad, []lisp.Object{str("a"), num(2)}, `"a "`},
+		{pad, []lisp.Object{str("a"), lisp.Nil, num('.')}, `"a..."`},
+		{half, []lisp.Object{num(3)}, "1.5"},
+		{half, []lisp.Object{lisp.NewFloat(1)}, "0.5"},
+		{not, []lisp.Object{lisp.Nil}, "t"},
+		{not, []lisp.Object{num(0)}, "nil"},
+		{point, nil, "3"},
+		{bufferName, []lisp.Object{lisp.NewBuffer("foo")}, `"foo"`},
+		{symbolName, []lisp.Object{lisp.T}, `"t"`},
+		{identity, []lisp.Object{lisp.List(num(1))}, "(1 . nil)"},
+		{ignore, []lisp.Object{num(1), num(2)}, "nil"},
+		{check, []lisp.Object{lisp.T}, "nil"},
+		{div, []lisp.Object{num(7), num(2)}, "3"},
+
+		{repeat, []lisp.Object{str("ab")}, "(wrong-number-of-arguments . ((2 . 2) . (1 . nil)))"},
+		{pad, nil, "(wrong-number-of-arguments . ((1 . 3) . (0 . nil)))"},
+		{join, nil, "(wrong-number-of-arguments . ((1 . many) . (0 . nil)))"},
+		{repeat, []lisp.Object{num(1), num(3)}, "(wrong-type-argument . (stringp . (1 . nil)))"},
+		{repeat, []lisp.Object{str("a"), lisp.T}, "(wrong-type-argument . (integerp . (t . nil)))"},
+		{join, []lisp.Object{str(","), str("a"), num(1)}, "(wrong-type-argument . (stringp . (1 . nil)))"},
+		{pad, []lisp.Object{str("a"), num(1), num(-1)}, "(wrong-type-argument . (characterp . (-1 . nil)))"},
+		{half, []lisp.Object{lisp.Nil}, "(wrong-type-argument . (numberp . (nil . nil)))"},
+		{bufferName, []lisp.Object{lisp.Nil}, "(wrong-type-argument . (bufferp . (nil . nil)))"},
+		{symbolName, []lisp.Object{num(1)}, "(wrong-type-argument . (symbolp . (1 . nil)))"},
+		{check, []lisp.Object{lisp.Nil}, "check failed"},
+		{div, []lisp.Object{num(1), num(0)}, "(arith-error . nil)"},
+	}
+	for _, tt := range tests {
+		res, err := env.Funcall(tt.fn, tt.args...)
+		have := lisp.ObjectString(res)
+		if err != nil {
+			have = err.Error()
+		}
+		if have != tt.want {
+			t.Errorf("(%s %s):\nhave: %s\nwant: %s",
+				tt.fn.Symbol().Name, lisp.ObjectSliceString(tt.args), have, tt.want)
+		}
+	}
+
+	arities := []struct {
+		fn   lisp.Object
+		want string
+	}{
+		{repeat, "(2 . 2)"},
+		{join, "(1 . many)"},
+		{pad, "(1 . 3)"},
+		{point, "(0 . 0)"},
+	}
+	for _, tt := range arities {
+		if have := lisp.ObjectString(env.GoFuncArity(tt.fn).Object()); have != tt.want {
+			t.Errorf("%s arity: have %s, want %s", tt.fn.Symbol().Name, have, tt.want)
+		}
+	}
+}
+
+func TestDefineFuncUserPtr(t *testing.T) {
+	type handle struct{ name string }
+	type process struct{}
+
+	env := newTestEnv()
+	define := func(name string, fn interface{}) lisp.Object {
+		fsym := env.newFsym(name)
+		env.DefineFunc(fsym, fn)
+		return fsym
+	}
+	open := define("open", func(name string) *handle {
+		return &handle{name: name}
+	})
+	handleName := define("handle-name", func(h *handle) string {
+		return h.name
+	})
+	typeName := define("user-ptr-type", func(p *lisp.UserPtr) string {
+		return p.TypeName
+	})
+	startProcess := define("start-process", func() *process {
+		return &process{}
+	})
+
+	h, err := env.Funcall(open, lisp.NewString([]byte("a.txt")))
+	if err != nil {
+		t.Fatalf("open: unexpected error: %v", err)
+	}
+	p, err := env.Funcall(startProcess)
+	if err != nil {
+		t.Fatalf("start-process: unexpected error: %v", err)
+	}
+
+	// User pointers that wrap values of other types,
+	// including untyped nil.
+	nilPtr := lisp.NewUserPtr(nil, "", nil)
+	intPtr := lisp.NewUserPtr(1, "", nil)
+
+	tests := []struct {
+		fn   lisp.Object
+		arg  lisp.Object
+		want string
+	}{
+		{handleName, h, `"a.txt"`},
+		{typeName, h, `"*bcode.handle"`},
+		{handleName, p, "(wrong-type-argument . (user-ptrp . (#<user-ptr *bcode.process> . nil)))"},
+		{handleName, lisp.Nil, "(wrong-type-argument . (user-ptrp . (nil . nil)))"},
+		{typeName, lisp.NewInt(1), "(wrong-type-argument . (user-ptrp . (1 . nil)))"},
+		{handleName, nilPtr, "(wrong-type-argument . (user-ptrp . (#<user-ptr> . nil)))"},
+		{handleName, intPtr, "(wrong-type-argument . (user-ptrp . (#<user-ptr> . nil)))"},
+	}
+	for _, tt := range tests {
+		res, err := env.Funcall(tt.fn, tt.arg)
+		have := lisp.ObjectString(res)
+		if err != nil {
+			have = err.Error()
+		}
+		if have != tt.want {
+			t.Errorf("(%s %s):\nhave: %s\nwant: %s",
+				tt.fn.Symbol().Name, lisp.ObjectString(tt.arg), have, tt.want)
+		}
+	}
+}
+
+func TestDefineFuncBadSignature(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   interface{}
+	}{
+		{"not-func", 10},
+		{"bad-param", func(x []int) {}},
+		{"bad-variadic", func(x ...[]int) {}},
+		{"required-after-optional", func(x *int, y int) {}},
+		{"bad-result", func() []int { return nil }},
+		{"no-error", func() (int, int) { return 0, 0 }},
+		{"too-many-results", func() (int, int, error) { return 0, 0, nil }},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", tt.name)
+				}
+			}()
+			env := newTestEnv()
+			env.DefineFunc(lisp.NewSymbol(tt.name), tt.fn)
+		}()
+	}
+}
@@ -0,0 +1,362 @@
+package bcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Go source generator.
+//
+// GenerateGo translates compiled functions into Go functions
+// that do the same work through the Env API. The verifier
+// proves that stack depth before every instruction is the same
+// on all paths that reach it, so every stack slot has a static
+// index: slot i becomes local variable si, stack-ref, stack-set
+// and dup become assignments and the stack pointer disappears.
+// Jumps become goto statements and calls go through Funcall.
+//
+// Functions that do not verify or use opcodes without Go
+// translation are skipped; they keep running as byte code.
+// Unlike byte code, the generated functions check the number
+// of arguments.
+
+// GenerateError reports a function that GenerateGo skipped.
+type GenerateError struct {
+	Name string
+	Err  error
+}
+
+func (e *GenerateError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+// goOps maps the opcodes that are implemented by
+// exported functions (see Car) to those functions.
+var goOps = map[string]string{
+	"car":     "Car",
+	"cdr":     "Cdr",
+	"add1":    "Add1",
+	"sub1":    "Sub1",
+	"negate":  "Negate",
+	"plus":    "Plus",
+	"diff":    "Diff",
+	"mult":    "Mult",
+	"eqlsign": "Eqlsign",
+	"gtr":     "Gtr",
+	"lss":     "Lss",
+	"leq":     "Leq",
+	"geq":     "Geq",
+}
+
+// GenerateGo writes Go source file of package pkg that
+// implements funcs. The package exports Funcs, the list of
+// generated AOTFunc values, and Register function that
+// passes them to MasterEnv.RegisterAOT.
+//
+// Anonymous functions can't be registered and are ignored.
+// Functions that can't be translated are reported by the
+// returned GenerateError list; err is set if w fails
+// or the generated code does not parse, which is a bug.
+func GenerateGo(w io.Writer, pkg string, funcs []NamedFunc) ([]*GenerateError, error) {
+	var skipped []*GenerateError
+	var bodies bytes.Buffer
+	var entries []string
+	used := make(map[string]bool)
+	for _, f := range funcs {
+		if f.Name == "" {
+			continue
+		}
+		goName := goFuncName(f.Name, used)
+		src, err := generateFunc(f.Name, goName, f.Func)
+		if err != nil {
+			skipped = append(skipped, &GenerateError{Name: f.Name, Err: err})
+			continue
+		}
+		bodies.Write(src)
+		entries = append(entries, fmt.Sprintf("{Name: %s, Fingerprint: %q, Nargs: %d, New: %s},\n",
+			strconv.Quote(f.Name), Fingerprint(f.Func), f.Func.nargs, goName))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by bcode.GenerateGo; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	buf.WriteString("import (\n\"emacs/bcode\"\n")
+	if len(entries) != 0 {
+		buf.WriteString("\"emacs/lisp\"\n")
+	}
+	buf.WriteString(")\n\n")
+	buf.WriteString("// Register makes env use Funcs instead of the compiled\n")
+	buf.WriteString("// functions that they were generated from.\n")
+	buf.WriteString("func Register(env *bcode.MasterEnv) {\nenv.RegisterAOT(Funcs...)\n}\n\n")
+	buf.WriteString("// Funcs are Go versions of compiled functions.\n")
+	buf.WriteString("var Funcs = []bcode.AOTFunc{\n")
+	for _, e := range entries {
+		buf.WriteString(e)
+	}
+	buf.WriteString("}\n")
+	buf.Write(bodies.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return skipped, fmt.Errorf("generated code does not parse: %v", err)
+	}
+	_, err = w.Write(src)
+	return skipped, err
+}
+
+// goFuncName returns unique Go name for the constructor
+// of Lisp function name: bench-fib becomes newBenchFib.
+func goFuncName(name string, used map[string]bool) string {
+	var b strings.Builder
+	b.WriteString("new")
+	upper := true
+	for _, r := range name {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	base := b.String()
+	goName := base
+	for i := 2; used[goName]; i++ {
+		goName = base + strconv.Itoa(i)
+	}
+	used[goName] = true
+	return goName
+}
+
+// funcGen holds the state of a single function translation.
+type funcGen struct {
+	fn     *Func
+	buf    bytes.Buffer
+	depths []int
+
+	// labels maps jump targets to their labels.
+	labels map[int]string
+
+	// reads marks the stack slots that are read.
+	// Values that are stored into other slots are discarded.
+	// It is filled by the first pass, final is set
+	// for the second one.
+	reads map[int]bool
+	final bool
+
+	// consts marks used constant indexes.
+	consts map[int]bool
+
+	// usesErr is set if err variable is needed.
+	usesErr bool
+}
+
+// generateFunc returns the source of goName function
+// that returns Go version of fn.
+func generateFunc(name, goName string, fn *Func) ([]byte, error) {
+	if fn.optArgs != 0 || fn.rest || fn.dynamic {
+		return nil, errors.New("&optional, &rest and dynamic binding arguments are not supported")
+	}
+	nargs := int(fn.nargs)
+	depths, maxDepth, err := stackDepths(fn, nargs)
+	if err != nil {
+		return nil, err
+	}
+	var instrs []instr
+	for pc := 0; pc < len(fn.code); {
+		ins, _ := decodeInstr(fn.code, uint32(pc))
+		if depths[pc] != -1 {
+			instrs = append(instrs, ins)
+		}
+		pc += int(ins.width)
+	}
+
+	g := &funcGen{
+		fn:     fn,
+		depths: depths,
+		labels: make(map[int]string),
+		reads:  make(map[int]bool),
+		consts: make(map[int]bool),
+	}
+	for _, ins := range instrs {
+		if ins.info.kind == argJump || ins.info.kind == argRelJump {
+			g.labels[ins.arg] = fmt.Sprintf("L%d", ins.arg)
+		}
+	}
+	// The first pass finds out which slots are read.
+	for _, ins := range instrs {
+		if err := g.instr(ins); err != nil {
+			return nil, err
+		}
+	}
+	g.buf.Reset()
+	g.final = true
+	for _, ins := range instrs {
+		g.instr(ins)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\n// %s implements %s.\n", goName, name)
+	fmt.Fprintf(&buf, "func %s(consts []lisp.Object) bcode.GoFuncCtx {\n", goName)
+	for i := range fn.consts {
+		if g.consts[i] {
+			fmt.Fprintf(&buf, "k%d := consts[%d]\n", i, i)
+		}
+	}
+	buf.WriteString("return func(env *bcode.Env, args []lisp.Object) error {\n")
+	fmt.Fprintf(&buf, "if len(args) != %d {\n", nargs+1)
+	fmt.Fprintf(&buf, "return bcode.WrongNumberOfArguments(bcode.Arity{Min: %d, Max: %d}, len(args)-1)\n}\n",
+		nargs, nargs)
+	var locals []string
+	for i := 0; i < maxDepth; i++ {
+		if !g.reads[i] {
+			continue
+		}
+		if i < nargs {
+			fmt.Fprintf(&buf, "s%d := args[%d]\n", i, i+1)
+		} else {
+			locals = append(locals, fmt.Sprintf("s%d", i))
+		}
+	}
+	if len(locals) != 0 {
+		fmt.Fprintf(&buf, "var %s lisp.Object\n", strings.Join(locals, ", "))
+	}
+	if g.usesErr {
+		buf.WriteString("var err error\n")
+	}
+	buf.Write(g.buf.Bytes())
+	buf.WriteString("}\n}\n")
+	return buf.Bytes(), nil
+}
+
+// printf appends formatted code.
+func (g *funcGen) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// get returns the variable of stack slot i for reading.
+func (g *funcGen) get(i int) string {
+	g.reads[i] = true
+	return fmt.Sprintf("s%d", i)
+}
+
+// set returns the variable of stack slot i for writing.
+func (g *funcGen) set(i int) string {
+	if g.final && !g.reads[i] {
+		return "_"
+	}
+	return fmt.Sprintf("s%d", i)
+}
+
+// konst returns the variable of constant i.
+func (g *funcGen) konst(i int) string {
+	g.consts[i] = true
+	return fmt.Sprintf("k%d", i)
+}
+
+// move copies slot src into slot dst.
+func (g *funcGen) move(dst, src int) {
+	if dst != src {
+		g.printf("%s = %s\n", g.set(dst), g.get(src))
+	}
+}
+
+// call stores the result of call that can fail into slot dst.
+func (g *funcGen) call(dst int, format string, args ...interface{}) {
+	g.usesErr = true
+	g.printf("if %s, err = %s; err != nil {\nreturn err\n}\n",
+		g.set(dst), fmt.Sprintf(format, args...))
+}
+
+// instr appends the translation of ins.
+func (g *funcGen) instr(ins instr) error {
+	pc := int(ins.pc)
+	d := g.depths[pc]
+	n := ins.arg
+	name := ins.info.name
+	if g.fn.code[pc] == OpDiscardB && n&discardPreserveTOS != 0 {
+		name = discardPreserveTOSInfo.name
+		n &^= discardPreserveTOS
+	}
+
+	if label, ok := g.labels[pc]; ok {
+		g.printf("%s:\n", label)
+	}
+	if ins.info.kind == argNone {
+		g.printf("// %d %s\n", pc, name)
+	} else {
+		g.printf("// %d %s %d\n", pc, name, n)
+	}
+
+	if goOp, ok := goOps[name]; ok {
+		if opStackUse[g.fn.code[pc]].pop == 1 {
+			g.call(d-1, "bcode.%s(%s)", goOp, g.get(d-1))
+		} else {
+			g.call(d-2, "bcode.%s(%s, %s)", goOp, g.get(d-2), g.get(d-1))
+		}
+		return nil
+	}
+
+	switch name {
+	case "stack-ref":
+		g.move(d, d-n-1)
+	case "dup":
+		g.move(d, d-1)
+	case "stack-set":
+		g.move(d-1-n, d-1)
+	case "discard", "discardN":
+	case "discardN-preserve-tos":
+		g.move(d-1-n, d-1)
+	case "constant":
+		g.printf("%s = %s\n", g.set(d), g.konst(n))
+
+	case "varref":
+		g.call(d, "env.SymbolValue(%s)", g.konst(n))
+	case "varset":
+		g.printf("env.SetSymbolValue(%s, %s)\n", g.konst(n), g.get(d-1))
+	case "varbind":
+		g.printf("env.Bind(%s, %s)\n", g.konst(n), g.get(d-1))
+	case "unbind":
+		if n != 0 {
+			g.printf("env.UnbindTo(env.BindingDepth() - %d)\n", n)
+		}
+
+	case "call", "go-call":
+		callee := d - n - 1
+		args := []string{g.get(callee)}
+		for i := callee + 1; i < d; i++ {
+			args = append(args, g.get(i))
+		}
+		g.call(callee, "env.Funcall(%s)", strings.Join(args, ", "))
+	case "return":
+		g.printf("args[0] = %s\nreturn nil\n", g.get(d-1))
+
+	case "goto", "rgoto":
+		g.printf("goto %s\n", g.labels[n])
+	case "goto-if-nil", "rgoto-if-nil",
+		"goto-if-nil-else-pop", "rgoto-if-nil-else-pop":
+		g.printf("if lisp.Null(&%s) {\ngoto %s\n}\n", g.get(d-1), g.labels[n])
+	case "goto-if-non-nil", "rgoto-if-non-nil",
+		"goto-if-non-nil-else-pop", "rgoto-if-non-nil-else-pop":
+		g.printf("if !lisp.Null(&%s) {\ngoto %s\n}\n", g.get(d-1), g.labels[n])
+
+	case "eq":
+		g.printf("%s = lisp.Bool(lisp.Eq(&%s, &%s))\n", g.set(d-2), g.get(d-2), g.get(d-1))
+	case "not":
+		g.printf("%s = lisp.Bool(lisp.Null(&%s))\n", g.set(d-1), g.get(d-1))
+	case "cons":
+		g.printf("%s = env.NewCons(%s, %s)\n", g.set(d-2), g.get(d-2), g.get(d-1))
+
+	default:
+		return &VerifyError{PC: pc, Msg: fmt.Sprintf("%s is not supported", name)}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"strings"
+	"testing"
+)
+
+func TestGenerateGo(t *testing.T) {
+	env, ob := newLispEnv()
+	compile := func(src string) *Func {
+		fn, err := compileString(env, ob, src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return fn
+	}
+	point, err := Assemble([]byte(`
+		point
+		return`), ob, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	skipped, err := GenerateGo(&buf, "gen", []NamedFunc{
+		{"my-car", compile("(lambda (x) (car x))")},
+		{"", compile("(lambda () 1)")},
+		{"my-point", point},
+		{"my/car", compile("(lambda (x y) (car y))")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0].Error() != "my-point: pc 0: point is not supported" {
+		t.Errorf("skipped: have %v", skipped)
+	}
+
+	src := buf.String()
+	for _, want := range []string{
+		"package gen\n",
+		`{Name: "my-car", Fingerprint: "` + Fingerprint(compile("(lambda (x) (car x))")),
+		"func newMyCar(consts []lisp.Object) bcode.GoFuncCtx {",
+		"func newMyCar2(consts []lisp.Object) bcode.GoFuncCtx {",
+		"if len(args) != 3 {",
+		"s0 := args[1]\n",
+		"s1 := args[2]\n",
+		"if s1, err = bcode.Car(s1); err != nil {",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("generated code has no %q:\n%s", want, src)
+		}
+	}
+	// The first argument of my/car is never read.
+	if n := strings.Count(src, "s0 := args[1]"); n != 1 {
+		t.Errorf("have %d loads of the first argument:\n%s", n, src)
+	}
+	if strings.Contains(src, "my-point") {
+		t.Errorf("skipped function is generated:\n%s", src)
+	}
+}
+
+func TestGenerateGoEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := GenerateGo(&buf, "gen", nil); err != nil {
+		t.Fatal(err)
+	}
+	// Without functions, lisp package is not imported.
+	if src := buf.String(); strings.Contains(src, "emacs/lisp") {
+		t.Errorf("unused import:\n%s", src)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	env, ob := newLispEnv()
+	fingerprint := func(src string) string {
+		fn, err := compileString(env, ob, src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return Fingerprint(fn)
+	}
+	a := fingerprint("(lambda (x) (cons x 'a))")
+	if b := fingerprint("(lambda (x) (cons x 'a))"); a != b {
+		t.Errorf("same function: %s and %s", a, b)
+	}
+	for _, src := range []string{
+		"(lambda (x) (cons x 'b))",
+		"(lambda (x) (cons 'a x))",
+		"(lambda (x y) (cons x 'a))",
+	} {
+		if b := fingerprint(src); a == b {
+			t.Errorf("%s has the same fingerprint", src)
+		}
+	}
+
+	// Nested functions are compared by their code.
+	a = fingerprint("(lambda () (lambda (x) (car x)))")
+	if b := fingerprint("(lambda () (lambda (x) (cdr x)))"); a == b {
+		t.Errorf("nested functions have the same fingerprint")
+	}
+}
+
+func TestRegisterAOT(t *testing.T) {
+	env, ob := newLispEnv()
+	fn, err := compileString(env, ob, "(lambda (x) 'bytecode)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.RegisterAOT(AOTFunc{
+		Name:        "f",
+		Fingerprint: Fingerprint(fn),
+		Nargs:       1,
+		New: func(consts []lisp.Object) GoFuncCtx {
+			return func(env *Env, args []lisp.Object) error {
+				args[0] = lisp.NewCons(ob.Intern("go"), consts[0])
+				return nil
+			}
+		},
+	})
+
+	for _, name := range []string{"f", "g"} {
+		fsym := ob.Intern(name)
+		env.Fset(fsym, fn.Object())
+		val, err := env.Funcall(fsym, lisp.NewInt(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "bytecode"
+		if name == "f" {
+			// The Go version gets the constants of fn.
+			want = "(go . bytecode)"
+			if arity := env.GoFuncArity(fsym); arity != (Arity{1, 1}) {
+				t.Errorf("%s: have arity %v", name, arity)
+			}
+		}
+		if have := lisp.Prin1String(val); have != want {
+			t.Errorf("%s: have %s, want %s", name, have, want)
+		}
+	}
+
+	// Other functions with the same name keep their byte code.
+	other, err := compileString(env, ob, "(lambda (x) 'other)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsym := ob.Intern("f")
+	env.Fset(fsym, other.Object())
+	if def := env.SymbolFunction(fsym); def.Type != lisp.TypeFunc {
+		t.Errorf("have definition %s", lisp.Prin1String(def))
+	}
+}
@@ -0,0 +1,132 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Non-local exit handlers of byte code.
+//
+// pushcatch and pushconditioncase pop a catch tag or
+// condition-case conditions and push a handler that stays
+// active until the matching pophandler.
+// When an error leaves the code that is protected by a handler
+// that catches it, run continues from the handler target with
+// the stack, the call frames and the dynamic bindings restored
+// to the state of the handler push; the thrown value or the
+// (ERROR-SYMBOL . DATA) cons is pushed to the stack.
+//
+// pushunwind handlers catch every error and receive the
+// pending error object. Compiled unwind-protect places the
+// handler target right after the body, so the unwind forms
+// run for both exits, and then rethrow signals the pending
+// error again.
+// Issue#7
+
+// handler is an active pushcatch, pushconditioncase
+// or pushunwind handler.
+type handler struct {
+	// catch is true for pushcatch handlers.
+	catch bool
+
+	// unwind is true for pushunwind handlers.
+	unwind bool
+
+	// tag is the catch tag or the condition-case conditions.
+	tag lisp.Object
+
+	// fn is the function that pushed the handler, target
+	// is the instruction index run continues from.
+	fn     *Func
+	target uint32
+
+	// callDepth and sp are restored when the handler
+	// catches an error.
+	callDepth int
+	sp        uint32
+
+	// specpdl and catchTags are the lengths of the
+	// corresponding Env stacks at the handler push.
+	specpdl   int
+	catchTags int
+}
+
+// pushHandler makes h the innermost handler.
+// Catch handlers make their tag active for `throw`.
+func (env *Env) pushHandler(h handler) {
+	h.specpdl = env.specpdlIndex()
+	h.catchTags = len(env.catchTags)
+	if h.catch {
+		env.catchTags = append(env.catchTags, h.tag)
+	}
+	env.handlers = append(env.handlers, h)
+}
+
+// popHandler removes the innermost handler.
+func (env *Env) popHandler() {
+	h := &env.handlers[len(env.handlers)-1]
+	env.catchTags = env.catchTags[:h.catchTags]
+	env.handlers = env.handlers[:len(env.handlers)-1]
+}
+
+// handleError finds the innermost handler above hbase that
+// catches err. The handler and all the handlers pushed after it
+// are removed and dynamic bindings made after the handler push
+// are undone.
+// Returns the handler along with the value it receives;
+// ok is false if err is not caught.
+func (env *Env) handleError(hbase int, err error) (h handler, val lisp.Object, ok bool) {
+	if err == ErrEOF {
+		return h, val, false
+	}
+	for i := len(env.handlers) - 1; i >= hbase; i-- {
+		h = env.handlers[i]
+		if h.unwind {
+			val = newPendingError(env, err)
+		} else if h.catch {
+			t, isThrow := err.(*Throw)
+			if !isThrow || !lisp.Eq(&t.Tag, &h.tag) {
+				continue
+			}
+			val = t.Value
+		} else {
+			sig := env.errorSignal(err)
+			if sig == nil || !env.conditionMatches(h.tag, sig.Symbol) {
+				continue
+			}
+			val = env.NewCons(sig.Symbol, sig.Data)
+		}
+		env.handlers = env.handlers[:i]
+		env.catchTags = env.catchTags[:h.catchTags]
+		env.unbindTo(h.specpdl)
+		return h, val, true
+	}
+	return h, val, false
+}
+
+// pendingError is the error that a pushunwind handler
+// receives; rethrow signals it again with its backtrace.
+type pendingError struct {
+	err       error
+	backtrace []Frame
+}
+
+// newPendingError wraps err into a user-ptr object.
+func newPendingError(env *Env, err error) lisp.Object {
+	p := &pendingError{err: err, backtrace: env.Backtrace(err)}
+	return lisp.NewUserPtr(p, "pending-error", nil)
+}
+
+// rethrow returns the error of pendingError object x
+// and restores its backtrace.
+// Returns nil if x is not a pendingError.
+func (env *Env) rethrow(x *lisp.Object) error {
+	if x.Type != lisp.TypeUserPtr {
+		return nil
+	}
+	p, ok := x.UserPtr().Value.(*pendingError)
+	if !ok {
+		return nil
+	}
+	env.backtraceErr, env.backtrace = p.err, p.backtrace
+	return p.err
+}
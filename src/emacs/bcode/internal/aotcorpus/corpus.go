@@ -0,0 +1,111 @@
+// Package aotcorpus holds Go versions of a set of
+// compiled Lisp functions.
+//
+// The functions are defined by Source; funcs.go is generated
+// from their compiled form by bcode.GenerateGo, and bcode
+// tests compare the Go versions with the byte code.
+package aotcorpus
+
+//go:generate go run gen.go
+
+import (
+	"emacs/bcode"
+	"emacs/lisp"
+	"emacs/reader"
+	"io"
+)
+
+// Source defines the corpus functions.
+const Source = `
+(defvar aot-level 0)
+
+(defun aot-length (l)
+  (let ((n 0)) (while l (setq n (1+ n) l (cdr l))) n))
+
+(defun aot-reverse (l)
+  (let (r) (while l (setq r (cons (car l) r) l (cdr l))) r))
+
+(defun aot-range (n)
+  (let (l) (while (> n 0) (setq l (cons n l) n (1- n))) l))
+
+(defun aot-memq (x l)
+  (while (and l (not (eq (car l) x))) (setq l (cdr l)))
+  l)
+
+(defun aot-assq (k al)
+  (let (r)
+    (while (and al (not r))
+      (if (eq (car (car al)) k) (setq r (car al)))
+      (setq al (cdr al)))
+    r))
+
+(defun aot-plist-get (pl prop)
+  (let (v)
+    (while pl
+      (if (eq (car pl) prop)
+          (setq v (car (cdr pl)) pl nil)
+        (setq pl (cdr (cdr pl)))))
+    v))
+
+(defun aot-sum (l)
+  (let ((s 0)) (while l (setq s (+ s (car l)) l (cdr l))) s))
+
+(defun aot-max (l)
+  (let ((m (car l)))
+    (while (setq l (cdr l))
+      (if (> (car l) m) (setq m (car l))))
+    m))
+
+(defun aot-fib (n)
+  (if (< n 2) n (+ (aot-fib (- n 1)) (aot-fib (- n 2)))))
+
+(defun aot-arith (x y)
+  (list (+ x y) (- x y) (* x y) (- x) (= x y) (< x y) (<= x y) (>= x y)))
+
+(defun aot-first (x y)
+  (or (car x) (and y (car y)) 'none))
+
+(defun aot-nest (n)
+  (let ((aot-level (1+ aot-level)))
+    (if (> n 0) (aot-nest (1- n)) aot-level)))
+
+(defun aot-count (l)
+  (setq aot-level 0)
+  (while l (setq aot-level (1+ aot-level) l (cdr l)))
+  aot-level)
+
+(defun aot-map (f l)
+  (let (r) (while l (setq r (cons (funcall f (car l)) r) l (cdr l))) r))
+`
+
+// Define evaluates Source in env. The functions are compiled
+// and defined by DefineCompiledFunc, so their registered
+// Go versions replace them. Returns the compiled functions.
+func Define(env *bcode.Env, ob *lisp.Obarray) ([]bcode.NamedFunc, error) {
+	defun := ob.Intern("defun")
+	lambda := ob.Intern("lambda")
+	var funcs []bcode.NamedFunc
+	r := reader.New([]byte(Source), ob)
+	for {
+		form, err := r.Read()
+		if err == io.EOF {
+			return funcs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cons := form.Cons(); cons.Car.Ptr != defun.Ptr {
+			if _, err := env.Eval(form, lisp.T); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name := form.Cons().Cdr.Cons().Car
+		fn, err := env.Compile(lisp.NewCons(lambda, form.Cons().Cdr.Cons().Cdr))
+		if err != nil {
+			return nil, err
+		}
+		env.DefineCompiledFunc(name, fn)
+		funcs = append(funcs, bcode.NamedFunc{Name: name.Symbol().Name, Func: fn})
+	}
+}
@@ -0,0 +1,39 @@
+//go:build ignore
+// +build ignore
+
+// gen generates funcs.go from the compiled Source functions.
+package main
+
+import (
+	"bytes"
+	"emacs/bcode"
+	"emacs/bcode/internal/aotcorpus"
+	"emacs/lisp"
+	"io/ioutil"
+	"log"
+)
+
+func main() {
+	ob := lisp.NewObarray()
+	bcode.AddSymbols(ob)
+	master := bcode.NewMasterEnv()
+	master.DefineSubrs(ob)
+	funcs, err := aotcorpus.Define(master.NewEnv(0, 0), ob)
+	if err != nil {
+		log.Fatal(bcode.ErrorMessage(err))
+	}
+	var buf bytes.Buffer
+	skipped, err := bcode.GenerateGo(&buf, "aotcorpus", funcs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, e := range skipped {
+		log.Printf("skipped %v", e)
+	}
+	if len(skipped) != 0 {
+		log.Fatal("all corpus functions must be generated")
+	}
+	if err := ioutil.WriteFile("funcs.go", buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
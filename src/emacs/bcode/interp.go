@@ -0,0 +1,897 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Interpreter for uncompiled Lisp forms.
+//
+// Forms are evaluated by walking them directly.
+// Like in Emacs, the interpreter supports both binding modes:
+//
+//	- dynamic binding: all variables are bound with specbind;
+//	- lexical binding: local variables live in the lexical
+//	  environment, an alist of (SYMBOL . VALUE) pairs;
+//	  special (defvar'ed) variables are still bound dynamically.
+//
+// Interpreted functions use Emacs representation:
+// (lambda ARGS . BODY) for dynamic binding functions and
+// (closure ENV ARGS . BODY) for lexical closures that capture ENV.
+// They can be called from compiled code and vice versa.
+
+// Symbols that have special meaning for the interpreter.
+//
+// Should be treated as constants.
+var (
+	SymLambda  = lisp.NewSymbol("lambda")
+	SymClosure = lisp.NewSymbol("closure")
+
+	symOptional = lisp.NewSymbol("&optional")
+	symRest     = lisp.NewSymbol("&rest")
+)
+
+// Special form symbols, see specialForms.
+var (
+	symQuote                  = lisp.NewSymbol("quote")
+	symFunction               = lisp.NewSymbol("function")
+	symProgn                  = lisp.NewSymbol("progn")
+	symProg1                  = lisp.NewSymbol("prog1")
+	symProg2                  = lisp.NewSymbol("prog2")
+	symIf                     = lisp.NewSymbol("if")
+	symCond                   = lisp.NewSymbol("cond")
+	symAnd                    = lisp.NewSymbol("and")
+	symOr                     = lisp.NewSymbol("or")
+	symWhile                  = lisp.NewSymbol("while")
+	symLet                    = lisp.NewSymbol("let")
+	symLetStar                = lisp.NewSymbol("let*")
+	symSetq                   = lisp.NewSymbol("setq")
+	symDefvar                 = lisp.NewSymbol("defvar")
+	symDefconst               = lisp.NewSymbol("defconst")
+	symCatch                  = lisp.NewSymbol("catch")
+	symUnwindProtect          = lisp.NewSymbol("unwind-protect")
+	symConditionCase          = lisp.NewSymbol("condition-case")
+	symSaveExcursion          = lisp.NewSymbol("save-excursion")
+	symSaveRestriction        = lisp.NewSymbol("save-restriction")
+	symSaveCurrentBuffer      = lisp.NewSymbol("save-current-buffer")
+	symSaveMatchData          = lisp.NewSymbol("save-match-data")
+	symWithOutputToTempBuffer = lisp.NewSymbol("with-output-to-temp-buffer")
+	symInteractive            = lisp.NewSymbol("interactive")
+
+	// specialFormSymbols lists the symbols above,
+	// AddSymbols adds them to obarrays.
+	specialFormSymbols = []lisp.Object{
+		symQuote, symFunction, symProgn, symProg1, symProg2,
+		symIf, symCond, symAnd, symOr, symWhile,
+		symLet, symLetStar, symSetq, symDefvar, symDefconst,
+		symCatch, symUnwindProtect, symConditionCase,
+		symSaveExcursion, symSaveRestriction, symSaveCurrentBuffer,
+		symSaveMatchData, symWithOutputToTempBuffer, symInteractive,
+	}
+)
+
+// specialForm evaluates special form with unevaluated args.
+type specialForm func(env *Env, args lisp.Object) (lisp.Object, error)
+
+// specialForms maps special form symbols to their implementations.
+// Special forms are recognized by symbol identity: the symbols
+// are added to obarrays by AddSymbols, other symbols with the
+// same names (like uninterned ones) are not special.
+var specialForms map[*lisp.Symbol]specialForm
+
+func init() {
+	specialForms = map[*lisp.Symbol]specialForm{
+		symQuote.Symbol():                  evalQuote,
+		symFunction.Symbol():               evalFunction,
+		SymLambda.Symbol():                 evalLambda,
+		symProgn.Symbol():                  evalProgn,
+		symProg1.Symbol():                  evalProg1,
+		symProg2.Symbol():                  evalProg2,
+		symIf.Symbol():                     evalIf,
+		symCond.Symbol():                   evalCond,
+		symAnd.Symbol():                    evalAnd,
+		symOr.Symbol():                     evalOr,
+		symWhile.Symbol():                  evalWhile,
+		symLet.Symbol():                    evalLet,
+		symLetStar.Symbol():                evalLetStar,
+		symSetq.Symbol():                   evalSetq,
+		symDefvar.Symbol():                 evalDefvar,
+		symDefconst.Symbol():               evalDefconst,
+		symCatch.Symbol():                  evalCatch,
+		symUnwindProtect.Symbol():          evalUnwindProtect,
+		symConditionCase.Symbol():          evalConditionCase,
+		symSaveExcursion.Symbol():          evalSaveExcursion,
+		symSaveRestriction.Symbol():        evalSaveRestriction,
+		symSaveCurrentBuffer.Symbol():      evalSaveCurrentBuffer,
+		symSaveMatchData.Symbol():          evalSaveMatchData,
+		symWithOutputToTempBuffer.Symbol(): evalWithOutputToTempBuffer,
+		symInteractive.Symbol():            evalInteractive,
+	}
+}
+
+// Eval implements `eval`.
+//
+// lexical selects the binding mode like `eval` LEXICAL argument does:
+// nil means dynamic binding, t means lexical binding, and an alist
+// of (SYMBOL . VALUE) pairs is the initial lexical environment.
+func (env *Env) Eval(form, lexical lisp.Object) (lisp.Object, error) {
+	if env.callDepth == 0 {
+		// Outermost evaluation, see Backtrace.
+		env.backtraceErr = nil
+	}
+	lexenv := env.lexenv
+	defer func() { env.lexenv = lexenv }()
+	switch {
+	case lisp.Null(&lexical):
+		env.lexenv = lisp.Nil
+	case lexical.Type == lisp.TypeCons:
+		env.lexenv = lexical
+	default:
+		env.lexenv = lisp.List(lisp.T)
+	}
+	return env.eval(form)
+}
+
+// eval evaluates form in the current lexical environment.
+//
+// Like Emacs lisp-eval-depth, nested forms count against
+// the call depth limit, so deeply nested forms signal
+// excessive-lisp-nesting even if they call no functions.
+func (env *Env) eval(form lisp.Object) (lisp.Object, error) {
+	if form.Type != lisp.TypeCons {
+		return env.evalForm(form)
+	}
+	if env.callDepth+env.evalDepth >= len(env.frames) {
+		return lisp.Nil, errCallDepth
+	}
+	env.evalDepth++
+	val, err := env.evalForm(form)
+	env.evalDepth--
+	return val, err
+}
+
+// evalForm implements eval.
+func (env *Env) evalForm(form lisp.Object) (lisp.Object, error) {
+	switch form.Type {
+	case lisp.TypeSymbol:
+		if val, ok := constantValue(form); ok {
+			return val, nil
+		}
+		if b := env.lexicalBinding(form); b != nil {
+			return b.Cdr, nil
+		}
+		return env.symbolValue(form)
+
+	case lisp.TypeCons:
+		cons := form.Cons()
+		if cons.Car.Type == lisp.TypeSymbol {
+			if sf, ok := specialForms[cons.Car.Symbol()]; ok {
+				return sf(env, cons.Cdr)
+			}
+			if expander, ok := env.macroFunction(cons.Car); ok {
+				expanded, err := env.Funcall(expander, listSlice(cons.Cdr)...)
+				if err != nil {
+					return lisp.Nil, err
+				}
+				return env.eval(expanded)
+			}
+		}
+		fn := cons.Car
+		switch {
+		case isLambda(fn):
+			// ((lambda ...) ARGS...) calls a closure.
+			fn = env.makeClosure(fn)
+		case fn.Type == lisp.TypeCons && fn.Cons().Car.Ptr != SymClosure.Ptr:
+			return lisp.Nil, signal(SymInvalidFunction, fn)
+		case fn.Type == lisp.TypeSymbol:
+			// Like Emacs eval_sub, the function is resolved
+			// before the arguments are evaluated.
+			def, err := env.indirectFunction(fn)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			if def.Type != lisp.TypeSymbol && !isFunctionList(def) {
+				return lisp.Nil, signal(SymInvalidFunction, fn)
+			}
+		}
+		args, err := env.evalArgs(cons.Cdr)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		return env.Funcall(fn, args...)
+	}
+	return form, nil
+}
+
+// evalArgs evaluates function call arguments.
+func (env *Env) evalArgs(forms lisp.Object) ([]lisp.Object, error) {
+	var args []lisp.Object
+	for ; forms.Type == lisp.TypeCons; forms = forms.Cons().Cdr {
+		val, err := env.eval(forms.Cons().Car)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, val)
+	}
+	return args, nil
+}
+
+// progn evaluates body forms and returns the last value.
+func (env *Env) progn(body lisp.Object) (lisp.Object, error) {
+	val := lisp.Nil
+	for ; body.Type == lisp.TypeCons; body = body.Cons().Cdr {
+		var err error
+		val, err = env.eval(body.Cons().Car)
+		if err != nil {
+			return lisp.Nil, err
+		}
+	}
+	return val, nil
+}
+
+// lexicalBinding returns the (SYMBOL . VALUE) cell of
+// the innermost lexical binding of sym or nil.
+func (env *Env) lexicalBinding(sym lisp.Object) *lisp.Cons {
+	for tail := env.lexenv; tail.Type == lisp.TypeCons; tail = tail.Cons().Cdr {
+		b := tail.Cons().Car
+		if b.Type == lisp.TypeCons && b.Cons().Car.Ptr == sym.Ptr {
+			return b.Cons()
+		}
+	}
+	return nil
+}
+
+// isSpecial reports whether sym must be bound dynamically
+// in the current lexical environment: it is declared
+// with defvar either globally or locally.
+func (env *Env) isSpecial(sym lisp.Object) bool {
+	if env.declaredSpecial(sym) {
+		return true
+	}
+	for tail := env.lexenv; tail.Type == lisp.TypeCons; tail = tail.Cons().Cdr {
+		if tail.Cons().Car.Ptr == sym.Ptr {
+			return true
+		}
+	}
+	return false
+}
+
+// bind binds variable sym to val: lexically if lexical
+// binding is active and sym is not special, dynamically otherwise.
+// Bindings are removed by restoring env.lexenv and unbindTo.
+func (env *Env) bind(sym, val lisp.Object) error {
+	if sym.Type != lisp.TypeSymbol {
+		return wrongTypeArgument(SymSymbolp, sym)
+	}
+	if _, ok := constantValue(sym); ok {
+		return signal(SymSettingConstant, sym)
+	}
+	if lisp.Null(&env.lexenv) || env.isSpecial(sym) {
+		env.specbind(sym, val)
+		return nil
+	}
+	env.lexenv = env.NewCons(env.NewCons(sym, val), env.lexenv)
+	return nil
+}
+
+// isLambda reports whether x is a (lambda ...) list.
+func isLambda(x lisp.Object) bool {
+	return x.Type == lisp.TypeCons && x.Cons().Car.Ptr == SymLambda.Ptr
+}
+
+// isFunctionList reports whether x is a (lambda ...)
+// or (closure ...) list.
+func isFunctionList(x lisp.Object) bool {
+	return isLambda(x) || x.Type == lisp.TypeCons && x.Cons().Car.Ptr == SymClosure.Ptr
+}
+
+// makeClosure returns function value of (lambda ARGS . BODY) form:
+// under lexical binding, it captures the current lexical environment.
+func (env *Env) makeClosure(lambda lisp.Object) lisp.Object {
+	if lisp.Null(&env.lexenv) {
+		return lambda
+	}
+	return env.NewCons(SymClosure, env.NewCons(env.lexenv, lambda.Cons().Cdr))
+}
+
+// funcallDef calls function symbol fn that has no
+// compiled or Go function bound to it.
+func (env *Env) funcallDef(fn lisp.Object, args []lisp.Object) (lisp.Object, error) {
+	def, err := env.indirectFunction(fn)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if def.Type == lisp.TypeSymbol {
+		return env.Funcall(def, args...)
+	}
+	return env.funcallLambda(fn, def, args)
+}
+
+// indirectFunction follows the aliases of function symbol fn
+// that had no definition when they were aliased. Returns the
+// first symbol that has a compiled or Go function bound to it
+// or the definition that is stored by Fset.
+// Signals void-function if the chain ends with a void symbol.
+func (env *Env) indirectFunction(fn lisp.Object) (lisp.Object, error) {
+	def := fn
+	// Longer chains must contain a loop.
+	for i := 0; def.Type == lisp.TypeSymbol; i++ {
+		sym := def.Symbol()
+		if env.funcRef(sym).bound() {
+			return def, nil
+		}
+		d, ok := env.fdef(sym)
+		if !ok || lisp.Null(&def) {
+			return lisp.Nil, signal(SymVoidFunction, def)
+		}
+		if i > env.fdefCount() {
+			return lisp.Nil, signal(SymCyclicFunctionIndirection, fn)
+		}
+		def = d
+	}
+	return def, nil
+}
+
+// funcallLambda calls interpreted function def with args.
+// fn is the called function object, it is used in backtraces.
+func (env *Env) funcallLambda(fn, def lisp.Object, args []lisp.Object) (lisp.Object, error) {
+	if def.Type != lisp.TypeCons {
+		return lisp.Nil, signal(SymInvalidFunction, fn)
+	}
+	var lexenv, rest lisp.Object
+	switch head := def.Cons(); head.Car.Ptr {
+	case SymLambda.Ptr:
+		lexenv, rest = lisp.Nil, head.Cdr
+	case SymClosure.Ptr:
+		if head.Cdr.Type != lisp.TypeCons {
+			return lisp.Nil, signal(SymInvalidFunction, fn)
+		}
+		lexenv, rest = head.Cdr.Cons().Car, head.Cdr.Cons().Cdr
+		if lisp.Null(&lexenv) {
+			// Closure without captured variables.
+			lexenv = lisp.List(lisp.T)
+		}
+	default:
+		return lisp.Nil, signal(SymInvalidFunction, fn)
+	}
+	if rest.Type != lisp.TypeCons {
+		return lisp.Nil, signal(SymInvalidFunction, fn)
+	}
+	params, body := rest.Cons().Car, rest.Cons().Cdr
+
+	if env.callDepth >= len(env.frames) {
+		return lisp.Nil, errCallDepth
+	}
+	if env.callDepth == 0 {
+		// Outermost call, see Backtrace.
+		env.backtraceErr = nil
+	}
+	callDepth := env.callDepth
+	specpdl := env.specpdlIndex()
+	savedLexenv := env.lexenv
+	defer func() {
+		env.callDepth = callDepth
+		env.unbindTo(specpdl)
+		env.lexenv = savedLexenv
+	}()
+	env.callDepth++
+	env.lexenv = lexenv
+
+	val, err := env.bindParams(def, params, args)
+	if err == nil {
+		val, err = env.progn(body)
+	}
+	if err != nil {
+		env.addFrame(err, fn, args)
+		return lisp.Nil, err
+	}
+	return val, nil
+}
+
+// bindParams binds lambda list params to args.
+// def is the function that is being called.
+func (env *Env) bindParams(def, params lisp.Object, args []lisp.Object) (lisp.Object, error) {
+	optional := false
+	i := 0
+	for ; params.Type == lisp.TypeCons; params = params.Cons().Cdr {
+		sym := params.Cons().Car
+		switch sym.Ptr {
+		case symOptional.Ptr:
+			optional = true
+			continue
+		case symRest.Ptr:
+			rest := params.Cons().Cdr
+			if rest.Type != lisp.TypeCons {
+				return lisp.Nil, signal(SymInvalidFunction, def)
+			}
+			var restArgs []lisp.Object
+			if i < len(args) {
+				restArgs = args[i:]
+			}
+			return lisp.Nil, env.bind(rest.Cons().Car, env.List(restArgs...))
+		}
+		val := lisp.Nil
+		switch {
+		case i < len(args):
+			val = args[i]
+		case !optional:
+			return lisp.Nil, signal(SymWrongNumberOfArguments, def, lisp.NewInt(int64(len(args))))
+		}
+		if err := env.bind(sym, val); err != nil {
+			return lisp.Nil, err
+		}
+		i++
+	}
+	if i < len(args) {
+		return lisp.Nil, signal(SymWrongNumberOfArguments, def, lisp.NewInt(int64(len(args))))
+	}
+	return lisp.Nil, nil
+}
+
+// argsSlice returns special form arguments.
+// Signals wrong-number-of-arguments if their number
+// is not within [min, max]; max=ArityMany means no limit.
+func argsSlice(name string, args lisp.Object, min, max int) ([]lisp.Object, error) {
+	xs := listSlice(args)
+	if len(xs) < min || max != ArityMany && len(xs) > max {
+		return nil, signal(SymWrongNumberOfArguments,
+			lisp.NewSymbol(name), lisp.NewInt(int64(len(xs))))
+	}
+	return xs, nil
+}
+
+func evalQuote(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("quote", args, 1, 1)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	return xs[0], nil
+}
+
+func evalFunction(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("function", args, 1, 1)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if isLambda(xs[0]) {
+		return env.makeClosure(xs[0]), nil
+	}
+	return xs[0], nil
+}
+
+func evalLambda(env *Env, args lisp.Object) (lisp.Object, error) {
+	return env.makeClosure(lisp.NewCons(SymLambda, args)), nil
+}
+
+func evalProgn(env *Env, args lisp.Object) (lisp.Object, error) {
+	return env.progn(args)
+}
+
+func evalProg1(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("prog1", args, 1, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	val, err := env.eval(xs[0])
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if _, err := env.progn(args.Cons().Cdr); err != nil {
+		return lisp.Nil, err
+	}
+	return val, nil
+}
+
+func evalProg2(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("prog2", args, 2, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if _, err := env.eval(xs[0]); err != nil {
+		return lisp.Nil, err
+	}
+	return evalProg1(env, args.Cons().Cdr)
+}
+
+func evalIf(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("if", args, 2, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	cond, err := env.eval(xs[0])
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if !lisp.Null(&cond) {
+		return env.eval(xs[1])
+	}
+	return env.progn(args.Cons().Cdr.Cons().Cdr)
+}
+
+func evalCond(env *Env, args lisp.Object) (lisp.Object, error) {
+	for ; args.Type == lisp.TypeCons; args = args.Cons().Cdr {
+		clause := args.Cons().Car
+		if clause.Type != lisp.TypeCons {
+			return lisp.Nil, wrongTypeArgument(SymListp, clause)
+		}
+		val, err := env.eval(clause.Cons().Car)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		if lisp.Null(&val) {
+			continue
+		}
+		if body := clause.Cons().Cdr; body.Type == lisp.TypeCons {
+			return env.progn(body)
+		}
+		return val, nil
+	}
+	return lisp.Nil, nil
+}
+
+func evalAnd(env *Env, args lisp.Object) (lisp.Object, error) {
+	val := lisp.T
+	for ; args.Type == lisp.TypeCons; args = args.Cons().Cdr {
+		var err error
+		val, err = env.eval(args.Cons().Car)
+		if err != nil || lisp.Null(&val) {
+			return val, err
+		}
+	}
+	return val, nil
+}
+
+func evalOr(env *Env, args lisp.Object) (lisp.Object, error) {
+	for ; args.Type == lisp.TypeCons; args = args.Cons().Cdr {
+		val, err := env.eval(args.Cons().Car)
+		if err != nil || !lisp.Null(&val) {
+			return val, err
+		}
+	}
+	return lisp.Nil, nil
+}
+
+func evalWhile(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("while", args, 1, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	body := args.Cons().Cdr
+	for {
+		cond, err := env.eval(xs[0])
+		if err != nil {
+			return lisp.Nil, err
+		}
+		if lisp.Null(&cond) {
+			return lisp.Nil, nil
+		}
+		if _, err := env.progn(body); err != nil {
+			return lisp.Nil, err
+		}
+	}
+}
+
+func evalLet(env *Env, args lisp.Object) (lisp.Object, error) {
+	return env.evalLet("let", args, false)
+}
+
+func evalLetStar(env *Env, args lisp.Object) (lisp.Object, error) {
+	return env.evalLet("let*", args, true)
+}
+
+// evalLet implements `let` and `let*`.
+// If sequential is true, each binding is made before
+// the next value is evaluated.
+func (env *Env) evalLet(name string, args lisp.Object, sequential bool) (lisp.Object, error) {
+	if _, err := argsSlice(name, args, 1, ArityMany); err != nil {
+		return lisp.Nil, err
+	}
+	specpdl := env.specpdlIndex()
+	lexenv := env.lexenv
+	defer func() {
+		env.unbindTo(specpdl)
+		env.lexenv = lexenv
+	}()
+
+	var syms, vals []lisp.Object
+	for _, b := range listSlice(args.Cons().Car) {
+		sym, init, err := letBinding(b)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		val, err := env.eval(init)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		if sequential {
+			if err := env.bind(sym, val); err != nil {
+				return lisp.Nil, err
+			}
+			continue
+		}
+		syms = append(syms, sym)
+		vals = append(vals, val)
+	}
+	for i, sym := range syms {
+		if err := env.bind(sym, vals[i]); err != nil {
+			return lisp.Nil, err
+		}
+	}
+	return env.progn(args.Cons().Cdr)
+}
+
+// letBinding returns the variable and the value form
+// of let binding b: SYMBOL, (SYMBOL) or (SYMBOL VALUE).
+func letBinding(b lisp.Object) (sym, init lisp.Object, err error) {
+	if b.Type != lisp.TypeCons {
+		return b, lisp.Nil, nil
+	}
+	xs := listSlice(b)
+	if len(xs) > 2 {
+		return b, lisp.Nil, signal(SymError,
+			lisp.NewString([]byte("`let' bindings can have only one value-form")), b)
+	}
+	if len(xs) == 2 {
+		return xs[0], xs[1], nil
+	}
+	return xs[0], lisp.Nil, nil
+}
+
+func evalSetq(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs := listSlice(args)
+	if len(xs)%2 != 0 {
+		return lisp.Nil, signal(SymWrongNumberOfArguments,
+			symSetq, lisp.NewInt(int64(len(xs))))
+	}
+	val := lisp.Nil
+	for i := 0; i < len(xs); i += 2 {
+		sym := xs[i]
+		if sym.Type != lisp.TypeSymbol {
+			return lisp.Nil, wrongTypeArgument(SymSymbolp, sym)
+		}
+		var err error
+		val, err = env.eval(xs[i+1])
+		if err != nil {
+			return lisp.Nil, err
+		}
+		if b := env.lexicalBinding(sym); b != nil {
+			b.Cdr = val
+			continue
+		}
+		if _, ok := constantValue(sym); ok {
+			return lisp.Nil, signal(SymSettingConstant, sym)
+		}
+		env.setSymbolValue(sym, val)
+	}
+	return val, nil
+}
+
+func evalDefvar(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("defvar", args, 1, 3)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	sym := xs[0]
+	if sym.Type != lisp.TypeSymbol {
+		return lisp.Nil, wrongTypeArgument(SymSymbolp, sym)
+	}
+	if len(xs) == 1 {
+		// (defvar SYM) makes SYM special only locally.
+		if !lisp.Null(&env.lexenv) {
+			env.lexenv = env.NewCons(sym, env.lexenv)
+		}
+		return sym, nil
+	}
+	env.defineSpecial(sym)
+	if _, ok := env.globalValue(sym); ok {
+		return sym, nil
+	}
+	val, err := env.eval(xs[1])
+	if err != nil {
+		return lisp.Nil, err
+	}
+	env.setGlobalValue(sym, val)
+	return sym, nil
+}
+
+func evalDefconst(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("defconst", args, 2, 3)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	sym := xs[0]
+	if sym.Type != lisp.TypeSymbol {
+		return lisp.Nil, wrongTypeArgument(SymSymbolp, sym)
+	}
+	val, err := env.eval(xs[1])
+	if err != nil {
+		return lisp.Nil, err
+	}
+	env.defineSpecial(sym)
+	env.setGlobalValue(sym, val)
+	return sym, nil
+}
+
+func evalCatch(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("catch", args, 1, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	tag, err := env.eval(xs[0])
+	if err != nil {
+		return lisp.Nil, err
+	}
+	env.catchTags = append(env.catchTags, tag)
+	val, err := env.progn(args.Cons().Cdr)
+	env.catchTags = env.catchTags[:len(env.catchTags)-1]
+	if t, ok := err.(*Throw); ok && lisp.Eq(&t.Tag, &tag) {
+		return t.Value, nil
+	}
+	return val, err
+}
+
+func evalUnwindProtect(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("unwind-protect", args, 1, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	val, err := env.eval(xs[0])
+	if _, unwindErr := env.progn(args.Cons().Cdr); unwindErr != nil {
+		return lisp.Nil, unwindErr
+	}
+	return val, err
+}
+
+func evalConditionCase(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("condition-case", args, 2, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	v := xs[0]
+	if v.Type != lisp.TypeSymbol {
+		return lisp.Nil, wrongTypeArgument(SymSymbolp, v)
+	}
+	val, err := env.eval(xs[1])
+	if err == nil {
+		return val, nil
+	}
+	sig := env.errorSignal(err)
+	if sig == nil {
+		return lisp.Nil, err
+	}
+	for _, handler := range xs[2:] {
+		if handler.Type != lisp.TypeCons {
+			continue
+		}
+		if !env.conditionMatches(handler.Cons().Car, sig.Symbol) {
+			continue
+		}
+		if lisp.Null(&v) {
+			return env.progn(handler.Cons().Cdr)
+		}
+		specpdl := env.specpdlIndex()
+		lexenv := env.lexenv
+		defer func() {
+			env.unbindTo(specpdl)
+			env.lexenv = lexenv
+		}()
+		if err := env.bind(v, env.NewCons(sig.Symbol, sig.Data)); err != nil {
+			return lisp.Nil, err
+		}
+		return env.progn(handler.Cons().Cdr)
+	}
+	return lisp.Nil, err
+}
+
+// errorSignal returns err as a signal that condition-case can handle.
+// Go errors are converted to `error` signals with a message;
+// throws to the active catch tags can't be handled and nil is
+// returned for them, other throws become no-catch signals.
+func (env *Env) errorSignal(err error) *Signal {
+	switch err := err.(type) {
+	case *Signal:
+		return err
+	case *Throw:
+		if env.catching(err.Tag) {
+			return nil
+		}
+		return signal(SymNoCatch, err.Tag, err.Value)
+	}
+	return signal(SymError, lisp.NewString([]byte(err.Error())))
+}
+
+// catching reports whether there is an active catch for tag.
+func (env *Env) catching(tag lisp.Object) bool {
+	for i := range env.catchTags {
+		if lisp.Eq(&env.catchTags[i], &tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionMatches reports whether condition-case handler
+// conditions (a symbol or a list of symbols) catch errSym.
+// Conditions are matched against `error-conditions`
+// property of errSym; t catches everything.
+func (env *Env) conditionMatches(conditions, errSym lisp.Object) bool {
+	if conditions.Type != lisp.TypeCons {
+		conditions = lisp.List(conditions)
+	}
+	errConditions := env.get(errSym, SymErrorConditions)
+	for _, c := range listSlice(conditions) {
+		if c.Ptr == lisp.T.Ptr {
+			return true
+		}
+		for x := errConditions; x.Type == lisp.TypeCons; x = x.Cons().Cdr {
+			if x.Cons().Car.Ptr == c.Ptr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func evalSaveExcursion(env *Env, args lisp.Object) (lisp.Object, error) {
+	var val lisp.Object
+	err := env.saveExcursion(func() (err error) {
+		val, err = env.progn(args)
+		return err
+	})
+	return val, err
+}
+
+func evalSaveRestriction(env *Env, args lisp.Object) (lisp.Object, error) {
+	var val lisp.Object
+	err := env.saveRestriction(func() (err error) {
+		val, err = env.progn(args)
+		return err
+	})
+	return val, err
+}
+
+func evalSaveCurrentBuffer(env *Env, args lisp.Object) (lisp.Object, error) {
+	var val lisp.Object
+	err := env.saveCurrentBuffer(func() (err error) {
+		val, err = env.progn(args)
+		return err
+	})
+	return val, err
+}
+
+func evalSaveMatchData(env *Env, args lisp.Object) (lisp.Object, error) {
+	var val lisp.Object
+	err := env.saveMatchData(func() (err error) {
+		val, err = env.progn(args)
+		return err
+	})
+	return val, err
+}
+
+// evalWithOutputToTempBuffer implements `with-output-to-temp-buffer`:
+// body output goes to the erased BUFNAME buffer,
+// which is shown after body finishes.
+func evalWithOutputToTempBuffer(env *Env, args lisp.Object) (lisp.Object, error) {
+	xs, err := argsSlice("with-output-to-temp-buffer", args, 1, ArityMany)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	bufname, err := env.eval(xs[0])
+	if err != nil {
+		return lisp.Nil, err
+	}
+	specpdl := env.specpdlIndex()
+	buf, err := env.tempOutputBufferSetup(&bufname)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	val, err := env.progn(args.Cons().Cdr)
+	if err != nil {
+		env.unbindTo(specpdl)
+		return lisp.Nil, err
+	}
+	return env.tempOutputBufferShow(&val, &buf)
+}
+
+func evalInteractive(env *Env, args lisp.Object) (lisp.Object, error) {
+	return lisp.Nil, nil
+}
@@ -0,0 +1,225 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"emacs/reader"
+	"testing"
+)
+
+// newLispEnv returns an environment with primitive
+// functions defined and the obarray they are interned into.
+func newLispEnv() (*Env, *lisp.Obarray) {
+	master := NewMasterEnv()
+	env := master.NewEnv(0, 0)
+	ob := lisp.NewObarray()
+	AddSymbols(ob)
+	master.DefineSubrs(ob)
+	return env, ob
+}
+
+func TestEvalForm(t *testing.T) {
+	env, ob := newLispEnv()
+	if err := env.Load([]byte(testElc), "test.elc", ob); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		form string
+		want string
+	}{
+		{"1", "1"},
+		{`"str"`, `"str"`},
+		{"nil", "nil"},
+		{":key", ":key"},
+		{"'(a b)", "(a b)"},
+		{"#'inc", "inc"},
+		{"test-var", "10"},
+		{"(inc 1)", "2"},
+		{"(inc (inc test-var))", "12"},
+		{"(list 1 'x (list))", "(1 x nil)"},
+		{"(defvar new-var 'v)", "new-var"},
+		{"new-var", "v"},
+		{"(defalias 'inc2 'inc)", "inc2"},
+		{"(inc2 2)", "3"},
+		{"(provide 'feature)", "feature"},
+
+		// Special forms.
+		{"(progn)", "nil"},
+		{"(progn 1 2)", "2"},
+		{"(prog1 1 2 3)", "1"},
+		{"(prog2 1 2 3)", "2"},
+		{"(if nil 1 2 3)", "3"},
+		{"(if t 1 2)", "1"},
+		{"(if nil 1)", "nil"},
+		{"(cond ((eq 1 2) 'a) ((+ 1 1)) (t 'c))", "2"},
+		{"(cond (nil 1))", "nil"},
+		{"(and)", "t"},
+		{"(and 1 nil 2)", "nil"},
+		{"(and 1 2)", "2"},
+		{"(or nil 2 3)", "2"},
+		{"(or)", "nil"},
+		{"(let ((i 0) (s 0)) (while (< i 5) (setq s (+ s i) i (1+ i))) s)", "10"},
+		{"(let ((x 1)) (let ((x 2) (y x)) y))", "1"},
+		{"(let ((x 1)) (let* ((x 2) (y x)) y))", "2"},
+		{"(let (x (y)) (list x y))", "(nil nil)"},
+		{"(setq)", "nil"},
+		{"(defconst new-const 5)", "new-const"},
+		{"new-const", "5"},
+		{"(defvar new-var 'ignored)", "new-var"},
+		{"new-var", "v"},
+		{"(catch 'done (throw 'done 1) 2)", "1"},
+		{"(catch 'done 2)", "2"},
+		{"(let ((x 1)) (catch 'tag (unwind-protect (throw 'tag 2) (setq x 3))) x)", "3"},
+		{"(condition-case nil (car 1) (error 'caught))", "caught"},
+		{"(condition-case e (car 1) (wrong-type-argument e))", "(wrong-type-argument listp 1)"},
+		{"(condition-case e (signal 'my-error '(1)) (arith-error 1) (t e))", "(my-error 1)"},
+		{"(condition-case nil 1 (error 2))", "1"},
+		{"(condition-case e (throw 'nosuch 1) (no-catch e))", "(no-catch nosuch 1)"},
+		{"(condition-case nil (throw 'x 1) (error 'caught))", "caught"},
+		{"(catch 'x (condition-case nil (throw 'x 1) (error 'caught)))", "1"},
+		{"(condition-case nil (catch 'y (throw 'x 1)) (no-catch 'caught))", "caught"},
+		{"(condition-case nil (signal 'my-error nil) (error 'caught))", "peculiar error"},
+		{"(condition-case nil (signal 'overflow-error nil) (arith-error 'caught))", "caught"},
+		{"(condition-case nil (signal 'file-missing nil) (range-error 1) ((void-variable file-error) 2))", "2"},
+		{"(condition-case nil (signal 'file-error nil) (file-missing 1) (error 2))", "2"},
+		{"(progn (put 'my-error 'error-conditions '(my-error file-error error)) 'my-error)", "my-error"},
+		{"(condition-case e (signal 'my-error '(1)) (file-error e))", "(my-error 1)"},
+		{"(get 'wrong-type-argument 'error-conditions)", "(wrong-type-argument error)"},
+
+		// Functions.
+		{"((lambda (x) (* x x)) 3)", "9"},
+		{"(funcall (lambda (&optional a &rest b) (list a b)))", "(nil nil)"},
+		{"(funcall (lambda (&optional a &rest b) (list a b)) 1 2 3)", "(1 (2 3))"},
+		{"(apply '+ 1 '(2 3))", "6"},
+		{"(defalias 'sq (lambda (x) (* x x)))", "sq"},
+		{"(sq 4)", "16"},
+		{"(inc (sq 2))", "5"},
+		{"(funcall #'inc (sq 3))", "10"},
+		{"(symbol-function 'sq)", "(closure (t) (x) (* x x))"},
+		{"(fboundp 'sq)", "t"},
+		{"(fboundp 'undefined-func)", "nil"},
+		{"(eval '(+ 1 2))", "3"},
+		{"(byte-code \"\\300\\301!\\207\" [sq 5] 2)", "25"},
+
+		// Lexical binding.
+		{"(let ((x 1)) (function (lambda () x)))", "(closure ((x . 1) t) nil x)"},
+		{"(let ((x 1)) (funcall (let ((x 2)) (lambda () x))))", "2"},
+		{"(defalias 'counter (let ((n 0)) (lambda () (setq n (1+ n)))))", "counter"},
+		{"(progn (counter) (counter))", "2"},
+		{"(defvar dyn-var 1)", "dyn-var"},
+		{"(defalias 'get-dyn (lambda () dyn-var))", "get-dyn"},
+		{"(let ((dyn-var 2)) (get-dyn))", "2"},
+		{"dyn-var", "1"},
+		{"(special-variable-p 'dyn-var)", "t"},
+		{"(let ((lex-var 2)) (boundp 'lex-var))", "nil"},
+
+		{"undefined-var", "Symbol’s value as variable is void: undefined-var"},
+		{"(undefined-func 1)", "Symbol’s function definition is void: undefined-func"},
+		{"(inc undefined-var)", "Symbol’s value as variable is void: undefined-var"},
+		// Function is resolved before the arguments are evaluated.
+		{"(undefined-func undefined-var)", "Symbol’s function definition is void: undefined-func"},
+		{"(progn (setq evaluated nil) (condition-case nil (undefined-func (setq evaluated t)) (void-function evaluated)))", "nil"},
+		{"(progn (fset 'not-func 1) (not-func undefined-var))", "Invalid function: not-func"},
+		{"(1 2)", "Invalid function: 1"},
+		{"(quote)", "Wrong number of arguments: quote, 0"},
+		{"(if)", "Wrong number of arguments: if, 0"},
+		{"(setq x)", "Wrong number of arguments: setq, 1"},
+		{"(setq nil 1)", "Attempt to set a constant symbol: nil"},
+		{"(let ((t 1)) t)", "Attempt to set a constant symbol: t"},
+		{"(sq)", "Wrong number of arguments: (closure (t) (x) (* x x)), 0"},
+		{"(car 1)", "Wrong type argument: listp, 1"},
+		{"(+ 1 'a)", "Wrong type argument: number-or-marker-p, a"},
+		{"(throw 'unknown 1)", "No catch for tag: unknown, 1"},
+		{"(signal 'error '(\"msg\"))", "msg"},
+		{"(progn (fset 'loop1 'loop2) (fset 'loop2 'loop1) (loop1))",
+			"Symbol’s chain of function indirections contains a loop: loop1"},
+		{"(loop1 undefined-var)", "Symbol’s chain of function indirections contains a loop: loop1"},
+	}
+	for _, test := range tests {
+		form, err := reader.ReadString(test.form, ob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		val, err := env.Eval(form, lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
+
+func TestEvalDynamic(t *testing.T) {
+	env, ob := newLispEnv()
+
+	tests := []struct {
+		form string
+		want string
+	}{
+		// Without lexical binding every variable is dynamic.
+		{"(defalias 'get-x (lambda () x))", "get-x"},
+		{"(let ((x 1)) (get-x))", "1"},
+		{"(let ((x 1)) (function (lambda () x)))", "(lambda nil x)"},
+		{"(let ((x 1)) (boundp 'x))", "t"},
+		{"(boundp 'x)", "nil"},
+		{"(eval '(let ((y 1)) (function (lambda () y))) t)", "(closure ((y . 1) t) nil y)"},
+	}
+	for _, test := range tests {
+		form, err := reader.ReadString(test.form, ob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		val, err := env.Eval(form, lisp.Nil)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
+
+func TestEvalSpecialFormSymbols(t *testing.T) {
+	env, ob := newLispEnv()
+
+	// Only the interned symbols name special forms.
+	form := lisp.List(ob.Intern("if"), lisp.Nil, lisp.NewInt(1), lisp.NewInt(2))
+	if val, err := env.Eval(form, lisp.T); err != nil || val.Int() != 2 {
+		t.Errorf("eval %s: have %s, %v", lisp.Prin1String(form), lisp.Prin1String(val), err)
+	}
+	form = lisp.List(lisp.NewSymbol("if"), lisp.Nil, lisp.NewInt(1), lisp.NewInt(2))
+	_, err := env.Eval(form, lisp.T)
+	if want := "Symbol’s function definition is void: if"; err == nil || ErrorMessage(err) != want {
+		t.Errorf("eval uninterned if: have error %v, want %s", err, want)
+	}
+}
+
+func TestEvalNesting(t *testing.T) {
+	master := NewMasterEnv()
+	env := master.NewEnv(0, 10)
+	ob := lisp.NewObarray()
+	AddSymbols(ob)
+	master.DefineSubrs(ob)
+
+	// nested returns (progn (progn ... 1)) with n progns.
+	nested := func(n int) lisp.Object {
+		form := lisp.NewInt(1)
+		for i := 0; i < n; i++ {
+			form = lisp.List(ob.Intern("progn"), form)
+		}
+		return form
+	}
+	if _, err := env.Eval(nested(10), lisp.T); err != nil {
+		t.Errorf("depth 10: unexpected error: %v", err)
+	}
+	_, err := env.Eval(nested(11), lisp.T)
+	if err == nil || err.Error() != "(excessive-lisp-nesting . nil)" {
+		t.Errorf("depth 11: have error %v, want excessive-lisp-nesting", err)
+	}
+	if env.evalDepth != 0 || env.callDepth != 0 {
+		t.Errorf("evalDepth=%d callDepth=%d, want 0", env.evalDepth, env.callDepth)
+	}
+}
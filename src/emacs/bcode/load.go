@@ -0,0 +1,306 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"emacs/reader"
+	"fmt"
+	"io"
+)
+
+// Loading of Lisp files.
+//
+// Load evaluates all top-level forms of byte-compiled (.elc)
+// or source (.el) files with the interpreter.
+// Compiled functions that use dynamic binding are loaded,
+// but calling them signals an error.
+
+// LoadError is an error that happened while loading a file.
+type LoadError struct {
+	// File is the name of the file being loaded.
+	File string
+
+	// Line is the line of the top-level form that failed.
+	Line int
+
+	// Err is the error, usually a *Signal.
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, ErrorMessage(e.Err))
+}
+
+// NamedFunc is a compiled function that is defined by a file.
+type NamedFunc struct {
+	// Name is the function name;
+	// empty for top-level byte-code forms.
+	Name string
+
+	Func *Func
+}
+
+// MakeByteCode implements `make-byte-code`.
+// It builds verified compiled function object from
+// #[ARGS CODE CONSTANTS DEPTH ...] elements;
+// elements after DEPTH are ignored.
+//
+// It is suitable for reader.Reader ByteCode hook.
+func MakeByteCode(elems []lisp.Object) (lisp.Object, error) {
+	fn, err := makeByteCode(elems, true)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	return fn.Object(), nil
+}
+
+// makeByteCode is MakeByteCode that can skip verification.
+func makeByteCode(elems []lisp.Object, verify bool) (*Func, error) {
+	if len(elems) < 4 {
+		return nil, signal(SymInvalidFunction, lisp.NewVector(elems))
+	}
+	args, code, consts, depth := elems[0], elems[1], elems[2], elems[3]
+	fn := &Func{}
+	switch {
+	case args.Type == lisp.TypeInt:
+		// Lexical argument descriptor: mandatory + nonrest<<8 + rest<<7.
+		desc := args.Int()
+		mandatory, nonrest := desc&127, desc>>8
+		if nonrest < mandatory || nonrest > 127 {
+			return nil, signal(SymInvalidFunction, lisp.NewVector(elems))
+		}
+		fn.nargs = uint32(nonrest)
+		fn.optArgs = uint32(nonrest - mandatory)
+		if desc&128 != 0 {
+			fn.nargs++
+			fn.rest = true
+		}
+	case lisp.Null(&args) || args.Type == lisp.TypeCons:
+		// Dynamic binding argument list; arguments
+		// are not passed on the stack.
+		fn.dynamic = true
+	default:
+		return nil, signal(SymInvalidFunction, lisp.NewVector(elems))
+	}
+	if code.Type != lisp.TypeString {
+		return nil, wrongTypeArgument(SymStringp, code)
+	}
+	if consts.Type != lisp.TypeVector {
+		return nil, wrongTypeArgument(SymVectorp, consts)
+	}
+	if depth.Type != lisp.TypeInt {
+		return nil, wrongTypeArgument(SymIntegerp, depth)
+	}
+
+	fn.code = append([]byte(nil), code.String().Chars...)
+	fn.consts = consts.Vector().Vals
+	fn.translate()
+	if !verify {
+		return fn, nil
+	}
+	nargs := int(fn.nargs)
+	maxDepth, err := Verify(fn, nargs)
+	if err != nil {
+		return nil, err
+	}
+	if int64(maxDepth) > depth.Int() {
+		return nil, &VerifyError{
+			Msg: fmt.Sprintf("stack depth %d exceeds declared depth %d", maxDepth, depth.Int()),
+		}
+	}
+	fn.maxStack = uint32(maxDepth - nargs)
+	return fn, nil
+}
+
+// Load implements `load`.
+// src is the file contents; filename is used in errors
+// and as `#$` value.
+// Symbols are interned into ob.
+//
+// Forms are evaluated with lexical binding if the first line
+// of src sets `lexical-binding` to t, like Emacs does.
+// Functions that forms call must be defined, see DefineSubrs.
+func (env *Env) Load(src []byte, filename string, ob *lisp.Obarray) error {
+	lexical := lisp.Nil
+	if lexicalBindingCookie(src) {
+		lexical = lisp.T
+	}
+	return readForms(src, filename, ob, true, env.Optimize, func(form lisp.Object) error {
+		_, err := env.Eval(form, lexical)
+		return err
+	})
+}
+
+// lexicalBindingCookie reports whether the first line of src
+// has "-*- lexical-binding: t -*-" file variables.
+func lexicalBindingCookie(src []byte) bool {
+	line := src
+	if i := bytes.IndexByte(src, '\n'); i != -1 {
+		line = src[:i]
+	}
+	start := bytes.Index(line, []byte("-*-"))
+	if start == -1 {
+		return false
+	}
+	line = line[start+3:]
+	end := bytes.Index(line, []byte("-*-"))
+	if end == -1 {
+		return false
+	}
+	for _, v := range bytes.Split(line[:end], []byte(";")) {
+		kv := bytes.SplitN(v, []byte(":"), 2)
+		if len(kv) == 2 && string(bytes.TrimSpace(kv[0])) == "lexical-binding" {
+			return string(bytes.TrimSpace(kv[1])) != "nil"
+		}
+	}
+	return false
+}
+
+// ReadCompiledFuncs returns functions that file defines,
+// without evaluating any code.
+// If verify is false, functions are not verified,
+// which makes it possible to inspect malformed code.
+func ReadCompiledFuncs(src []byte, filename string, ob *lisp.Obarray, verify bool) ([]NamedFunc, error) {
+	var funcs []NamedFunc
+	err := readForms(src, filename, ob, verify, false, func(form lisp.Object) error {
+		switch formName(form) {
+		case "defalias", "fset":
+			name, def, err := defaliasArgs(form)
+			if err != nil {
+				return err
+			}
+			if def.Type == lisp.TypeFunc {
+				funcs = append(funcs, NamedFunc{name.Symbol().Name, objectFunc(&def)})
+			}
+		case "byte-code":
+			fn, err := byteCodeForm(form, verify)
+			if err != nil {
+				return err
+			}
+			funcs = append(funcs, NamedFunc{"", fn})
+		}
+		return nil
+	})
+	return funcs, err
+}
+
+// readForms calls fn for every top-level form in src.
+// If optimize is true, compiled functions are optimized.
+func readForms(src []byte, filename string, ob *lisp.Obarray, verify, optimize bool, fn func(form lisp.Object) error) error {
+	r := reader.New(src, ob)
+	r.FileName = filename
+	r.ByteCode = func(elems []lisp.Object) (lisp.Object, error) {
+		fn, err := makeByteCode(elems, verify)
+		if err == nil && optimize {
+			fn, err = Optimize(fn)
+		}
+		if err != nil {
+			return lisp.Nil, err
+		}
+		return fn.Object(), nil
+	}
+
+	for {
+		line := r.Line()
+		form, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		switch e := err.(type) {
+		case nil:
+			err = fn(form)
+		case *reader.Error:
+			line = e.Line
+			err = signal(SymInvalidReadSyntax, lisp.NewString([]byte(e.Msg)))
+		default:
+			if err == io.ErrUnexpectedEOF {
+				err = signal(SymEndOfFile)
+			}
+		}
+		if err != nil {
+			return &LoadError{File: filename, Line: line, Err: err}
+		}
+	}
+}
+
+// formName returns the name of the function that form calls.
+// Returns empty string if form is not a function call.
+func formName(form lisp.Object) string {
+	if form.Type != lisp.TypeCons {
+		return ""
+	}
+	car := form.Cons().Car
+	if car.Type != lisp.TypeSymbol {
+		return ""
+	}
+	return car.Symbol().Name
+}
+
+// defaliasArgs returns defined symbol and definition
+// of (defalias 'NAME DEF) form.
+func defaliasArgs(form lisp.Object) (name, def lisp.Object, err error) {
+	args := listSlice(form)[1:]
+	if len(args) < 2 {
+		return lisp.Nil, lisp.Nil, unsupportedForm(form)
+	}
+	name, ok := constantValue(args[0])
+	if !ok || name.Type != lisp.TypeSymbol {
+		return lisp.Nil, lisp.Nil, unsupportedForm(form)
+	}
+	def, ok = constantValue(args[1])
+	if !ok {
+		return lisp.Nil, lisp.Nil, unsupportedForm(form)
+	}
+	return name, def, nil
+}
+
+// byteCodeForm returns function that (byte-code CODE CONSTANTS DEPTH)
+// form executes.
+func byteCodeForm(form lisp.Object, verify bool) (*Func, error) {
+	args := listSlice(form)[1:]
+	if len(args) != 3 {
+		return nil, unsupportedForm(form)
+	}
+	return makeByteCode(append([]lisp.Object{lisp.NewInt(0)}, args...), verify)
+}
+
+// constantValue returns the value of form that
+// evaluates to itself or is quoted.
+func constantValue(form lisp.Object) (lisp.Object, bool) {
+	switch form.Type {
+	case lisp.TypeCons:
+		switch formName(form) {
+		case "quote", "function":
+			args := listSlice(form)[1:]
+			if len(args) == 1 {
+				return args[0], true
+			}
+		}
+		return lisp.Nil, false
+	case lisp.TypeSymbol:
+		name := form.Symbol().Name
+		if name == "nil" || name == "t" || name != "" && name[0] == ':' {
+			return form, true
+		}
+		return lisp.Nil, false
+	}
+	return form, true
+}
+
+// listSlice returns list elements.
+// Improper list tail is ignored.
+func listSlice(list lisp.Object) []lisp.Object {
+	var xs []lisp.Object
+	for ; list.Type == lisp.TypeCons; list = list.Cons().Cdr {
+		xs = append(xs, list.Cons().Car)
+	}
+	return xs
+}
+
+// unsupportedForm returns error about top-level form
+// that loader can't evaluate.
+func unsupportedForm(form lisp.Object) error {
+	return signal(SymError,
+		lisp.NewString([]byte("Unsupported top-level form")),
+		form)
+}
@@ -0,0 +1,160 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+// testElc is a byte-compiled file in Emacs format.
+const testElc = `;ELC   
+;;; Compiled
+;;; in Emacs version 26.1
+;;; with all optimizations.
+
+;;; This file uses dynamic docstrings, first added in Emacs 19.29.
+
+#@27 Return X plus one.
+
+(fn X)
+(defalias 'inc #[257 "\211T\207" [] 2 (#$ . 83)])
+(defalias 'inc-alias 'inc)
+(defvar test-var 10)
+(defvar test-var 20)
+(defconst test-const '(1 2))
+(defalias 'call-inc #[0 "\300\301!\207" [inc 41] 2])
+(defalias 'opt-rest #[641 "\300\003\003\003#\207" [list] 7])
+(defalias 'call-opt-rest #[0 "\300\301\302\303#\207" [opt-rest 1 2 3] 4])
+(byte-code "\300\301!\207" [inc 1] 2)
+(provide 'test)
+`
+
+func TestLoad(t *testing.T) {
+	env, ob := newLispEnv()
+	if err := env.Load([]byte(testElc), "test.elc", ob); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fn   string
+		args []lisp.Object
+		want string
+	}{
+		{"inc", []lisp.Object{lisp.NewInt(1)}, "2"},
+		{"inc-alias", []lisp.Object{lisp.NewInt(5)}, "6"},
+		{"call-inc", nil, "42"},
+		{"opt-rest", []lisp.Object{lisp.NewInt(1)}, "(1 . (nil . (nil . nil)))"},
+		{"opt-rest", []lisp.Object{lisp.NewInt(1), lisp.NewInt(2)}, "(1 . (2 . (nil . nil)))"},
+		{
+			"opt-rest", []lisp.Object{lisp.NewInt(1), lisp.NewInt(2), lisp.NewInt(3), lisp.NewInt(4)},
+			"(1 . (2 . ((3 . (4 . nil)) . nil)))",
+		},
+		{"call-opt-rest", nil, "(1 . (2 . ((3 . nil) . nil)))"},
+	}
+	for _, test := range tests {
+		have, err := env.Funcall(ob.Intern(test.fn), test.args...)
+		if err != nil {
+			t.Errorf("%s: %v", test.fn, err)
+			continue
+		}
+		if lisp.ObjectString(have) != test.want {
+			t.Errorf("%s: have %s, want %s", test.fn, lisp.ObjectString(have), test.want)
+		}
+	}
+
+	vars := []struct {
+		name string
+		want string
+	}{
+		{"test-var", "10"},
+		{"test-const", "(1 . (2 . nil))"},
+	}
+	for _, v := range vars {
+		have, err := env.SymbolValue(ob.Intern(v.name))
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+			continue
+		}
+		if lisp.ObjectString(have) != v.want {
+			t.Errorf("%s: have %s, want %s", v.name, lisp.ObjectString(have), v.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"(defalias 'f #[257 \"\\207\" [] 1])\n(", "test.elc:2: End of file during parsing"},
+		{"\n\n)", "test.elc:3: Invalid read syntax: \")\""},
+		{"(foo)", "test.elc:1: Symbol’s function definition is void: foo"},
+		{"\n(defvar x (foo))", "test.elc:2: Symbol’s function definition is void: foo"},
+		{"(defalias 'f #[(x) \"\\010\\207\" [x] 1])\n(f 1)", "test.elc:2: Dynamic binding functions are not supported"},
+		{"(defalias 'f #[385 \"\\207\" [] 2])\n(f)", "test.elc:2: Wrong number of arguments: (1 . many), 0"},
+		{"(defalias 'f #[258 \"\" [] 2])", "test.elc:1: Invalid function: [258 \"\" [] 2]"},
+		{"(defalias 'f #[0 \"\\207\" [] 1])", "test.elc:1: pc 0: return: stack underflow (depth 0, needs 1)"},
+		{"(defalias 'f #[0 \"\\300\\211\\207\" [1] 1])", "test.elc:1: pc 0: stack depth 2 exceeds declared depth 1"},
+		{"(byte-code \"\\300\\301!\\207\" [f 1] 2)", "test.elc:1: Symbol’s function definition is void: f"},
+	}
+
+	for _, test := range tests {
+		env, ob := newLispEnv()
+		err := env.Load([]byte(test.src), "test.elc", ob)
+		if err == nil {
+			t.Errorf("load %q: expected error", test.src)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("load %q:\nhave: %v\nwant: %s", test.src, err, test.want)
+		}
+	}
+}
+
+func TestReadCompiledFuncs(t *testing.T) {
+	funcs, err := ReadCompiledFuncs([]byte(testElc), "test.elc", lisp.NewObarray(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range funcs {
+		names = append(names, f.Name)
+	}
+	want := []string{"inc", "call-inc", "opt-rest", "call-opt-rest", ""}
+	if len(names) != len(want) {
+		t.Fatalf("have %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("have %q, want %q", names, want)
+		}
+	}
+
+	// Malformed code is only rejected when verifying.
+	bad := []byte("(defalias 'f #[0 \"\\207\" [] 1])")
+	if _, err := ReadCompiledFuncs(bad, "bad.elc", lisp.NewObarray(), false); err != nil {
+		t.Errorf("read without verification: %v", err)
+	}
+	if _, err := ReadCompiledFuncs(bad, "bad.elc", lisp.NewObarray(), true); err == nil {
+		t.Errorf("read with verification: expected error")
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{signal(SymVoidFunction, lisp.NewSymbol("foo")), "Symbol’s function definition is void: foo"},
+		{wrongTypeArgument(SymStringp, lisp.NewInt(1)), "Wrong type argument: stringp, 1"},
+		{signal(SymError, lisp.NewString([]byte("Oops")), lisp.NewInt(1)), "Oops: 1"},
+		{signal(SymEndOfBuffer), "End of buffer"},
+		{signal(lisp.NewSymbol("my-error"), lisp.NewInt(1)), "peculiar error: 1"},
+		{&Throw{Tag: lisp.NewSymbol("done"), Value: lisp.NewInt(3)}, "No catch for tag: done, 3"},
+		{ErrBadOpcode, "found unexpected opcode"},
+	}
+	for _, test := range tests {
+		if have := ErrorMessage(test.err); have != test.want {
+			t.Errorf("ErrorMessage(%v):\nhave: %s\nwant: %s", test.err, have, test.want)
+		}
+	}
+}
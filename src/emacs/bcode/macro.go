@@ -0,0 +1,553 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Macro expansion.
+//
+// Like in Emacs, a macro is a function cell that holds
+// (macro . FUNCTION): FUNCTION receives unevaluated arguments
+// and returns the expansion.
+// The interpreter and the byte compiler expand macros
+// before evaluation or compilation of the form.
+//
+// Compiler macros are optional rewrites of function calls:
+// FUNCTION `compiler-macro` property is called with the whole
+// call form followed by its arguments; it returns a replacement
+// or the form itself to keep the call.
+// They are applied by macroexpand-all and the byte compiler.
+//
+// defun, defmacro and backquote are macros that are
+// implemented in Go, see DefineSubrs.
+
+// Symbols that are used by the macro expander.
+//
+// Should be treated as constants.
+var (
+	SymMacro         = lisp.NewSymbol("macro")
+	SymCompilerMacro = lisp.NewSymbol("compiler-macro")
+)
+
+// DefineMacro makes Go function fn the macro expander of fsym.
+// fn receives unevaluated macro arguments and returns the expansion;
+// parameter conventions are the same as with DefineFunc.
+func (env *MasterEnv) DefineMacro(fsym lisp.Object, fn interface{}) {
+	// Expander symbol is not interned, so it can't be
+	// called or redefined by Lisp code directly.
+	expander := lisp.NewSymbol(fsym.Symbol().Name)
+	env.DefineFunc(expander, fn)
+	env.Fset(fsym, lisp.NewCons(SymMacro, expander))
+}
+
+// macroFunction returns expander of macro fsym.
+// Aliases are followed.
+func (env *MasterEnv) macroFunction(fsym lisp.Object) (lisp.Object, bool) {
+	def := fsym
+	for i := 0; def.Type == lisp.TypeSymbol && !lisp.Null(&def); i++ {
+		if env.funcRef(def.Symbol()).bound() || i > env.fdefCount() {
+			return lisp.Nil, false
+		}
+		d, ok := env.fdef(def.Symbol())
+		if !ok {
+			return lisp.Nil, false
+		}
+		def = d
+	}
+	if def.Type == lisp.TypeCons && def.Cons().Car.Ptr == SymMacro.Ptr {
+		return def.Cons().Cdr, true
+	}
+	return lisp.Nil, false
+}
+
+// MacroExpand1 implements `macroexpand-1`.
+// It expands form once if it is a macro call.
+//
+// environment is an alist of (NAME . FUNCTION) local macro
+// definitions that take precedence over global definitions;
+// FUNCTION nil means that NAME is not a macro.
+func (env *Env) MacroExpand1(form, environment lisp.Object) (lisp.Object, error) {
+	if form.Type != lisp.TypeCons || form.Cons().Car.Type != lisp.TypeSymbol {
+		return form, nil
+	}
+	head := form.Cons().Car
+	expander, ok := lisp.Nil, false
+	if b := assq(head, environment); b != nil {
+		expander, ok = b.Cdr, !lisp.Null(&b.Cdr)
+	} else {
+		expander, ok = env.macroFunction(head)
+	}
+	if !ok {
+		return form, nil
+	}
+	return env.Funcall(expander, listSlice(form.Cons().Cdr)...)
+}
+
+// MacroExpand implements `macroexpand`.
+// It expands form until it is not a macro call.
+func (env *Env) MacroExpand(form, environment lisp.Object) (lisp.Object, error) {
+	for {
+		expanded, err := env.MacroExpand1(form, environment)
+		if err != nil || lisp.Eq(&expanded, &form) {
+			return expanded, err
+		}
+		form = expanded
+	}
+}
+
+// MacroExpandAll implements `macroexpand-all`.
+// It expands all macro calls inside form, including
+// compiler macros; quoted data is not touched.
+func (env *Env) MacroExpandAll(form, environment lisp.Object) (lisp.Object, error) {
+	form, err := env.MacroExpand(form, environment)
+	if err != nil || form.Type != lisp.TypeCons {
+		return form, err
+	}
+	expandList := func(forms lisp.Object) (lisp.Object, error) {
+		var xs []lisp.Object
+		for _, x := range listSlice(forms) {
+			x, err := env.MacroExpandAll(x, environment)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			xs = append(xs, x)
+		}
+		return lisp.List(xs...), nil
+	}
+	// expandTail expands all elements of list but the first n.
+	expandTail := func(list lisp.Object, n int) (lisp.Object, error) {
+		xs := listSlice(list)
+		if len(xs) < n {
+			return list, nil
+		}
+		tail, err := expandList(nthcdr(n, list))
+		return lisp.List(append(xs[:n:n], listSlice(tail)...)...), err
+	}
+
+	head := form.Cons().Car
+	if isLambda(head) {
+		// ((lambda ARGS . BODY) ARGS...)
+		lambda, err := expandTail(head, 2)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		args, err := expandList(form.Cons().Cdr)
+		return lisp.NewCons(lambda, args), err
+	}
+	if head.Type != lisp.TypeSymbol {
+		return form, nil
+	}
+
+	switch head.Symbol() {
+	case symQuote.Symbol():
+		return form, nil
+	case symFunction.Symbol():
+		if arg := nthcdr(1, form); arg.Type == lisp.TypeCons && isLambda(arg.Cons().Car) {
+			lambda, err := expandTail(arg.Cons().Car, 2)
+			return lisp.List(head, lambda), err
+		}
+		return form, nil
+	case SymLambda.Symbol():
+		return expandTail(form, 2)
+	case symCond.Symbol():
+		var clauses []lisp.Object
+		for _, clause := range listSlice(form.Cons().Cdr) {
+			if clause.Type != lisp.TypeCons {
+				// Left for the evaluator to report.
+				clauses = append(clauses, clause)
+				continue
+			}
+			clause, err := expandList(clause)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			clauses = append(clauses, clause)
+		}
+		return lisp.NewCons(head, lisp.List(clauses...)), nil
+	case symConditionCase.Symbol():
+		xs := listSlice(form)
+		if len(xs) < 3 {
+			return form, nil
+		}
+		body, err := env.MacroExpandAll(xs[2], environment)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		xs = append([]lisp.Object(nil), xs...)
+		xs[2] = body
+		for i, handler := range xs[3:] {
+			if xs[3+i], err = expandTail(handler, 1); err != nil {
+				return lisp.Nil, err
+			}
+		}
+		return lisp.List(xs...), nil
+	case symLet.Symbol(), symLetStar.Symbol():
+		xs := listSlice(form)
+		if len(xs) < 2 {
+			return form, nil
+		}
+		var bindings []lisp.Object
+		for _, b := range listSlice(xs[1]) {
+			if b.Type == lisp.TypeCons {
+				var err error
+				if b, err = expandTail(b, 1); err != nil {
+					return lisp.Nil, err
+				}
+			}
+			bindings = append(bindings, b)
+		}
+		body, err := expandList(nthcdr(2, form))
+		return lisp.NewCons(head, lisp.NewCons(lisp.List(bindings...), body)), err
+	default:
+		if _, ok := specialForms[head.Symbol()]; ok {
+			return expandTail(form, 1)
+		}
+	}
+
+	expanded, err := env.compilerMacroExpand(form)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if !lisp.Eq(&expanded, &form) {
+		return env.MacroExpandAll(expanded, environment)
+	}
+	return expandTail(form, 1)
+}
+
+// compilerMacroExpand applies compiler macro of
+// function call form. Returns form itself if
+// there is no compiler macro or it declined.
+func (env *Env) compilerMacroExpand(form lisp.Object) (lisp.Object, error) {
+	head := form.Cons().Car
+	if head.Type != lisp.TypeSymbol {
+		return form, nil
+	}
+	handler := env.get(head, SymCompilerMacro)
+	if lisp.Null(&handler) {
+		return form, nil
+	}
+	return env.Funcall(handler, append([]lisp.Object{form}, listSlice(form.Cons().Cdr)...)...)
+}
+
+// get implements `get`: it returns sym property prop.
+func (env *MasterEnv) get(sym, prop lisp.Object) lisp.Object {
+	env.symbolsMu.RLock()
+	defer env.symbolsMu.RUnlock()
+	plist := env.plists[sym.Symbol()]
+	for ; plist.Type == lisp.TypeCons; plist = nthcdr(2, plist) {
+		val := nthcdr(1, plist)
+		if val.Type != lisp.TypeCons {
+			break
+		}
+		if plist.Cons().Car.Ptr == prop.Ptr {
+			return val.Cons().Car
+		}
+	}
+	return lisp.Nil
+}
+
+// put implements `put`: it sets sym property prop to val.
+func (env *MasterEnv) put(sym, prop, val lisp.Object) {
+	env.symbolsMu.Lock()
+	defer env.symbolsMu.Unlock()
+	if env.plists == nil {
+		env.plists = make(map[*lisp.Symbol]lisp.Object)
+	}
+	plist, ok := env.plists[sym.Symbol()]
+	if !ok {
+		plist = lisp.Nil
+	}
+	for tail := plist; tail.Type == lisp.TypeCons; tail = nthcdr(2, tail) {
+		if tail.Cons().Car.Ptr == prop.Ptr && tail.Cons().Cdr.Type == lisp.TypeCons {
+			tail.Cons().Cdr.Cons().Car = val
+			return
+		}
+	}
+	env.plists[sym.Symbol()] = lisp.NewCons(prop, lisp.NewCons(val, plist))
+}
+
+// assq returns the first alist element whose car is key or nil.
+func assq(key, alist lisp.Object) *lisp.Cons {
+	for ; alist.Type == lisp.TypeCons; alist = alist.Cons().Cdr {
+		b := alist.Cons().Car
+		if b.Type == lisp.TypeCons && lisp.Eq(&b.Cons().Car, &key) {
+			return b.Cons()
+		}
+	}
+	return nil
+}
+
+// nthcdr returns list without its first n elements.
+// Returns nil if list is shorter.
+func nthcdr(n int, list lisp.Object) lisp.Object {
+	for ; n > 0 && list.Type == lisp.TypeCons; n-- {
+		list = list.Cons().Cdr
+	}
+	if n > 0 {
+		return lisp.Nil
+	}
+	return list
+}
+
+// defineMacros defines macros that are implemented in Go.
+func (env *MasterEnv) defineMacros(ob *lisp.Obarray) {
+	sym := ob.Intern
+	quote := func(x lisp.Object) lisp.Object {
+		return lisp.List(symQuote, x)
+	}
+	function := func(x lisp.Object) lisp.Object {
+		return lisp.List(symFunction, x)
+	}
+
+	env.DefineMacro(sym("defmacro"), func(name, params lisp.Object, body ...lisp.Object) lisp.Object {
+		body, _ = splitDeclarations(body)
+		lambda := lisp.NewCons(SymLambda, lisp.NewCons(params, lisp.List(body...)))
+		return lisp.List(sym("defalias"), quote(name),
+			lisp.List(sym("cons"), quote(SymMacro), function(lambda)))
+	})
+
+	env.DefineMacro(sym("defun"), func(name, params lisp.Object, body ...lisp.Object) lisp.Object {
+		body, decls := splitDeclarations(body)
+		lambda := lisp.NewCons(SymLambda, lisp.NewCons(params, lisp.List(body...)))
+		def := lisp.List(sym("defalias"), quote(name), function(lambda))
+		forms := []lisp.Object{symProg1, def}
+		for _, decl := range decls {
+			xs := listSlice(decl)
+			if len(xs) == 2 && xs[0].Ptr == SymCompilerMacro.Ptr {
+				forms = append(forms, lisp.List(sym("function-put"),
+					quote(name), quote(SymCompilerMacro), function(xs[1])))
+			}
+		}
+		if len(forms) == 2 {
+			return def
+		}
+		return lisp.List(forms...)
+	})
+
+	bq := &backquote{
+		backquote: sym("`"),
+		comma:     sym(","),
+		commaAt:   sym(",@"),
+		quote:     symQuote,
+		list:      sym("list"),
+		append:    sym("append"),
+		cons:      sym("cons"),
+		apply:     sym("apply"),
+		vector:    sym("vector"),
+	}
+	env.DefineMacro(bq.backquote, func(form lisp.Object) (lisp.Object, error) {
+		expansion, err := bq.expand(form, 0)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		return bq.code(expansion), nil
+	})
+}
+
+// splitDeclarations removes (declare ...) forms from the
+// function body, after the docstring, and returns them
+// along with the rest of the body.
+func splitDeclarations(body []lisp.Object) (rest, decls []lisp.Object) {
+	i := 0
+	if len(body) > 1 && body[0].Type == lisp.TypeString {
+		i = 1
+	}
+	for ; i < len(body); i++ {
+		if formName(body[i]) != "declare" {
+			break
+		}
+		decls = append(decls, listSlice(body[i].Cons().Cdr)...)
+		body = append(body[:i:i], body[i+1:]...)
+		i--
+	}
+	return body, decls
+}
+
+// backquote implements "`" macro.
+//
+// Backquoted forms are translated into list, cons, append
+// and vector construction code; constant parts are quoted.
+// Nested backquotes are supported: unquotes are evaluated
+// only at the level of the innermost backquote.
+type backquote struct {
+	backquote, comma, commaAt lisp.Object
+	quote, list, append, cons lisp.Object
+	apply, vector             lisp.Object
+}
+
+// bqForm is an expansion of backquoted subform:
+// either constant value or code that computes it.
+type bqForm struct {
+	isConst bool
+	val     lisp.Object
+	form    lisp.Object
+}
+
+// bqPart is a list element expansion;
+// spliced parts are ,@ unquotes.
+type bqPart struct {
+	bqForm
+	splice bool
+}
+
+// code returns code that computes f value.
+func (bq *backquote) code(f bqForm) lisp.Object {
+	if !f.isConst {
+		return f.form
+	}
+	if _, ok := constantValue(f.val); ok && f.val.Type != lisp.TypeCons {
+		return f.val
+	}
+	return lisp.List(bq.quote, f.val)
+}
+
+// unquote returns operator and argument if x is
+// (, ARG), (,@ ARG) or (` ARG).
+func (bq *backquote) unquote(x lisp.Object) (op, arg lisp.Object, ok bool) {
+	if x.Type != lisp.TypeCons {
+		return lisp.Nil, lisp.Nil, false
+	}
+	op = x.Cons().Car
+	if op.Ptr != bq.comma.Ptr && op.Ptr != bq.commaAt.Ptr && op.Ptr != bq.backquote.Ptr {
+		return lisp.Nil, lisp.Nil, false
+	}
+	rest := x.Cons().Cdr
+	if rest.Type != lisp.TypeCons || !lisp.Null(&rest.Cons().Cdr) {
+		return lisp.Nil, lisp.Nil, false
+	}
+	return op, rest.Cons().Car, true
+}
+
+// expand expands backquoted form x.
+// level is the number of enclosing backquotes
+// that are nested into the expanded one.
+func (bq *backquote) expand(x lisp.Object, level int) (bqForm, error) {
+	switch x.Type {
+	case lisp.TypeVector:
+		elems, err := bq.expand(lisp.List(x.Vector().Vals...), level)
+		if err != nil || elems.isConst {
+			return bqForm{isConst: true, val: x}, err
+		}
+		return bqForm{form: lisp.List(bq.apply, lisp.List(bq.quote, bq.vector), elems.form)}, nil
+	case lisp.TypeCons:
+	default:
+		return bqForm{isConst: true, val: x}, nil
+	}
+
+	var parts []bqPart
+	tail := x
+	if op, arg, ok := bq.unquote(x); ok {
+		switch {
+		case op.Ptr == bq.backquote.Ptr:
+			level++
+		case level == 0 && op.Ptr == bq.comma.Ptr:
+			return bqForm{form: arg}, nil
+		case level == 0:
+			return bqForm{}, signal(SymError, lisp.NewString([]byte(",@ after `")), arg)
+		default:
+			level--
+		}
+		// Nested operator is kept, its argument is
+		// expanded like a list element.
+		parts = append(parts, bqPart{bqForm: bqForm{isConst: true, val: op}})
+		tail = x.Cons().Cdr
+	}
+
+	for ; tail.Type == lisp.TypeCons; tail = tail.Cons().Cdr {
+		if _, _, ok := bq.unquote(tail); ok {
+			// Dotted unquote: (a . ,b) is (a \, b).
+			break
+		}
+		elem := tail.Cons().Car
+		if op, arg, ok := bq.unquote(elem); ok && level == 0 && op.Ptr == bq.commaAt.Ptr {
+			parts = append(parts, bqPart{bqForm: bqForm{form: arg}, splice: true})
+			continue
+		}
+		f, err := bq.expand(elem, level)
+		if err != nil {
+			return bqForm{}, err
+		}
+		parts = append(parts, bqPart{bqForm: f})
+	}
+	return bq.build(parts, tail, level)
+}
+
+// build returns expansion of list that has parts
+// as elements, followed by backquoted tail.
+func (bq *backquote) build(parts []bqPart, tail lisp.Object, level int) (bqForm, error) {
+	end, err := bq.expand(tail, level)
+	if err != nil {
+		return bqForm{}, err
+	}
+
+	isConst := end.isConst
+	for _, p := range parts {
+		isConst = isConst && p.isConst && !p.splice
+	}
+	if isConst {
+		list := end.val
+		for i := len(parts) - 1; i >= 0; i-- {
+			list = lisp.NewCons(parts[i].val, list)
+		}
+		return bqForm{isConst: true, val: list}, nil
+	}
+
+	// Consecutive elements are grouped into list calls
+	// (or quoted lists), spliced parts are appended.
+	var args []lisp.Object
+	var items []bqPart
+	flush := func(tail lisp.Object) {
+		if len(items) == 0 {
+			return
+		}
+		constItems := end.isConst && lisp.Null(&tail)
+		var vals, codes []lisp.Object
+		for _, item := range items {
+			constItems = constItems && item.isConst
+			vals = append(vals, item.val)
+			codes = append(codes, bq.code(item.bqForm))
+		}
+		switch {
+		case constItems:
+			args = append(args, lisp.List(bq.quote, lisp.List(vals...)))
+		case lisp.Null(&tail):
+			args = append(args, lisp.NewCons(bq.list, lisp.List(codes...)))
+		default:
+			list := tail
+			for i := len(codes) - 1; i >= 0; i-- {
+				list = lisp.List(bq.cons, codes[i], list)
+			}
+			args = append(args, list)
+		}
+		items = nil
+	}
+	for i, p := range parts {
+		if p.splice && i == len(parts)-1 && end.isConst && lisp.Null(&end.val) {
+			// The last spliced list is shared, like append does.
+			if len(items) != 0 {
+				flush(p.form)
+			} else {
+				args = append(args, p.form)
+			}
+			continue
+		}
+		if p.splice {
+			flush(lisp.Nil)
+			args = append(args, p.form)
+			continue
+		}
+		items = append(items, p)
+	}
+	if end.isConst && lisp.Null(&end.val) {
+		flush(lisp.Nil)
+	} else if len(items) != 0 {
+		flush(bq.code(end))
+	} else {
+		args = append(args, bq.code(end))
+	}
+
+	if len(args) == 1 {
+		return bqForm{form: args[0]}, nil
+	}
+	return bqForm{form: lisp.NewCons(bq.append, lisp.List(args...))}, nil
+}
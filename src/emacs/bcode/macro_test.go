@@ -0,0 +1,170 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+func TestMacroExpand(t *testing.T) {
+	env, ob := newLispEnv()
+	for _, test := range []struct {
+		form string
+		want string
+	}{
+		{"(defmacro my-inc (x) (list '+ x 1))", "my-inc"},
+		{"(my-inc 2)", "3"},
+		{"(macroexpand-1 '(my-inc 2))", "(+ 2 1)"},
+		{"(defmacro my-inc2 (x) (list 'my-inc (list 'my-inc x)))", "my-inc2"},
+		{"(macroexpand-1 '(my-inc2 a))", "(my-inc (my-inc a))"},
+		{"(macroexpand '(my-inc2 a))", "(+ (my-inc a) 1)"},
+		{"(macroexpand-all '(my-inc2 a))", "(+ (+ a 1) 1)"},
+		{"(macroexpand-all '(if (my-inc a) '(my-inc b) (cond ((my-inc c) (my-inc d)))))",
+			"(if (+ a 1) '(my-inc b) (cond ((+ c 1) (+ d 1))))"},
+		{"(macroexpand-all '(let ((x (my-inc 1)) y) (my-inc x)))", "(let ((x (+ 1 1)) y) (+ x 1))"},
+		{"(macroexpand-all '(function (lambda (x) (my-inc x))))", "#'(lambda (x) (+ x 1))"},
+		{"(macroexpand-all '(condition-case e (my-inc a) (error (my-inc e))))",
+			"(condition-case e (+ a 1) (error (+ e 1)))"},
+		{"(macroexpand '(my-inc a) '((my-inc . nil)))", "(my-inc a)"},
+		{"(macroexpand '(my-inc a) (list (cons 'my-inc (lambda (x) (list '- x)))))", "(- a)"},
+		{"(macroexpand-all '(list (my-inc2 a)) (list (cons 'my-inc (lambda (x) x))))", "(list a)"},
+		{"(macroexpand '(car x))", "(car x)"},
+		{"(defalias 'my-inc-alias 'my-inc)", "my-inc-alias"},
+		{"(my-inc-alias 5)", "6"},
+		{"(defun sq (x) \"Square X.\" (* x x))", "sq"},
+		{"(sq 3)", "9"},
+		{"(macroexpand '(defun f (x) x))", "(defalias 'f #'(lambda (x) x))"},
+		{"(defmacro with-x (val &rest body) (cons 'let (cons (list (list 'x val)) body)))", "with-x"},
+		{"(with-x 2 (setq x (* x 10)) (+ x 1))", "21"},
+		{"(funcall 'my-inc 1)", "Invalid function: my-inc"},
+	} {
+		val, err := env.Eval(mustRead(t, test.form, ob), lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
+
+func TestBackquote(t *testing.T) {
+	env, ob := newLispEnv()
+	for _, form := range []string{
+		"(defvar x 1)",
+		"(defvar xs '(2 3))",
+		"(defvar name 'n)",
+	} {
+		if _, err := env.Eval(mustRead(t, form, ob), lisp.T); err != nil {
+			t.Fatalf("%s: %v", form, err)
+		}
+	}
+
+	for _, test := range []struct {
+		form string
+		want string
+	}{
+		{"`a", "a"},
+		{"`5", "5"},
+		{"`(a b)", "(a b)"},
+		{"`(a ,x)", "(a 1)"},
+		{"`(a ,@xs)", "(a 2 3)"},
+		{"`(,@xs a)", "(2 3 a)"},
+		{"`(,@xs ,@xs)", "(2 3 2 3)"},
+		{"`(a ,@nil b)", "(a b)"},
+		{"`(a . ,x)", "(a . 1)"},
+		{"`(a . b)", "(a . b)"},
+		{"`((a ,x) (b ,@xs))", "((a 1) (b 2 3))"},
+		{"`[a ,x ,@xs]", "[a 1 2 3]"},
+		{"`[a b]", "[a b]"},
+		{"`(a `(b ,(c ,x)))", "(a `(b ,(c 1)))"},
+		{"`(a `(b ,,name))", "(a `(b ,n))"},
+		{"`(a `(b ,@,@xs))", "(a `(b (\\,@ 2 3)))"},
+		{"(macroexpand '`(a ,x ,@xs))", "(cons 'a (cons x xs))"},
+		{"(macroexpand '`(a ,@xs b))", "(append '(a) xs '(b))"},
+		{"(macroexpand '`(,x))", "(list x)"},
+		{"`,x", "1"},
+		{"`,@xs", ",@ after `: xs"},
+	} {
+		val, err := env.Eval(mustRead(t, test.form, ob), lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
+
+func TestCompilerMacro(t *testing.T) {
+	env, ob := newLispEnv()
+	for _, test := range []struct {
+		form string
+		want string
+	}{
+		{"(defun twice (x) (declare (compiler-macro twice--inline)) (+ x x))", "twice"},
+		{"(defun twice--inline (form x) (if (eq x 4) 8 form))", "twice--inline"},
+		{"(function-get 'twice 'compiler-macro)", "twice--inline"},
+		{"(macroexpand-all '(twice 4))", "8"},
+		{"(macroexpand-all '(twice y))", "(twice y)"},
+		{"(macroexpand-all '(list (twice 4) '(twice 4)))", "(list 8 '(twice 4))"},
+		{"(twice 5)", "10"},
+		{"(put 'sym 'prop 1)", "1"},
+		{"(put 'sym 'prop 2)", "2"},
+		{"(get 'sym 'prop)", "2"},
+		{"(get 'sym 'other)", "nil"},
+		{"(get 1 'prop)", "Wrong type argument: symbolp, 1"},
+	} {
+		val, err := env.Eval(mustRead(t, test.form, ob), lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
+
+func TestCompileMacros(t *testing.T) {
+	env, ob := newLispEnv()
+	for _, form := range []string{
+		"(defmacro my-when (cond &rest body) `(if ,cond (progn ,@body)))",
+		"(defmacro swap (a b) `(setq ,a (prog1 ,b (setq ,b ,a))))",
+		"(defun dec (x) (- x 1))",
+		"(put 'dec 'compiler-macro (lambda (form x) `(list 'inlined ,x)))",
+	} {
+		if _, err := env.Eval(mustRead(t, form, ob), lisp.T); err != nil {
+			t.Fatalf("%s: %v", form, err)
+		}
+	}
+
+	tests := []struct {
+		src  string
+		args string
+		want string
+	}{
+		{"(lambda (x) (my-when (> x 0) 'pos))", "(1)", "pos"},
+		{"(lambda (x) (my-when (> x 0) 'pos))", "(-1)", "nil"},
+		{"(lambda (x y) (swap x y) (list x y))", "(1 2)", "(2 1)"},
+		{"(lambda (x) `(,x ,@(list x x)))", "(1)", "(1 1 1)"},
+		{"(lambda (x) (dec x))", "(3)", "(inlined 3)"},
+	}
+	for _, test := range tests {
+		fn, err := compileString(env, ob, test.src)
+		if err != nil {
+			t.Errorf("compile %s: %v", test.src, err)
+			continue
+		}
+		args := listSlice(mustRead(t, test.args, ob))
+		val, err := env.Funcall(fn.Object(), args...)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("%s %s:\nhave: %s\nwant: %s", test.src, test.args, have, test.want)
+		}
+	}
+}
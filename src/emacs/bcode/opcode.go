@@ -187,7 +187,7 @@ const (
 	OpInsertB               byte = 0261
 	OpStackSetB             byte = 0262
 	OpStackSetW             byte = 0263
-	OpDiscardB              byte = 0266
+	OpDiscardB              byte = 0266 // Operand may have discardPreserveTOS bit set
 
 	OpConstant0  byte = 0300
 	OpConstant1       = OpConstant0 + 1
@@ -268,21 +268,18 @@ const (
 	OpExtGoCallB      = OpExtGoCall0 + 6
 	OpExtGoCallW      = OpExtGoCall0 + 7
 
+	// OpExtPushUnwind pushes a handler that catches every error.
+	// The handler is entered with the pending error pushed.
+	OpExtPushUnwind byte = 9
+	// OpExtRethrow signals the pending error again if it is
+	// on the stack top; other values are left as is.
+	OpExtRethrow byte = 10
+
 	// OpExtShagit is school-pensioner; Pudge from katka (gaem).
 	OpExtShagit byte = 0xFF
 )
 
-// extOpWidth specifies PC advancement for enumerated opcodes.
-var extOpWidth = [256]uint32{
-	// OpExtStop does not modify PC as it stops evaluation when executed.
-	OpExtStop: 0,
-
-	OpExtGoCall0: 2,
-	OpExtGoCall1: 2,
-	OpExtGoCall2: 2,
-	OpExtGoCall3: 2,
-	OpExtGoCall4: 2,
-	OpExtGoCall5: 2,
-	OpExtGoCallB: 2,
-	OpExtGoCallW: 2,
-}
+// discardPreserveTOS is OpDiscardB operand flag:
+// when it is set, the top of the stack is kept and
+// values below it are discarded (discardN-preserve-tos).
+const discardPreserveTOS = 0x80
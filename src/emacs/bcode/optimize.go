@@ -0,0 +1,608 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"strings"
+)
+
+// Peephole optimizer.
+//
+// Like Emacs byte-opt, Optimize works on a symbolic form
+// of the code (LAP), where jumps refer to labels instead of pcs.
+// Passes are repeated until none of them changes the code:
+//
+//	- dup/discard pairs and other pushes of values that are
+//	  discarded right away are removed, so are discards before return;
+//	- comparisons of constants, negations of constants and
+//	  conditional jumps on constants are folded;
+//	- jumps to jumps are collapsed, jumps to return become return;
+//	- unreachable code is dropped.
+//
+// Handler pushes refer to their handlers by labels too,
+// so the handlers are relocated like jump targets.
+// Jumps are encoded as relative rgoto jumps when their
+// targets are close enough.
+// Code is verified after every pass that changed it,
+// so optimizer bugs are reported instead of being executed.
+
+// lapInstr is an instruction of the optimizer code representation.
+type lapInstr struct {
+	// name is an instruction mnemonic, like in Disassemble output;
+	// it is empty for labels. Relative jumps use the names
+	// of absolute jumps.
+	name string
+
+	// arg is an instruction operand.
+	// For jumps, handler pushes and labels it is a label number.
+	arg int
+}
+
+// isLabel reports whether ins marks a jump target.
+func (ins *lapInstr) isLabel() bool {
+	return ins.name == ""
+}
+
+// isJump reports whether ins transfers control to a label.
+func (ins *lapInstr) isJump() bool {
+	return strings.HasPrefix(ins.name, "goto")
+}
+
+// hasLabel reports whether ins operand is a label.
+func (ins *lapInstr) hasLabel() bool {
+	return ins.isJump() || isHandlerPush(ins.name)
+}
+
+// relJumpOps maps jump mnemonics to their relative forms.
+var relJumpOps = map[string]byte{
+	"goto":                     OpRgotoB,
+	"goto-if-nil":              OpRgotoIfNilB,
+	"goto-if-non-nil":          OpRgotoIfNonNilB,
+	"goto-if-nil-else-pop":     OpRgotoIfNilElsePopB,
+	"goto-if-non-nil-else-pop": OpRgotoIfNonNilElsePopB,
+}
+
+// optimizer holds the state of a single function optimization.
+type optimizer struct {
+	fn *Func
+
+	lap    []lapInstr
+	consts []lisp.Object
+
+	// ownConsts is set when consts is a copy that
+	// can be appended to without affecting fn.
+	ownConsts bool
+
+	// labels is the number of allocated label numbers.
+	labels int
+}
+
+// optimizerPass is a single optimization.
+// It returns true if the code was changed.
+type optimizerPass struct {
+	name string
+	run  func(o *optimizer) bool
+}
+
+var optimizerPasses = []optimizerPass{
+	{"push-discard", (*optimizer).removeDiscards},
+	{"constant-folding", (*optimizer).foldConstants},
+	{"jump-threading", (*optimizer).threadJumps},
+	{"dead-code", (*optimizer).removeDeadCode},
+}
+
+// Optimize returns a verified copy of fn with peephole
+// optimizations applied. fn must pass Verify.
+func Optimize(fn *Func) (*Func, error) {
+	nargs := int(fn.nargs)
+	if _, err := Verify(fn, nargs); err != nil {
+		return nil, err
+	}
+	o := &optimizer{fn: fn, consts: fn.consts}
+	if !o.decode() {
+		return fn, nil
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range optimizerPasses {
+			if !pass.run(o) {
+				continue
+			}
+			changed = true
+			if _, err := o.encode(); err != nil {
+				return nil, o.passError(pass.name, err)
+			}
+		}
+	}
+	opt, err := o.encode()
+	if err != nil {
+		return nil, err
+	}
+	opt.translate()
+	return opt, nil
+}
+
+// passError describes the failure of the named pass.
+func (o *optimizer) passError(pass string, err error) error {
+	if e, ok := err.(*VerifyError); ok {
+		return &VerifyError{PC: e.PC, Msg: pass + ": " + e.Msg}
+	}
+	return err
+}
+
+// decode translates fn code into LAP.
+// Returns false if code can't be optimized.
+func (o *optimizer) decode() bool {
+	code := o.fn.code
+	labels := make(map[int]int)
+	var instrs []instr
+	for pc := 0; pc < len(code); {
+		ins, ok := decodeInstr(code, uint32(pc))
+		if !ok || ins.info == nil {
+			return false
+		}
+		if ins.info.kind == argJump || ins.info.kind == argRelJump {
+			if _, ok := labels[ins.arg]; !ok {
+				labels[ins.arg] = len(labels)
+			}
+		}
+		instrs = append(instrs, ins)
+		pc += int(ins.width)
+	}
+	o.labels = len(labels)
+
+	for _, ins := range instrs {
+		if label, ok := labels[int(ins.pc)]; ok {
+			o.lap = append(o.lap, lapInstr{arg: label})
+		}
+		name, arg := ins.info.name, ins.arg
+		switch {
+		case ins.info.kind == argJump || ins.info.kind == argRelJump:
+			name = strings.TrimPrefix(name, "r")
+			arg = labels[arg]
+		case name == "discardN" && arg&discardPreserveTOS != 0:
+			name = discardPreserveTOSInfo.name
+			arg &^= discardPreserveTOS
+		}
+		o.lap = append(o.lap, lapInstr{name: name, arg: arg})
+	}
+	return true
+}
+
+// encode translates LAP back into byte code and verifies it.
+func (o *optimizer) encode() (*Func, error) {
+	// Jumps start in the short relative form; the ones
+	// that can't reach their targets are widened until
+	// the layout stops changing.
+	long := make([]bool, len(o.lap))
+	pcs := make([]int, len(o.lap))
+	labelPCs := make([]int, o.labels)
+	for {
+		pc := 0
+		for i := range o.lap {
+			ins := &o.lap[i]
+			pcs[i] = pc
+			switch {
+			case ins.isLabel():
+				labelPCs[ins.arg] = pc
+			case ins.isJump() && long[i]:
+				pc += 3
+			case ins.isJump():
+				pc += 2
+			default:
+				pc += len(o.appendInstr(nil, ins))
+			}
+		}
+
+		widened := false
+		for i := range o.lap {
+			ins := &o.lap[i]
+			if !ins.isJump() || long[i] {
+				continue
+			}
+			if offset := labelPCs[ins.arg] - pcs[i] + 126; offset < 0 || offset > 0xFF {
+				long[i] = true
+				widened = true
+			}
+		}
+		if !widened {
+			break
+		}
+	}
+
+	var code []byte
+	for i := range o.lap {
+		ins := &o.lap[i]
+		switch {
+		case ins.isLabel():
+		case ins.isJump() && long[i]:
+			target := labelPCs[ins.arg]
+			if target > 0xFFFF {
+				return nil, &VerifyError{PC: pcs[i], Msg: "jump target is out of range"}
+			}
+			code = appendForm(code, asmOps[ins.name][0], operandW, target)
+		case isHandlerPush(ins.name):
+			target := labelPCs[ins.arg]
+			if target > 0xFFFF {
+				return nil, &VerifyError{PC: pcs[i], Msg: "handler is out of range"}
+			}
+			code = o.appendInstr(code, &lapInstr{name: ins.name, arg: target})
+		case ins.isJump():
+			offset := labelPCs[ins.arg] - pcs[i] + 126
+			code = append(code, relJumpOps[ins.name], byte(offset))
+		default:
+			code = o.appendInstr(code, ins)
+		}
+	}
+
+	nargs := int(o.fn.nargs)
+	fn := &Func{
+		code:    code,
+		consts:  o.consts,
+		nargs:   o.fn.nargs,
+		optArgs: o.fn.optArgs,
+		rest:    o.fn.rest,
+		dynamic: o.fn.dynamic,
+	}
+	maxDepth, err := Verify(fn, nargs)
+	if err != nil {
+		return nil, err
+	}
+	fn.maxStack = uint32(maxDepth - nargs)
+	return fn, nil
+}
+
+// appendInstr appends encoded non-jump instruction to code.
+func (o *optimizer) appendInstr(code []byte, ins *lapInstr) []byte {
+	n := ins.arg
+	if ins.name == discardPreserveTOSInfo.name {
+		n |= discardPreserveTOS
+	}
+	// Operands come from decoded code or are reduced
+	// by the passes, so they always fit.
+	code, _ = appendInstr(code, asmOps[ins.name], n)
+	return code
+}
+
+// constIndex returns x index inside the constant vector,
+// adding it to the vector if necessary.
+func (o *optimizer) constIndex(x lisp.Object) int {
+	for i := range o.consts {
+		if lisp.Eq(&o.consts[i], &x) {
+			return i
+		}
+	}
+	if !o.ownConsts {
+		o.consts = append([]lisp.Object(nil), o.consts...)
+		o.ownConsts = true
+	}
+	o.consts = append(o.consts, x)
+	return len(o.consts) - 1
+}
+
+// replace replaces n instructions at i with instrs.
+func (o *optimizer) replace(i, n int, instrs ...lapInstr) {
+	tail := append(instrs, o.lap[i+n:]...)
+	o.lap = append(o.lap[:i], tail...)
+}
+
+// at returns instruction at i or an empty label if i is
+// out of range; labels never match instruction patterns.
+func (o *optimizer) at(i int) *lapInstr {
+	if i < len(o.lap) {
+		return &o.lap[i]
+	}
+	return &lapInstr{arg: -1}
+}
+
+// isPurePush reports whether ins pushes a value
+// without any side effects.
+func isPurePush(ins *lapInstr) bool {
+	switch ins.name {
+	case "dup", "constant", "stack-ref":
+		return true
+	}
+	return false
+}
+
+// discardCount returns the number of values that ins
+// discards or 0 if it is not a plain discard.
+func discardCount(ins *lapInstr) int {
+	switch ins.name {
+	case "discard":
+		return 1
+	case "discardN":
+		return ins.arg
+	}
+	return 0
+}
+
+// discardInstrs returns instructions that discard n values.
+func discardInstrs(n int) []lapInstr {
+	switch n {
+	case 0:
+		return nil
+	case 1:
+		return []lapInstr{{name: "discard"}}
+	}
+	return []lapInstr{{name: "discardN", arg: n}}
+}
+
+// removeDiscards removes values that are discarded right
+// after they are pushed and merges adjacent discards.
+func (o *optimizer) removeDiscards() bool {
+	changed := false
+	for i := 0; i < len(o.lap); i++ {
+		a, b, c := o.at(i), o.at(i+1), o.at(i+2)
+		switch {
+		case isPurePush(a) && discardCount(b) != 0:
+			// dup discard => nothing.
+			o.replace(i, 2, discardInstrs(discardCount(b)-1)...)
+		case discardCount(a) != 0 && discardCount(b) != 0 &&
+			discardCount(a)+discardCount(b) < discardPreserveTOS:
+			// discard discard => discardN 2.
+			o.replace(i, 2, discardInstrs(discardCount(a)+discardCount(b))...)
+		case a.name == discardPreserveTOSInfo.name && b.name == "return",
+			a.name == "dup" && b.name == "return":
+			// Return does not care about the values below TOS.
+			o.replace(i, 2, *b)
+		case a.name == "dup" && b.name == "varset" && c.name == "discard":
+			// dup varset discard => varset.
+			o.replace(i, 3, *b)
+		case a.name == "dup" && b.name == "stack-set" && c.name == "discard":
+			// Storing the copy and dropping the original is
+			// the same as storing the original one slot deeper.
+			if b.arg == 1 {
+				o.replace(i, 3, lapInstr{name: "discard"})
+			} else {
+				o.replace(i, 3, lapInstr{name: "stack-set", arg: b.arg - 1})
+			}
+		default:
+			continue
+		}
+		changed = true
+		// Replacement may form a new pattern with
+		// the previous instruction.
+		i -= 2
+		if i < -1 {
+			i = -1
+		}
+	}
+	return changed
+}
+
+// foldable reports whether constant x is known at compile time.
+// Symbols other than nil, t and keywords may be placeholders
+// of closure variables that make-closure replaces.
+func foldable(x lisp.Object) bool {
+	if x.Type != lisp.TypeSymbol {
+		return true
+	}
+	_, ok := constantValue(x)
+	return ok
+}
+
+// foldConstants evaluates instructions whose operands are
+// constants and removes conditional jumps on constants.
+func (o *optimizer) foldConstants() bool {
+	changed := false
+	for i := 0; i < len(o.lap); i++ {
+		a, b, c := o.at(i), o.at(i+1), o.at(i+2)
+		switch {
+		case a.name == "constant" && !foldable(o.consts[a.arg]):
+			continue
+		case a.name == "constant" && b.name == "constant" && !c.isLabel():
+			x, y := o.consts[a.arg], o.consts[b.arg]
+			if !foldable(y) {
+				continue
+			}
+			val, ok := foldCompare(c.name, x, y)
+			if !ok {
+				continue
+			}
+			o.replace(i, 3, lapInstr{name: "constant", arg: o.constIndex(val)})
+		case a.name == "constant" && b.name == "not":
+			val := lisp.Bool(lisp.Null(&o.consts[a.arg]))
+			o.replace(i, 2, lapInstr{name: "constant", arg: o.constIndex(val)})
+		case a.name == "constant" && b.isJump() && b.name != "goto":
+			isNil := lisp.Null(&o.consts[a.arg])
+			jump := lapInstr{name: "goto", arg: b.arg}
+			switch b.name {
+			case "goto-if-nil":
+				o.replace(i, 2, jumpIf(isNil, jump)...)
+			case "goto-if-non-nil":
+				o.replace(i, 2, jumpIf(!isNil, jump)...)
+			case "goto-if-nil-else-pop":
+				o.replace(i, 2, jumpIf(isNil, *a, jump)...)
+			case "goto-if-non-nil-else-pop":
+				o.replace(i, 2, jumpIf(!isNil, *a, jump)...)
+			}
+		case a.name == "not" && b.name == "goto-if-nil":
+			o.replace(i, 2, lapInstr{name: "goto-if-non-nil", arg: b.arg})
+		case a.name == "not" && b.name == "goto-if-non-nil":
+			o.replace(i, 2, lapInstr{name: "goto-if-nil", arg: b.arg})
+		default:
+			continue
+		}
+		changed = true
+		i -= 2
+		if i < -1 {
+			i = -1
+		}
+	}
+	return changed
+}
+
+// jumpIf returns instrs if cond is true and nothing otherwise.
+func jumpIf(cond bool, instrs ...lapInstr) []lapInstr {
+	if cond {
+		return instrs
+	}
+	return nil
+}
+
+// foldCompare computes comparison instruction name on
+// constants x and y. Returns false if instruction is not
+// a comparison or it would signal an error.
+func foldCompare(name string, x, y lisp.Object) (lisp.Object, bool) {
+	if name == "eq" {
+		return lisp.Bool(lisp.Eq(&x, &y)), true
+	}
+	var op byte
+	switch name {
+	case "eqlsign":
+		op = OpEqlsign
+	case "gtr":
+		op = OpGtr
+	case "lss":
+		op = OpLss
+	case "leq":
+		op = OpLeq
+	case "geq":
+		op = OpGeq
+	default:
+		return lisp.Nil, false
+	}
+	ok, err := compare(x, []lisp.Object{y}, compareOps[op])
+	if err != nil {
+		return lisp.Nil, false
+	}
+	return lisp.Bool(ok), true
+}
+
+// invertedJumps maps conditional jumps that pop their
+// operand to the jumps with the opposite condition.
+var invertedJumps = map[string]string{
+	"goto-if-nil":     "goto-if-non-nil",
+	"goto-if-non-nil": "goto-if-nil",
+}
+
+// labelIndexes maps label numbers to their LAP indexes.
+func (o *optimizer) labelIndexes() []int {
+	index := make([]int, o.labels)
+	for i := range o.lap {
+		if o.lap[i].isLabel() {
+			index[o.lap[i].arg] = i
+		}
+	}
+	return index
+}
+
+// threadJumps retargets jumps to unconditional jumps,
+// replaces jumps to return with return and removes
+// jumps to the next instruction.
+func (o *optimizer) threadJumps() bool {
+	changed := false
+	index := o.labelIndexes()
+	// target returns the first instruction after label.
+	target := func(label int) (int, *lapInstr) {
+		i := index[label]
+		for o.at(i).isLabel() && i < len(o.lap) {
+			i++
+		}
+		return i, o.at(i)
+	}
+	for i := 0; i < len(o.lap); i++ {
+		ins := &o.lap[i]
+		if !ins.isJump() {
+			continue
+		}
+		j, next := target(ins.arg)
+		after := o.at(i + 1)
+		toNext := j > i && o.onlyLabels(i+1, j)
+		switch {
+		case toNext && ins.name == "goto":
+			o.replace(i, 1)
+		case toNext && invertedJumps[ins.name] != "":
+			// Both paths continue at the same place.
+			o.replace(i, 1, lapInstr{name: "discard"})
+		case invertedJumps[ins.name] != "" && after.name == "goto" && j > i+1 && o.onlyLabels(i+2, j):
+			// goto-if-nil L1; goto L2; L1: => goto-if-non-nil L2; L1:
+			o.replace(i, 2, lapInstr{name: invertedJumps[ins.name], arg: after.arg})
+		case next.name == "goto" && o.chainEnd(target, ins.arg) != ins.arg:
+			ins.arg = o.chainEnd(target, ins.arg)
+		case ins.name == "goto" && next.name == "return":
+			*ins = lapInstr{name: "return"}
+		default:
+			continue
+		}
+		changed = true
+		index = o.labelIndexes()
+		i--
+	}
+	return changed
+}
+
+// chainEnd returns the label that ends the chain of
+// unconditional jumps that starts at label.
+// Chains that form a loop are left as they are.
+func (o *optimizer) chainEnd(target func(label int) (int, *lapInstr), label int) int {
+	seen := map[int]bool{label: true}
+	end := label
+	for {
+		_, next := target(end)
+		if next.name != "goto" {
+			return end
+		}
+		if seen[next.arg] {
+			return label
+		}
+		seen[next.arg] = true
+		end = next.arg
+	}
+}
+
+// onlyLabels reports whether instructions in [i,j) are labels.
+func (o *optimizer) onlyLabels(i, j int) bool {
+	for ; i < j; i++ {
+		if !o.lap[i].isLabel() {
+			return false
+		}
+	}
+	return true
+}
+
+// removeDeadCode removes instructions that are not reachable
+// from the function entry and labels that are not referenced.
+func (o *optimizer) removeDeadCode() bool {
+	index := o.labelIndexes()
+	reachable := make([]bool, len(o.lap))
+	queue := []int{0}
+	if n := len(o.lap); n != 0 && o.lap[n-1].name == "stop" {
+		// Trailing stop is the return address of the
+		// top-level code, see eval.
+		queue = append(queue, n-1)
+	}
+	for len(queue) != 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if i >= len(o.lap) || reachable[i] {
+			continue
+		}
+		reachable[i] = true
+		ins := &o.lap[i]
+		if ins.hasLabel() {
+			queue = append(queue, index[ins.arg])
+		}
+		switch ins.name {
+		case "goto", "return", "stop":
+			continue
+		}
+		queue = append(queue, i+1)
+	}
+
+	referenced := make([]bool, o.labels)
+	for i := range o.lap {
+		if reachable[i] && o.lap[i].hasLabel() {
+			referenced[o.lap[i].arg] = true
+		}
+	}
+	lap := o.lap[:0]
+	for i, ins := range o.lap {
+		if ins.isLabel() && referenced[ins.arg] || !ins.isLabel() && reachable[i] {
+			lap = append(lap, ins)
+		}
+	}
+	changed := len(lap) != len(o.lap)
+	o.lap = lap
+	return changed
+}
@@ -0,0 +1,512 @@
+package bcode
+
+import (
+	"bytes"
+	"emacs/lisp"
+	"strings"
+	"testing"
+)
+
+// optimizeAsm assembles src into a function of nargs
+// arguments and returns disassembly of its optimized version.
+func optimizeAsm(t *testing.T, src string, nargs int) string {
+	ob := lisp.NewObarray()
+	fn, err := assemble([]byte(src), ob)
+	if err != nil {
+		t.Fatalf("assemble %s: %v", src, err)
+	}
+	fn.nargs = uint32(nargs)
+	opt, err := Optimize(fn)
+	if err != nil {
+		return err.Error()
+	}
+	var buf bytes.Buffer
+	if err := Disassemble(&buf, "", opt); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name  string
+		nargs int
+		src   string
+		want  []string
+	}{
+		{
+			"DupDiscard",
+			3,
+			`
+			dup
+			discard
+			constant a
+			discardN 2
+			stack-ref 1
+			return`,
+			[]string{
+				"0\tdiscard",
+				"1\tstack-ref 1",
+				"2\treturn",
+			},
+		},
+		{
+			"MergeDiscards",
+			3,
+			`
+			dup
+			discard
+			discard
+			discardN 1
+			return`,
+			[]string{
+				"0\tdiscardN  2",
+				"2\treturn",
+			},
+		},
+		{
+			"Varset",
+			1,
+			`
+			dup
+			varset x
+			discard
+			constant nil
+			return`,
+			[]string{
+				"0\tvarset\t  x",
+				"1\tconstant  nil",
+				"2\treturn",
+			},
+		},
+		{
+			"Return",
+			2,
+			`
+			dup
+			discardN-preserve-tos 2
+			dup
+			return`,
+			[]string{
+				"0\treturn",
+			},
+		},
+		{
+			"StackSet",
+			2,
+			`
+			stack-ref 0
+			dup
+			stack-set 2
+			discard
+			dup
+			stack-set 1
+			discard
+			return`,
+			[]string{
+				"0\tdiscard",
+				"1\treturn",
+			},
+		},
+		{
+			"FoldCompare",
+			0,
+			`
+			constant 1
+			constant 2
+			lss
+			constant :a
+			constant :a
+			eq
+			constant 1
+			constant 1.5
+			geq
+			list3
+			return`,
+			[]string{
+				"0\tconstant  t",
+				"1\tconstant  t",
+				"2\tconstant  nil",
+				"3\tlist3",
+				"4\treturn",
+			},
+		},
+		{
+			"FoldJumps",
+			0,
+			`
+			constant nil
+			goto-if-non-nil l1
+			constant t
+			not
+			goto-if-nil l2
+			constant 1
+			return
+			l1:
+			constant 2
+			return
+			l2:
+			constant 3
+			return`,
+			[]string{
+				"0\tconstant  3",
+				"1\treturn",
+			},
+		},
+		{
+			"FoldElsePop",
+			0,
+			`
+			constant :x
+			goto-if-non-nil-else-pop l1
+			constant 1
+			l1:
+			return`,
+			[]string{
+				"0\tconstant  :x",
+				"1\treturn",
+			},
+		},
+		{
+			// Symbols may be closure variables that
+			// make-closure replaces.
+			"ClosureVars",
+			0,
+			`
+			constant x
+			not
+			constant y
+			goto-if-nil l1
+			constant x
+			constant y
+			eq
+			return
+			l1:
+			return`,
+			[]string{
+				"0\tconstant  x",
+				"1\tnot",
+				"2\tconstant  y",
+				"3\trgoto-if-nil 1",
+				"5\tconstant  x",
+				"6\tconstant  y",
+				"7\teq",
+				"8\treturn",
+				"9:1\treturn",
+			},
+		},
+		{
+			"NotJump",
+			1,
+			`
+			dup
+			not
+			goto-if-nil l1
+			constant 1
+			return
+			l1:
+			constant 2
+			return`,
+			[]string{
+				"0\tdup",
+				"1\trgoto-if-non-nil 1",
+				"3\tconstant  1",
+				"4\treturn",
+				"5:1\tconstant  2",
+				"6\treturn",
+			},
+		},
+		{
+			// Handlers are relocated like jump targets.
+			"Catch",
+			1,
+			`
+			constant done
+			pushcatch l1
+			dup
+			discard
+			dup
+			car
+			pophandler
+			l1:
+			return`,
+			[]string{
+				"0\tconstant  done",
+				"1\tpushcatch 1",
+				"4\tdup",
+				"5\tcar",
+				"6\tpophandler",
+				"7:1\treturn",
+			},
+		},
+		{
+			// Unreachable handler code is removed,
+			// the handler itself is kept.
+			"ConditionCase",
+			1,
+			`
+			constant error
+			pushconditioncase l1
+			dup
+			car
+			pophandler
+			goto l2
+			constant 1
+			return
+			l1:
+			dup
+			discard
+			l2:
+			return`,
+			[]string{
+				"0\tconstant  error",
+				"1\tpushconditioncase 1",
+				"4\tdup",
+				"5\tcar",
+				"6\tpophandler",
+				"7\treturn",
+				"8:1\treturn",
+			},
+		},
+		{
+			"JumpChain",
+			1,
+			`
+			dup
+			goto-if-nil l1
+			constant 1
+			goto l2
+			l1:
+			goto l3
+			l2:
+			goto l4
+			l3:
+			constant 2
+			l4:
+			return`,
+			[]string{
+				"0\tdup",
+				"1\trgoto-if-nil 1",
+				"3\tconstant  1",
+				"4\treturn",
+				"5:1\tconstant  2",
+				"6\treturn",
+			},
+		},
+		{
+			"JumpToNext",
+			1,
+			`
+			dup
+			goto-if-nil l1
+			l1:
+			goto l2
+			l2:
+			return`,
+			[]string{
+				"0\treturn",
+			},
+		},
+		{
+			"Loop",
+			1,
+			`
+			l1:
+			dup
+			goto-if-nil l2
+			goto l1
+			l2:
+			return`,
+			[]string{
+				"0:1\tdup",
+				"1\trgoto-if-non-nil 1",
+				"3\treturn",
+			},
+		},
+		{
+			"InfiniteLoop",
+			0,
+			`
+			l1:
+			goto l2
+			l2:
+			goto l1`,
+			[]string{
+				"0:1\trgoto\t  1",
+			},
+		},
+		{
+			"TrailingStop",
+			0,
+			`
+			constant 1
+			discard
+			stop`,
+			[]string{
+				"0\tstop",
+			},
+		},
+	}
+	for _, test := range tests {
+		have := optimizeAsm(t, test.src, test.nargs)
+		want := strings.Join(append([]string{"byte code:"}, test.want...), "\n")
+		if have != want {
+			t.Errorf("%s:\nhave:\n%s\nwant:\n%s", test.name, have, want)
+		}
+	}
+}
+
+func TestOptimizeLongJumps(t *testing.T) {
+	var src bytes.Buffer
+	src.WriteString("stack-ref 0\ngoto-if-nil far\n")
+	for i := 0; i < 200; i++ {
+		src.WriteString("constant 1\ncall 0\ndiscard\n")
+	}
+	src.WriteString("far:\nreturn\n")
+	have := optimizeAsm(t, src.String(), 1)
+	if !strings.Contains(have, "\tgoto-if-nil 1\n") {
+		t.Errorf("have:\n%s\nwant goto-if-nil with 16bit operand", have)
+	}
+}
+
+func TestOptimizeCompiled(t *testing.T) {
+	env, ob := newLispEnv()
+	for _, form := range []string{
+		"(defvar dyn 10)",
+		"(defalias 'sq (lambda (x) (* x x)))",
+	} {
+		if _, err := env.Eval(mustRead(t, form, ob), lisp.T); err != nil {
+			t.Fatalf("%s: %v", form, err)
+		}
+	}
+
+	tests := []struct {
+		src  string
+		args string
+		want string
+	}{
+		{"(lambda (x) (if (not x) 'a 'b))", "(nil)", "a"},
+		{"(lambda (x) (if (not x) 'a 'b))", "(1)", "b"},
+		{"(lambda (x) (if (< 1 2) x 'never))", "(1)", "1"},
+		{"(lambda (x) (let ((y x)) (setq y (+ y 1)) (setq x y) (list x y)))", "(1)", "(2 2)"},
+		{"(lambda (n) (let ((s 0)) (while (> n 0) (setq s (+ s n) n (1- n))) s))", "(100)", "5050"},
+		{"(lambda (x) (and x (or (eq x 'a) (eq x 'b)) (cond ((eq x 'a) 1) (t 2))))", "(b)", "2"},
+		{"(lambda (x) (setq dyn x) (let ((dyn 1)) (sq dyn)))", "(5)", "1"},
+	}
+	for _, test := range tests {
+		fn, err := compileString(env, ob, test.src)
+		if err != nil {
+			t.Fatalf("compile %s: %v", test.src, err)
+		}
+		opt, err := Optimize(fn)
+		if err != nil {
+			t.Errorf("optimize %s: %v", test.src, err)
+			continue
+		}
+		if len(opt.code) > len(fn.code) {
+			t.Errorf("%s: optimized code is longer: %d > %d", test.src, len(opt.code), len(fn.code))
+		}
+		args := listSlice(mustRead(t, test.args, ob))
+		val, err := env.Exec(opt, args...)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("%s %s:\nhave: %s\nwant: %s", test.src, test.args, have, test.want)
+		}
+	}
+
+	// Compiler optimizes its output when asked to.
+	env.Optimize = true
+	if _, err := env.Eval(mustRead(t, "(defalias 'f (lambda () (if t 1 2)))", ob), lisp.T); err != nil {
+		t.Fatal(err)
+	}
+	val, err := env.Eval(mustRead(t, "(byte-compile 'f)", ob), lisp.T)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := len(objectFunc(&val).code); have != 2 {
+		t.Errorf("optimized (if t 1 2): have %d bytes, want 2", have)
+	}
+}
+
+// benchmarkLoop runs the compiled summation loop.
+func benchmarkLoop(b *testing.B, optimize bool) {
+	env, ob := newLispEnv()
+	src := "(lambda (n) (let ((s 0)) (while (> n 0) (setq s (+ s n) n (1- n))) s))"
+	fn, err := compileString(env, ob, src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkOptimize(b, env, fn, optimize)
+}
+
+// loopAsm is the summation loop in the form that a compiler
+// without an optimizer emits: values are stored by
+// dup/stack-set/discard, the loop condition is followed by
+// a test of a constant and the back jump goes through a chain.
+const loopAsm = `
+	constant 0
+loop:
+	stack-ref 1
+	constant 0
+	gtr
+	goto-if-nil done
+	stack-ref 0
+	stack-ref 2
+	plus
+	dup
+	stack-set 2
+	discard
+	stack-ref 1
+	sub1
+	dup
+	stack-set 3
+	discard
+	constant t
+	goto-if-nil done
+	goto next
+next:
+	goto loop
+done:
+	stack-ref 0
+	return`
+
+// benchmarkLoopAsm runs loopAsm.
+func benchmarkLoopAsm(b *testing.B, optimize bool) {
+	env, _ := newLispEnv()
+	fn, err := Assemble([]byte(loopAsm), lisp.NewObarray(), 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkOptimize(b, env, fn, optimize)
+}
+
+// benchmarkOptimize runs fn, or its optimized version,
+// with argument 1000.
+func benchmarkOptimize(b *testing.B, env *Env, fn *Func, optimize bool) {
+	if optimize {
+		var err error
+		if fn, err = Optimize(fn); err != nil {
+			b.Fatal(err)
+		}
+	}
+	arg := lisp.NewInt(1000)
+	if val, err := env.Exec(fn, arg); err != nil || val.Int() != 500500 {
+		b.Fatalf("have %s, %v; want 500500", lisp.Prin1String(val), err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := env.Exec(fn, arg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoop(b *testing.B)             { benchmarkLoop(b, false) }
+func BenchmarkLoopOptimized(b *testing.B)    { benchmarkLoop(b, true) }
+func BenchmarkLoopAsm(b *testing.B)          { benchmarkLoopAsm(b, false) }
+func BenchmarkLoopAsmOptimized(b *testing.B) { benchmarkLoopAsm(b, true) }
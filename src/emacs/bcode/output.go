@@ -0,0 +1,152 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Named buffers, temporary output buffers and printing.
+// Issue#4
+
+// defineOutputSubrs defines the temporary output buffer
+// and printing functions.
+// `with-output-to-temp-buffer` is a special form.
+//
+// Printing functions write to their PRINTCHARFUN argument
+// or to `standard-output`, like WriteOutput does; insert
+// writes to the current buffer.
+func (env *MasterEnv) defineOutputSubrs(ob *lisp.Obarray) {
+	for _, subr := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"temp-output-buffer-show", func(env *Env, buf lisp.Object) error {
+			return env.showTempBuffer(&buf)
+		}},
+		{"princ", func(env *Env, x lisp.Object, printcharfun *lisp.Object) (lisp.Object, error) {
+			out := env.outputStream(optional(printcharfun))
+			return x, env.writeOutput(out, lisp.PrincString(x))
+		}},
+		{"prin1", func(env *Env, x lisp.Object, printcharfun *lisp.Object) (lisp.Object, error) {
+			out := env.outputStream(optional(printcharfun))
+			return x, env.writeOutput(out, lisp.Prin1String(x))
+		}},
+		{"print", func(env *Env, x lisp.Object, printcharfun *lisp.Object) (lisp.Object, error) {
+			out := env.outputStream(optional(printcharfun))
+			return x, env.writeOutput(out, "\n"+lisp.Prin1String(x)+"\n")
+		}},
+		{"terpri", func(env *Env, printcharfun, ensure *lisp.Object) (bool, error) {
+			out := env.outputStream(optional(printcharfun))
+			// Only buffers know their current column.
+			if !lisp.Null(optional(ensure)) && out.Type == lisp.TypeBuffer {
+				if buf := out.Buffer(); buf.Point() == buf.PointMin() || buf.CharAt(buf.Point()-1) == '\n' {
+					return false, nil
+				}
+			}
+			return true, env.writeOutput(out, "\n")
+		}},
+		{"insert", func(env *Env, args ...lisp.Object) error {
+			return insert(env.buffer, args)
+		}},
+	} {
+		env.DefineFunc(ob.Intern(subr.name), subr.fn)
+	}
+}
+
+// insert implements `insert`: strings and
+// characters of args are inserted at buf point.
+// Signals wrong-type-argument before inserting
+// anything if some argument has another type.
+func insert(buf *lisp.Buffer, args []lisp.Object) error {
+	var chars []rune
+	for _, x := range args {
+		switch x.Type {
+		case lisp.TypeString:
+			chars = append(chars, []rune(string(x.String().Chars))...)
+		case lisp.TypeInt:
+			if x.Int() < 0 || x.Int() > maxChar {
+				return wrongTypeArgument(SymCharOrStringp, x)
+			}
+			chars = append(chars, rune(x.Int()))
+		default:
+			return wrongTypeArgument(SymCharOrStringp, x)
+		}
+	}
+	buf.Insert(chars)
+	return nil
+}
+
+// getBufferCreate implements `get-buffer-create`.
+// Returns existing buffer with given name or creates a new one.
+func (env *MasterEnv) getBufferCreate(name string) *lisp.Buffer {
+	env.buffersMu.Lock()
+	defer env.buffersMu.Unlock()
+
+	if buf, ok := env.buffers[name]; ok {
+		return buf
+	}
+	if env.buffers == nil {
+		env.buffers = make(map[string]*lisp.Buffer)
+	}
+	o := lisp.NewBuffer(name)
+	env.buffers[name] = o.Buffer()
+	return o.Buffer()
+}
+
+// tempOutputBufferSetup implements the first half of the
+// `with-output-to-temp-buffer` protocol.
+//
+// Buffer named bufname is created (or reused) and erased,
+// then `standard-output` is bound to it.
+// Current buffer is not changed.
+//
+// Returns the new `standard-output` value.
+// The binding must be removed by tempOutputBufferShow.
+func (env *Env) tempOutputBufferSetup(bufname *lisp.Object) (lisp.Object, error) {
+	if bufname.Type != lisp.TypeString {
+		return lisp.Nil, wrongTypeArgument(SymStringp, *bufname)
+	}
+	buf := env.getBufferCreate(string(bufname.String().Chars))
+	buf.KillLocalVariables()
+	buf.Erase()
+
+	o := buf.Object()
+	env.specbind(SymStandardOutput, o)
+	return o, nil
+}
+
+// tempOutputBufferShow implements the second half of the
+// `with-output-to-temp-buffer` protocol.
+//
+// Output buffer is shown by showTempBuffer.
+// The innermost binding (`standard-output`) is removed;
+// it is an error if there are no bindings at all.
+//
+// Returns val, which is the protected forms result.
+func (env *Env) tempOutputBufferShow(val, buf *lisp.Object) (lisp.Object, error) {
+	if env.specpdlIndex() == 0 {
+		return lisp.Nil, signal(SymError, lisp.NewString([]byte(
+			"temp-output-buffer-show without temp-output-buffer-setup")))
+	}
+	defer env.unbindTo(env.specpdlIndex() - 1)
+
+	if err := env.showTempBuffer(buf); err != nil {
+		return lisp.Nil, err
+	}
+	return *val, nil
+}
+
+// showTempBuffer implements `temp-output-buffer-show`:
+// buf is widened, its point is moved to the beginning
+// and then it is passed to the TempBufferShow host callback.
+func (env *Env) showTempBuffer(buf *lisp.Object) error {
+	if buf.Type != lisp.TypeBuffer {
+		return wrongTypeArgument(SymBufferp, *buf)
+	}
+	b := buf.Buffer()
+	b.Widen()
+	b.SetPoint(b.PointMin())
+	if env.TempBufferShow != nil {
+		return env.TempBufferShow(b)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"strings"
+	"testing"
+)
+
+func TestTempOutputBuffer(t *testing.T) {
+	interp := newTestInterpreter(t)
+	interp.setGlobalValue(SymStandardOutput, lisp.T)
+
+	var shown []string
+	interp.TempBufferShow = func(buf *lisp.Buffer) error {
+		shown = append(shown, buf.Name+": "+bufferString(buf))
+		return nil
+	}
+
+	// Buffer contents are replaced; its locals are reset.
+	help := interp.getBufferCreate("*Help*")
+	help.Insert([]rune("old"))
+	help.CaseFoldSearch = false
+
+	// princ inserts its argument into standard-output buffer.
+	princ := interp.AddGoFunc("princ", func(args []lisp.Object) error {
+		out, err := interp.symbolValue(SymStandardOutput)
+		if err != nil {
+			return err
+		}
+		out.Buffer().Insert([]rune(string(args[1].String().Chars)))
+		args[0] = args[1]
+		return nil
+	})
+
+	type (
+		consts []interface{}
+		steps  []interface{}
+	)
+	interp.LoadSteps(steps{
+		OpConstant0, `"*Help*"`,
+		OpTempOutputBufferSetup, `#<buffer *Help*>`,
+		OpConstant1, `#<buffer *Help*> princ`,
+		OpConstant2, `#<buffer *Help*> princ "usage"`,
+		OpExt, OpExtGoCall1, `#<buffer *Help*> "usage"`,
+		OpDiscard, `#<buffer *Help*>`,
+		OpConstant3, `#<buffer *Help*> 42`,
+		OpTempOutputBufferShow, `42`,
+	})
+	consts0 := consts{lisp.NewString([]byte("*Help*")), princ, lisp.NewString([]byte("usage")), 42}
+	interp.Run("TempOutputBuffer", promoteObjects(consts0), nil)
+
+	if len(shown) != 1 || shown[0] != "*Help*: |usage" {
+		t.Errorf("show callback calls: %q", shown)
+	}
+	if !help.CaseFoldSearch {
+		t.Errorf("buffer-local variables are not reset")
+	}
+	if interp.specpdlIndex() != 0 {
+		t.Errorf("standard-output binding is not removed")
+	}
+	if out, _ := interp.symbolValue(SymStandardOutput); !lisp.Eq(&out, &lisp.T) {
+		t.Errorf("standard-output: have %s, want t", lisp.ObjectString(out))
+	}
+	if interp.getBufferCreate("*Help*") != help {
+		t.Errorf("get-buffer-create returned a new buffer for existing name")
+	}
+}
+
+func TestTempOutputBufferShowUnbound(t *testing.T) {
+	env := newTestEnv()
+	buf := env.getBufferCreate("*Help*").Object()
+	val := lisp.NewInt(1)
+	_, err := env.tempOutputBufferShow(&val, &buf)
+	want := "temp-output-buffer-show without temp-output-buffer-setup"
+	if err == nil || ErrorMessage(err) != want {
+		t.Errorf("have error %v, want %q", err, want)
+	}
+}
+
+func TestWithOutputToTempBuffer(t *testing.T) {
+	env, ob := newLispEnv()
+	var output strings.Builder
+	env.Output = &output
+	var shown []string
+	env.TempBufferShow = func(buf *lisp.Buffer) error {
+		shown = append(shown, buf.Name+": "+bufferString(buf))
+		return nil
+	}
+	tests := []struct {
+		form  string
+		want  string
+		shown string
+	}{
+		{`(with-output-to-temp-buffer "*Help*" (princ "usage") 42)`, "42", "*Help*: |usage"},
+		{`(with-output-to-temp-buffer "*Help*" (princ "a") (princ "b"))`, `"b"`, "*Help*: |ab"},
+		{`(with-output-to-temp-buffer "*Help*")`, "nil", "*Help*: |"},
+		{`(funcall (byte-compile (lambda (x) (with-output-to-temp-buffer "*Help*" (princ x) (princ x)))) "x")`, `"x"`, "*Help*: |xx"},
+		{`(condition-case nil (with-output-to-temp-buffer "*Help*" (princ "a") (car 1)) (error (boundp (quote standard-output))))`, "nil", ""},
+		{`(with-output-to-temp-buffer "*Help*" (prin1 "a") (print 'b) (terpri) (terpri nil t) (princ '("c" d)))`, `("c" d)`, "*Help*: |\"a\"\nb\n\n(c d)"},
+		{`(with-output-to-temp-buffer "*Help*" (terpri nil t) (princ 1 t))`, "1", "*Help*: |"},
+		{`(with-output-to-temp-buffer "*Help*" (insert "i" ?j))`, "nil", "*Help*: |"},
+		{`(with-output-to-temp-buffer "*Help*" (princ 1 1))`, "Wrong type argument: bufferp, 1", ""},
+		{`(with-output-to-temp-buffer 1)`, "Wrong type argument: stringp, 1", ""},
+		{`(progn (with-output-to-temp-buffer "*Help*" (princ "z")) (temp-output-buffer-show (with-output-to-temp-buffer "*Help*" standard-output)))`, "nil", "*Help*: |z *Help*: | *Help*: |"},
+	}
+	for _, test := range tests {
+		shown = nil
+		val, err := env.Eval(mustRead(t, test.form, ob), lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+		if have := strings.Join(shown, " "); have != test.shown {
+			t.Errorf("eval %s: shown buffers:\nhave: %s\nwant: %s", test.form, have, test.shown)
+		}
+		if n := env.specpdlIndex(); n != 0 {
+			t.Errorf("eval %s: %d bindings are not removed", test.form, n)
+		}
+	}
+	if have := output.String(); have != "1" {
+		t.Errorf("output: have %q, want %q", have, "1")
+	}
+	if have := env.CurrentBuffer().Text(); have != "ij" {
+		t.Errorf("current buffer: have %q, want %q", have, "ij")
+	}
+	if _, err := env.Eval(mustRead(t, `(insert "x" 'y)`, ob), lisp.T); err == nil ||
+		ErrorMessage(err) != "Wrong type argument: char-or-string-p, y" {
+		t.Errorf("insert: have error %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"sort"
+	"strings"
+)
+
+// SequenceCount is the number of occurrences of
+// an instruction sequence, see ProfileSequences.
+type SequenceCount struct {
+	// Ops holds instruction mnemonics, like "stack-ref".
+	Ops []string
+
+	Count int
+}
+
+// String returns sequence mnemonics joined by " + ".
+func (c SequenceCount) String() string {
+	return strings.Join(c.Ops, " + ")
+}
+
+// ProfileSequences counts sequences of n adjacent
+// instructions in funcs and compiled functions from
+// their constant vectors.
+// Operands are ignored: "stack-ref 1" and "stack-ref 2"
+// are the same instruction.
+//
+// Sequences are sorted by count in descending order.
+// The counts are used to pick superinstructions.
+func ProfileSequences(funcs []*Func, n int) []SequenceCount {
+	counts := make(map[string]int)
+	visited := make(map[*Func]bool)
+	var profile func(fn *Func)
+	profile = func(fn *Func) {
+		if visited[fn] {
+			return
+		}
+		visited[fn] = true
+
+		var names []string
+		for pc := uint32(0); int(pc) < len(fn.code); {
+			ins, ok := decodeInstr(fn.code, pc)
+			if !ok {
+				break
+			}
+			name := "<unknown>"
+			if ins.info != nil {
+				name = ins.info.name
+				if fn.code[pc] == OpDiscardB && ins.arg&discardPreserveTOS != 0 {
+					name = discardPreserveTOSInfo.name
+				}
+			}
+			names = append(names, name)
+			pc += ins.width
+		}
+		for i := 0; i+n <= len(names); i++ {
+			counts[strings.Join(names[i:i+n], "\x00")]++
+		}
+
+		for i := range fn.consts {
+			if fn.consts[i].Type == lisp.TypeFunc {
+				profile(objectFunc(&fn.consts[i]))
+			}
+		}
+	}
+	for _, fn := range funcs {
+		profile(fn)
+	}
+
+	seqs := make([]SequenceCount, 0, len(counts))
+	for key, count := range counts {
+		seqs = append(seqs, SequenceCount{
+			Ops:   strings.Split(key, "\x00"),
+			Count: count,
+		})
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		if seqs[i].Count != seqs[j].Count {
+			return seqs[i].Count > seqs[j].Count
+		}
+		return seqs[i].String() < seqs[j].String()
+	})
+	return seqs
+}
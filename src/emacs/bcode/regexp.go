@@ -0,0 +1,1042 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"unicode"
+)
+
+// Emacs regular expressions engine.
+//
+// Patterns are parsed into a syntax tree which is then compiled
+// into a program for a backtracking matcher.
+// Backtracking is required to support back references and
+// to reproduce Emacs matching semantics (first alternative wins).
+// Issue#4
+
+// reDupMax is the maximum repetition count for \{m,n\} intervals.
+const reDupMax = 0xFFFF
+
+// reStackLimit bounds the backtracking stack size.
+const reStackLimit = 1 << 22
+
+// reBacktrackLimit bounds the number of backtracks of a single
+// match attempt. The stack limit alone does not stop patterns like
+// \(a*\)*b that backtrack exponentially with a shallow stack.
+const reBacktrackLimit = 1 << 22
+
+// reNodeKind enumerates regexp syntax tree node types.
+type reNodeKind int
+
+const (
+	reEmpty        reNodeKind = iota
+	reLiteral                 // c
+	reAnyButNL                // .
+	reSet                     // [...]
+	reConcat                  // ab
+	reAlt                     // a\|b
+	reRepeat                  // a*, a+, a?, a\{m,n\}
+	reGroup                   // \(a\), \(?N:a\)
+	reBackref                 // \N
+	reLineStart               // ^
+	reLineEnd                 // $
+	reBufStart                // \`
+	reBufEnd                  // \'
+	rePoint                   // \=
+	reWordBound               // \b
+	reNotWordBound            // \B
+	reWordStart               // \<
+	reWordEnd                 // \>
+	reSymbolStart             // \_<
+	reSymbolEnd               // \_>
+	reSyntax                  // \sC, \w
+	reCategory                // \cC
+)
+
+// reNode is a regexp syntax tree node.
+type reNode struct {
+	kind reNodeKind
+
+	// c is a literal char.
+	c rune
+
+	// set is a bracket expression char set.
+	set *charSet
+
+	// sub holds child nodes for concatenation, alternation,
+	// repetition and grouping nodes.
+	sub []*reNode
+
+	// min and max are repetition bounds; max=-1 means "unbounded".
+	min, max int
+
+	// lazy is set for non-greedy repetitions, like a*?.
+	lazy bool
+
+	// arg is a group or back reference number,
+	// a syntax class or a category char.
+	arg int
+
+	// negated is set for \S, \C and \W.
+	negated bool
+}
+
+// regexpError returns `invalid-regexp` signal with msg.
+func regexpError(msg string) *Signal {
+	return signal(SymInvalidRegexp, lisp.NewString([]byte(msg)))
+}
+
+// reParser converts regexp pattern into a syntax tree.
+type reParser struct {
+	src []rune
+	pos int
+
+	// syntax is used for [:word:] and similar bracket classes.
+	syntax *lisp.SyntaxTable
+
+	// ngroups is the maximum group number used so far.
+	ngroups int
+
+	// open is a set of groups that are being parsed.
+	open map[int]bool
+}
+
+// parseRegexp returns syntax tree for Emacs regexp pattern
+// along with the maximum group number.
+func parseRegexp(pattern []rune, syntax *lisp.SyntaxTable) (*reNode, int, error) {
+	p := &reParser{
+		src:    pattern,
+		syntax: syntax,
+		open:   make(map[int]bool),
+	}
+	node, err := p.parseAlt(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.pos != len(p.src) {
+		// Only unmatched "\)" can stop top-level parsing early.
+		return nil, 0, regexpError("Unmatched ) or \\)")
+	}
+	return node, p.ngroups, nil
+}
+
+// peekBackslash reports whether src at pos is "\" followed by c.
+func (p *reParser) peekBackslash(pos int, c rune) bool {
+	return pos+1 < len(p.src) && p.src[pos] == '\\' && p.src[pos+1] == c
+}
+
+// parseAlt parses branches separated by "\|".
+// depth is a group nesting level.
+func (p *reParser) parseAlt(depth int) (*reNode, error) {
+	var branches []*reNode
+	for {
+		branch, err := p.parseBranch()
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, branch)
+		if !p.peekBackslash(p.pos, '|') {
+			break
+		}
+		p.pos += 2
+	}
+	if p.peekBackslash(p.pos, ')') && depth == 0 {
+		return nil, regexpError("Unmatched ) or \\)")
+	}
+	if len(branches) == 1 {
+		return branches[0], nil
+	}
+	return &reNode{kind: reAlt, sub: branches}, nil
+}
+
+// parseBranch parses a sequence of pieces up to "\|", "\)" or the end.
+func (p *reParser) parseBranch() (*reNode, error) {
+	var items []*reNode
+	branchStart := true // Operators are literal at the branch start
+	for p.pos < len(p.src) {
+		if p.peekBackslash(p.pos, '|') || p.peekBackslash(p.pos, ')') {
+			break
+		}
+		atom, err := p.parseAtom(branchStart)
+		if err != nil {
+			return nil, err
+		}
+		isAnchor := atom.kind == reLineStart
+		atom, err = p.parsePostfix(atom, branchStart && isAnchor)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, atom)
+		branchStart = branchStart && isAnchor
+	}
+	switch len(items) {
+	case 0:
+		return &reNode{kind: reEmpty}, nil
+	case 1:
+		return items[0], nil
+	default:
+		return &reNode{kind: reConcat, sub: items}, nil
+	}
+}
+
+// atEnd reports whether pos is at the place where "$" is special:
+// the end of pattern, or before "\)" or "\|".
+func (p *reParser) atEnd(pos int) bool {
+	return pos == len(p.src) ||
+		p.peekBackslash(pos, ')') ||
+		p.peekBackslash(pos, '|')
+}
+
+// parsePostfix applies any postfix operators to atom.
+// If noOps is set, operator chars are not consumed;
+// they will be parsed as literal chars.
+func (p *reParser) parsePostfix(atom *reNode, noOps bool) (*reNode, error) {
+	if noOps {
+		return atom, nil
+	}
+	for p.pos < len(p.src) {
+		min, max := 0, -1
+		lazy := false
+		switch c := p.src[p.pos]; {
+		case c == '*' || c == '+' || c == '?':
+			min, max, lazy = p.parseRepeatOps()
+		case p.peekBackslash(p.pos, '{'):
+			p.pos += 2
+			var err error
+			min, max, err = p.parseInterval()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return atom, nil
+		}
+		atom = &reNode{
+			kind: reRepeat,
+			sub:  []*reNode{atom},
+			min:  min,
+			max:  max,
+			lazy: lazy,
+		}
+	}
+	return atom, nil
+}
+
+// parseRepeatOps parses a run of "*", "+" and "?" operators.
+// Like in Emacs, the run is a single repeat: "a**" is "a*",
+// "a+*" is "a*" and "?" after "*" or "+" makes it lazy.
+// Nested repeats would make backtracking exponential.
+func (p *reParser) parseRepeatOps() (min, max int, lazy bool) {
+	zero, many := false, false
+loop:
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c == '?' && (zero || many):
+			lazy = true
+		case c == '*' || c == '+' || c == '?':
+			zero = zero || c != '+'
+			many = many || c != '?'
+		default:
+			break loop
+		}
+	}
+	min, max = 1, 1
+	if zero {
+		min = 0
+	}
+	if many {
+		max = -1
+	}
+	return min, max, lazy
+}
+
+// parseInterval parses "m,n\}" part of the interval operator.
+func (p *reParser) parseInterval() (int, int, error) {
+	readCount := func() int {
+		n := -1
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			if n < 0 {
+				n = 0
+			}
+			n = n*10 + int(p.src[p.pos]-'0')
+			if n > reDupMax {
+				n = reDupMax + 1
+			}
+			p.pos++
+		}
+		return n
+	}
+
+	min := readCount()
+	max := min
+	if p.pos < len(p.src) && p.src[p.pos] == ',' {
+		p.pos++
+		max = readCount()
+	}
+	if min < 0 {
+		min = 0
+	}
+	if !p.peekBackslash(p.pos, '}') || (max >= 0 && min > max) ||
+		min > reDupMax || max > reDupMax {
+		return 0, 0, regexpError("Invalid content of \\{\\}")
+	}
+	p.pos += 2
+	return min, max, nil
+}
+
+// parseAtom parses a single pattern element.
+// branchStart is set when there is no preceding element
+// in the current branch.
+func (p *reParser) parseAtom(branchStart bool) (*reNode, error) {
+	c := p.src[p.pos]
+	p.pos++
+
+	switch c {
+	case '^':
+		if branchStart {
+			return &reNode{kind: reLineStart}, nil
+		}
+	case '$':
+		if p.atEnd(p.pos) {
+			return &reNode{kind: reLineEnd}, nil
+		}
+	case '.':
+		return &reNode{kind: reAnyButNL}, nil
+	case '[':
+		return p.parseBracket()
+	case '\\':
+		return p.parseEscape()
+	}
+
+	return &reNode{kind: reLiteral, c: c}, nil
+}
+
+// parseEscape parses backslash constructs.
+func (p *reParser) parseEscape() (*reNode, error) {
+	if p.pos == len(p.src) {
+		return nil, regexpError("Trailing backslash")
+	}
+	c := p.src[p.pos]
+	p.pos++
+
+	switch c {
+	case '(':
+		return p.parseGroup()
+	case '{':
+		// Interval without preceding expression is literal.
+		return &reNode{kind: reLiteral, c: '{'}, nil
+	case '`':
+		return &reNode{kind: reBufStart}, nil
+	case '\'':
+		return &reNode{kind: reBufEnd}, nil
+	case '=':
+		return &reNode{kind: rePoint}, nil
+	case 'b':
+		return &reNode{kind: reWordBound}, nil
+	case 'B':
+		return &reNode{kind: reNotWordBound}, nil
+	case '<':
+		return &reNode{kind: reWordStart}, nil
+	case '>':
+		return &reNode{kind: reWordEnd}, nil
+	case '_':
+		if p.pos < len(p.src) {
+			switch p.src[p.pos] {
+			case '<':
+				p.pos++
+				return &reNode{kind: reSymbolStart}, nil
+			case '>':
+				p.pos++
+				return &reNode{kind: reSymbolEnd}, nil
+			}
+		}
+		return nil, regexpError("Invalid regular expression")
+	case 'w', 'W':
+		return &reNode{
+			kind:    reSyntax,
+			arg:     int(lisp.SyntaxWord),
+			negated: c == 'W',
+		}, nil
+	case 's', 'S':
+		if p.pos == len(p.src) {
+			return nil, regexpError("Invalid syntax designator")
+		}
+		spec := p.src[p.pos]
+		p.pos++
+		if spec >= 256 || syntaxSpecCode[spec] == 0xFF {
+			return nil, regexpError("Invalid syntax designator")
+		}
+		return &reNode{
+			kind:    reSyntax,
+			arg:     int(syntaxSpecCode[spec]),
+			negated: c == 'S',
+		}, nil
+	case 'c', 'C':
+		if p.pos == len(p.src) {
+			return nil, regexpError("Invalid category designator")
+		}
+		cat := p.src[p.pos]
+		p.pos++
+		if _, ok := categories[cat]; !ok {
+			return nil, regexpError("Invalid category designator")
+		}
+		return &reNode{
+			kind:    reCategory,
+			arg:     int(cat),
+			negated: c == 'C',
+		}, nil
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		n := int(c - '0')
+		if n > p.ngroups || p.open[n] {
+			return nil, regexpError("Invalid back reference")
+		}
+		return &reNode{kind: reBackref, arg: n}, nil
+	case ')':
+		return nil, regexpError("Unmatched ) or \\)")
+	}
+
+	return &reNode{kind: reLiteral, c: c}, nil
+}
+
+// parseGroup parses group contents after "\(".
+func (p *reParser) parseGroup() (*reNode, error) {
+	group := 0
+	shy := false
+
+	if p.pos < len(p.src) && p.src[p.pos] == '?' {
+		p.pos++
+		n := 0
+		digits := 0
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			n = n*10 + int(p.src[p.pos]-'0')
+			digits++
+			p.pos++
+		}
+		if p.pos == len(p.src) || p.src[p.pos] != ':' {
+			return nil, regexpError("Invalid regular expression")
+		}
+		p.pos++
+		if digits == 0 {
+			shy = true
+		} else if n == 0 {
+			return nil, regexpError("Invalid regular expression")
+		} else {
+			group = n
+		}
+	}
+	if !shy && group == 0 {
+		group = p.ngroups + 1
+	}
+	if group > p.ngroups {
+		p.ngroups = group
+	}
+
+	if !shy {
+		p.open[group] = true
+	}
+	body, err := p.parseAlt(1)
+	if err != nil {
+		return nil, err
+	}
+	if !p.peekBackslash(p.pos, ')') {
+		return nil, regexpError("Unmatched ( or \\(")
+	}
+	p.pos += 2
+	if shy {
+		return body, nil
+	}
+	delete(p.open, group)
+	return &reNode{kind: reGroup, sub: []*reNode{body}, arg: group}, nil
+}
+
+// parseBracket parses "[...]" bracket expression after "[".
+func (p *reParser) parseBracket() (*reNode, error) {
+	cs := &charSet{syntax: p.syntax}
+	errUnmatched := regexpError("Unmatched [ or [^")
+
+	if p.pos < len(p.src) && p.src[p.pos] == '^' {
+		cs.negated = true
+		p.pos++
+	}
+	// "]" right after the opening bracket is literal.
+	if p.pos < len(p.src) && p.src[p.pos] == ']' {
+		cs.addRange(']', ']')
+		p.pos++
+	}
+
+	for {
+		if p.pos == len(p.src) {
+			return nil, errUnmatched
+		}
+		c := p.src[p.pos]
+		if c == ']' {
+			p.pos++
+			break
+		}
+
+		if c == '[' && p.pos+1 < len(p.src) && p.src[p.pos+1] == ':' {
+			end := -1
+			for i := p.pos + 2; i+1 < len(p.src); i++ {
+				if p.src[i] == ':' && p.src[i+1] == ']' {
+					end = i
+					break
+				}
+			}
+			if end != -1 {
+				cc, ok := charClassByName[string(p.src[p.pos+2:end])]
+				if !ok {
+					return nil, regexpError("Invalid character class name")
+				}
+				cs.classes |= cc
+				p.pos = end + 2
+				continue
+			}
+		}
+
+		p.pos++
+		if p.pos+1 < len(p.src) && p.src[p.pos] == '-' && p.src[p.pos+1] != ']' {
+			cs.addRange(c, p.src[p.pos+1])
+			p.pos += 2
+		} else {
+			cs.addRange(c, c)
+		}
+	}
+
+	return &reNode{kind: reSet, set: cs}, nil
+}
+
+// reOpcode enumerates regexp program instructions.
+type reOpcode uint8
+
+const (
+	reOpMatch reOpcode = iota
+	reOpChar
+	reOpAnyButNL
+	reOpSet
+	reOpSplit    // Try x, then y
+	reOpJump     // Goto x
+	reOpSave     // Set capture slot x to the current position
+	reOpMark     // Set loop register x to the current position
+	reOpProgress // Goto y if loop register x equals current position
+	reOpBackref
+	reOpLineStart
+	reOpLineEnd
+	reOpBufStart
+	reOpBufEnd
+	reOpPoint
+	reOpWordBound
+	reOpNotWordBound
+	reOpWordStart
+	reOpWordEnd
+	reOpSymbolStart
+	reOpSymbolEnd
+	reOpSyntax
+	reOpCategory
+)
+
+// reInst is a single regexp program instruction.
+type reInst struct {
+	op      reOpcode
+	negated bool
+	c       rune
+	set     *charSet
+	x, y    int
+}
+
+// regexp is a compiled Emacs regular expression.
+//
+// It is safe to match the same regexp concurrently.
+type regexp struct {
+	prog []reInst
+
+	// ngroups is the number of capture groups,
+	// including implicit zero group.
+	ngroups int
+
+	// nloops is the number of loop progress registers.
+	nloops int
+
+	// caseFold is set for case-insensitive regexps.
+	caseFold bool
+}
+
+// compileRegexp returns compiled pattern.
+// If caseFold is true, letter case is ignored during matching.
+func compileRegexp(pattern []rune, caseFold bool, syntax *lisp.SyntaxTable) (*regexp, error) {
+	tree, ngroups, err := parseRegexp(pattern, syntax)
+	if err != nil {
+		return nil, err
+	}
+	c := &reCompiler{re: &regexp{ngroups: ngroups + 1, caseFold: caseFold}}
+	c.emit(reInst{op: reOpSave, x: 0})
+	c.compile(tree)
+	c.emit(reInst{op: reOpSave, x: 1})
+	c.emit(reInst{op: reOpMatch})
+	return c.re, nil
+}
+
+// reCompiler translates regexp syntax tree into a program.
+type reCompiler struct {
+	re *regexp
+}
+
+func (c *reCompiler) emit(inst reInst) int {
+	c.re.prog = append(c.re.prog, inst)
+	return len(c.re.prog) - 1
+}
+
+func (c *reCompiler) compile(n *reNode) {
+	switch n.kind {
+	case reEmpty:
+		// Nothing to emit.
+	case reLiteral:
+		ch := n.c
+		if c.re.caseFold {
+			ch = unicode.ToLower(ch)
+		}
+		c.emit(reInst{op: reOpChar, c: ch})
+	case reAnyButNL:
+		c.emit(reInst{op: reOpAnyButNL})
+	case reSet:
+		c.emit(reInst{op: reOpSet, set: n.set})
+	case reConcat:
+		for _, sub := range n.sub {
+			c.compile(sub)
+		}
+	case reAlt:
+		var jumps []int
+		for i, sub := range n.sub {
+			if i == len(n.sub)-1 {
+				c.compile(sub)
+				break
+			}
+			split := c.emit(reInst{op: reOpSplit})
+			c.re.prog[split].x = split + 1
+			c.compile(sub)
+			jumps = append(jumps, c.emit(reInst{op: reOpJump}))
+			c.re.prog[split].y = len(c.re.prog)
+		}
+		for _, j := range jumps {
+			c.re.prog[j].x = len(c.re.prog)
+		}
+	case reGroup:
+		c.emit(reInst{op: reOpSave, x: n.arg * 2})
+		c.compile(n.sub[0])
+		c.emit(reInst{op: reOpSave, x: n.arg*2 + 1})
+	case reRepeat:
+		c.compileRepeat(n)
+	case reBackref:
+		c.emit(reInst{op: reOpBackref, x: n.arg})
+	case reSyntax:
+		c.emit(reInst{op: reOpSyntax, x: n.arg, negated: n.negated})
+	case reCategory:
+		c.emit(reInst{op: reOpCategory, c: rune(n.arg), negated: n.negated})
+	default:
+		c.emit(reInst{op: assertionOps[n.kind]})
+	}
+}
+
+// assertionOps maps zero-width node kinds to their opcodes.
+var assertionOps = map[reNodeKind]reOpcode{
+	reLineStart:    reOpLineStart,
+	reLineEnd:      reOpLineEnd,
+	reBufStart:     reOpBufStart,
+	reBufEnd:       reOpBufEnd,
+	rePoint:        reOpPoint,
+	reWordBound:    reOpWordBound,
+	reNotWordBound: reOpNotWordBound,
+	reWordStart:    reOpWordStart,
+	reWordEnd:      reOpWordEnd,
+	reSymbolStart:  reOpSymbolStart,
+	reSymbolEnd:    reOpSymbolEnd,
+}
+
+// patchSplit makes split instruction choose between the
+// instruction that follows it and the current position.
+// The following instruction is preferred unless lazy is set.
+func (c *reCompiler) patchSplit(split int, lazy bool) {
+	next, alt := split+1, len(c.re.prog)
+	if lazy {
+		next, alt = alt, next
+	}
+	c.re.prog[split].x = next
+	c.re.prog[split].y = alt
+}
+
+func (c *reCompiler) compileRepeat(n *reNode) {
+	body := n.sub[0]
+	for i := 0; i < n.min; i++ {
+		c.compile(body)
+	}
+
+	if n.max == -1 {
+		loop := c.emit(reInst{op: reOpSplit})
+		mayBeEmpty := canMatchEmpty(body)
+		reg := 0
+		if mayBeEmpty {
+			reg = c.re.nloops
+			c.re.nloops++
+			c.emit(reInst{op: reOpMark, x: reg})
+		}
+		c.compile(body)
+		progress := -1
+		if mayBeEmpty {
+			progress = c.emit(reInst{op: reOpProgress, x: reg})
+		}
+		c.emit(reInst{op: reOpJump, x: loop})
+		c.patchSplit(loop, n.lazy)
+		if progress != -1 {
+			c.re.prog[progress].y = len(c.re.prog)
+		}
+		return
+	}
+
+	var splits []int
+	for i := n.min; i < n.max; i++ {
+		splits = append(splits, c.emit(reInst{op: reOpSplit}))
+		c.compile(body)
+	}
+	for _, split := range splits {
+		c.patchSplit(split, n.lazy)
+	}
+}
+
+// canMatchEmpty reports whether n can match an empty string.
+func canMatchEmpty(n *reNode) bool {
+	switch n.kind {
+	case reLiteral, reAnyButNL, reSet, reSyntax, reCategory:
+		return false
+	case reConcat:
+		for _, sub := range n.sub {
+			if !canMatchEmpty(sub) {
+				return false
+			}
+		}
+		return true
+	case reAlt:
+		for _, sub := range n.sub {
+			if canMatchEmpty(sub) {
+				return true
+			}
+		}
+		return false
+	case reGroup:
+		return canMatchEmpty(n.sub[0])
+	case reRepeat:
+		return n.min == 0 || canMatchEmpty(n.sub[0])
+	default:
+		// Assertions, back references and empty nodes.
+		return true
+	}
+}
+
+// reInput describes the text that is being matched.
+type reInput struct {
+	text []rune
+
+	// beg and end are text bounds as seen by the matcher;
+	// they are used by anchors and boundary assertions.
+	beg, end int
+
+	// point is a `\=' position; -1 if there is no point.
+	point int
+
+	syntax *lisp.SyntaxTable
+}
+
+// reBacktrack is a matcher backtracking stack entry.
+// Entries either resume execution at pc with pos,
+// or restore a slot (capture or loop register) value.
+type reBacktrack struct {
+	pc   int
+	pos  int
+	slot int // -1 for resume entries
+}
+
+// reMatcher holds a single match attempt state.
+type reMatcher struct {
+	re    *regexp
+	in    *reInput
+	stop  int
+	caps  []int
+	loops []int
+	stack []reBacktrack
+
+	// backtracks counts backtracks of the current run.
+	backtracks int
+}
+
+// fold returns c in canonical case for case-insensitive matching.
+func (m *reMatcher) fold(c rune) rune {
+	if m.re.caseFold {
+		return unicode.ToLower(c)
+	}
+	return c
+}
+
+// isWord reports whether char at pos is a word constituent.
+func (m *reMatcher) isWord(pos int) bool {
+	return m.in.syntax.Class(m.in.text[pos]) == lisp.SyntaxWord
+}
+
+// isSymbolChar reports whether char at pos is a word or symbol constituent.
+func (m *reMatcher) isSymbolChar(pos int) bool {
+	class := m.in.syntax.Class(m.in.text[pos])
+	return class == lisp.SyntaxWord || class == lisp.SyntaxSymbol
+}
+
+// setHas reports whether c is a member of cs,
+// respecting case folding.
+func (m *reMatcher) setHas(cs *charSet, c rune) bool {
+	if !m.re.caseFold {
+		return cs.has(c)
+	}
+	if cs.contains(c) || cs.contains(unicode.ToLower(c)) || cs.contains(unicode.ToUpper(c)) {
+		return !cs.negated
+	}
+	return cs.negated
+}
+
+// setSlot updates capture or loop register slot,
+// recording previous value for backtracking.
+func (m *reMatcher) setSlot(slot, pos int) {
+	var old int
+	if slot < len(m.caps) {
+		old = m.caps[slot]
+		m.caps[slot] = pos
+	} else {
+		old = m.loops[slot-len(m.caps)]
+		m.loops[slot-len(m.caps)] = pos
+	}
+	m.stack = append(m.stack, reBacktrack{slot: slot, pos: old})
+}
+
+// run tries to match the program at pos.
+// On success, m.caps holds the match positions.
+func (m *reMatcher) run(pos int) (bool, error) {
+	for i := range m.caps {
+		m.caps[i] = -1
+	}
+	m.stack = m.stack[:0]
+	m.backtracks = 0
+	prog := m.re.prog
+	text := m.in.text
+	pc := 0
+
+	for {
+		inst := &prog[pc]
+		ok := true
+		switch inst.op {
+		case reOpMatch:
+			return true, nil
+		case reOpChar:
+			ok = pos < m.stop && m.fold(text[pos]) == inst.c
+			pos++
+		case reOpAnyButNL:
+			ok = pos < m.stop && text[pos] != '\n'
+			pos++
+		case reOpSet:
+			ok = pos < m.stop && m.setHas(inst.set, text[pos])
+			pos++
+		case reOpSplit:
+			if len(m.stack) >= reStackLimit {
+				return false, reStackOverflow()
+			}
+			m.stack = append(m.stack, reBacktrack{pc: inst.y, pos: pos, slot: -1})
+			pc = inst.x
+			continue
+		case reOpJump:
+			pc = inst.x
+			continue
+		case reOpSave:
+			m.setSlot(inst.x, pos)
+		case reOpMark:
+			m.setSlot(len(m.caps)+inst.x, pos)
+		case reOpProgress:
+			if m.loops[inst.x] == pos {
+				pc = inst.y
+				continue
+			}
+		case reOpBackref:
+			pos, ok = m.matchBackref(inst.x, pos)
+		case reOpSyntax:
+			ok = pos < m.stop &&
+				(m.in.syntax.Class(text[pos]) == lisp.SyntaxClass(inst.x)) != inst.negated
+			pos++
+		case reOpCategory:
+			ok = pos < m.stop && hasCategory(inst.c, text[pos]) != inst.negated
+			pos++
+		default:
+			ok = m.assert(inst.op, pos)
+		}
+
+		if ok {
+			pc++
+			continue
+		}
+
+		// Backtrack.
+		for {
+			if len(m.stack) == 0 {
+				return false, nil
+			}
+			top := m.stack[len(m.stack)-1]
+			m.stack = m.stack[:len(m.stack)-1]
+			if top.slot == -1 {
+				m.backtracks++
+				if m.backtracks > reBacktrackLimit {
+					return false, reStackOverflow()
+				}
+				pc, pos = top.pc, top.pos
+				break
+			}
+			if top.slot < len(m.caps) {
+				m.caps[top.slot] = top.pos
+			} else {
+				m.loops[top.slot-len(m.caps)] = top.pos
+			}
+		}
+	}
+}
+
+// reStackOverflow returns the error that Emacs signals when
+// a match attempt exceeds the matcher limits.
+func reStackOverflow() error {
+	return signal(SymError, lisp.NewString([]byte("Stack overflow in regexp matcher")))
+}
+
+// matchBackref matches the text of group n at pos.
+func (m *reMatcher) matchBackref(n, pos int) (int, bool) {
+	start, end := m.caps[n*2], m.caps[n*2+1]
+	if start < 0 || end < 0 {
+		return pos, false
+	}
+	for i := start; i < end; i++ {
+		if pos >= m.stop || m.fold(m.in.text[pos]) != m.fold(m.in.text[i]) {
+			return pos, false
+		}
+		pos++
+	}
+	return pos, true
+}
+
+// assert evaluates zero-width assertion op at pos.
+func (m *reMatcher) assert(op reOpcode, pos int) bool {
+	in := m.in
+	atBeg := pos == in.beg
+	atEnd := pos == in.end
+
+	switch op {
+	case reOpLineStart:
+		return atBeg || in.text[pos-1] == '\n'
+	case reOpLineEnd:
+		return atEnd || in.text[pos] == '\n'
+	case reOpBufStart:
+		return atBeg
+	case reOpBufEnd:
+		return atEnd
+	case reOpPoint:
+		return pos == in.point
+	case reOpWordBound, reOpNotWordBound:
+		bound := atBeg || atEnd || m.isWord(pos-1) != m.isWord(pos)
+		return bound == (op == reOpWordBound)
+	case reOpWordStart:
+		return !atEnd && m.isWord(pos) && (atBeg || !m.isWord(pos-1))
+	case reOpWordEnd:
+		return !atBeg && m.isWord(pos-1) && (atEnd || !m.isWord(pos))
+	case reOpSymbolStart:
+		return !atEnd && m.isSymbolChar(pos) && (atBeg || !m.isSymbolChar(pos-1))
+	case reOpSymbolEnd:
+		return !atBeg && m.isSymbolChar(pos-1) && (atEnd || !m.isSymbolChar(pos))
+	}
+	return false
+}
+
+// newMatcher returns a matcher for re on in.
+// Chars at or after stop can not be consumed.
+func (re *regexp) newMatcher(in *reInput, stop int) *reMatcher {
+	return &reMatcher{
+		re:    re,
+		in:    in,
+		stop:  stop,
+		caps:  make([]int, re.ngroups*2),
+		loops: make([]int, re.nloops),
+	}
+}
+
+// match tries to match re exactly at pos.
+// Returns capture positions (nil on failure).
+func (re *regexp) match(in *reInput, pos, stop int) ([]int, error) {
+	m := re.newMatcher(in, stop)
+	ok, err := m.run(pos)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return m.caps, nil
+}
+
+// search looks for the first match that starts inside [from, to]
+// range; if from>to, the search goes backward.
+// Returns capture positions (nil on failure).
+func (re *regexp) search(in *reInput, from, to, stop int) ([]int, error) {
+	m := re.newMatcher(in, stop)
+	step := 1
+	if from > to {
+		step = -1
+	}
+
+	// Patterns that start with a literal char can skip
+	// positions that can not start a match.
+	first := rune(-1)
+	if inst := re.prog[1]; inst.op == reOpChar && !re.caseFold {
+		first = inst.c
+	}
+
+	for pos := from; ; pos += step {
+		if first == -1 || (pos < stop && in.text[pos] == first) {
+			ok, err := m.run(pos)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return m.caps, nil
+			}
+		}
+		if pos == to {
+			return nil, nil
+		}
+	}
+}
+
+// categories maps category designator chars to char predicates.
+// It covers a subset of the standard Emacs category table.
+var categories = map[rune]func(c rune) bool{
+	'a': func(c rune) bool { return c < 0x80 },
+	'l': func(c rune) bool { return c >= 0x80 && c <= 0x24F },
+	'g': func(c rune) bool { return unicode.Is(unicode.Greek, c) },
+	'y': func(c rune) bool { return unicode.Is(unicode.Cyrillic, c) },
+	'b': func(c rune) bool { return unicode.Is(unicode.Arabic, c) },
+	'w': func(c rune) bool { return unicode.Is(unicode.Hebrew, c) },
+	't': func(c rune) bool { return unicode.Is(unicode.Thai, c) },
+	'e': func(c rune) bool { return unicode.Is(unicode.Ethiopic, c) },
+	'h': func(c rune) bool { return unicode.Is(unicode.Hangul, c) },
+	'k': func(c rune) bool { return unicode.Is(unicode.Katakana, c) },
+	'r': func(c rune) bool { return c >= 0xFF01 && c <= 0xFF5E },
+	'K': func(c rune) bool { return unicode.Is(unicode.Katakana, c) },
+	'H': func(c rune) bool { return unicode.Is(unicode.Hiragana, c) },
+	'C': func(c rune) bool { return unicode.Is(unicode.Han, c) },
+	'c': func(c rune) bool { return unicode.Is(unicode.Han, c) },
+	'j': func(c rune) bool {
+		return unicode.In(c, unicode.Han, unicode.Hiragana, unicode.Katakana)
+	},
+	'|': func(c rune) bool {
+		return unicode.In(c, unicode.Han, unicode.Hiragana, unicode.Katakana)
+	},
+	'^': func(c rune) bool { return unicode.In(c, unicode.Mn, unicode.Me) },
+	'.': func(c rune) bool { return !unicode.In(c, unicode.Mn, unicode.Me) },
+	'L': func(c rune) bool { return !unicode.In(c, unicode.Arabic, unicode.Hebrew) },
+	'R': func(c rune) bool { return unicode.In(c, unicode.Arabic, unicode.Hebrew) },
+}
+
+// hasCategory reports whether c belongs to category cat.
+func hasCategory(cat, c rune) bool {
+	return categories[cat](c)
+}
@@ -0,0 +1,281 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// matchDataString returns regs printed like a `match-data` list.
+func matchDataString(regs []int) string {
+	for len(regs) != 0 && regs[len(regs)-2] < 0 {
+		regs = regs[:len(regs)-2]
+	}
+	parts := make([]string, len(regs))
+	for i, pos := range regs {
+		if pos < 0 {
+			parts[i] = "nil"
+		} else {
+			parts[i] = strconv.Itoa(pos)
+		}
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestRegexpMatch(t *testing.T) {
+	// want is a printed match data or "nil" if there should be no match.
+	tests := []struct {
+		re   string
+		str  string
+		want string
+	}{
+		// Literals and simple operators.
+		{`abc`, "xxabcxx", "(2 5)"},
+		{`a.c`, "abc", "(0 3)"},
+		{`a.c`, "a\nc", "nil"},
+		{`ab*c`, "ac abbc", "(0 2)"},
+		{`ab+c`, "ac abbc", "(3 7)"},
+		{`ab?c`, "abbc abc", "(5 8)"},
+		{`a*`, "bbb", "(0 0)"},
+		{`*a`, "x*a", "(1 3)"},
+		{`a**`, "aaa", "(0 3)"},
+		{`a+*b`, "b", "(0 1)"},
+		{`a?+`, "aaa", "(0 3)"},
+		{`a*?+`, "aaa", "(0 0)"},
+		{`\{`, "{", "(0 1)"},
+		{`x\{2\}`, "x xx", "(2 4)"},
+		{`x\{2,\}`, "xxxxx", "(0 5)"},
+		{`x\{,2\}y`, "xxxy", "(1 4)"},
+		{`x\{1,2\}`, "xxx", "(0 2)"},
+		{`x\{\}`, "xxx", "(0 3)"},
+
+		// Non-greedy operators.
+		{`<.*>`, "<a><b>", "(0 6)"},
+		{`<.*?>`, "<a><b>", "(0 3)"},
+		{`a+?`, "aaa", "(0 1)"},
+		{`a??b`, "ab", "(0 2)"},
+
+		// Anchors.
+		{`^b`, "ab\nb", "(3 4)"},
+		{`a$`, "ab\na", "(3 4)"},
+		{`a^`, "a^", "(0 2)"},
+		{`$a`, "$a", "(0 2)"},
+		{`x\|^b`, "ab\nb", "(3 4)"},
+		{`\(^b\)`, "ab\nb", "(3 4 3 4)"},
+		{`\` + "`b", "b\nb", "(0 1)"},
+		{`\` + "`b", "ab", "nil"},
+		{`b\'`, "b\nb", "(2 3)"},
+
+		// Groups and alternation.
+		{`\(a\)\(b\)`, "ab", "(0 2 0 1 1 2)"},
+		{`\(?:a\)\(b\)`, "ab", "(0 2 1 2)"},
+		{`\(?2:a\)`, "a", "(0 1 nil nil 0 1)"},
+		{`\(?2:a\)\(b\)`, "ab", "(0 2 nil nil 0 1 1 2)"},
+		{`\(a\)\|b`, "b", "(0 1)"},
+		{`foo\|bar`, "xbar", "(1 4)"},
+		{`\(a\|ab\)c`, "abc", "(0 3 0 2)"},
+		{`\(a*\)*b`, "aab", "(0 3 2 2)"},
+		{`\(a\|\)*b`, "aab", "(0 3 2 2)"},
+		{`\(x\)?y`, "y", "(0 1)"},
+		{`a\|`, "b", "(0 0)"},
+
+		// Back references.
+		{`\(a+\)b\1`, "aabaa", "(0 5 0 2)"},
+		{`\(a+\)b\1`, "aaba", "(1 4 1 2)"},
+		{`\(.\)\1`, "abccd", "(2 4 2 3)"},
+
+		// Bracket expressions.
+		{`[abc]+`, "xxbcay", "(2 5)"},
+		{`[^abc]+`, "abxy\nc", "(2 5)"},
+		{`[]a]+`, "x]a]", "(1 4)"},
+		{`[^]a]`, "]ab", "(2 3)"},
+		{`[a-c-]+`, "x-ab-", "(1 5)"},
+		{`[[:digit:]]+`, "ab123", "(2 5)"},
+		{`[[:alpha:]_]+`, "1a_b2", "(1 4)"},
+		{`[[:space:]]`, "a\tb", "(1 2)"},
+		{`[\]`, `a\`, "(1 2)"},
+		{`[z-a]`, "b", "nil"},
+
+		// Syntax classes and boundaries.
+		{`\w+`, "-- abc --", "(3 6)"},
+		{`\W+`, "abc -- d", "(3 7)"},
+		{`\s-+`, "a  b", "(1 3)"},
+		{`\S-+`, "  ab ", "(2 4)"},
+		{`\s(`, "a(b", "(1 2)"},
+		{`\bfoo\b`, "foobar foo", "(7 10)"},
+		{`\Boo`, "oo foo", "(4 6)"},
+		{`\<b`, "ab b", "(3 4)"},
+		{`a\>`, "ab a", "(3 4)"},
+		{`\_<x-y\_>`, "ax-y x-y", "(5 8)"},
+		{`\_<x\_>`, "x-y x", "(4 5)"},
+
+		// Categories.
+		{`\cg+`, "abγδ", "(2 4)"},
+		{`\Ca+`, "abγδ", "(2 4)"},
+
+		// Other quoted chars are literal.
+		{`a\.b`, "axb a.b", "(4 7)"},
+		{`\*`, "a*", "(1 2)"},
+
+		// Point is never matched inside strings.
+		{`\=`, "a", "nil"},
+	}
+
+	env := newTestEnv()
+	env.buffer.CaseFoldSearch = false
+	for _, tt := range tests {
+		re := lisp.NewString([]byte(tt.re))
+		str := lisp.NewString([]byte(tt.str))
+		env.matchData = nil
+		res, err := env.stringMatch(&re, &str, &lisp.Nil)
+		if err != nil {
+			t.Errorf("(string-match %q %q): unexpected error: %v", tt.re, tt.str, err)
+			continue
+		}
+		have := "nil"
+		if !lisp.Null(&res) {
+			have = matchDataString(env.matchData)
+		}
+		if have != tt.want {
+			t.Errorf("(string-match %q %q):\nhave: %s\nwant: %s",
+				tt.re, tt.str, have, tt.want)
+		}
+	}
+}
+
+func TestRegexpCaseFold(t *testing.T) {
+	tests := []struct {
+		re       string
+		str      string
+		caseFold bool
+		want     string
+	}{
+		{`abc`, "xABC", true, "1"},
+		{`abc`, "xABC", false, "nil"},
+		{`ABC`, "xabc", true, "1"},
+		{`[a-c]+`, "xAbC", true, "1"},
+		{`[a-c]+`, "xAbC", false, "2"},
+		{`\(a\)\1`, "aA", true, "0"},
+		{`\(a\)\1`, "aA", false, "nil"},
+	}
+
+	env := newTestEnv()
+	for _, tt := range tests {
+		env.buffer.CaseFoldSearch = tt.caseFold
+		re := lisp.NewString([]byte(tt.re))
+		str := lisp.NewString([]byte(tt.str))
+		res, err := env.stringMatch(&re, &str, &lisp.Nil)
+		if err != nil {
+			t.Errorf("(string-match %q %q): unexpected error: %v", tt.re, tt.str, err)
+			continue
+		}
+		if have := lisp.ObjectString(res); have != tt.want {
+			t.Errorf("(string-match %q %q) case-fold=%v:\nhave: %s\nwant: %s",
+				tt.re, tt.str, tt.caseFold, have, tt.want)
+		}
+	}
+}
+
+func TestRegexpErrors(t *testing.T) {
+	tests := []struct {
+		re   string
+		want string
+	}{
+		{`\(a`, "Unmatched ( or \\("},
+		{`a\)`, "Unmatched ) or \\)"},
+		{`[ab`, "Unmatched [ or [^"},
+		{`[]`, "Unmatched [ or [^"},
+		{`ab\`, "Trailing backslash"},
+		{`\1`, "Invalid back reference"},
+		{`\(a\1\)`, "Invalid back reference"},
+		{`a\{2,1\}`, "Invalid content of \\{\\}"},
+		{`a\{2`, "Invalid content of \\{\\}"},
+		{`a\{70000\}`, "Invalid content of \\{\\}"},
+		{`[[:foo:]]`, "Invalid character class name"},
+		{`\sZ`, "Invalid syntax designator"},
+		{`\c~`, "Invalid category designator"},
+		{`\(?x:a\)`, "Invalid regular expression"},
+	}
+
+	st := newStandardSyntaxTable()
+	for _, tt := range tests {
+		_, err := compileRegexp([]rune(tt.re), false, st)
+		if err == nil {
+			t.Errorf("compile %q: expected error", tt.re)
+			continue
+		}
+		sig, ok := err.(*Signal)
+		if !ok || sig.Symbol != SymInvalidRegexp {
+			t.Errorf("compile %q: unexpected error: %v", tt.re, err)
+			continue
+		}
+		if have := string(sig.Data.Cons().Car.String().Chars); have != tt.want {
+			t.Errorf("compile %q:\nhave: %s\nwant: %s", tt.re, have, tt.want)
+		}
+	}
+}
+
+func TestRegexpStackOverflow(t *testing.T) {
+	re, err := compileRegexp([]rune(`\(?:a\|b\)*c`), false, newStandardSyntaxTable())
+	if err != nil {
+		t.Fatalf("compile: unexpected error: %v", err)
+	}
+	text := make([]rune, reStackLimit)
+	for i := range text {
+		text[i] = 'a'
+	}
+	in := &reInput{text: text, end: len(text), point: -1}
+	_, err = re.match(in, 0, len(text))
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymError {
+		t.Errorf("expected stack overflow error, got %v", err)
+	}
+}
+
+func TestRegexpBacktrackLimit(t *testing.T) {
+	// Nested repeats backtrack exponentially
+	// while the stack stays shallow.
+	re, err := compileRegexp([]rune(`\(a*\)*b`), false, newStandardSyntaxTable())
+	if err != nil {
+		t.Fatalf("compile: unexpected error: %v", err)
+	}
+	text := []rune(strings.Repeat("a", 64))
+	in := &reInput{text: text, end: len(text), point: -1}
+	_, err = re.search(in, 0, len(text), len(text))
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymError {
+		t.Errorf("expected stack overflow error, got %v", err)
+	}
+
+	// The same text matches when b is there.
+	text = append(text, 'b')
+	in = &reInput{text: text, end: len(text), point: -1}
+	if caps, err := re.search(in, 0, len(text), len(text)); err != nil || caps == nil {
+		t.Errorf("search in %d chars: have %v, %v; want match", len(text), caps, err)
+	}
+}
+
+func TestRegexpRepeatRun(t *testing.T) {
+	// A run of postfix operators is a single repeat;
+	// nested repeats would backtrack exponentially.
+	tests := []struct {
+		re  string
+		str string
+	}{
+		{`a**b`, strings.Repeat("a", 22) + "c"},
+		{`0*++++++0++`, strings.Repeat("0", 40) + "x"},
+	}
+
+	env := newTestEnv()
+	for _, tt := range tests {
+		re := lisp.NewString([]byte(tt.re))
+		str := lisp.NewString([]byte(tt.str))
+		start := time.Now()
+		if _, err := env.stringMatch(&re, &str, &lisp.Nil); err != nil {
+			t.Errorf("(string-match %q %q): unexpected error: %v", tt.re, tt.str, err)
+		}
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Errorf("(string-match %q %q) took %v", tt.re, tt.str, d)
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"unicode"
+)
+
+// Regexp searching and match data.
+//
+// Match data is stored per Env as a list of group bounds.
+// For buffer searches, bounds are buffer positions;
+// for string searches, they are 0-based char indexes.
+// Issue#4
+
+// defineSearchSubrs defines the search and match data functions.
+func (env *MasterEnv) defineSearchSubrs(ob *lisp.Obarray) {
+	for _, subr := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"string-match", func(env *Env, regexp, str lisp.Object, start *lisp.Object) (lisp.Object, error) {
+			return env.stringMatch(&regexp, &str, optional(start))
+		}},
+		{"looking-at", func(env *Env, regexp lisp.Object) (lisp.Object, error) {
+			return env.lookingAt(&regexp)
+		}},
+		{"re-search-forward", func(env *Env, regexp lisp.Object, bound, noerror, count *lisp.Object) (lisp.Object, error) {
+			return env.reSearch(true, &regexp, optional(bound), optional(noerror), optional(count))
+		}},
+		{"re-search-backward", func(env *Env, regexp lisp.Object, bound, noerror, count *lisp.Object) (lisp.Object, error) {
+			return env.reSearch(false, &regexp, optional(bound), optional(noerror), optional(count))
+		}},
+		{"replace-match", func(env *Env, newtext lisp.Object, fixedcase, literal, str, subexp *lisp.Object) (lisp.Object, error) {
+			return env.replaceMatch(&newtext, optional(fixedcase), optional(literal), optional(str), optional(subexp))
+		}},
+		{"match-beginning", func(env *Env, subexp lisp.Object) (lisp.Object, error) {
+			return env.matchBound(&subexp, 0)
+		}},
+		{"match-end", func(env *Env, subexp lisp.Object) (lisp.Object, error) {
+			return env.matchBound(&subexp, 1)
+		}},
+		{"match-data", func(env *Env, integers, reuse, reseat *lisp.Object) lisp.Object {
+			return env.matchDataList()
+		}},
+		{"set-match-data", func(env *Env, list lisp.Object, reseat *lisp.Object) (lisp.Object, error) {
+			return env.setMatchData(&list)
+		}},
+	} {
+		env.DefineFunc(ob.Intern(subr.name), subr.fn)
+	}
+	// case-fold-search has no global value: unless it is set,
+	// the current buffer setting is used.
+	env.defineSpecial(SymCaseFoldSearch)
+}
+
+// regexpCacheSize is the number of compiled patterns kept by Env.
+const regexpCacheSize = 20
+
+// regexpCacheEntry is a compiled pattern along with
+// the parameters that affect compilation.
+type regexpCacheEntry struct {
+	pattern  string
+	caseFold bool
+	syntax   *lisp.SyntaxTable
+	re       *regexp
+}
+
+// compilePattern returns compiled pattern regexp.
+// `case-fold-search` and the current buffer syntax table are used.
+//
+// Recently used patterns are served from the cache.
+func (env *Env) compilePattern(pattern *lisp.Object) (*regexp, error) {
+	if pattern.Type != lisp.TypeString {
+		return nil, wrongTypeArgument(SymStringp, *pattern)
+	}
+	src := string(pattern.String().Chars)
+	caseFold := env.caseFoldSearch()
+	syntax := env.syntaxTable()
+
+	for i := range env.regexpCache {
+		e := &env.regexpCache[i]
+		if e.re != nil && e.pattern == src && e.caseFold == caseFold && e.syntax == syntax {
+			return e.re, nil
+		}
+	}
+
+	re, err := compileRegexp([]rune(src), caseFold, syntax)
+	if err != nil {
+		return nil, err
+	}
+	env.regexpCache[env.regexpCacheNext] = regexpCacheEntry{
+		pattern:  src,
+		caseFold: caseFold,
+		syntax:   syntax,
+		re:       re,
+	}
+	env.regexpCacheNext = (env.regexpCacheNext + 1) % regexpCacheSize
+	return re, nil
+}
+
+// caseFoldSearch returns `case-fold-search` value, respecting
+// dynamic bindings. If the variable is unbound, the current
+// buffer setting is returned.
+func (env *Env) caseFoldSearch() bool {
+	val, err := env.symbolValue(SymCaseFoldSearch)
+	if err != nil {
+		return env.buffer.CaseFoldSearch
+	}
+	return !lisp.Null(&val)
+}
+
+// bufferInput returns matcher input for buf accessible portion.
+// Text indexes are buffer positions minus 1.
+func (env *Env) bufferInput(buf *lisp.Buffer) *reInput {
+	return &reInput{
+		text:   buf.Substring(1, buf.Size()+1),
+		beg:    buf.PointMin() - 1,
+		end:    buf.PointMax() - 1,
+		point:  buf.Point() - 1,
+		syntax: env.syntaxTable(),
+	}
+}
+
+// stringInput returns matcher input for str chars.
+func (env *Env) stringInput(str []rune) *reInput {
+	return &reInput{
+		text:   str,
+		end:    len(str),
+		point:  -1,
+		syntax: env.syntaxTable(),
+	}
+}
+
+// setMatch stores regs as the new match data.
+// offset is added to all matched positions.
+func (env *Env) setMatch(regs []int, offset int) {
+	env.matchData = append(env.matchData[:0], regs...)
+	for i, pos := range env.matchData {
+		if pos >= 0 {
+			env.matchData[i] = pos + offset
+		}
+	}
+}
+
+// stringMatch implements `string-match`.
+// Returns the index of match start or nil.
+func (env *Env) stringMatch(regexp, str, start *lisp.Object) (lisp.Object, error) {
+	re, err := env.compilePattern(regexp)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if str.Type != lisp.TypeString {
+		return lisp.Nil, wrongTypeArgument(SymStringp, *str)
+	}
+	chars := []rune(string(str.String().Chars))
+	from, err := intArgOr(start, 0)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if from < 0 {
+		from += len(chars)
+	}
+	if from < 0 || from > len(chars) {
+		return lisp.Nil, signal(SymArgsOutOfRange, *str, *start)
+	}
+
+	regs, err := re.search(env.stringInput(chars), from, len(chars), len(chars))
+	if err != nil || regs == nil {
+		return lisp.Nil, err
+	}
+	env.setMatch(regs, 0)
+	return lisp.NewInt(int64(regs[0])), nil
+}
+
+// lookingAt implements `looking-at`.
+// Reports whether text after point matches regexp.
+func (env *Env) lookingAt(regexp *lisp.Object) (lisp.Object, error) {
+	re, err := env.compilePattern(regexp)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	buf := env.buffer
+	regs, err := re.match(env.bufferInput(buf), buf.Point()-1, buf.PointMax()-1)
+	if err != nil || regs == nil {
+		return lisp.Nil, err
+	}
+	env.setMatch(regs, 1)
+	return lisp.T, nil
+}
+
+// reSearch implements `re-search-forward` (forward=true)
+// and `re-search-backward` (forward=false).
+//
+// On success, point is moved to the end of the last match
+// (beginning, for backward search) and the new point is returned.
+func (env *Env) reSearch(forward bool, regexp, bound, noerror, count *lisp.Object) (lisp.Object, error) {
+	re, err := env.compilePattern(regexp)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	n, err := intArgOr(count, 1)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if !forward {
+		n = -n
+	}
+
+	buf := env.buffer
+	lim := buf.PointMax()
+	if n < 0 {
+		lim = buf.PointMin()
+	}
+	if !lisp.Null(bound) {
+		lim, err = intArgOr(bound, 0)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		if (n > 0 && lim < buf.Point()) || (n < 0 && lim > buf.Point()) {
+			return lisp.Nil, signal(SymError, lisp.NewString([]byte(
+				"Invalid search bound (wrong side of point)")))
+		}
+		if lim > buf.PointMax() {
+			lim = buf.PointMax()
+		}
+		if lim < buf.PointMin() {
+			lim = buf.PointMin()
+		}
+	}
+
+	in := env.bufferInput(buf)
+	pos := buf.Point() - 1
+	for ; n != 0; n -= sign(n) {
+		var regs []int
+		if n > 0 {
+			regs, err = re.search(in, pos, lim-1, lim-1)
+		} else {
+			regs, err = re.search(in, pos, lim-1, pos)
+		}
+		if err != nil {
+			return lisp.Nil, err
+		}
+		if regs == nil {
+			switch {
+			case lisp.Null(noerror):
+				return lisp.Nil, signal(SymSearchFailed, *regexp)
+			case !lisp.Eq(noerror, &lisp.T):
+				buf.SetPoint(lim)
+			}
+			return lisp.Nil, nil
+		}
+		env.setMatch(regs, 1)
+		if n > 0 {
+			pos = regs[1]
+		} else {
+			pos = regs[0]
+		}
+	}
+
+	buf.SetPoint(pos + 1)
+	return lisp.NewInt(int64(pos + 1)), nil
+}
+
+// sign returns -1 for negative x and 1 otherwise.
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	}
+	return 1
+}
+
+// matchGroupArg returns x as match data group index.
+// Signals if there is no match data at all.
+func (env *Env) matchGroupArg(x *lisp.Object) (int, error) {
+	if x.Type != lisp.TypeInt {
+		return 0, wrongTypeArgument(SymIntegerp, *x)
+	}
+	if x.Int() < 0 {
+		return 0, signal(SymArgsOutOfRange, *x, lisp.NewInt(0))
+	}
+	if len(env.matchData) == 0 {
+		return 0, signal(SymError, lisp.NewString([]byte(
+			"No match data, because no search succeeded")))
+	}
+	return int(x.Int()), nil
+}
+
+// matchBound implements `match-beginning` (end=0)
+// and `match-end` (end=1).
+// Returns nil for groups that did not match.
+func (env *Env) matchBound(subexp *lisp.Object, end int) (lisp.Object, error) {
+	n, err := env.matchGroupArg(subexp)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if n*2 >= len(env.matchData) || env.matchData[n*2] < 0 {
+		return lisp.Nil, nil
+	}
+	return lisp.NewInt(int64(env.matchData[n*2+end])), nil
+}
+
+// matchDataList implements `match-data`.
+// Returns a list of group bounds; trailing unmatched groups
+// are omitted, others are represented by nil pairs.
+func (env *Env) matchDataList() lisp.Object {
+	regs := env.matchData
+	for len(regs) != 0 && regs[len(regs)-2] < 0 {
+		regs = regs[:len(regs)-2]
+	}
+	vals := make([]lisp.Object, len(regs))
+	for i, pos := range regs {
+		if pos < 0 {
+			vals[i] = lisp.Nil
+		} else {
+			vals[i] = lisp.NewInt(int64(pos))
+		}
+	}
+	return env.List(vals...)
+}
+
+// setMatchData implements `set-match-data`.
+// list has the format returned by matchDataList.
+//
+// Together with matchDataList, it makes `save-match-data`
+// possible.
+func (env *Env) setMatchData(list *lisp.Object) (lisp.Object, error) {
+	var regs []int
+	for x := *list; !lisp.Null(&x); {
+		if x.Type != lisp.TypeCons {
+			return lisp.Nil, wrongTypeArgument(SymListp, *list)
+		}
+		pos := x.Cons().Car
+		switch {
+		case lisp.Null(&pos):
+			regs = append(regs, -1)
+		case pos.Type == lisp.TypeInt:
+			regs = append(regs, int(pos.Int()))
+		default:
+			return lisp.Nil, wrongTypeArgument(SymIntegerp, pos)
+		}
+		x = x.Cons().Cdr
+	}
+	if len(regs)%2 != 0 {
+		regs = regs[:len(regs)-1]
+	}
+	for i := 0; i < len(regs); i += 2 {
+		if regs[i] < 0 || regs[i+1] < 0 {
+			regs[i], regs[i+1] = -1, -1
+		}
+	}
+	env.matchData = regs
+	return lisp.Nil, nil
+}
+
+// saveMatchData implements `save-match-data`.
+// Match data is restored after body returns, even on error.
+func (env *Env) saveMatchData(body func() error) error {
+	saved := append([]int(nil), env.matchData...)
+	defer func() { env.matchData = saved }()
+	return body()
+}
+
+// replaceCase is a case conversion that is applied
+// to the replacement text.
+type replaceCase int
+
+const (
+	replaceNoChange replaceCase = iota
+	replaceAllCaps
+	replaceCapInitial
+)
+
+// matchCase decides how replacement should be converted to follow
+// the case pattern of the replaced text, like Emacs does.
+func matchCase(st *lisp.SyntaxTable, text []rune) replaceCase {
+	someMultiletterWord := false
+	someLowercase := false
+	someUppercase := false
+	someNonuppercaseInitial := false
+
+	prev := '\n'
+	for _, c := range text {
+		prevWord := st.Class(prev) == lisp.SyntaxWord
+		switch {
+		case unicode.IsLower(c):
+			someLowercase = true
+			if prevWord {
+				someMultiletterWord = true
+			} else {
+				someNonuppercaseInitial = true
+			}
+		case unicode.IsUpper(c):
+			someUppercase = true
+			if prevWord {
+				someMultiletterWord = true
+			}
+		default:
+			// Caseless word constituent initial is
+			// treated like a lowercase initial.
+			if !prevWord && st.Class(c) == lisp.SyntaxWord {
+				someNonuppercaseInitial = true
+			}
+		}
+		prev = c
+	}
+
+	switch {
+	case !someLowercase && someMultiletterWord:
+		return replaceAllCaps
+	case !someNonuppercaseInitial && someMultiletterWord:
+		return replaceCapInitial
+	case !someNonuppercaseInitial && someUppercase:
+		return replaceAllCaps
+	default:
+		return replaceNoChange
+	}
+}
+
+// applyCase converts text according to conv.
+func applyCase(st *lisp.SyntaxTable, text []rune, conv replaceCase) {
+	switch conv {
+	case replaceAllCaps:
+		for i, c := range text {
+			text[i] = unicode.ToUpper(c)
+		}
+	case replaceCapInitial:
+		inWord := false
+		for i, c := range text {
+			if !inWord {
+				text[i] = unicode.ToUpper(c)
+			}
+			inWord = st.Class(c) == lisp.SyntaxWord
+		}
+	}
+}
+
+// expandReplacement substitutes "\&", "\N" and "\\" inside newtext.
+// group returns the text of the specified match group.
+func expandReplacement(newtext []rune, sub int, group func(n int) []rune) ([]rune, error) {
+	var out []rune
+	for i := 0; i < len(newtext); i++ {
+		c := newtext[i]
+		if c != '\\' || i+1 == len(newtext) {
+			out = append(out, c)
+			continue
+		}
+		i++
+		switch c = newtext[i]; {
+		case c == '&':
+			out = append(out, group(sub)...)
+		case c >= '1' && c <= '9':
+			out = append(out, group(int(c-'0'))...)
+		case c == '\\':
+			out = append(out, '\\')
+		case c == '?':
+			out = append(out, '\\', '?')
+		default:
+			return nil, signal(SymError, lisp.NewString([]byte(
+				"Invalid use of `\\' in replacement text")))
+		}
+	}
+	return out, nil
+}
+
+// replaceMatch implements `replace-match`.
+//
+// If str is nil, matched text of the current buffer is replaced,
+// point is moved to the end of the replacement and nil is returned.
+// Otherwise, a new string with the replacement is returned.
+func (env *Env) replaceMatch(newtext, fixedcase, literal, str, subexp *lisp.Object) (lisp.Object, error) {
+	if newtext.Type != lisp.TypeString {
+		return lisp.Nil, wrongTypeArgument(SymStringp, *newtext)
+	}
+	if len(env.matchData) == 0 {
+		return lisp.Nil, signal(SymError, lisp.NewString([]byte(
+			"`replace-match' called before any match found")))
+	}
+	sub, err := intArgOr(subexp, 0)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	nregs := len(env.matchData) / 2
+	if sub < 0 || sub >= nregs || env.matchData[sub*2] < 0 {
+		if lisp.Null(subexp) {
+			return lisp.Nil, signal(SymError, lisp.NewString([]byte(
+				"`replace-match' called before any match found")))
+		}
+		return lisp.Nil, signal(SymArgsOutOfRange, *subexp, lisp.NewInt(int64(nregs)))
+	}
+
+	start, end := env.matchData[sub*2], env.matchData[sub*2+1]
+	buf := env.buffer
+
+	// text returns chars between match data positions.
+	var text func(start, end int) []rune
+	var chars []rune
+	if lisp.Null(str) {
+		if start < buf.PointMin() || start > end || end > buf.PointMax() {
+			return lisp.Nil, signal(SymArgsOutOfRange,
+				lisp.NewInt(int64(start)), lisp.NewInt(int64(end)))
+		}
+		text = func(start, end int) []rune {
+			return buf.Substring(start, end)
+		}
+	} else {
+		if str.Type != lisp.TypeString {
+			return lisp.Nil, wrongTypeArgument(SymStringp, *str)
+		}
+		chars = []rune(string(str.String().Chars))
+		if start < 0 || start > end || end > len(chars) {
+			return lisp.Nil, signal(SymArgsOutOfRange,
+				lisp.NewInt(int64(start)), lisp.NewInt(int64(end)))
+		}
+		text = func(start, end int) []rune {
+			return chars[start:end]
+		}
+	}
+
+	replacement := []rune(string(newtext.String().Chars))
+	if lisp.Null(literal) {
+		replacement, err = expandReplacement(replacement, sub, func(n int) []rune {
+			if n >= nregs || env.matchData[n*2] < 0 {
+				return nil
+			}
+			return text(env.matchData[n*2], env.matchData[n*2+1])
+		})
+		if err != nil {
+			return lisp.Nil, err
+		}
+	}
+	if lisp.Null(fixedcase) {
+		st := env.syntaxTable()
+		applyCase(st, replacement, matchCase(st, text(start, end)))
+	}
+
+	if !lisp.Null(str) {
+		result := make([]rune, 0, len(chars)-(end-start)+len(replacement))
+		result = append(result, chars[:start]...)
+		result = append(result, replacement...)
+		result = append(result, chars[end:]...)
+		return lisp.NewString([]byte(string(result))), nil
+	}
+
+	buf.Delete(start, end)
+	buf.SetPoint(start)
+	buf.Insert(replacement)
+
+	// Adjust match data for the changed text.
+	newEnd := start + len(replacement)
+	change := newEnd - end
+	for i, pos := range env.matchData {
+		switch {
+		case pos >= end:
+			env.matchData[i] = pos + change
+		case pos > start:
+			env.matchData[i] = start
+		}
+	}
+	return lisp.Nil, nil
+}
@@ -0,0 +1,364 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+func TestLookingAt(t *testing.T) {
+	tests := []struct {
+		before string
+		re     string
+		want   string
+		match  string
+	}{
+		{"|foo bar", `fo+`, "t", "(1 4)"},
+		{"foo |bar", `fo+`, "nil", ""},
+		{"foo |bar", `\(b\)ar\'`, "t", "(5 8 5 6)"},
+		{"foo |bar", `\=bar`, "t", "(5 8)"},
+		{"foo |bar", `^bar`, "nil", ""},
+		{"foo\n|bar", `^bar$`, "t", "(5 8)"},
+	}
+
+	for _, tt := range tests {
+		env := newTestEnv()
+		env.buffer = newTestBuffer(tt.before)
+		re := lisp.NewString([]byte(tt.re))
+		res, err := env.lookingAt(&re)
+		if err != nil {
+			t.Errorf("%q (looking-at %q): unexpected error: %v", tt.before, tt.re, err)
+			continue
+		}
+		if have := lisp.ObjectString(res); have != tt.want {
+			t.Errorf("%q (looking-at %q):\nhave: %s\nwant: %s",
+				tt.before, tt.re, have, tt.want)
+		}
+		if tt.match == "" {
+			continue
+		}
+		if have := matchDataString(env.matchData); have != tt.match {
+			t.Errorf("%q (looking-at %q) match data:\nhave: %s\nwant: %s",
+				tt.before, tt.re, have, tt.match)
+		}
+	}
+}
+
+func TestReSearch(t *testing.T) {
+	tests := []struct {
+		before  string
+		forward bool
+		re      string
+		bound   lisp.Object
+		noerror lisp.Object
+		count   lisp.Object
+		after   string
+		result  string
+	}{
+		{"|ab ab ab", true, `ab`, lisp.Nil, lisp.Nil, lisp.Nil, "ab| ab ab", "3"},
+		{"|ab ab ab", true, `ab`, lisp.Nil, lisp.Nil, lisp.NewInt(2), "ab ab| ab", "6"},
+		{"|ab ab ab", true, `ab`, lisp.Nil, lisp.T, lisp.NewInt(-1), "|ab ab ab", "nil"},
+		{"ab |ab ab", true, `^ab`, lisp.Nil, lisp.T, lisp.Nil, "ab |ab ab", "nil"},
+		{"ab |ab ab", true, `x`, lisp.Nil, lisp.NewInt(0), lisp.Nil, "ab ab ab|", "nil"},
+		{"ab |ab ab", true, `ab`, lisp.NewInt(5), lisp.T, lisp.Nil, "ab |ab ab", "nil"},
+		{"ab |ab ab", true, `ab`, lisp.NewInt(6), lisp.Nil, lisp.Nil, "ab ab| ab", "6"},
+		{"ab |ab ab", true, `a*`, lisp.Nil, lisp.Nil, lisp.Nil, "ab a|b ab", "5"},
+		{"ab ab ab|", false, `ab`, lisp.Nil, lisp.Nil, lisp.Nil, "ab ab |ab", "7"},
+		{"ab ab ab|", false, `ab`, lisp.Nil, lisp.Nil, lisp.NewInt(3), "|ab ab ab", "1"},
+		{"ab ab a|b", false, `ab`, lisp.Nil, lisp.Nil, lisp.Nil, "ab |ab ab", "4"},
+		{"ab ab |ab", false, `b \w`, lisp.Nil, lisp.Nil, lisp.Nil, "a|b ab ab", "2"},
+		{"ab ab a|b", false, `x`, lisp.Nil, lisp.NewInt(0), lisp.Nil, "|ab ab ab", "nil"},
+		{"ab ab a|b", false, `x`, lisp.NewInt(3), lisp.NewInt(0), lisp.Nil, "ab| ab ab", "nil"},
+	}
+
+	for i, tt := range tests {
+		env := newTestEnv()
+		env.buffer = newTestBuffer(tt.before)
+		re := lisp.NewString([]byte(tt.re))
+		res, err := env.reSearch(tt.forward, &re, &tt.bound, &tt.noerror, &tt.count)
+		if err != nil {
+			t.Errorf("re-search test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if have := bufferString(env.buffer); have != tt.after {
+			t.Errorf("re-search test %d: buffer mismatch:\nhave: %q\nwant: %q",
+				i, have, tt.after)
+		}
+		if have := lisp.ObjectString(res); have != tt.result {
+			t.Errorf("re-search test %d: result mismatch:\nhave: %s\nwant: %s",
+				i, have, tt.result)
+		}
+	}
+}
+
+func TestReSearchErrors(t *testing.T) {
+	env := newTestEnv()
+	env.buffer = newTestBuffer("ab |ab")
+	re := lisp.NewString([]byte(`x`))
+	_, err := env.reSearch(true, &re, &lisp.Nil, &lisp.Nil, &lisp.Nil)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymSearchFailed {
+		t.Errorf("search failure: expected search-failed, got %v", err)
+	}
+	bound := lisp.NewInt(2)
+	_, err = env.reSearch(true, &re, &bound, &lisp.Nil, &lisp.Nil)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymError {
+		t.Errorf("invalid bound: expected error, got %v", err)
+	}
+}
+
+func TestReSearchNarrowed(t *testing.T) {
+	env := newTestEnv()
+	env.buffer = newTestBuffer("xab ab abx")
+	env.buffer.Narrow(2, 10)
+	env.buffer.SetPoint(2)
+
+	re := lisp.NewString([]byte(`b\'`))
+	res, err := env.reSearch(true, &re, &lisp.Nil, &lisp.Nil, &lisp.Nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have := lisp.ObjectString(res); have != "10" {
+		t.Errorf("search for buffer end: have %s, want 10", have)
+	}
+
+	re = lisp.NewString([]byte("\\`a"))
+	res, err = env.reSearch(false, &re, &lisp.Nil, &lisp.Nil, &lisp.Nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have := lisp.ObjectString(res); have != "2" {
+		t.Errorf("search for buffer start: have %s, want 2", have)
+	}
+}
+
+func TestReplaceMatch(t *testing.T) {
+	tests := []struct {
+		re        string
+		str       string
+		newtext   string
+		fixedcase bool
+		literal   bool
+		subexp    lisp.Object
+		want      string
+	}{
+		{`b+`, "abbc", "x", false, false, lisp.Nil, "axc"},
+		{`\(b+\)\(c\)`, "abbcd", `<\2\1\&>`, false, false, lisp.Nil, "a<cbbbbc>d"},
+		{`\(b+\)\(x\)?`, "abbc", `[\2]`, false, false, lisp.Nil, "a[]c"},
+		{`b`, "abc", `\\`, false, false, lisp.Nil, `a\c`},
+		{`b`, "abc", `\?`, false, false, lisp.Nil, `a\?c`},
+		{`b`, "abc", `\&\1`, false, true, lisp.Nil, `a\&\1c`},
+		{`a\(b+\)c`, "abbc", `x`, false, false, lisp.NewInt(1), "axc"},
+		{`a\(b+\)c`, "abbc", `\&\&`, false, false, lisp.NewInt(1), "abbbbc"},
+
+		// Case conversion.
+		{`foo`, "FOO bar", "baz", false, false, lisp.Nil, "BAZ bar"},
+		{`foo`, "Foo bar", "baz qux", false, false, lisp.Nil, "Baz Qux bar"},
+		{`foo`, "foo bar", "Baz", false, false, lisp.Nil, "Baz bar"},
+		{`foo`, "FOO bar", "baz", true, false, lisp.Nil, "baz bar"},
+		{`f`, "F bar", "baz", false, false, lisp.Nil, "BAZ bar"},
+	}
+
+	for _, tt := range tests {
+		env := newTestEnv()
+		env.buffer.CaseFoldSearch = true
+		re := lisp.NewString([]byte(tt.re))
+		str := lisp.NewString([]byte(tt.str))
+		if _, err := env.stringMatch(&re, &str, &lisp.Nil); err != nil {
+			t.Errorf("(string-match %q %q): unexpected error: %v", tt.re, tt.str, err)
+			continue
+		}
+		newtext := lisp.NewString([]byte(tt.newtext))
+		fixedcase := lisp.Bool(tt.fixedcase)
+		literal := lisp.Bool(tt.literal)
+
+		res, err := env.replaceMatch(&newtext, &fixedcase, &literal, &str, &tt.subexp)
+		if err != nil {
+			t.Errorf("replace %q by %q: unexpected error: %v", tt.re, tt.newtext, err)
+			continue
+		}
+		if have := string(res.String().Chars); have != tt.want {
+			t.Errorf("replace %q by %q in string:\nhave: %q\nwant: %q",
+				tt.re, tt.newtext, have, tt.want)
+		}
+
+		// Same replacement inside a buffer should give identical results.
+		env.buffer = newTestBuffer(tt.str)
+		if _, err := env.reSearch(true, &re, &lisp.Nil, &lisp.Nil, &lisp.Nil); err != nil {
+			t.Errorf("(re-search-forward %q): unexpected error: %v", tt.re, err)
+			continue
+		}
+		if _, err := env.replaceMatch(&newtext, &fixedcase, &literal, &lisp.Nil, &tt.subexp); err != nil {
+			t.Errorf("replace %q by %q: unexpected error: %v", tt.re, tt.newtext, err)
+			continue
+		}
+		if have := env.buffer.Text(); have != tt.want {
+			t.Errorf("replace %q by %q in buffer:\nhave: %q\nwant: %q",
+				tt.re, tt.newtext, have, tt.want)
+		}
+	}
+}
+
+func TestReplaceMatchBuffer(t *testing.T) {
+	env := newTestEnv()
+	env.buffer = newTestBuffer("|x foo(bar) y")
+	re := lisp.NewString([]byte(`\(\w+\)(\(\w+\))`))
+	if _, err := env.reSearch(true, &re, &lisp.Nil, &lisp.Nil, &lisp.Nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newtext := lisp.NewString([]byte("quux"))
+	subexp := lisp.NewInt(1)
+	if _, err := env.replaceMatch(&newtext, &lisp.T, &lisp.Nil, &lisp.Nil, &subexp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have, want := bufferString(env.buffer), "x quux|(bar) y"; have != want {
+		t.Errorf("buffer mismatch:\nhave: %q\nwant: %q", have, want)
+	}
+	// Match data is adjusted to the replaced text.
+	if have, want := matchDataString(env.matchData), "(3 12 3 7 8 11)"; have != want {
+		t.Errorf("match data mismatch:\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestReplaceMatchErrors(t *testing.T) {
+	env := newTestEnv()
+	newtext := lisp.NewString([]byte("x"))
+	str := lisp.NewString([]byte("abc"))
+	_, err := env.replaceMatch(&newtext, &lisp.Nil, &lisp.Nil, &str, &lisp.Nil)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymError {
+		t.Errorf("replace without match: expected error, got %v", err)
+	}
+
+	re := lisp.NewString([]byte(`b\(x\)?`))
+	if _, err := env.stringMatch(&re, &str, &lisp.Nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	subexp := lisp.NewInt(1)
+	_, err = env.replaceMatch(&newtext, &lisp.Nil, &lisp.Nil, &str, &subexp)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymArgsOutOfRange {
+		t.Errorf("unmatched subexp: expected args-out-of-range, got %v", err)
+	}
+	newtext = lisp.NewString([]byte(`\x`))
+	_, err = env.replaceMatch(&newtext, &lisp.Nil, &lisp.Nil, &str, &lisp.Nil)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymError {
+		t.Errorf("invalid backslash: expected error, got %v", err)
+	}
+}
+
+func TestMatchData(t *testing.T) {
+	env := newTestEnv()
+	zero := lisp.NewInt(0)
+	if _, err := env.matchBound(&zero, 0); err == nil {
+		t.Errorf("match-beginning without match data: expected error")
+	}
+
+	re := lisp.NewString([]byte(`\(a\)\(x\)?\(b\)\(y\)?`))
+	str := lisp.NewString([]byte("_ab"))
+	if _, err := env.stringMatch(&re, &str, &lisp.Nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	list := env.matchDataList()
+	if have, want := lisp.ObjectString(list), "(1 . (3 . (1 . (2 . (nil . (nil . (2 . (3 . nil))))))))"; have != want {
+		t.Errorf("match-data:\nhave: %s\nwant: %s", have, want)
+	}
+
+	// save-match-data restores match data even if body fails.
+	err := env.saveMatchData(func() error {
+		str := lisp.NewString([]byte("ab"))
+		if _, err := env.stringMatch(&re, &str, &lisp.Nil); err != nil {
+			return err
+		}
+		return signal(SymError)
+	})
+	if err == nil {
+		t.Errorf("save-match-data: expected body error")
+	}
+	if have, want := matchDataString(env.matchData), "(1 3 1 2 nil nil 2 3)"; have != want {
+		t.Errorf("saved match data:\nhave: %s\nwant: %s", have, want)
+	}
+
+	env.matchData = nil
+	if _, err := env.setMatchData(&list); err != nil {
+		t.Fatalf("set-match-data: unexpected error: %v", err)
+	}
+	if have, want := matchDataString(env.matchData), "(1 3 1 2 nil nil 2 3)"; have != want {
+		t.Errorf("set-match-data:\nhave: %s\nwant: %s", have, want)
+	}
+	bad := lisp.NewInt(1)
+	if _, err := env.setMatchData(&bad); err == nil {
+		t.Errorf("set-match-data with non-list: expected error")
+	}
+}
+
+func TestEvalMatchBounds(t *testing.T) {
+	interp := newTestInterpreter(t)
+	interp.buffer = newTestBuffer("|foo bar")
+	re := lisp.NewString([]byte(`\(o+\) \(x\)?`))
+	if _, err := interp.reSearch(true, &re, &lisp.Nil, &lisp.Nil, &lisp.Nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type (
+		consts []interface{}
+		steps  []interface{}
+	)
+	interp.LoadSteps(steps{
+		OpConstant0, `0`,
+		OpMatchBeginning, `2`,
+		OpConstant1, `2 1`,
+		OpMatchEnd, `2 4`,
+		OpConstant2, `2 4 2`,
+		OpMatchEnd, `2 4 nil`,
+	})
+	interp.Run("MatchBounds", promoteObjects(consts{0, 1, 2}), nil)
+}
+
+func TestSearchSubrs(t *testing.T) {
+	env, ob := newLispEnv()
+	env.buffer = newTestBuffer("|foo bar baz")
+
+	tests := []struct {
+		form string
+		want string
+	}{
+		{`(string-match "b\\(a\\)r" "foo bar")`, "4"},
+		{"(match-data)", "(4 7 5 6)"},
+		{"(list (match-beginning 1) (match-end 0) (match-end 2))", "(5 7 nil)"},
+		{`(string-match "x" "foo")`, "nil"},
+		{`(string-match "o" "foo" 2)`, "2"},
+		{`(replace-match "0" nil nil "foo")`, `"fo0"`},
+		{`(looking-at "fo+")`, "t"},
+		{`(looking-at "bar")`, "nil"},
+		{`(re-search-forward "ba." nil nil 2)`, "12"},
+		{"(match-data)", "(9 12)"},
+		{`(re-search-backward "\\(b\\)ar")`, "5"},
+		{`(replace-match "Q\\1" t)`, "nil"},
+		{`(re-search-backward "Qb" nil t)`, "5"},
+		{`(re-search-forward "none" nil t)`, "nil"},
+		{`(re-search-forward "none" 1)`, "Invalid search bound (wrong side of point)"},
+		{`(re-search-forward "none")`, `Search failed: "none"`},
+		{"(progn (set-match-data '(1 2)) (match-data))", "(1 2)"},
+		{`(save-match-data (string-match "z" "baz") (match-data))`, "(2 3)"},
+		{"(match-data)", "(1 2)"},
+		{`(condition-case nil (save-match-data (set-match-data '(3 4)) (car 1)) (error (match-data)))`, "(1 2)"},
+		{`(string-match "[" "x")`, "Invalid regexp: \"Unmatched [ or [^\""},
+		{`(string-match "A" "a")`, "0"},
+		{`(let ((case-fold-search nil)) (string-match "A" "a"))`, "nil"},
+		{`(let ((case-fold-search t)) (string-match "A" "a"))`, "0"},
+		{`(funcall (byte-compile (lambda () (let ((case-fold-search nil)) (string-match "A" "a")))))`, "nil"},
+		{`(let ((case-fold-search nil)) (looking-at "QB"))`, "nil"},
+		{`(looking-at "QB")`, "t"},
+	}
+	for _, test := range tests {
+		form := mustRead(t, test.form, ob)
+		val, err := env.Eval(form, lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+	if have, want := bufferString(env.buffer), "foo |Qb baz"; have != want {
+		t.Errorf("buffer: have %q, want %q", have, want)
+	}
+}
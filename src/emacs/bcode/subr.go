@@ -0,0 +1,438 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Primitive functions.
+//
+// These are the functions that the interpreter and
+// loaded files need for function calls, non-local exits,
+// definitions and basic list and number handling.
+
+// DefineSubrs defines primitive functions and the macros
+// that are implemented in Go; their symbols are interned into ob.
+func (env *MasterEnv) DefineSubrs(ob *lisp.Obarray) {
+	for _, subr := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"funcall", func(env *Env, fn lisp.Object, args ...lisp.Object) (lisp.Object, error) {
+			return env.Funcall(fn, args...)
+		}},
+		{"apply", func(env *Env, fn lisp.Object, args ...lisp.Object) (lisp.Object, error) {
+			return env.Apply(fn, args...)
+		}},
+		{"eval", func(env *Env, form lisp.Object, lexical *lisp.Object) (lisp.Object, error) {
+			if lexical == nil {
+				lexical = &lisp.Nil
+			}
+			return env.Eval(form, *lexical)
+		}},
+		{"byte-code", func(env *Env, code, consts, depth lisp.Object) (lisp.Object, error) {
+			fn, err := makeByteCode([]lisp.Object{lisp.NewInt(0), code, consts, depth}, true)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			return env.Exec(fn)
+		}},
+		{"byte-compile", func(env *Env, form lisp.Object) (lisp.Object, error) {
+			def := form
+			if form.Type == lisp.TypeSymbol {
+				def = env.SymbolFunction(form)
+			}
+			if def.Type != lisp.TypeCons {
+				// Already compiled or not a function at all.
+				return def, nil
+			}
+			fn, err := env.Compile(def)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			if form.Type == lisp.TypeSymbol {
+				env.Fset(form, fn.Object())
+			}
+			return fn.Object(), nil
+		}},
+		{"make-closure", func(prototype lisp.Object, vars ...lisp.Object) (lisp.Object, error) {
+			if prototype.Type != lisp.TypeFunc {
+				return lisp.Nil, wrongTypeArgument(SymByteCodeFunctionp, prototype)
+			}
+			fn, err := makeClosure(objectFunc(&prototype), vars)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			return fn.Object(), nil
+		}},
+		{"macroexpand", func(env *Env, form lisp.Object, environment *lisp.Object) (lisp.Object, error) {
+			if environment == nil {
+				environment = &lisp.Nil
+			}
+			return env.MacroExpand(form, *environment)
+		}},
+		{"macroexpand-1", func(env *Env, form lisp.Object, environment *lisp.Object) (lisp.Object, error) {
+			if environment == nil {
+				environment = &lisp.Nil
+			}
+			return env.MacroExpand1(form, *environment)
+		}},
+		{"macroexpand-all", func(env *Env, form lisp.Object, environment *lisp.Object) (lisp.Object, error) {
+			if environment == nil {
+				environment = &lisp.Nil
+			}
+			return env.MacroExpandAll(form, *environment)
+		}},
+		{"signal", func(sym, data lisp.Object) error {
+			return &Signal{Symbol: sym, Data: data}
+		}},
+		{"throw", func(env *Env, tag, val lisp.Object) error {
+			if !env.catching(tag) {
+				return signal(SymNoCatch, tag, val)
+			}
+			return &Throw{Tag: tag, Value: val}
+		}},
+
+		{"defalias", func(env *Env, sym, def lisp.Object, doc *lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			env.Fset(sym, def)
+			return sym, nil
+		}},
+		{"fset", func(env *Env, sym, def lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			env.Fset(sym, def)
+			return def, nil
+		}},
+		{"symbol-function", func(env *Env, sym lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			return env.SymbolFunction(sym), nil
+		}},
+		{"fboundp", func(env *Env, sym lisp.Object) (bool, error) {
+			if err := checkSymbol(sym); err != nil {
+				return false, err
+			}
+			fn := env.SymbolFunction(sym)
+			return !lisp.Null(&fn), nil
+		}},
+		{"symbol-value", func(env *Env, sym lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			return env.symbolValue(sym)
+		}},
+		{"set", func(env *Env, sym, val lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			if _, ok := constantValue(sym); ok {
+				return lisp.Nil, signal(SymSettingConstant, sym)
+			}
+			env.setSymbolValue(sym, val)
+			return val, nil
+		}},
+		{"boundp", func(env *Env, sym lisp.Object) (bool, error) {
+			if err := checkSymbol(sym); err != nil {
+				return false, err
+			}
+			_, err := env.symbolValue(sym)
+			return err == nil, nil
+		}},
+		{"special-variable-p", func(env *Env, sym lisp.Object) (bool, error) {
+			if err := checkSymbol(sym); err != nil {
+				return false, err
+			}
+			return env.declaredSpecial(sym), nil
+		}},
+		{"get", func(env *Env, sym, prop lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			return env.get(sym, prop), nil
+		}},
+		{"put", func(env *Env, sym, prop, val lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			env.put(sym, prop, val)
+			return val, nil
+		}},
+		{"function-get", func(env *Env, sym, prop lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			return env.get(sym, prop), nil
+		}},
+		{"function-put", func(env *Env, sym, prop, val lisp.Object) (lisp.Object, error) {
+			if err := checkSymbol(sym); err != nil {
+				return lisp.Nil, err
+			}
+			env.put(sym, prop, val)
+			return val, nil
+		}},
+		{"provide", func(feature lisp.Object, subfeatures *lisp.Object) lisp.Object {
+			return feature
+		}},
+		{"require", func(feature lisp.Object, filename, noerror *lisp.Object) lisp.Object {
+			return feature
+		}},
+
+		{"cons", func(env *Env, car, cdr lisp.Object) lisp.Object {
+			return env.NewCons(car, cdr)
+		}},
+		{"list", func(env *Env, args ...lisp.Object) lisp.Object {
+			return env.List(args...)
+		}},
+		{"append", func(env *Env, seqs ...lisp.Object) (lisp.Object, error) {
+			if len(seqs) == 0 {
+				return lisp.Nil, nil
+			}
+			var xs []lisp.Object
+			for _, seq := range seqs[:len(seqs)-1] {
+				switch {
+				case seq.Type == lisp.TypeVector:
+					xs = append(xs, seq.Vector().Vals...)
+				case seq.Type == lisp.TypeCons || lisp.Null(&seq):
+					xs = append(xs, listSlice(seq)...)
+				default:
+					return lisp.Nil, wrongTypeArgument(SymListp, seq)
+				}
+			}
+			list := seqs[len(seqs)-1]
+			for i := len(xs) - 1; i >= 0; i-- {
+				list = env.NewCons(xs[i], list)
+			}
+			return list, nil
+		}},
+		{"vector", func(args ...lisp.Object) lisp.Object {
+			return lisp.NewVector(append([]lisp.Object(nil), args...))
+		}},
+		{"car", func(x lisp.Object) (lisp.Object, error) {
+			return car(x)
+		}},
+		{"cdr", func(x lisp.Object) (lisp.Object, error) {
+			return cdr(x)
+		}},
+		{"setcar", func(cell, newcar lisp.Object) (lisp.Object, error) {
+			return setcar(cell, newcar)
+		}},
+		{"eq", func(x, y lisp.Object) bool {
+			return lisp.Eq(&x, &y)
+		}},
+		{"null", func(x lisp.Object) bool {
+			return lisp.Null(&x)
+		}},
+		{"not", func(x lisp.Object) bool {
+			return lisp.Null(&x)
+		}},
+		{"type-of", func(x lisp.Object) lisp.Object {
+			return typeOf(&x)
+		}},
+		{"user-ptrp", func(x lisp.Object) lisp.Object {
+			return userPtrp(&x)
+		}},
+
+		{"+", func(args ...lisp.Object) (lisp.Object, error) {
+			return arith(opAdd, args)
+		}},
+		{"-", func(args ...lisp.Object) (lisp.Object, error) {
+			if len(args) == 1 {
+				return arith(opSub, []lisp.Object{lisp.NewInt(0), args[0]})
+			}
+			return arith(opSub, args)
+		}},
+		{"*", func(args ...lisp.Object) (lisp.Object, error) {
+			return arith(opMul, args)
+		}},
+		{"1+", func(x lisp.Object) (lisp.Object, error) {
+			return arith(opAdd, []lisp.Object{x, lisp.NewInt(1)})
+		}},
+		{"1-", func(x lisp.Object) (lisp.Object, error) {
+			return arith(opSub, []lisp.Object{x, lisp.NewInt(1)})
+		}},
+		{"=", func(x lisp.Object, ys ...lisp.Object) (bool, error) {
+			return compare(x, ys, func(c int) bool { return c == 0 })
+		}},
+		{"<", func(x lisp.Object, ys ...lisp.Object) (bool, error) {
+			return compare(x, ys, func(c int) bool { return c < 0 })
+		}},
+		{">", func(x lisp.Object, ys ...lisp.Object) (bool, error) {
+			return compare(x, ys, func(c int) bool { return c > 0 })
+		}},
+		{"<=", func(x lisp.Object, ys ...lisp.Object) (bool, error) {
+			return compare(x, ys, func(c int) bool { return c <= 0 })
+		}},
+		{">=", func(x lisp.Object, ys ...lisp.Object) (bool, error) {
+			return compare(x, ys, func(c int) bool { return c >= 0 })
+		}},
+	} {
+		env.DefineFunc(ob.Intern(subr.name), subr.fn)
+	}
+	env.defineBufferSubrs(ob)
+	env.defineOutputSubrs(ob)
+	env.defineSearchSubrs(ob)
+	env.defineSyntaxSubrs(ob)
+	env.defineMacros(ob)
+	env.defineErrors()
+}
+
+// optional returns optional argument x of
+// DefineFunc function, or nil if x is omitted.
+func optional(x *lisp.Object) *lisp.Object {
+	if x == nil {
+		return &lisp.Nil
+	}
+	return x
+}
+
+// checkSymbol signals wrong-type-argument if x is not a symbol.
+func checkSymbol(x lisp.Object) error {
+	if x.Type != lisp.TypeSymbol {
+		return wrongTypeArgument(SymSymbolp, x)
+	}
+	return nil
+}
+
+// car implements `car`.
+func car(x lisp.Object) (lisp.Object, error) {
+	switch {
+	case x.Type == lisp.TypeCons:
+		return x.Cons().Car, nil
+	case lisp.Null(&x):
+		return lisp.Nil, nil
+	}
+	return lisp.Nil, wrongTypeArgument(SymListp, x)
+}
+
+// cdr implements `cdr`.
+func cdr(x lisp.Object) (lisp.Object, error) {
+	switch {
+	case x.Type == lisp.TypeCons:
+		return x.Cons().Cdr, nil
+	case lisp.Null(&x):
+		return lisp.Nil, nil
+	}
+	return lisp.Nil, wrongTypeArgument(SymListp, x)
+}
+
+// setcar implements `setcar`.
+func setcar(cell, newcar lisp.Object) (lisp.Object, error) {
+	if cell.Type != lisp.TypeCons {
+		return lisp.Nil, wrongTypeArgument(SymConsp, cell)
+	}
+	cell.Cons().Car = newcar
+	return newcar, nil
+}
+
+// arithOp is an arithmetic operation.
+type arithOp int
+
+const (
+	opAdd arithOp = iota
+	opSub
+	opMul
+)
+
+// arith folds numbers with op.
+// The result is a float if any argument is a float.
+func arith(op arithOp, args []lisp.Object) (lisp.Object, error) {
+	isFloat := false
+	for _, x := range args {
+		switch x.Type {
+		case lisp.TypeFloat:
+			isFloat = true
+		case lisp.TypeInt:
+		default:
+			return lisp.Nil, wrongTypeArgument(SymNumberOrMarkerp, x)
+		}
+	}
+	if isFloat {
+		acc := 0.0
+		if op == opMul {
+			acc = 1
+		}
+		for i, x := range args {
+			v := numberFloat(x)
+			switch {
+			case op == opAdd:
+				acc += v
+			case op == opMul:
+				acc *= v
+			case i == 0:
+				acc = v
+			default:
+				acc -= v
+			}
+		}
+		return lisp.NewFloat(acc), nil
+	}
+	acc := int64(0)
+	if op == opMul {
+		acc = 1
+	}
+	for i, x := range args {
+		v := x.Int()
+		switch {
+		case op == opAdd:
+			acc += v
+		case op == opMul:
+			acc *= v
+		case i == 0:
+			acc = v
+		default:
+			acc -= v
+		}
+	}
+	return lisp.NewInt(acc), nil
+}
+
+// numberFloat returns number x as float.
+func numberFloat(x lisp.Object) float64 {
+	if x.Type == lisp.TypeInt {
+		return float64(x.Int())
+	}
+	return x.Float()
+}
+
+// compare reports whether ok holds for every pair of
+// adjacent numbers of x and ys.
+// ok receives -1, 0 or 1, like strings.Compare results.
+func compare(x lisp.Object, ys []lisp.Object, ok func(c int) bool) (bool, error) {
+	if x.Type != lisp.TypeInt && x.Type != lisp.TypeFloat {
+		return false, wrongTypeArgument(SymNumberOrMarkerp, x)
+	}
+	for _, y := range ys {
+		if y.Type != lisp.TypeInt && y.Type != lisp.TypeFloat {
+			return false, wrongTypeArgument(SymNumberOrMarkerp, y)
+		}
+	}
+	result := true
+	for _, y := range ys {
+		c := 0
+		if x.Type == lisp.TypeInt && y.Type == lisp.TypeInt {
+			switch a, b := x.Int(), y.Int(); {
+			case a < b:
+				c = -1
+			case a > b:
+				c = 1
+			}
+		} else {
+			switch a, b := numberFloat(x), numberFloat(y); {
+			case a < b:
+				c = -1
+			case a > b:
+				c = 1
+			case a != b:
+				// NaN is not comparable.
+				result = false
+			}
+		}
+		result = result && ok(c)
+		x = y
+	}
+	return result, nil
+}
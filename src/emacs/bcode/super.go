@@ -0,0 +1,74 @@
+package bcode
+
+// Superinstructions.
+//
+// A superinstruction is a single run handler for a common
+// sequence of instructions. Every instruction of the sequence
+// is dispatched separately otherwise; fused handler saves
+// the dispatch jumps and keeps the values in registers.
+//
+// Fusion changes only the op of the first insn of the sequence.
+// The rest of insns are left intact, so insns still map 1:1 to
+// the code instructions: jumps into the middle of the sequence,
+// call frame pc values and the disassembler are not affected.
+// Fused handlers read the operands from the insns of the
+// sequence and then skip them.
+// Errors are reported as if the instructions were run
+// one by one.
+//
+// The sequences are picked with ProfileSequences over the
+// compiled list-processing code (see benchCorpus in tests):
+// loop conditions, list traversal and local variable updates.
+// Each of them is measured by BenchmarkSuperInsns; fusions
+// that don't occur in the corpus or don't pay off are not kept.
+
+// Superinstruction opcodes.
+// They continue the dense numbering of insn opcodes.
+const (
+	insnStackRefCar = insnDiscardN + 1 + iota
+	insnStackRefCdr
+	insnStackRefGotoIfNil
+	insnDupStackSet
+	insnDupStackSetDiscard
+)
+
+// superInsns lists the fused sequences.
+// Longer sequences go first, so they take precedence.
+var superInsns = []struct {
+	ops []uint8
+	op  uint8
+}{
+	{[]uint8{insnDup, insnStackSet, insnDiscard}, insnDupStackSetDiscard},
+	{[]uint8{insnStackRef, insnCar}, insnStackRefCar},
+	{[]uint8{insnStackRef, insnCdr}, insnStackRefCdr},
+	{[]uint8{insnStackRef, insnGotoIfNil}, insnStackRefGotoIfNil},
+	{[]uint8{insnDup, insnStackSet}, insnDupStackSet},
+}
+
+// fuseInsns replaces the first insns of superInsns
+// sequences with superinstructions.
+// Sequences are matched against original ops: insns
+// after i are not changed before they are visited.
+func fuseInsns(insns []insn) {
+	for i := range insns {
+		for _, s := range superInsns {
+			if matchInsns(insns[i:], s.ops) {
+				insns[i].op = s.op
+				break
+			}
+		}
+	}
+}
+
+// matchInsns reports whether insns start with ops.
+func matchInsns(insns []insn, ops []uint8) bool {
+	if len(insns) < len(ops) {
+		return false
+	}
+	for i, op := range ops {
+		if insns[i].op != op {
+			return false
+		}
+	}
+	return true
+}
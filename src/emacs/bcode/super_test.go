@@ -0,0 +1,363 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"emacs/reader"
+	"fmt"
+	"testing"
+)
+
+// benchCorpusDefs are the helper definitions that
+// benchCorpus functions use.
+var benchCorpusDefs = []string{
+	"(defun bench-fib (n) (if (< n 2) n (+ (bench-fib (- n 1)) (bench-fib (- n 2)))))",
+	"(byte-compile 'bench-fib)",
+	"(defun bench-range (n) (let (l) (while (> n 0) (setq l (cons n l) n (1- n))) l))",
+	"(byte-compile 'bench-range)",
+}
+
+// benchCorpus is a set of functions in the style of
+// list-processing Emacs Lisp code.
+var benchCorpus = []struct {
+	name string
+	src  string
+	args string
+	want string
+}{
+	{
+		"length",
+		"(lambda (l) (let ((n 0)) (while l (setq n (1+ n) l (cdr l))) n))",
+		"((bench-range 50))", "50",
+	},
+	{
+		"reverse",
+		"(lambda (l) (let (r) (while l (setq r (cons (car l) r) l (cdr l))) r))",
+		"('(1 2 3 4 5 6 7 8))", "(8 7 6 5 4 3 2 1)",
+	},
+	{
+		"memq",
+		"(lambda (x l) (while (and l (not (eq (car l) x))) (setq l (cdr l))) l)",
+		"(40 (bench-range 50))", "(40 41 42 43 44 45 46 47 48 49 50)",
+	},
+	{
+		"assq",
+		`(lambda (k al)
+		   (let (r)
+		     (while (and al (not r))
+		       (if (eq (car (car al)) k) (setq r (car al)))
+		       (setq al (cdr al)))
+		     r))`,
+		"('e '((a . 1) (b . 2) (c . 3) (d . 4) (e . 5)))", "(e . 5)",
+	},
+	{
+		"plist-get",
+		`(lambda (pl prop)
+		   (let (v)
+		     (while pl
+		       (if (eq (car pl) prop)
+		           (setq v (car (cdr pl)) pl nil)
+		         (setq pl (cdr (cdr pl)))))
+		     v))`,
+		"('(:a 1 :b 2 :c 3 :d 4) :d)", "4",
+	},
+	{
+		"sum",
+		"(lambda (l) (let ((s 0)) (while l (setq s (+ s (car l)) l (cdr l))) s))",
+		"((bench-range 50))", "1275",
+	},
+	{
+		"max",
+		`(lambda (l)
+		   (let ((m (car l)))
+		     (while (setq l (cdr l))
+		       (if (> (car l) m) (setq m (car l))))
+		     m))`,
+		"((bench-range 50))", "50",
+	},
+	{
+		"keys",
+		`(lambda (al)
+		   (let (keys)
+		     (while al
+		       (setq keys (cons (car (car al)) keys))
+		       (setq al (cdr al)))
+		     keys))`,
+		"('((a . 1) (b . 2) (c . 3)))", "(c b a)",
+	},
+	{
+		"fib",
+		"(lambda (n) (bench-fib n))",
+		"(15)", "610",
+	},
+}
+
+// corpusFunc is a compiled benchCorpus function
+// along with its arguments.
+type corpusFunc struct {
+	name string
+	fn   *Func
+	args []lisp.Object
+	want string
+}
+
+// compileCorpus compiles benchCorpus functions.
+func compileCorpus(tb testing.TB) (*Env, []corpusFunc) {
+	env, ob := newLispEnv()
+	for _, form := range benchCorpusDefs {
+		x, err := reader.ReadString(form, ob)
+		if err == nil {
+			_, err = env.Eval(x, lisp.T)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", form, err)
+		}
+	}
+	var funcs []corpusFunc
+	for _, f := range benchCorpus {
+		fn, err := compileString(env, ob, f.src)
+		if err != nil {
+			tb.Fatalf("compile %s: %v", f.name, err)
+		}
+		args, err := reader.ReadString(f.args, ob)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		var vals []lisp.Object
+		for _, arg := range listSlice(args) {
+			val, err := env.Eval(arg, lisp.T)
+			if err != nil {
+				tb.Fatalf("%s args: %v", f.name, err)
+			}
+			vals = append(vals, val)
+		}
+		funcs = append(funcs, corpusFunc{f.name, fn, vals, f.want})
+	}
+	return env, funcs
+}
+
+// setSuperInsns translates corpus functions and functions
+// defined in env with or without superinstructions.
+func setSuperInsns(env *Env, funcs []corpusFunc, fuse bool) {
+	translate := (*Func).translatePlain
+	if fuse {
+		translate = (*Func).translate
+	}
+	for _, page := range env.funcPages {
+		for _, ref := range page {
+			if ref.fn != nil {
+				translate(ref.fn)
+			}
+		}
+	}
+	for _, f := range funcs {
+		translate(f.fn)
+	}
+}
+
+func TestSuperInsns(t *testing.T) {
+	env, funcs := compileCorpus(t)
+	for _, fuse := range []bool{false, true} {
+		setSuperInsns(env, funcs, fuse)
+		for _, f := range funcs {
+			val, err := env.Exec(f.fn, f.args...)
+			if err != nil {
+				t.Errorf("%s (fuse=%v): %v", f.name, fuse, err)
+				continue
+			}
+			if have := lisp.Prin1String(val); have != f.want {
+				t.Errorf("%s (fuse=%v):\nhave: %s\nwant: %s", f.name, fuse, have, f.want)
+			}
+		}
+	}
+
+}
+
+func TestSuperInsnsFuse(t *testing.T) {
+	ob := lisp.NewObarray()
+	fn, err := assemble([]byte(`
+		stack-ref 1
+		car
+		dup
+		stack-set 2
+		discard
+		dup
+		goto-if-nil l1
+		constant f
+		call 0
+	l1:
+		stack-ref 1
+		goto-if-nil l1
+		return`), ob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint8{
+		insnStackRefCar,
+		insnCar,
+		insnDupStackSetDiscard,
+		insnStackSet,
+		insnDiscard,
+		insnDup,
+		insnGotoIfNil,
+		insnConstant,
+		insnCall,
+		insnStackRefGotoIfNil,
+		insnGotoIfNil,
+		insnReturn,
+		insnBad,
+	}
+	if len(fn.insns) != len(want) {
+		t.Fatalf("have %d insns, want %d", len(fn.insns), len(want))
+	}
+	for i, op := range want {
+		if fn.insns[i].op != op {
+			t.Errorf("insn %d: have op %d, want %d", i, fn.insns[i].op, op)
+		}
+	}
+}
+
+func TestSuperInsnsEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		args string
+		want string
+	}{
+		// Errors of the fused instructions are reported
+		// like errors of separate ones.
+		{"(lambda (x y) (car x))", "(1 nil)", "Wrong type argument: listp, 1"},
+		{"(lambda (x y) (cdr x))", "(a nil)", "Wrong type argument: listp, a"},
+		{"(lambda (x y) (car (cdr x)))", "((1 . 2) nil)", "Wrong type argument: listp, 2"},
+		{"(lambda () (undefined-f))", "()", "Symbol’s function definition is void: undefined-f"},
+		{"(lambda (x) (if x 'yes 'no))", "(nil)", "no"},
+		{"(lambda (x) (or x 'none))", "(nil)", "none"},
+		{"(lambda (x) (or x 'none))", "(1)", "1"},
+		{"(lambda (x y) (setq x (car y)) (list x y))", "(1 (2))", "(2 (2))"},
+		{"(lambda (x) (while (car x) (setq x (cdr x))) x)", "((1 2 nil 3))", "(nil 3)"},
+	}
+	env, ob := newLispEnv()
+	for _, test := range tests {
+		fn, err := compileString(env, ob, test.src)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		args := listSlice(mustRead(t, test.args, ob))
+		var results [2]string
+		for i, fuse := range []bool{false, true} {
+			setSuperInsns(env, []corpusFunc{{fn: fn}}, fuse)
+			val, err := env.Exec(fn, args...)
+			results[i] = lisp.Prin1String(val)
+			if err != nil {
+				results[i] = ErrorMessage(err)
+			}
+		}
+		if results[0] != test.want || results[1] != test.want {
+			t.Errorf("%s %s:\nhave: %s (plain), %s (fused)\nwant: %s",
+				test.src, test.args, results[0], results[1], test.want)
+		}
+	}
+}
+
+func TestProfileSequences(t *testing.T) {
+	ob := lisp.NewObarray()
+	fn, err := assemble([]byte(`
+		stack-ref 1
+		car
+		stack-ref 2
+		car
+		stack-ref 1
+		cons
+		return`), ob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := ProfileSequences([]*Func{fn}, 2)
+	want := []string{
+		"2 car + stack-ref",
+		"2 stack-ref + car",
+		"1 cons + return",
+		"1 stack-ref + cons",
+	}
+	if len(have) != len(want) {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	for i := range want {
+		if s := fmt.Sprintf("%d %s", have[i].Count, have[i]); s != want[i] {
+			t.Errorf("sequence %d: have %s, want %s", i, s, want[i])
+		}
+	}
+}
+
+func BenchmarkCorpus(b *testing.B) {
+	env, funcs := compileCorpus(b)
+	for _, fuse := range []bool{false, true} {
+		name := "plain"
+		if fuse {
+			name = "super"
+		}
+		setSuperInsns(env, funcs, fuse)
+		for _, f := range funcs {
+			f := f
+			b.Run(f.name+"/"+name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := env.Exec(f.fn, f.args...); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// superInsnNames names superinstructions in benchmarks.
+var superInsnNames = map[uint8]string{
+	insnStackRefCar:        "stack-ref+car",
+	insnStackRefCdr:        "stack-ref+cdr",
+	insnStackRefGotoIfNil:  "stack-ref+goto-if-nil",
+	insnDupStackSet:        "dup+stack-set",
+	insnDupStackSetDiscard: "dup+stack-set+discard",
+}
+
+// BenchmarkSuperInsns runs the corpus with no
+// superinstructions, with every single one of them and
+// with all of them, so each fusion is compared to "none".
+// fib is left out: its time goes to calls and would
+// hide the differences of the loops.
+func BenchmarkSuperInsns(b *testing.B) {
+	env, corpus := compileCorpus(b)
+	var funcs []corpusFunc
+	for _, f := range corpus {
+		if f.name != "fib" {
+			funcs = append(funcs, f)
+		}
+	}
+	all := superInsns
+	defer func() { superInsns = all }()
+
+	run := func(name string, fused []uint8) {
+		superInsns = nil
+		for _, s := range all {
+			for _, op := range fused {
+				if s.op == op {
+					superInsns = append(superInsns, s)
+				}
+			}
+		}
+		setSuperInsns(env, funcs, true)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, f := range funcs {
+					if _, err := env.Exec(f.fn, f.args...); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+	run("none", nil)
+	var ops []uint8
+	for _, s := range all {
+		run(superInsnNames[s.op], []uint8{s.op})
+		ops = append(ops, s.op)
+	}
+	run("all", ops)
+}
@@ -0,0 +1,819 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"unicode/utf8"
+)
+
+// Syntax tables support.
+//
+// Scanning routines are ports of their Emacs syntax.c counterparts.
+// Syntax properties (`syntax-table' text property) are not supported.
+// Issue#6
+
+// maxChar is the maximal Emacs character code.
+const maxChar = 0x3FFFFF
+
+// stCommentStyle is a comment style for comment fences.
+const stCommentStyle = 256 + 1
+
+// syntaxCodeSpec maps syntax class to its designator char.
+var syntaxCodeSpec = [...]byte{
+	lisp.SyntaxWhitespace:   ' ',
+	lisp.SyntaxPunct:        '.',
+	lisp.SyntaxWord:         'w',
+	lisp.SyntaxSymbol:       '_',
+	lisp.SyntaxOpen:         '(',
+	lisp.SyntaxClose:        ')',
+	lisp.SyntaxQuote:        '\'',
+	lisp.SyntaxString:       '"',
+	lisp.SyntaxMath:         '$',
+	lisp.SyntaxEscape:       '\\',
+	lisp.SyntaxCharQuote:    '/',
+	lisp.SyntaxComment:      '<',
+	lisp.SyntaxEndComment:   '>',
+	lisp.SyntaxInherit:      '@',
+	lisp.SyntaxCommentFence: '!',
+	lisp.SyntaxStringFence:  '|',
+}
+
+// syntaxSpecCode maps designator char to its syntax class.
+// Invalid designators are mapped to 0xFF.
+var syntaxSpecCode = func() [256]byte {
+	var codes [256]byte
+	for i := range codes {
+		codes[i] = 0xFF
+	}
+	for class, c := range syntaxCodeSpec {
+		codes[c] = byte(class)
+	}
+	codes['-'] = byte(lisp.SyntaxWhitespace)
+	return codes
+}()
+
+// syntaxFlagChars maps syntax flag bit index to flag char.
+var syntaxFlagChars = [...]byte{'1', '2', '3', '4', 'p', 'b', 'n', 'c'}
+
+// newStandardSyntaxTable returns a table that is initialized
+// the same way as the Emacs standard syntax table.
+func newStandardSyntaxTable() *lisp.SyntaxTable {
+	o := lisp.NewSyntaxTable(nil)
+	st := o.SyntaxTable()
+
+	set := func(chars string, class lisp.SyntaxClass) {
+		for _, c := range chars {
+			st.SetEntry(c, lisp.SyntaxEntry{Class: class})
+		}
+	}
+
+	// Control characters should not be whitespace,
+	// except that a few really are.
+	st.SetRange(0, ' '-1, lisp.SyntaxEntry{Class: lisp.SyntaxPunct})
+	st.SetEntry(0177, lisp.SyntaxEntry{Class: lisp.SyntaxPunct})
+	set(" \t\n\r\f", lisp.SyntaxWhitespace)
+
+	st.SetRange('a', 'z', lisp.SyntaxEntry{Class: lisp.SyntaxWord})
+	st.SetRange('A', 'Z', lisp.SyntaxEntry{Class: lisp.SyntaxWord})
+	st.SetRange('0', '9', lisp.SyntaxEntry{Class: lisp.SyntaxWord})
+	set("$%", lisp.SyntaxWord)
+
+	for _, pair := range [...]string{"()", "[]", "{}"} {
+		open, close := rune(pair[0]), rune(pair[1])
+		st.SetEntry(open, lisp.SyntaxEntry{Class: lisp.SyntaxOpen, Match: close})
+		st.SetEntry(close, lisp.SyntaxEntry{Class: lisp.SyntaxClose, Match: open})
+	}
+
+	set(`"`, lisp.SyntaxString)
+	set(`\`, lisp.SyntaxEscape)
+	set("_-+*/&|<>=", lisp.SyntaxSymbol)
+	set(".,;:?!#@~^'`", lisp.SyntaxPunct)
+
+	// All multibyte characters have word syntax by default.
+	st.SetRange(utf8.RuneSelf, maxChar, lisp.SyntaxEntry{Class: lisp.SyntaxWord})
+
+	return st
+}
+
+// defineSyntaxSubrs defines the syntax table functions.
+func (env *MasterEnv) defineSyntaxSubrs(ob *lisp.Obarray) {
+	for _, subr := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"syntax-table", func(env *Env) lisp.Object {
+			return env.syntaxTable().Object()
+		}},
+		{"standard-syntax-table", func(env *Env) lisp.Object {
+			return env.stdSyntaxTable.Object()
+		}},
+		{"set-syntax-table", func(env *Env, table lisp.Object) (lisp.Object, error) {
+			if table.Type != lisp.TypeSyntaxTable {
+				return lisp.Nil, wrongTypeArgument(SymSyntaxTablep, table)
+			}
+			env.buffer.SyntaxTable = table.SyntaxTable()
+			return table, nil
+		}},
+		{"make-syntax-table", func(env *Env, oldtable *lisp.Object) (lisp.Object, error) {
+			parent := env.stdSyntaxTable
+			if oldtable != nil {
+				if oldtable.Type != lisp.TypeSyntaxTable {
+					return lisp.Nil, wrongTypeArgument(SymSyntaxTablep, *oldtable)
+				}
+				parent = oldtable.SyntaxTable()
+			}
+			return lisp.NewSyntaxTable(parent), nil
+		}},
+		{"modify-syntax-entry", func(env *Env, c, newentry lisp.Object, table *lisp.Object) (lisp.Object, error) {
+			st, err := env.syntaxTableArg(table)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			return modifySyntaxEntry(st, &c, &newentry)
+		}},
+		{"string-to-syntax", func(desc lisp.Object) (lisp.Object, error) {
+			if desc.Type != lisp.TypeString {
+				return lisp.Nil, wrongTypeArgument(SymStringp, desc)
+			}
+			e, err := parseSyntaxDescriptor(desc.String().Chars)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			return syntaxEntryObject(e), nil
+		}},
+		{"syntax-class-to-char", func(class lisp.Object) (lisp.Object, error) {
+			return syntaxClassToChar(&class)
+		}},
+		{"char-syntax", func(env *Env, c lisp.Object) (lisp.Object, error) {
+			return charSyntax(env.syntaxTable(), &c)
+		}},
+		{"skip-syntax-forward", func(env *Env, syntax lisp.Object, lim *lisp.Object) (lisp.Object, error) {
+			return skipSyntax(env.buffer, env.syntaxTable(), true, &syntax, optional(lim))
+		}},
+		{"skip-syntax-backward", func(env *Env, syntax lisp.Object, lim *lisp.Object) (lisp.Object, error) {
+			return skipSyntax(env.buffer, env.syntaxTable(), false, &syntax, optional(lim))
+		}},
+		{"scan-lists", func(env *Env, from, count, depth int) (lisp.Object, error) {
+			return scanLists(env.buffer, env.syntaxTable(), from, count, depth, false)
+		}},
+		{"scan-sexps", func(env *Env, from, count int) (lisp.Object, error) {
+			return scanLists(env.buffer, env.syntaxTable(), from, count, 0, true)
+		}},
+	} {
+		env.DefineFunc(ob.Intern(subr.name), subr.fn)
+	}
+}
+
+// syntaxTableArg returns optional syntax table argument
+// table or current syntax table if table is omitted.
+func (env *Env) syntaxTableArg(table *lisp.Object) (*lisp.SyntaxTable, error) {
+	if table == nil {
+		return env.syntaxTable(), nil
+	}
+	if table.Type != lisp.TypeSyntaxTable {
+		return nil, wrongTypeArgument(SymSyntaxTablep, *table)
+	}
+	return table.SyntaxTable(), nil
+}
+
+// syntaxTable returns current buffer syntax table.
+func (env *Env) syntaxTable() *lisp.SyntaxTable {
+	if env.buffer.SyntaxTable != nil {
+		return env.buffer.SyntaxTable
+	}
+	return env.stdSyntaxTable
+}
+
+// parseSyntaxDescriptor implements `string-to-syntax`.
+//
+// Descriptor consists of a class designator char,
+// optional matching char and a sequence of flag chars.
+// Returns entry with SyntaxInherit class for "@" descriptors.
+func parseSyntaxDescriptor(desc []byte) (lisp.SyntaxEntry, error) {
+	var e lisp.SyntaxEntry
+	if len(desc) == 0 || syntaxSpecCode[desc[0]] == 0xFF {
+		letter := ""
+		if len(desc) != 0 {
+			letter = string(desc[0])
+		}
+		return e, signal(SymError, lisp.NewString([]byte(
+			"Invalid syntax description letter: "+letter)))
+	}
+	e.Class = lisp.SyntaxClass(syntaxSpecCode[desc[0]])
+	if e.Class == lisp.SyntaxInherit {
+		return e, nil
+	}
+
+	rest := desc[1:]
+	if len(rest) != 0 {
+		match, size := utf8.DecodeRune(rest)
+		if match != ' ' {
+			e.Match = match
+		}
+		rest = rest[size:]
+	}
+	for _, c := range rest {
+		for i, flag := range syntaxFlagChars {
+			if c == flag {
+				e.Flags |= 1 << uint(i)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// syntaxEntryObject returns raw syntax descriptor for e,
+// which is a (CODE . MATCHING-CHAR) cons.
+// Inherit entries are represented by nil.
+func syntaxEntryObject(e lisp.SyntaxEntry) lisp.Object {
+	if e.Class == lisp.SyntaxInherit {
+		return lisp.Nil
+	}
+	match := lisp.Nil
+	if e.Match != 0 {
+		match = lisp.NewInt(int64(e.Match))
+	}
+	return lisp.NewCons(lisp.NewInt(e.Code()), match)
+}
+
+// charArg returns x as a char.
+// Signals wrong-type-argument if x is not a valid character.
+func charArg(x *lisp.Object) (rune, error) {
+	if x.Type != lisp.TypeInt || x.Int() < 0 || x.Int() > maxChar {
+		return 0, wrongTypeArgument(SymCharacterp, *x)
+	}
+	return rune(x.Int()), nil
+}
+
+// charSyntax implements `char-syntax`.
+// Returns class designator char of c syntax.
+func charSyntax(st *lisp.SyntaxTable, c *lisp.Object) (lisp.Object, error) {
+	ch, err := charArg(c)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	return lisp.NewInt(int64(syntaxCodeSpec[st.Class(ch)])), nil
+}
+
+// syntaxClassToChar implements `syntax-class-to-char`.
+func syntaxClassToChar(class *lisp.Object) (lisp.Object, error) {
+	if class.Type != lisp.TypeInt {
+		return lisp.Nil, wrongTypeArgument(SymIntegerp, *class)
+	}
+	if n := class.Int(); n < 0 || n >= int64(len(syntaxCodeSpec)) {
+		return lisp.Nil, signal(SymArgsOutOfRange,
+			lisp.NewInt(int64(len(syntaxCodeSpec)-1)), *class)
+	}
+	return lisp.NewInt(int64(syntaxCodeSpec[class.Int()])), nil
+}
+
+// modifySyntaxEntry implements `modify-syntax-entry`.
+// c is either a char or a (MIN . MAX) chars range.
+func modifySyntaxEntry(st *lisp.SyntaxTable, c, desc *lisp.Object) (lisp.Object, error) {
+	if desc.Type != lisp.TypeString {
+		return lisp.Nil, wrongTypeArgument(SymStringp, *desc)
+	}
+	e, err := parseSyntaxDescriptor(desc.String().Chars)
+	if err != nil {
+		return lisp.Nil, err
+	}
+
+	if c.Type == lisp.TypeCons {
+		lo, err := charArg(&c.Cons().Car)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		hi, err := charArg(&c.Cons().Cdr)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		st.SetRange(lo, hi, e)
+		return lisp.Nil, nil
+	}
+
+	ch, err := charArg(c)
+	if err != nil {
+		return lisp.Nil, err
+	}
+	st.SetEntry(ch, e)
+	return lisp.Nil, nil
+}
+
+// skipLimit returns clamped `skip-*` functions LIM argument value.
+func skipLimit(buf *lisp.Buffer, forward bool, lim *lisp.Object) (int, error) {
+	def := buf.PointMin()
+	if forward {
+		def = buf.PointMax()
+	}
+	limit, err := intArgOr(lim, def)
+	if err != nil {
+		return 0, err
+	}
+	if limit > buf.PointMax() {
+		limit = buf.PointMax()
+	}
+	if limit < buf.PointMin() {
+		limit = buf.PointMin()
+	}
+	return limit, nil
+}
+
+// skipSyntax implements `skip-syntax-forward` (forward=true)
+// and `skip-syntax-backward` (forward=false).
+// syntax is a string of class designators, "^" negates it.
+//
+// Returns the distance traveled, negative for backward motion.
+func skipSyntax(buf *lisp.Buffer, st *lisp.SyntaxTable, forward bool, syntax, lim *lisp.Object) (lisp.Object, error) {
+	if syntax.Type != lisp.TypeString {
+		return lisp.Nil, wrongTypeArgument(SymStringp, *syntax)
+	}
+	limit, err := skipLimit(buf, forward, lim)
+	if err != nil {
+		return lisp.Nil, err
+	}
+
+	var classes [len(syntaxCodeSpec)]bool
+	spec := syntax.String().Chars
+	negate := len(spec) != 0 && spec[0] == '^'
+	if negate {
+		spec = spec[1:]
+	}
+	for _, c := range spec {
+		if code := syntaxSpecCode[c]; code != 0xFF {
+			classes[code] = true
+		}
+	}
+	if negate {
+		for i := range classes {
+			classes[i] = !classes[i]
+		}
+	}
+
+	start := buf.Point()
+	pos := start
+	if forward {
+		for pos < limit && classes[st.Class(buf.CharAt(pos))] {
+			pos++
+		}
+	} else {
+		for pos > limit && classes[st.Class(buf.CharAt(pos-1))] {
+			pos--
+		}
+	}
+	buf.SetPoint(pos)
+
+	return lisp.NewInt(int64(pos - start)), nil
+}
+
+// commentStyle returns comment style bits for a comment
+// delimiter with e syntax that is paired with other.
+func commentStyle(e, other lisp.SyntaxEntry) int {
+	style := 0
+	if e.Flags&lisp.SyntaxStyleB != 0 {
+		style |= 1
+	}
+	if (e.Flags|other.Flags)&lisp.SyntaxStyleC != 0 {
+		style |= 2
+	}
+	return style
+}
+
+// charQuoted reports whether char at pos is quoted by
+// an odd number of escape or char-quote chars.
+func charQuoted(buf *lisp.Buffer, st *lisp.SyntaxTable, pos int) bool {
+	quoted := false
+	for pos > buf.PointMin() {
+		class := st.Class(buf.CharAt(pos - 1))
+		if class != lisp.SyntaxEscape && class != lisp.SyntaxCharQuote {
+			break
+		}
+		quoted = !quoted
+		pos--
+	}
+	return quoted
+}
+
+// forwComment scans forward over the body of a comment.
+// from must point right after the comment starter.
+// nesting is 1 for nested comments and -1 otherwise.
+//
+// Returns the position after comment ender and true if
+// comment end was found; otherwise returns stop and false.
+func forwComment(buf *lisp.Buffer, st *lisp.SyntaxTable, from, stop, nesting, style int) (int, bool) {
+	nestedFlag := func(e lisp.SyntaxEntry) bool {
+		return e.Flags&lisp.SyntaxNested != 0
+	}
+	for {
+		if from == stop {
+			return from, false
+		}
+		e := st.Entry(buf.CharAt(from))
+		if e.Class == lisp.SyntaxEndComment &&
+			commentStyle(e, lisp.SyntaxEntry{}) == style {
+			if nestedFlag(e) {
+				if nesting > 0 {
+					nesting--
+					if nesting == 0 {
+						return from + 1, true
+					}
+				}
+			} else if nesting < 0 {
+				return from + 1, true
+			}
+		}
+		if e.Class == lisp.SyntaxCommentFence && style == stCommentStyle {
+			return from + 1, true
+		}
+		if nesting > 0 && e.Class == lisp.SyntaxComment && nestedFlag(e) &&
+			commentStyle(e, lisp.SyntaxEntry{}) == style {
+			nesting++
+		}
+		from++
+
+		if from < stop && e.Flags&lisp.SyntaxComEndFirst != 0 {
+			other := st.Entry(buf.CharAt(from))
+			nested := nestedFlag(e) || nestedFlag(other)
+			if other.Flags&lisp.SyntaxComEndSecond != 0 &&
+				commentStyle(e, other) == style &&
+				(nested && nesting > 0 || !nested && nesting < 0) {
+				nesting--
+				if nesting <= 0 {
+					return from + 1, true
+				}
+				from++
+				// So that "|#" can not use "#" that ends "#|" starter.
+				e = lisp.SyntaxEntry{}
+			}
+		}
+		if nesting > 0 && from < stop && e.Flags&lisp.SyntaxComStartFirst != 0 {
+			other := st.Entry(buf.CharAt(from))
+			if commentStyle(other, e) == style &&
+				other.Flags&lisp.SyntaxComStartSecond != 0 &&
+				(nestedFlag(e) || nestedFlag(other)) {
+				from++
+				nesting++
+			}
+		}
+	}
+}
+
+// commentStart checks whether a comment starts at pos.
+// Returns comment style, nesting and starter length (0 if there
+// is no comment start at pos).
+func commentStart(buf *lisp.Buffer, st *lisp.SyntaxTable, pos, stop int) (style, nesting, size int) {
+	e := st.Entry(buf.CharAt(pos))
+	if pos+1 < stop && e.Flags&lisp.SyntaxComStartFirst != 0 {
+		other := st.Entry(buf.CharAt(pos + 1))
+		if other.Flags&lisp.SyntaxComStartSecond != 0 {
+			nesting = -1
+			if (e.Flags|other.Flags)&lisp.SyntaxNested != 0 {
+				nesting = 1
+			}
+			return commentStyle(other, e), nesting, 2
+		}
+	}
+	switch e.Class {
+	case lisp.SyntaxComment:
+		nesting = -1
+		if e.Flags&lisp.SyntaxNested != 0 {
+			nesting = 1
+		}
+		return commentStyle(e, lisp.SyntaxEntry{}), nesting, 1
+	case lisp.SyntaxCommentFence:
+		return stCommentStyle, -1, 1
+	}
+	return 0, 0, 0
+}
+
+// backComment finds the beginning of a comment which ender is at pos.
+//
+// Unlike Emacs heuristics, it parses the accessible portion from
+// the beginning, so the result is always precise.
+// Returns comment starter position and true on success.
+func backComment(buf *lisp.Buffer, st *lisp.SyntaxTable, pos int) (int, bool) {
+	stop := buf.PointMax()
+	from := buf.PointMin()
+	for from < pos {
+		e := st.Entry(buf.CharAt(from))
+		style, nesting, size := commentStart(buf, st, from, stop)
+		if size != 0 {
+			end, _ := forwComment(buf, st, from+size, stop, nesting, style)
+			if end > pos {
+				return from, true
+			}
+			from = end
+			continue
+		}
+		switch e.Class {
+		case lisp.SyntaxEscape, lisp.SyntaxCharQuote:
+			from += 2
+		case lisp.SyntaxString, lisp.SyntaxStringFence:
+			from, _ = skipString(buf, st, from, stop)
+		default:
+			from++
+		}
+	}
+	return pos, false
+}
+
+// skipString returns the position after the string that starts at pos.
+// If string is unterminated, returns stop and false.
+func skipString(buf *lisp.Buffer, st *lisp.SyntaxTable, pos, stop int) (int, bool) {
+	term := buf.CharAt(pos)
+	fence := st.Class(term) == lisp.SyntaxStringFence
+	for pos++; pos < stop; pos++ {
+		c := buf.CharAt(pos)
+		class := st.Class(c)
+		if fence && class == lisp.SyntaxStringFence ||
+			!fence && c == term && class == lisp.SyntaxString {
+			return pos + 1, true
+		}
+		if class == lisp.SyntaxEscape || class == lisp.SyntaxCharQuote {
+			pos++
+		}
+	}
+	return stop, false
+}
+
+// scanError returns `scan-error` signal.
+func scanError(msg string, from, to int) *Signal {
+	return signal(SymScanError,
+		lisp.NewString([]byte(msg)),
+		lisp.NewInt(int64(from)),
+		lisp.NewInt(int64(to)))
+}
+
+// scanLists implements `scan-lists` (sexpflag=false)
+// and `scan-sexps` (sexpflag=true).
+//
+// Returns the position after count balanced expressions
+// starting from pos; negative count scans backward.
+// Returns nil if accessible portion bound is reached at zero depth.
+func scanLists(buf *lisp.Buffer, st *lisp.SyntaxTable, from, count, depth int, sexpflag bool) (lisp.Object, error) {
+	ignoreComments := buf.ParseSexpIgnoreComments
+	minDepth := depth
+	if depth > 0 {
+		minDepth = 0
+	}
+	if from < buf.PointMin() {
+		from = buf.PointMin()
+	}
+	if from > buf.PointMax() {
+		from = buf.PointMax()
+	}
+	lastGood := from
+	mathExit := false
+
+	isWordish := func(class lisp.SyntaxClass) bool {
+		return class == lisp.SyntaxWord ||
+			class == lisp.SyntaxSymbol ||
+			class == lisp.SyntaxQuote
+	}
+
+	for stop := buf.PointMax(); count > 0; count-- {
+	scanForward:
+		for {
+			if from >= stop {
+				if depth != 0 {
+					return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+				}
+				return lisp.Nil, nil
+			}
+			c := buf.CharAt(from)
+			e := st.Entry(c)
+			class := e.Class
+			if depth == minDepth {
+				lastGood = from
+			}
+			comStyle, comNesting := 0, -1
+			if ignoreComments {
+				if style, nesting, size := commentStart(buf, st, from, stop); size == 2 {
+					class = lisp.SyntaxComment
+					comStyle, comNesting = style, nesting
+					from++
+				} else if size == 1 {
+					comStyle, comNesting = style, nesting
+				}
+			}
+			from++
+			if e.Flags&lisp.SyntaxPrefix != 0 {
+				continue
+			}
+
+			switch class {
+			case lisp.SyntaxEscape, lisp.SyntaxCharQuote,
+				lisp.SyntaxWord, lisp.SyntaxSymbol:
+				if class == lisp.SyntaxEscape || class == lisp.SyntaxCharQuote {
+					if from == stop {
+						return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+					}
+					from++
+				}
+				if depth != 0 || !sexpflag {
+					break
+				}
+				// This word counts as a sexp; return at end of it.
+				for from < stop {
+					switch class := st.Class(buf.CharAt(from)); {
+					case class == lisp.SyntaxEscape || class == lisp.SyntaxCharQuote:
+						from++
+						if from == stop {
+							return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+						}
+					case isWordish(class):
+					default:
+						break scanForward
+					}
+					from++
+				}
+				break scanForward
+
+			case lisp.SyntaxComment, lisp.SyntaxCommentFence:
+				if !ignoreComments {
+					break
+				}
+				end, found := forwComment(buf, st, from, stop, comNesting, comStyle)
+				from = end
+				if !found {
+					if depth == 0 {
+						break scanForward
+					}
+					return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+				}
+
+			case lisp.SyntaxMath, lisp.SyntaxOpen, lisp.SyntaxClose:
+				if class == lisp.SyntaxMath {
+					if !sexpflag {
+						break
+					}
+					if from != stop && c == buf.CharAt(from) {
+						from++
+					}
+					if mathExit {
+						mathExit = false
+						class = lisp.SyntaxClose
+					} else {
+						mathExit = true
+						class = lisp.SyntaxOpen
+					}
+				}
+				if class == lisp.SyntaxOpen {
+					depth++
+					if depth == 0 {
+						break scanForward
+					}
+					mathExit = false
+					break
+				}
+				depth--
+				if depth == 0 {
+					break scanForward
+				}
+				if depth < minDepth {
+					return lisp.Nil, scanError("Containing expression ends prematurely", lastGood, from)
+				}
+
+			case lisp.SyntaxString, lisp.SyntaxStringFence:
+				var ok bool
+				from, ok = skipString(buf, st, from-1, stop)
+				if !ok {
+					return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+				}
+				if depth == 0 && sexpflag {
+					break scanForward
+				}
+			}
+		}
+	}
+
+	for stop := buf.PointMin(); count < 0; count++ {
+	scanBackward:
+		for {
+			if from <= stop {
+				if depth != 0 {
+					return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+				}
+				return lisp.Nil, nil
+			}
+			from--
+			c := buf.CharAt(from)
+			e := st.Entry(c)
+			class := e.Class
+			if depth == minDepth {
+				lastGood = from
+			}
+			if ignoreComments && from > stop && e.Flags&lisp.SyntaxComEndSecond != 0 {
+				prev := st.Entry(buf.CharAt(from - 1))
+				if prev.Flags&lisp.SyntaxComEndFirst != 0 && !charQuoted(buf, st, from-1) {
+					from--
+					class = lisp.SyntaxEndComment
+				}
+			}
+
+			// Quoting turns anything except a comment-ender
+			// into a word character.
+			if class != lisp.SyntaxEndComment && charQuoted(buf, st, from) {
+				from--
+				class = lisp.SyntaxWord
+			} else if e.Flags&lisp.SyntaxPrefix != 0 {
+				continue
+			}
+
+			switch class {
+			case lisp.SyntaxWord, lisp.SyntaxSymbol,
+				lisp.SyntaxEscape, lisp.SyntaxCharQuote:
+				if depth != 0 || !sexpflag {
+					break
+				}
+				// This word counts as a sexp;
+				// count object finished after passing it.
+				for from > stop {
+					pos := from - 1
+					// Don't allow comment-end to be quoted.
+					if st.Class(buf.CharAt(pos)) == lisp.SyntaxEndComment {
+						break
+					}
+					quoted := charQuoted(buf, st, pos)
+					if quoted {
+						pos--
+					}
+					if !quoted && !isWordish(st.Class(buf.CharAt(pos))) {
+						break
+					}
+					from = pos
+				}
+				break scanBackward
+
+			case lisp.SyntaxMath, lisp.SyntaxOpen, lisp.SyntaxClose:
+				if class == lisp.SyntaxMath {
+					if !sexpflag {
+						break
+					}
+					if from > stop && c == buf.CharAt(from-1) {
+						from--
+					}
+					if mathExit {
+						mathExit = false
+						class = lisp.SyntaxOpen
+					} else {
+						mathExit = true
+						class = lisp.SyntaxClose
+					}
+				}
+				if class == lisp.SyntaxClose {
+					depth++
+					if depth == 0 {
+						break scanBackward
+					}
+					break
+				}
+				depth--
+				if depth == 0 {
+					break scanBackward
+				}
+				if depth < minDepth {
+					return lisp.Nil, scanError("Containing expression ends prematurely", lastGood, from)
+				}
+
+			case lisp.SyntaxEndComment:
+				if !ignoreComments {
+					break
+				}
+				if start, found := backComment(buf, st, from); found {
+					from = start
+				}
+
+			case lisp.SyntaxCommentFence, lisp.SyntaxStringFence:
+				for {
+					if from == stop {
+						return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+					}
+					from--
+					if !charQuoted(buf, st, from) && st.Class(buf.CharAt(from)) == class {
+						break
+					}
+				}
+				if class == lisp.SyntaxStringFence && depth == 0 && sexpflag {
+					break scanBackward
+				}
+
+			case lisp.SyntaxString:
+				for {
+					if from == stop {
+						return lisp.Nil, scanError("Unbalanced parentheses", lastGood, from)
+					}
+					from--
+					c2 := buf.CharAt(from)
+					if !charQuoted(buf, st, from) && c2 == c && st.Class(c2) == lisp.SyntaxString {
+						break
+					}
+				}
+				if depth == 0 && sexpflag {
+					break scanBackward
+				}
+			}
+		}
+	}
+
+	return lisp.NewInt(int64(from)), nil
+}
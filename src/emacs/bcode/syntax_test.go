@@ -0,0 +1,455 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+// newLispSyntaxTable returns a syntax table that is close
+// to the Emacs `lisp-data-mode-syntax-table'.
+func newLispSyntaxTable() *lisp.SyntaxTable {
+	o := lisp.NewSyntaxTable(newStandardSyntaxTable())
+	st := o.SyntaxTable()
+	entries := []struct {
+		c    rune
+		desc string
+	}{
+		{';', "<"},
+		{'\n', ">"},
+		{'`', "'"},
+		{'\'', "'"},
+		{',', "'"},
+		{'#', "'"},
+		{'@', "_ p"},
+		{'?', "_ p"},
+		{'.', "_"},
+	}
+	for _, e := range entries {
+		c := lisp.NewInt(int64(e.c))
+		desc := lisp.NewString([]byte(e.desc))
+		if _, err := modifySyntaxEntry(st, &c, &desc); err != nil {
+			panic(err)
+		}
+	}
+	return st
+}
+
+// newCSyntaxTable returns a syntax table with C-style comments.
+func newCSyntaxTable() *lisp.SyntaxTable {
+	o := lisp.NewSyntaxTable(newStandardSyntaxTable())
+	st := o.SyntaxTable()
+	for c, desc := range map[rune]string{
+		'/':  ". 124b",
+		'*':  ". 23",
+		'\n': "> b",
+		'\'': `"`,
+	} {
+		e, err := parseSyntaxDescriptor([]byte(desc))
+		if err != nil {
+			panic(err)
+		}
+		st.SetEntry(c, e)
+	}
+	return st
+}
+
+func TestStringToSyntax(t *testing.T) {
+	tests := []struct {
+		desc string
+		want string
+	}{
+		{" ", "(0)"},
+		{"-", "(0)"},
+		{"w", "(2)"},
+		{"_", "(3)"},
+		{"()", "(4 . 41)"},
+		{")(", "(5 . 40)"},
+		{"(]", "(4 . 93)"},
+		{"\"", "(7)"},
+		{"\\", "(9)"},
+		{"<", "(11)"},
+		{"> b", "(2097164)"},
+		{". 124b", "(2818049)"},
+		{". 23", "(393217)"},
+		{"_ p", "(1048579)"},
+		{"< nc", "(12582923)"},
+		{"@", "nil"},
+		{"|", "(15)"},
+	}
+	for _, tt := range tests {
+		e, err := parseSyntaxDescriptor([]byte(tt.desc))
+		if err != nil {
+			t.Errorf("string-to-syntax %q: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		have := lisp.ObjectString(syntaxEntryObject(e))
+		have = conslistString(have)
+		if have != tt.want {
+			t.Errorf("string-to-syntax %q:\nhave: %s\nwant: %s",
+				tt.desc, have, tt.want)
+		}
+	}
+
+	for _, desc := range []string{"", "z", "W"} {
+		_, err := parseSyntaxDescriptor([]byte(desc))
+		if sig, ok := err.(*Signal); !ok || sig.Symbol != SymError {
+			t.Errorf("string-to-syntax %q: expected error, got %v", desc, err)
+		}
+	}
+}
+
+// conslistString simplifies "(x . nil)" printed form into "(x)".
+func conslistString(s string) string {
+	const suffix = " . nil)"
+	if len(s) > len(suffix) && s[len(s)-len(suffix):] == suffix {
+		return s[:len(s)-len(suffix)] + ")"
+	}
+	return s
+}
+
+func TestCharSyntax(t *testing.T) {
+	std := newStandardSyntaxTable()
+	lispTable := newLispSyntaxTable()
+
+	tests := []struct {
+		st   *lisp.SyntaxTable
+		c    rune
+		want rune
+	}{
+		{std, 'a', 'w'},
+		{std, 'Z', 'w'},
+		{std, '5', 'w'},
+		{std, '$', 'w'},
+		{std, ' ', ' '},
+		{std, '\n', ' '},
+		{std, '\x01', '.'},
+		{std, '(', '('},
+		{std, ']', ')'},
+		{std, '"', '"'},
+		{std, '\\', '\\'},
+		{std, '-', '_'},
+		{std, ';', '.'},
+		{std, 'ж', 'w'},
+		{std, '世', 'w'},
+		{lispTable, ';', '<'},
+		{lispTable, '\n', '>'},
+		{lispTable, '\'', '\''},
+		{lispTable, '?', '_'},
+		{lispTable, 'a', 'w'}, // Inherited from the standard table
+		{lispTable, '(', '('},
+	}
+	for _, tt := range tests {
+		c := lisp.NewInt(int64(tt.c))
+		res, err := charSyntax(tt.st, &c)
+		if err != nil {
+			t.Errorf("char-syntax %q: unexpected error: %v", tt.c, err)
+			continue
+		}
+		if rune(res.Int()) != tt.want {
+			t.Errorf("char-syntax %q:\nhave: %q\nwant: %q",
+				tt.c, rune(res.Int()), tt.want)
+		}
+	}
+
+	badChar := lisp.NewInt(-1)
+	_, err := charSyntax(std, &badChar)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymWrongTypeArgument {
+		t.Errorf("char-syntax -1: expected wrong-type-argument, got %v", err)
+	}
+}
+
+func TestModifySyntaxEntry(t *testing.T) {
+	o := lisp.NewSyntaxTable(newStandardSyntaxTable())
+	st := o.SyntaxTable()
+
+	set := func(c lisp.Object, desc string) {
+		d := lisp.NewString([]byte(desc))
+		if _, err := modifySyntaxEntry(st, &c, &d); err != nil {
+			t.Fatalf("modify-syntax-entry %s %q: %v",
+				lisp.ObjectString(c), desc, err)
+		}
+	}
+	check := func(c rune, want lisp.SyntaxClass) {
+		if have := st.Class(c); have != want {
+			t.Errorf("%q syntax:\nhave: %c\nwant: %c",
+				c, syntaxCodeSpec[have], syntaxCodeSpec[want])
+		}
+	}
+
+	set(lisp.NewInt('a'), ".")
+	check('a', lisp.SyntaxPunct)
+	check('b', lisp.SyntaxWord)
+
+	set(lisp.NewCons(lisp.NewInt('x'), lisp.NewInt('z')), "_")
+	check('w', lisp.SyntaxWord)
+	check('x', lisp.SyntaxSymbol)
+	check('z', lisp.SyntaxSymbol)
+
+	set(lisp.NewCons(lisp.NewInt('α'), lisp.NewInt('ω')), ".")
+	check('α', lisp.SyntaxPunct)
+	check('β', lisp.SyntaxPunct)
+	check('ж', lisp.SyntaxWord)
+	set(lisp.NewInt('β'), "w")
+	check('β', lisp.SyntaxWord)
+	check('γ', lisp.SyntaxPunct)
+
+	// "@" restores inheritance.
+	set(lisp.NewInt('a'), "@")
+	check('a', lisp.SyntaxWord)
+
+	// Parent modifications are visible through the child.
+	st.Parent.SetEntry('%', lisp.SyntaxEntry{Class: lisp.SyntaxPunct})
+	check('%', lisp.SyntaxPunct)
+
+	// Copy is independent.
+	cp := st.Copy()
+	set(lisp.NewInt('q'), ".")
+	if cp.Class('q') != lisp.SyntaxWord {
+		t.Errorf("copied syntax table is affected by the original modifications")
+	}
+
+	c := lisp.NewInt('a')
+	desc := lisp.NewInt(1)
+	_, err := modifySyntaxEntry(st, &c, &desc)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymWrongTypeArgument {
+		t.Errorf("expected wrong-type-argument, got %v", err)
+	}
+}
+
+func TestSyntaxClassToChar(t *testing.T) {
+	for class, want := range syntaxCodeSpec {
+		arg := lisp.NewInt(int64(class))
+		res, err := syntaxClassToChar(&arg)
+		if err != nil || res.Int() != int64(want) {
+			t.Errorf("syntax-class-to-char %d: have %v (%v), want %c",
+				class, lisp.ObjectString(res), err, want)
+		}
+	}
+	arg := lisp.NewInt(16)
+	_, err := syntaxClassToChar(&arg)
+	if sig, ok := err.(*Signal); !ok || sig.Symbol != SymArgsOutOfRange {
+		t.Errorf("syntax-class-to-char 16: expected args-out-of-range, got %v", err)
+	}
+}
+
+func TestSkipSyntax(t *testing.T) {
+	tests := []struct {
+		before  string
+		forward bool
+		syntax  string
+		lim     lisp.Object
+		after   string
+		result  int64
+	}{
+		{"|  foo", true, " ", lisp.Nil, "  |foo", 2},
+		{"|  foo", true, "-", lisp.Nil, "  |foo", 2},
+		{"|foo-bar baz", true, "w_", lisp.Nil, "foo-bar| baz", 7},
+		{"|foo-bar baz", true, "^ ", lisp.Nil, "foo-bar| baz", 7},
+		{"|foo-bar baz", true, "w", lisp.NewInt(3), "fo|o-bar baz", 2},
+		{"foo-bar baz|", false, "w", lisp.Nil, "foo-bar |baz", -3},
+		{"foo-bar baz|", false, "w_ ", lisp.Nil, "|foo-bar baz", -11},
+		{"((x)|", false, "()", lisp.Nil, "((x|)", -1},
+		{"foo| ", false, "", lisp.Nil, "foo| ", 0},
+	}
+	st := newStandardSyntaxTable()
+	for _, tt := range tests {
+		buf := newTestBuffer(tt.before)
+		syntax := lisp.NewString([]byte(tt.syntax))
+		res, err := skipSyntax(buf, st, tt.forward, &syntax, &tt.lim)
+		if err != nil {
+			t.Errorf("skip-syntax %q in %q: unexpected error: %v",
+				tt.syntax, tt.before, err)
+			continue
+		}
+		if have := bufferString(buf); have != tt.after {
+			t.Errorf("skip-syntax %q in %q: buffer mismatch:\nhave: %q\nwant: %q",
+				tt.syntax, tt.before, have, tt.after)
+		}
+		if res.Int() != tt.result {
+			t.Errorf("skip-syntax %q in %q: result mismatch:\nhave: %d\nwant: %d",
+				tt.syntax, tt.before, res.Int(), tt.result)
+		}
+	}
+}
+
+func TestScanSexps(t *testing.T) {
+	lispTable := newLispSyntaxTable()
+	cTable := newCSyntaxTable()
+
+	tests := []struct {
+		st     *lisp.SyntaxTable
+		before string
+		count  int
+		after  string // Empty string means nil result
+	}{
+		0:  {lispTable, "|foo bar", 1, "foo| bar"},
+		1:  {lispTable, "|foo bar", 2, "foo bar|"},
+		2:  {lispTable, "|foo bar", 3, ""},
+		3:  {lispTable, "|(a (b) c) d", 1, "(a (b) c)| d"},
+		4:  {lispTable, "(a |(b) c) d", 2, "(a (b) c|) d"},
+		5:  {lispTable, `|"a \" b" c`, 1, `"a \" b"| c`},
+		6:  {lispTable, "|'(a b) c", 1, "'(a b)| c"},
+		7:  {lispTable, "|?\\( x", 1, "?\\(| x"},
+		8:  {lispTable, "|;; (\n(x)", 1, ";; (\n(x)|"},
+		9:  {lispTable, "|[1 2] x", 1, "[1 2]| x"},
+		10: {lispTable, "|foo\\ bar baz", 1, "foo\\ bar| baz"},
+		11: {lispTable, "(a (b) c)| d", -1, "|(a (b) c) d"},
+		12: {lispTable, "foo bar|", -2, "|foo bar"},
+		13: {lispTable, `x "a \" b"|`, -1, `x |"a \" b"`},
+		14: {lispTable, "x (y) ; )\n|", -1, "x |(y) ; )\n"},
+		15: {lispTable, "x foo\\ bar|", -1, "x |foo\\ bar"},
+		16: {lispTable, "x '(a b)|", -1, "x '|(a b)"},
+		17: {lispTable, "|   ", 1, ""},
+		18: {cTable, "|/* ) */ (x) y", 1, "/* ) */ (x)| y"},
+		19: {cTable, "|// )\n(x) y", 1, "// )\n(x)| y"},
+		20: {cTable, "(x) /* ( */|", -1, "|(x) /* ( */"},
+		21: {cTable, "|'a)b' c", 1, "'a)b'| c"},
+	}
+	for i, tt := range tests {
+		buf := newTestBuffer(tt.before)
+		buf.ParseSexpIgnoreComments = true
+		res, err := scanLists(buf, tt.st, buf.Point(), tt.count, 0, true)
+		if err != nil {
+			t.Errorf("test %d: scan-sexps %q: unexpected error: %v",
+				i, tt.before, err)
+			continue
+		}
+		have := ""
+		if !lisp.Null(&res) {
+			buf.SetPoint(int(res.Int()))
+			have = bufferString(buf)
+		}
+		if have != tt.after {
+			t.Errorf("test %d: scan-sexps %q %d:\nhave: %q\nwant: %q",
+				i, tt.before, tt.count, have, tt.after)
+		}
+	}
+}
+
+func TestScanLists(t *testing.T) {
+	st := newLispSyntaxTable()
+	tests := []struct {
+		before string
+		count  int
+		depth  int
+		after  string
+	}{
+		{"|a (b) c", 1, 0, "a (b)| c"},
+		{"(a |(b) c) d", 1, 1, "(a (b) c)| d"},
+		{"(a (b) c|) d", -1, 1, "|(a (b) c) d"},
+		{"(a (b| c)) d", 1, 1, "(a (b c)|) d"},
+	}
+	for _, tt := range tests {
+		buf := newTestBuffer(tt.before)
+		res, err := scanLists(buf, st, buf.Point(), tt.count, tt.depth, false)
+		if err != nil {
+			t.Errorf("scan-lists %q: unexpected error: %v", tt.before, err)
+			continue
+		}
+		buf.SetPoint(int(res.Int()))
+		if have := bufferString(buf); have != tt.after {
+			t.Errorf("scan-lists %q %d %d:\nhave: %q\nwant: %q",
+				tt.before, tt.count, tt.depth, have, tt.after)
+		}
+	}
+}
+
+func TestScanError(t *testing.T) {
+	st := newLispSyntaxTable()
+	tests := []struct {
+		text  string
+		count int
+		msg   string
+	}{
+		{"|(a b", 1, "Unbalanced parentheses"},
+		{"|\"abc", 1, "Unbalanced parentheses"},
+		{"|a) b", 2, "Containing expression ends prematurely"},
+		{"a (b|", -2, "Containing expression ends prematurely"},
+		{"a b)|", -1, "Unbalanced parentheses"},
+	}
+	for _, tt := range tests {
+		buf := newTestBuffer(tt.text)
+		_, err := scanLists(buf, st, buf.Point(), tt.count, 0, true)
+		sig, ok := err.(*Signal)
+		if !ok || sig.Symbol != SymScanError {
+			t.Errorf("scan-sexps %q: expected scan-error, got %v", tt.text, err)
+			continue
+		}
+		msg := sig.Data.Cons().Car
+		if have := string(msg.String().Chars); have != tt.msg {
+			t.Errorf("scan-sexps %q: message mismatch:\nhave: %s\nwant: %s",
+				tt.text, have, tt.msg)
+		}
+	}
+}
+
+func TestEvalCharSyntax(t *testing.T) {
+	interp := newTestInterpreter(t)
+	interp.buffer = newTestBuffer("")
+
+	type (
+		consts []interface{}
+		steps  []interface{}
+	)
+	interp.LoadSteps(steps{
+		OpConstant0, `97`,
+		OpCharSyntax, `119`,
+		OpConstant1, `119 59`,
+		OpCharSyntax, `119 46`,
+	})
+	interp.Run("CharSyntax", promoteObjects(consts{int('a'), int(';')}), nil)
+
+	interp.buffer.SyntaxTable = newLispSyntaxTable()
+	interp.LoadSteps(steps{
+		OpConstant1, `59`,
+		OpCharSyntax, `60`,
+	})
+	interp.Run("CharSyntaxLocal", promoteObjects(consts{int('a'), int(';')}), nil)
+}
+
+func TestSyntaxSubrs(t *testing.T) {
+	env, ob := newLispEnv()
+	env.buffer = newTestBuffer("|foo-bar (a [b] c) baz")
+
+	tests := []struct {
+		form string
+		want string
+	}{
+		{`(string-to-syntax "()")`, "(4 . 41)"},
+		{`(string-to-syntax ". 12")`, "(196609)"},
+		{`(string-to-syntax "@")`, "nil"},
+		{`(string-to-syntax "Z")`, "Invalid syntax description letter: Z"},
+		{"(syntax-class-to-char 2)", "119"},
+		{"(syntax-class-to-char 16)", "Args out of range: 15, 16"},
+		{"(char-syntax ?-)", "95"},
+		{"(skip-syntax-forward \"w\")", "3"},
+		{"(skip-syntax-forward \"_w\")", "4"},
+		{"(skip-syntax-backward \"w_\" 3)", "-5"},
+		{"(scan-lists 9 1 0)", "18"},
+		{"(scan-lists 10 1 1)", "18"},
+		{"(scan-lists 9 2 0)", "nil"},
+		{"(scan-lists 15 1 0)", `Scan error: "Containing expression ends prematurely", 17, 18`},
+		{"(scan-sexps 1 2)", "18"},
+		{"(scan-sexps 18 -1)", "9"},
+		{"(eq (syntax-table) (standard-syntax-table))", "t"},
+		{"(progn (set-syntax-table (make-syntax-table)) (eq (syntax-table) (standard-syntax-table)))", "nil"},
+		{"(modify-syntax-entry ?- \"w\")", "nil"},
+		{"(skip-syntax-forward \"w\")", "5"},
+		{"(char-syntax ?-)", "119"},
+		{"(progn (modify-syntax-entry '(?a . ?c) \".\" (standard-syntax-table)) (char-syntax ?b))", "46"},
+		{"(progn (set-syntax-table (standard-syntax-table)) (char-syntax ?-))", "95"},
+		{"(set-syntax-table 1)", "Wrong type argument: syntax-table-p, 1"},
+		{"(modify-syntax-entry ?a \"w\" 1)", "Wrong type argument: syntax-table-p, 1"},
+	}
+	for _, test := range tests {
+		form := mustRead(t, test.form, ob)
+		val, err := env.Eval(form, lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+}
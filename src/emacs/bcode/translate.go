@@ -0,0 +1,271 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Pre-decoded instructions.
+//
+// Byte code is compact, but it is slow to interpret: opcode
+// families need operand decoding and 16bit operands are
+// assembled from separate bytes on every execution.
+// Functions are translated into insn arrays when they are
+// created; run executes insns and the original code is
+// kept for the disassembler, the verifier and the optimizer.
+//
+// All forms of an opcode family share the insn opcode and
+// have the decoded operand: stack-ref 2 becomes insnStackRef
+// with arg=2, constant 5 becomes insnConstant that points to
+// the constant. Relative jumps become absolute ones and all
+// jump targets are instruction indexes. OpExt instructions
+// keep their extended opcode in ext; go-call family becomes
+// OpExtGoCallW; pushunwind and rethrow have insn opcodes
+// of their own. Common instruction sequences are then fused
+// into superinstructions, see fuseInsns.
+
+// Pre-decoded instruction opcodes.
+// They are numbered densely, so run dispatches
+// them with a jump table.
+const (
+	// insnBad makes run fail with ErrBadOpcode.
+	// Unknown and unsupported opcodes and truncated
+	// instructions are translated into insnBad; jumps to
+	// the offsets that do not start an instruction go to the
+	// insnBad instruction that terminates every insn array.
+	insnBad uint8 = iota
+
+	insnExt
+	insnStackRef
+	insnCall
+	insnVarRef
+	insnVarSet
+	insnVarBind
+	insnUnbind
+	insnEq
+	insnNot
+	insnCar
+	insnCdr
+	insnCons
+	insnSetcar
+	insnDiscard
+	insnReturn
+	insnAdd1
+	insnSub1
+	insnPlus
+	insnDiff
+	insnMult
+	insnNegate
+	insnCompare
+	insnPoint
+	insnCurrentColumn
+	insnIndentTo
+	insnForwardChar
+	insnForwardWord
+	insnSkipCharsForward
+	insnSkipCharsBackward
+	insnCharSyntax
+	insnForwardLine
+	insnEndOfLine
+	insnSaveExcursion
+	insnSaveRestriction
+	insnSaveCurrentBuffer
+	insnTempOutputBufferSetup
+	insnTempOutputBufferShow
+	insnMatchBeginning
+	insnMatchEnd
+	insnPushCatch
+	insnPushConditionCase
+	insnPopHandler
+	insnPushUnwind
+	insnRethrow
+	insnConstant
+	insnGoto
+	insnGotoIfNil
+	insnGotoIfNonNil
+	insnGotoIfNilElsePop
+	insnGotoIfNonNilElsePop
+	insnDup
+	insnStackSet
+	insnDiscardN
+)
+
+// insnOps maps opcodes to insn opcodes.
+// Opcode families are handled by translateInstr.
+var insnOps = [256]uint8{
+	OpEq:                    insnEq,
+	OpNot:                   insnNot,
+	OpCar:                   insnCar,
+	OpCdr:                   insnCdr,
+	OpCons:                  insnCons,
+	OpSetcar:                insnSetcar,
+	OpDiscard:               insnDiscard,
+	OpReturn:                insnReturn,
+	OpAdd1:                  insnAdd1,
+	OpSub1:                  insnSub1,
+	OpPlus:                  insnPlus,
+	OpDiff:                  insnDiff,
+	OpMult:                  insnMult,
+	OpNegate:                insnNegate,
+	OpEqlsign:               insnCompare,
+	OpGtr:                   insnCompare,
+	OpLss:                   insnCompare,
+	OpLeq:                   insnCompare,
+	OpGeq:                   insnCompare,
+	OpPoint:                 insnPoint,
+	OpCurrentColumn:         insnCurrentColumn,
+	OpIndentTo:              insnIndentTo,
+	OpForwardChar:           insnForwardChar,
+	OpForwardWord:           insnForwardWord,
+	OpSkipCharsForward:      insnSkipCharsForward,
+	OpSkipCharsBackward:     insnSkipCharsBackward,
+	OpCharSyntax:            insnCharSyntax,
+	OpForwardLine:           insnForwardLine,
+	OpEndOfLine:             insnEndOfLine,
+	OpSaveExcursion:         insnSaveExcursion,
+	OpSaveRestriction:       insnSaveRestriction,
+	OpSaveCurrentBuffer:     insnSaveCurrentBuffer,
+	OpSaveCurrentBuffer2:    insnSaveCurrentBuffer,
+	OpTempOutputBufferSetup: insnTempOutputBufferSetup,
+	OpTempOutputBufferShow:  insnTempOutputBufferShow,
+	OpMatchBeginning:        insnMatchBeginning,
+	OpMatchEnd:              insnMatchEnd,
+	OpPushCatch:             insnPushCatch,
+	OpPushConditionCase:     insnPushConditionCase,
+	OpPopHandler:            insnPopHandler,
+	OpConstantW:             insnConstant,
+	OpGotoW:                 insnGoto,
+	OpGotoIfNilW:            insnGotoIfNil,
+	OpGotoIfNonNilW:         insnGotoIfNonNil,
+	OpGotoIfNilElsePopW:     insnGotoIfNilElsePop,
+	OpGotoIfNonNilElsePopW:  insnGotoIfNonNilElsePop,
+	OpRgotoB:                insnGoto,
+	OpRgotoIfNilB:           insnGotoIfNil,
+	OpRgotoIfNonNilB:        insnGotoIfNonNil,
+	OpRgotoIfNilElsePopB:    insnGotoIfNilElsePop,
+	OpRgotoIfNonNilElsePopB: insnGotoIfNonNilElsePop,
+	OpDup:                   insnDup,
+	OpStackSetB:             insnStackSet,
+	OpStackSetW:             insnStackSet,
+	OpDiscardB:              insnDiscardN,
+}
+
+// familyInsnOps maps opcode families that start
+// at OpVarRef0 to insn opcodes.
+var familyInsnOps = [...]uint8{
+	insnVarRef,
+	insnVarSet,
+	insnVarBind,
+	insnCall,
+	insnUnbind,
+}
+
+// insn is a pre-decoded instruction.
+type insn struct {
+	// op is insn opcode.
+	// The first insn of a fused sequence
+	// has superinstruction opcode here.
+	op uint8
+
+	// ext is the extended opcode of insnExt instruction.
+	ext uint8
+
+	// arg is the decoded operand.
+	// For jumps it is the target instruction index.
+	// Instructions without operand have their
+	// original opcode here.
+	arg uint32
+
+	// obj points to the fn.consts element that is
+	// referenced by the instruction.
+	obj *lisp.Object
+}
+
+// translate fills fn.insns from fn.code
+// and fuses superinstructions.
+// Code does not have to be verified.
+func (fn *Func) translate() {
+	fn.translatePlain()
+	fuseInsns(fn.insns)
+}
+
+// translatePlain fills fn.insns from fn.code
+// without superinstructions.
+func (fn *Func) translatePlain() {
+	code := fn.code
+	var decoded []instr
+	for pc := 0; pc < len(code); {
+		ins, ok := decodeInstr(code, uint32(pc))
+		if !ok {
+			ins.info = nil
+			ins.width = uint32(len(code) - pc)
+		}
+		decoded = append(decoded, ins)
+		pc += int(ins.width)
+	}
+
+	// index maps code offsets to instruction indexes.
+	bad := uint32(len(decoded))
+	index := make([]uint32, len(code))
+	for i := range index {
+		index[i] = bad
+	}
+	for i, ins := range decoded {
+		index[ins.pc] = uint32(i)
+	}
+
+	insns := make([]insn, len(decoded)+1)
+	for i, ins := range decoded {
+		insns[i] = fn.translateInstr(ins, index, bad)
+	}
+	insns[bad] = insn{op: insnBad}
+	fn.insns = insns
+}
+
+// translateInstr returns pre-decoded form of ins.
+func (fn *Func) translateInstr(ins instr, index []uint32, bad uint32) insn {
+	if ins.info == nil {
+		return insn{op: insnBad}
+	}
+	op := fn.code[ins.pc]
+	in := insn{arg: uint32(ins.arg)}
+	switch {
+	case op == OpExt:
+		ext := fn.code[ins.pc+1]
+		switch {
+		case ext >= OpExtGoCall0 && ext <= OpExtGoCallW:
+			ext = OpExtGoCallW
+		case ext == OpExtPushUnwind:
+			in.op = insnPushUnwind
+		case ext == OpExtRethrow:
+			in.op = insnRethrow
+		}
+		if in.op == insnBad {
+			return insn{op: insnExt, ext: ext, arg: uint32(ins.arg)}
+		}
+	case op >= OpConstant0:
+		in.op = insnConstant
+	case op >= OpStackRef1 && op <= OpStackRefW:
+		in.op = insnStackRef
+	case op >= OpVarRef0 && op <= OpUnbindW:
+		// Opcode families are aligned to 8 opcodes.
+		in.op = familyInsnOps[(op-OpVarRef0)/8]
+	default:
+		in.op = insnOps[op]
+	}
+
+	switch ins.info.kind {
+	case argNone:
+		in.arg = uint32(op)
+	case argConst:
+		if ins.arg >= len(fn.consts) {
+			return insn{op: insnBad}
+		}
+		in.obj = &fn.consts[ins.arg]
+	case argJump, argRelJump:
+		in.arg = bad
+		if ins.arg >= 0 && ins.arg < len(index) {
+			in.arg = index[ins.arg]
+		}
+	}
+	return in
+}
@@ -0,0 +1,88 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	ob := lisp.NewObarray()
+	fn, err := assemble([]byte(`
+		stack-ref 2
+		stack-ref 300
+		constant a
+		constant 70
+		varref x
+		call 2
+		call 200
+		go-call 1
+		goto-if-nil l1
+		goto-if-non-nil-else-pop l1
+		eqlsign
+	l1:
+		discardN-preserve-tos 1
+		return`), ob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []insn{
+		{op: insnStackRef, arg: 2},
+		{op: insnStackRef, arg: 300},
+		{op: insnConstant, obj: &fn.consts[0]},
+		{op: insnConstant, arg: 1, obj: &fn.consts[1]},
+		{op: insnVarRef, arg: 2, obj: &fn.consts[2]},
+		{op: insnCall, arg: 2},
+		{op: insnCall, arg: 200},
+		{op: insnExt, ext: OpExtGoCallW, arg: 1},
+		{op: insnGotoIfNil, arg: 11},
+		{op: insnGotoIfNonNilElsePop, arg: 11},
+		{op: insnCompare, arg: uint32(OpEqlsign)},
+		{op: insnDiscardN, arg: discardPreserveTOS | 1},
+		{op: insnReturn, arg: uint32(OpReturn)},
+		{op: insnBad},
+	}
+	if len(fn.insns) != len(want) {
+		t.Fatalf("have %d insns, want %d", len(fn.insns), len(want))
+	}
+	for i := range want {
+		if fn.insns[i] != want[i] {
+			t.Errorf("insn %d:\nhave: %+v\nwant: %+v", i, fn.insns[i], want[i])
+		}
+	}
+
+	// Relative jump targets are resolved too.
+	fn = &Func{code: []byte{OpDup, OpRgotoB, 128, OpReturn}}
+	fn.translate()
+	if have := fn.insns[1]; have != (insn{op: insnGoto, arg: 2}) {
+		t.Errorf("rgoto: have %+v", have)
+	}
+
+	// Truncated instructions can't be executed.
+	fn = &Func{code: []byte{OpReturn, OpConstantW, 1}}
+	fn.translate()
+	if len(fn.insns) != 3 || fn.insns[1].op != insnBad {
+		t.Errorf("truncated code: have %+v", fn.insns)
+	}
+}
+
+func TestTranslateBadCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+	}{
+		{"UnknownOpcode", []byte{0112, OpExt, OpExtStop}},
+		{"Unsupported", []byte{OpNth, OpExt, OpExtStop}},
+		{"BadConstant", []byte{OpConstant5, OpExt, OpExtStop}},
+		{"JumpIntoInstr", []byte{OpGotoW, 1, 0, OpExt, OpExtStop}},
+		{"JumpOutOfCode", []byte{OpGotoW, 100, 0, OpExt, OpExtStop}},
+	}
+	env := newTestEnv()
+	for _, test := range tests {
+		fn := Func{code: test.code}
+		fn.translate()
+		if _, err := eval(&env.Env, &fn, 0); err != ErrBadOpcode {
+			t.Errorf("%s: have %v error, want %v", test.name, err, ErrBadOpcode)
+		}
+	}
+}
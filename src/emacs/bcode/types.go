@@ -0,0 +1,57 @@
+package bcode
+
+import (
+	"emacs/lisp"
+)
+
+// Type introspection functions.
+
+// typeSymbols maps lisp.Type to `type-of` results.
+//
+// Should be treated as constants.
+var typeSymbols = [...]lisp.Object{
+	lisp.TypeInt:         lisp.NewSymbol("integer"),
+	lisp.TypeFloat:       lisp.NewSymbol("float"),
+	lisp.TypeSymbol:      lisp.NewSymbol("symbol"),
+	lisp.TypeVector:      lisp.NewSymbol("vector"),
+	lisp.TypeCons:        lisp.NewSymbol("cons"),
+	lisp.TypeString:      lisp.NewSymbol("string"),
+	lisp.TypeBuffer:      lisp.NewSymbol("buffer"),
+	lisp.TypeSyntaxTable: lisp.NewSymbol("char-table"),
+	lisp.TypeUserPtr:     lisp.NewSymbol("user-ptr"),
+	lisp.TypeFunc:        lisp.NewSymbol("compiled-function"),
+}
+
+// typeOf implements `type-of`.
+// Returns a symbol that names x type.
+func typeOf(x *lisp.Object) lisp.Object {
+	return typeSymbols[x.Type]
+}
+
+// userPtrp implements `user-ptrp`.
+func userPtrp(x *lisp.Object) lisp.Object {
+	return lisp.Bool(x.Type == lisp.TypeUserPtr)
+}
+
+// UserPtrArg returns a Go value that is wrapped by user-ptr x.
+// If typeName is not empty, x must have the same TypeName.
+// Signals wrong-type-argument otherwise.
+//
+// It is intended to be used inside Go functions,
+// along with a type assertion:
+//
+//	val, err := bcode.UserPtrArg(args[1], "file")
+//	if err != nil {
+//		return err
+//	}
+//	f := val.(*os.File)
+func UserPtrArg(x lisp.Object, typeName string) (interface{}, error) {
+	if x.Type != lisp.TypeUserPtr {
+		return nil, wrongTypeArgument(SymUserPtrp, x)
+	}
+	p := x.UserPtr()
+	if typeName != "" && p.TypeName != typeName {
+		return nil, wrongTypeArgument(SymUserPtrp, x)
+	}
+	return p.Value, nil
+}
@@ -0,0 +1,102 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+func TestTypeOf(t *testing.T) {
+	tests := []struct {
+		x    lisp.Object
+		want string
+	}{
+		{lisp.NewInt(1), "integer"},
+		{lisp.NewFloat(1), "float"},
+		{lisp.Nil, "symbol"},
+		{lisp.NewVector(nil), "vector"},
+		{lisp.List(lisp.T), "cons"},
+		{lisp.NewString(nil), "string"},
+		{lisp.NewBuffer("a"), "buffer"},
+		{lisp.NewSyntaxTable(nil), "char-table"},
+		{lisp.NewUserPtr(1, "", nil), "user-ptr"},
+		{(&Func{}).Object(), "compiled-function"},
+	}
+	for _, tt := range tests {
+		if have := lisp.ObjectString(typeOf(&tt.x)); have != tt.want {
+			t.Errorf("(type-of %s): have %s, want %s", lisp.ObjectString(tt.x), have, tt.want)
+		}
+		wantUserPtrp := tt.want == "user-ptr"
+		if have := userPtrp(&tt.x); lisp.Null(&have) == wantUserPtrp {
+			t.Errorf("(user-ptrp %s): have %s", lisp.ObjectString(tt.x), lisp.ObjectString(have))
+		}
+	}
+}
+
+func TestUserPtrArg(t *testing.T) {
+	file := lisp.NewUserPtr("file-value", "file", nil)
+
+	tests := []struct {
+		x        lisp.Object
+		typeName string
+		want     string
+	}{
+		{file, "file", "file-value"},
+		{file, "", "file-value"},
+		{file, "process", "(wrong-type-argument . (user-ptrp . (#<user-ptr file> . nil)))"},
+		{lisp.NewInt(1), "", "(wrong-type-argument . (user-ptrp . (1 . nil)))"},
+	}
+	for _, tt := range tests {
+		val, err := UserPtrArg(tt.x, tt.typeName)
+		var have string
+		if err != nil {
+			have = err.Error()
+		} else {
+			have = val.(string)
+		}
+		if have != tt.want {
+			t.Errorf("UserPtrArg(%s, %q):\nhave: %s\nwant: %s",
+				lisp.ObjectString(tt.x), tt.typeName, have, tt.want)
+		}
+	}
+}
+
+func TestTypeOfSubrs(t *testing.T) {
+	env, ob := newLispEnv()
+	tests := []struct {
+		form string
+		want string
+	}{
+		{"(type-of 1)", "integer"},
+		{"(eq (type-of 1.5) 'float)", "t"},
+		{"(type-of 'a)", "symbol"},
+		{"(type-of nil)", "symbol"},
+		{"(type-of '(1))", "cons"},
+		{"(type-of [1])", "vector"},
+		{`(type-of "s")`, "string"},
+		{"(type-of (byte-compile (lambda () 1)))", "compiled-function"},
+		{"(user-ptrp 1)", "nil"},
+		{"(type-of)", "Wrong number of arguments: (1 . 1), 0"},
+	}
+	for _, test := range tests {
+		form := mustRead(t, test.form, ob)
+		val, err := env.Eval(form, lisp.T)
+		have := lisp.Prin1String(val)
+		if err != nil {
+			have = ErrorMessage(err)
+		}
+		if have != test.want {
+			t.Errorf("eval %s:\nhave: %s\nwant: %s", test.form, have, test.want)
+		}
+	}
+
+	p := lisp.NewUserPtr(1, "", nil)
+	for name, want := range map[string]string{"type-of": "user-ptr", "user-ptrp": "t"} {
+		val, err := env.Funcall(ob.Intern(name), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := lisp.Prin1String(val); have != want {
+			t.Errorf("(%s %s): have %s, want %s", name, lisp.Prin1String(p), have, want)
+		}
+	}
+}
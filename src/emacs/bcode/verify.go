@@ -0,0 +1,383 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"fmt"
+)
+
+// Static byte code verifier.
+//
+// Verify checks code without executing it:
+//	- every instruction decodes;
+//	- jump targets are instruction boundaries;
+//	- constant indexes are inside constant vector;
+//	- stack depth is the same on every path that reaches
+//	  an instruction, never drops below zero and never
+//	  exceeds maxStackDepth;
+//	- execution can't fall off the end of code.
+//
+// Instructions that are not reachable from pc=0 are only
+// checked for decoding errors.
+
+// maxStackDepth limits stack depth of a single function.
+// It is the limit of the largest stack-ref operand.
+const maxStackDepth = 0xFFFF
+
+// VerifyError describes malformed byte code.
+type VerifyError struct {
+	// PC is an offset of the offending instruction.
+	PC int
+
+	Msg string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("pc %d: %s", e.PC, e.Msg)
+}
+
+// stackUse describes instruction stack effect:
+// pop values are consumed, then push values are produced.
+type stackUse struct {
+	pop, push int
+}
+
+// opStackUse holds stack effects of opcodes that have
+// no operand-dependent behavior.
+var opStackUse = [256]stackUse{
+	OpPopHandler:        {0, 0},
+	OpPushConditionCase: {1, 0},
+	OpPushCatch:         {1, 0},
+
+	OpNth:       {2, 1},
+	OpSymbolp:   {1, 1},
+	OpConsp:     {1, 1},
+	OpStringp:   {1, 1},
+	OpListp:     {1, 1},
+	OpEq:        {2, 1},
+	OpMemq:      {2, 1},
+	OpNot:       {1, 1},
+	OpCar:       {1, 1},
+	OpCdr:       {1, 1},
+	OpCons:      {2, 1},
+	OpList1:     {1, 1},
+	OpList2:     {2, 1},
+	OpList3:     {3, 1},
+	OpList4:     {4, 1},
+	OpLength:    {1, 1},
+	OpAref:      {2, 1},
+	OpAset:      {3, 1},
+	OpSet:       {2, 1},
+	OpFset:      {2, 1},
+	OpGet:       {2, 1},
+	OpSubstring: {3, 1},
+	OpConcat2:   {2, 1},
+	OpConcat3:   {3, 1},
+	OpConcat4:   {4, 1},
+	OpSub1:      {1, 1},
+	OpAdd1:      {1, 1},
+	OpEqlsign:   {2, 1},
+	OpGtr:       {2, 1},
+	OpLss:       {2, 1},
+	OpLeq:       {2, 1},
+	OpGeq:       {2, 1},
+	OpDiff:      {2, 1},
+	OpNegate:    {1, 1},
+	OpPlus:      {2, 1},
+	OpMax:       {2, 1},
+	OpMin:       {2, 1},
+	OpMult:      {2, 1},
+
+	OpPoint:               {0, 1},
+	OpSaveCurrentBuffer:   {0, 0},
+	OpGotoChar:            {1, 1},
+	OpInsert:              {1, 1},
+	OpPointMax:            {0, 1},
+	OpPointMin:            {0, 1},
+	OpCharAfter:           {1, 1},
+	OpFollowingChar:       {0, 1},
+	OpPrecedingChar:       {0, 1},
+	OpCurrentColumn:       {0, 1},
+	OpIndentTo:            {1, 1},
+	OpEolp:                {0, 1},
+	OpEobp:                {0, 1},
+	OpBolp:                {0, 1},
+	OpBobp:                {0, 1},
+	OpCurrentBuffer:       {0, 1},
+	OpSetBuffer:           {1, 1},
+	OpSaveCurrentBuffer2:  {0, 0},
+	OpInteractivep:        {0, 1},
+	OpForwardChar:         {1, 1},
+	OpForwardWord:         {1, 1},
+	OpSkipCharsForward:    {2, 1},
+	OpSkipCharsBackward:   {2, 1},
+	OpForwardLine:         {1, 1},
+	OpCharSyntax:          {1, 1},
+	OpBufferSubstring:     {2, 1},
+	OpDeleteRegion:        {2, 1},
+	OpNarrowToRegion:      {2, 1},
+	OpWiden:               {0, 1},
+	OpEndOfLine:           {1, 1},
+	OpReturn:              {1, 0},
+	OpDiscard:             {1, 0},
+	OpDup:                 {1, 2},
+	OpSaveExcursion:       {0, 0},
+	OpSaveWindowExcursion: {0, 0},
+	OpSaveRestriction:     {0, 0},
+	OpCatch:               {2, 1},
+	OpUnwindProtect:       {1, 0},
+	OpConditionCase:       {3, 1},
+
+	OpTempOutputBufferSetup: {1, 1},
+	OpTempOutputBufferShow:  {2, 1},
+
+	OpUnbindAll:      {0, 0},
+	OpSetMarker:      {3, 1},
+	OpMatchBeginning: {1, 1},
+	OpMatchEnd:       {1, 1},
+	OpUpcase:         {1, 1},
+	OpDowncase:       {1, 1},
+	OpStringEqlsign:  {2, 1},
+	OpStringLss:      {2, 1},
+	OpEqual:          {2, 1},
+	OpNthCdr:         {2, 1},
+	OpElt:            {2, 1},
+	OpMember:         {2, 1},
+	OpAssq:           {2, 1},
+	OpNreverse:       {1, 1},
+	OpSetcar:         {2, 1},
+	OpSetcdr:         {2, 1},
+	OpCarSafe:        {1, 1},
+	OpCdrSafe:        {1, 1},
+	OpNconc:          {2, 1},
+	OpQuo:            {2, 1},
+	OpRem:            {2, 1},
+	OpNumberp:        {1, 1},
+	OpIntegerp:       {1, 1},
+}
+
+// instrStackUse returns ins stack effect.
+// For stack-ref and stack-set, pop includes the
+// referenced slot, so depth check covers it.
+func instrStackUse(code []byte, ins instr) stackUse {
+	n := ins.arg
+	switch ins.info.name {
+	case "stack-ref":
+		// Referenced value is not popped, but must exist.
+		return stackUse{pop: n + 1, push: n + 2}
+	case "stack-set":
+		return stackUse{pop: n + 1, push: n}
+	case "varref", "constant":
+		return stackUse{0, 1}
+	case "varset", "varbind":
+		return stackUse{1, 0}
+	case "unbind":
+		return stackUse{0, 0}
+	case "call", "go-call":
+		return stackUse{pop: n + 1, push: 1}
+	case "listN", "concatN", "insertN":
+		return stackUse{pop: n, push: 1}
+	case "discardN":
+		if n&discardPreserveTOS != 0 {
+			n &^= discardPreserveTOS
+			return stackUse{pop: n + 1, push: 1}
+		}
+		return stackUse{pop: n, push: 0}
+	case "goto", "rgoto", "stop", "shagit", "pushunwind":
+		return stackUse{0, 0}
+	case "rethrow":
+		return stackUse{1, 1}
+	case "goto-if-nil", "goto-if-non-nil",
+		"rgoto-if-nil", "rgoto-if-non-nil",
+		"goto-if-nil-else-pop", "goto-if-non-nil-else-pop",
+		"rgoto-if-nil-else-pop", "rgoto-if-non-nil-else-pop":
+		// Else-pop jumps keep the value when jump is taken,
+		// see Verify.
+		return stackUse{1, 0}
+	}
+	return opStackUse[code[ins.pc]]
+}
+
+// isElsePop reports whether jump instruction keeps
+// its operand on the stack when the jump is taken.
+func isElsePop(name string) bool {
+	switch name {
+	case "goto-if-nil-else-pop", "goto-if-non-nil-else-pop",
+		"rgoto-if-nil-else-pop", "rgoto-if-non-nil-else-pop":
+		return true
+	}
+	return false
+}
+
+// isHandlerPush reports whether name is the mnemonic
+// of an instruction that pushes a catch, condition-case
+// or unwind handler; its jump target is the handler.
+func isHandlerPush(name string) bool {
+	return name == "pushcatch" || name == "pushconditioncase" || name == "pushunwind"
+}
+
+// Verify checks fn code and returns its maximal stack depth.
+// nargs is the number of values that are on the stack
+// when fn starts execution.
+func Verify(fn *Func, nargs int) (int, error) {
+	_, maxDepth, err := stackDepths(fn, nargs)
+	return maxDepth, err
+}
+
+// stackDepths verifies fn code like Verify does and returns
+// stack depths before every instruction along with the maximal
+// stack depth. depths are indexed by pc; unreachable
+// instructions and non-boundary offsets have -1.
+func stackDepths(fn *Func, nargs int) ([]int, int, error) {
+	code := fn.code
+
+	// Decode all instructions to find instruction boundaries.
+	// instrs is indexed by pc; widths of non-boundary entries are 0.
+	instrs := make([]instr, len(code))
+	for pc := 0; pc < len(code); {
+		ins, ok := decodeInstr(code, uint32(pc))
+		if !ok {
+			return nil, 0, &VerifyError{PC: pc, Msg: "truncated instruction"}
+		}
+		if ins.info == nil {
+			if code[pc] == OpExt {
+				return nil, 0, &VerifyError{PC: pc, Msg: fmt.Sprintf("unknown extended opcode %d", code[pc+1])}
+			}
+			return nil, 0, &VerifyError{PC: pc, Msg: fmt.Sprintf("unknown opcode %d", code[pc])}
+		}
+		instrs[pc] = ins
+		pc += int(ins.width)
+	}
+
+	for pc, ins := range instrs {
+		if ins.width == 0 {
+			continue
+		}
+		switch ins.info.kind {
+		case argConst:
+			if ins.arg >= len(fn.consts) {
+				return nil, 0, &VerifyError{
+					PC:  pc,
+					Msg: fmt.Sprintf("constant index %d is out of range [0,%d)", ins.arg, len(fn.consts)),
+				}
+			}
+		case argJump, argRelJump:
+			if ins.arg < 0 || ins.arg >= len(code) || instrs[ins.arg].width == 0 {
+				return nil, 0, &VerifyError{
+					PC:  pc,
+					Msg: fmt.Sprintf("jump target %d is not an instruction boundary", ins.arg),
+				}
+			}
+		}
+	}
+
+	// Propagate stack depths along all execution paths.
+	// depths are known stack depths; -1 for unvisited instructions.
+	// Every depth an instruction is entered with counts for
+	// maxDepth, including the handler entry depths that no
+	// instruction pushes to.
+	depths := make([]int, len(code))
+	for i := range depths {
+		depths[i] = -1
+	}
+	maxDepth := nargs
+	var queue []int
+	enter := func(from, pc, depth int) error {
+		if pc >= len(code) {
+			return &VerifyError{PC: from, Msg: "execution falls off the end of code"}
+		}
+		if depth > maxStackDepth {
+			return &VerifyError{
+				PC:  from,
+				Msg: fmt.Sprintf("stack depth %d exceeds the limit of %d", depth, maxStackDepth),
+			}
+		}
+		if have := depths[pc]; have != -1 {
+			if have != depth {
+				return &VerifyError{
+					PC:  from,
+					Msg: fmt.Sprintf("inconsistent stack depth at %d: %d and %d", pc, have, depth),
+				}
+			}
+			return nil
+		}
+		depths[pc] = depth
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		queue = append(queue, pc)
+		return nil
+	}
+
+	if len(code) == 0 {
+		return nil, 0, &VerifyError{PC: 0, Msg: "empty code"}
+	}
+	if err := enter(0, 0, nargs); err != nil {
+		return nil, 0, err
+	}
+	for len(queue) != 0 {
+		pc := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		ins := instrs[pc]
+		depth := depths[pc]
+
+		use := instrStackUse(code, ins)
+		if depth < use.pop {
+			return nil, 0, &VerifyError{
+				PC:  pc,
+				Msg: fmt.Sprintf("%s: stack underflow (depth %d, needs %d)", ins.info.name, depth, use.pop),
+			}
+		}
+		next := depth - use.pop + use.push
+		if next > maxStackDepth {
+			return nil, 0, &VerifyError{
+				PC:  pc,
+				Msg: fmt.Sprintf("stack depth %d exceeds the limit of %d", next, maxStackDepth),
+			}
+		}
+		if next > maxDepth {
+			maxDepth = next
+		}
+
+		switch ins.info.name {
+		case "return", "stop":
+			continue
+		}
+		if ins.info.kind == argJump || ins.info.kind == argRelJump {
+			jumpDepth := next
+			if isElsePop(ins.info.name) {
+				jumpDepth = depth
+			}
+			if isHandlerPush(ins.info.name) {
+				// The handler is entered with the thrown
+				// value, signal data or pending error pushed.
+				jumpDepth = next + 1
+			}
+			if err := enter(pc, ins.arg, jumpDepth); err != nil {
+				return nil, 0, err
+			}
+			if ins.info.name == "goto" || ins.info.name == "rgoto" {
+				continue
+			}
+		}
+		if err := enter(pc, pc+int(ins.width), next); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return depths, maxDepth, nil
+}
+
+// NewFunc returns verified compiled function.
+// nargs is the number of arguments that function
+// finds on the stack when it is called.
+func NewFunc(code []byte, consts []lisp.Object, nargs int) (*Func, error) {
+	fn := &Func{code: code, consts: consts}
+	maxDepth, err := Verify(fn, nargs)
+	if err != nil {
+		return nil, err
+	}
+	fn.maxStack = uint32(maxDepth - nargs)
+	fn.nargs = uint32(nargs)
+	fn.translate()
+	return fn, nil
+}
@@ -0,0 +1,264 @@
+package bcode
+
+import (
+	"emacs/lisp"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		src      string
+		nargs    int
+		maxDepth int
+	}{
+		{"constant 1\nreturn", 0, 1},
+		{"stack-ref 1\nstack-ref 1\ncons\nreturn", 2, 4},
+		{
+			// (if x 'a (list x x x))
+			`
+			    dup
+			    goto-if-nil else
+			    constant a
+			    return
+			else:
+			    constant list
+			    stack-ref 1
+			    stack-ref 2
+			    stack-ref 3
+			    go-call 3
+			    return`,
+			1, 5,
+		},
+		{
+			// Else-pop jump keeps the value only when it is taken.
+			`
+			    dup
+			    goto-if-nil-else-pop done
+			    constant 1
+			done:
+			    return`,
+			1, 2,
+		},
+		{
+			// Loop with consistent stack depth.
+			`
+			loop:
+			    dup
+			    goto-if-nil done
+			    cdr
+			    goto loop
+			done:
+			    return`,
+			1, 2,
+		},
+		{
+			// Unreachable code is not checked for stack usage.
+			`
+			    return
+			    cons
+			    cons`,
+			1, 1,
+		},
+		{"constant 1\nstop", 0, 1},
+		{"listN 3\ndiscardN 1\nstop", 3, 3},
+		{"constant 1\ndiscardN-preserve-tos 2\nreturn", 2, 3},
+		{"stack-set 2\ndiscard\nreturn", 3, 3},
+		{"varbind x\nunbind 1\nconstant x\nreturn", 1, 1},
+		{
+			// (catch 'tag (throw 'tag x)): the handler
+			// is entered with the thrown value.
+			`
+			    constant tag
+			    pushcatch done
+			    constant throw
+			    constant tag
+			    stack-ref 2
+			    call 2
+			    pophandler
+			done:
+			    return`,
+			1, 4,
+		},
+		{
+			// (condition-case nil (car x) (error 'bad)):
+			// the handler discards the signal data.
+			`
+			    constant error
+			    pushconditioncase handler
+			    dup
+			    car
+			    pophandler
+			    return
+			handler:
+			    discard
+			    constant bad
+			    return`,
+			1, 2,
+		},
+		{
+			// (unwind-protect (car x) 'unwind): the body
+			// value and the pending error share the slot.
+			`
+			    pushunwind unwind
+			    dup
+			    car
+			    pophandler
+			unwind:
+			    constant unwind
+			    discard
+			    rethrow
+			    return`,
+			1, 3,
+		},
+		{
+			// The handler target is the deepest point:
+			// no instruction pushes the pending error.
+			`
+			    pushunwind handler
+			    car
+			    return
+			handler:
+			    discard
+			    return`,
+			1, 2,
+		},
+	}
+
+	for _, test := range tests {
+		fn, err := assemble([]byte(test.src), lisp.NewObarray())
+		if err != nil {
+			t.Fatalf("assemble %q: %v", test.src, err)
+		}
+		maxDepth, err := Verify(fn, test.nargs)
+		if err != nil {
+			t.Errorf("verify %q: %v", test.src, err)
+			continue
+		}
+		if maxDepth != test.maxDepth {
+			t.Errorf("verify %q: max depth: have %d, want %d", test.src, maxDepth, test.maxDepth)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	tests := []struct {
+		fn    Func
+		nargs int
+		want  string
+	}{
+		{Func{}, 0, "pc 0: empty code"},
+		{Func{code: []byte{OpDup}}, 1, "pc 0: execution falls off the end of code"},
+		{
+			Func{code: []byte{OpConstant0, OpReturn}},
+			0, "pc 0: constant index 0 is out of range [0,0)",
+		},
+		{Func{code: []byte{OpDup, OpReturn}}, 0, "pc 0: dup: stack underflow (depth 0, needs 1)"},
+		{
+			Func{code: []byte{OpStackRefB, 3, OpReturn}},
+			3, "pc 0: stack-ref: stack underflow (depth 3, needs 4)",
+		},
+		{
+			Func{code: []byte{OpCallB, 2, OpReturn}},
+			2, "pc 0: call: stack underflow (depth 2, needs 3)",
+		},
+		{Func{code: []byte{OpConstantW, 0}}, 0, "pc 0: truncated instruction"},
+		{Func{code: []byte{OpReturn, OpExt}}, 0, "pc 1: truncated instruction"},
+		{Func{code: []byte{0112, OpReturn}}, 0, "pc 0: unknown opcode 74"},
+		{Func{code: []byte{OpExt, 100}}, 0, "pc 0: unknown extended opcode 100"},
+		{
+			Func{code: []byte{OpGotoW, 1, 0, OpReturn}},
+			1, "pc 0: jump target 1 is not an instruction boundary",
+		},
+		{
+			Func{code: []byte{OpGotoW, 10, 0}},
+			1, "pc 0: jump target 10 is not an instruction boundary",
+		},
+		{
+			// (if x 1 (progn 1 2)) with a missing discard.
+			Func{
+				code: []byte{
+					OpGotoIfNilW, 7, 0,
+					OpConstant0,
+					OpGotoW, 9, 0,
+					OpConstant0,
+					OpConstant0,
+					OpReturn,
+				},
+				consts: []lisp.Object{lisp.NewInt(1)},
+			},
+			1, "pc 8: inconsistent stack depth at 9: 1 and 2",
+		},
+		{
+			// Handler is entered one value deeper than
+			// the instruction after pushcatch.
+			Func{
+				code:   []byte{OpConstant0, OpPushCatch, 4, 0, OpReturn},
+				consts: []lisp.Object{lisp.NewSymbol("tag")},
+			},
+			1, "pc 1: inconsistent stack depth at 4: 2 and 1",
+		},
+		{
+			Func{code: []byte{OpDup, OpGotoW, 0, 0}},
+			1, "pc 1: inconsistent stack depth at 0: 1 and 2",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Verify(&test.fn, test.nargs)
+		if err == nil {
+			t.Errorf("verify %v: expected error %q", test.fn.code, test.want)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("verify %v:\nhave: %v\nwant: %s", test.fn.code, err, test.want)
+		}
+	}
+}
+
+func TestNewFuncStackOverflow(t *testing.T) {
+	interp := newTestInterpreter(t)
+	// Push more values than Env stack can hold.
+	code := make([]byte, 0, len(interp.stack)+1)
+	for i := 0; i < len(interp.stack); i++ {
+		code = append(code, OpDup)
+	}
+	code = append(code, OpReturn)
+	fn, err := NewFunc(code, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn.maxStack != uint32(len(interp.stack)) {
+		t.Errorf("maxStack: have %d, want %d", fn.maxStack, len(interp.stack))
+	}
+
+	f := interp.AddFunc("overflow", *fn)
+	if _, err := interp.Funcall(f, lisp.Nil); err != ErrStackOverflow {
+		t.Errorf("Funcall: have error %v, want %v", err, ErrStackOverflow)
+	}
+
+	if _, err := NewFunc([]byte{OpDiscard, OpReturn}, nil, 0); err == nil {
+		t.Errorf("NewFunc: expected verification error")
+	}
+}
+
+func TestNewFuncHandlerDepth(t *testing.T) {
+	// The handler is entered with the pending error pushed,
+	// one value deeper than any instruction of the body.
+	code := []byte{OpExt, OpExtPushUnwind, 6, 0, OpCar, OpReturn, OpDiscard, OpReturn}
+	fn, err := NewFunc(code, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn.maxStack != 1 {
+		t.Errorf("maxStack: have %d, want 1", fn.maxStack)
+	}
+	// Exec needs a slot for the callee, the argument
+	// and maxStack slots.
+	master := NewMasterEnv()
+	if _, err := master.NewEnv(2, 0).Exec(fn, lisp.NewInt(1)); err != ErrStackOverflow {
+		t.Errorf("Exec: have error %v, want %v", err, ErrStackOverflow)
+	}
+	if _, err := master.NewEnv(3, 0).Exec(fn, lisp.NewInt(1)); err != nil {
+		t.Errorf("Exec: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,462 @@
+// Command elvm runs and inspects byte-compiled Emacs Lisp files.
+//
+// Usage:
+//
+//	elvm run [-O] [-f FUNC] FILE...
+//	elvm disasm [-O] [-f FUNC] FILE...
+//	elvm verify [-v] FILE|DIR...
+//	elvm bench [-O] [-n N] [-time D] -f FUNC FILE...
+//	elvm profile [-n N] [-len L] FILE...
+//	elvm aot [-O] [-pkg NAME] [-o OUT] FILE...
+//	elvm repl [-O] [FILE...]
+//
+// run loads files and calls FUNC without arguments,
+// like `emacs --batch -l FILE -f FUNC` does.
+// disasm prints disassembly of compiled functions without
+// running any code. verify checks all compiled functions of
+// .elc files; directories are searched recursively.
+// bench loads files and reports average FUNC call time
+// and the number of conses that a call allocates.
+// profile prints the most common sequences of L instructions
+// in compiled functions; the counts guide superinstruction
+// selection.
+// aot writes Go package source that implements compiled
+// functions of files (see bcode.GenerateGo); functions
+// that can't be translated are reported to stderr.
+// repl loads files and starts interactive read-eval-print loop.
+// -O flag makes commands optimize compiled functions
+// with the peephole optimizer before running or printing them.
+//
+// Like Emacs in batch mode, elvm prints Lisp errors to stderr
+// and exits with status 255. Verification failures
+// make verify exit with status 1; usage errors exit with 2.
+package main
+
+import (
+	"bytes"
+	"emacs/bcode"
+	"emacs/lisp"
+	"emacs/repl"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Exit statuses.
+const (
+	exitOK        = 0
+	exitFailed    = 1
+	exitUsage     = 2
+	exitLispError = 255
+)
+
+const usage = `usage: elvm <command> [arguments]
+
+commands:
+  run     load files and call a function
+  disasm  print disassembly of compiled functions
+  verify  verify compiled functions
+  bench   measure function call time
+  profile count common instruction sequences
+  aot     generate Go source from compiled functions
+  repl    start interactive Lisp session
+
+run "elvm <command> -h" for command flags
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes elvm command line and returns exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	commands := map[string]func(c *command) int{
+		"run":     cmdRun,
+		"disasm":  cmdDisasm,
+		"verify":  cmdVerify,
+		"bench":   cmdBench,
+		"profile": cmdProfile,
+		"aot":     cmdAOT,
+		"repl":    cmdRepl,
+	}
+	fn, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "elvm: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+	c := &command{
+		flags:  flag.NewFlagSet(args[0], flag.ContinueOnError),
+		args:   args[1:],
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		ob:     lisp.NewObarray(),
+	}
+	c.flags.SetOutput(stderr)
+	bcode.AddSymbols(c.ob)
+	return fn(c)
+}
+
+// command holds subcommand execution context.
+type command struct {
+	flags  *flag.FlagSet
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	// ob is shared by all loaded files.
+	ob *lisp.Obarray
+
+	// optimize is set by -O flag.
+	optimize bool
+}
+
+// optimizeFlag defines -O flag.
+func (c *command) optimizeFlag() {
+	c.flags.BoolVar(&c.optimize, "O", false, "optimize compiled functions")
+}
+
+// parse parses command flags.
+// Returns false if command should exit with usage error.
+func (c *command) parse(minArgs int) bool {
+	if err := c.flags.Parse(c.args); err != nil {
+		return false
+	}
+	if c.flags.NArg() < minArgs {
+		fmt.Fprintf(c.stderr, "elvm %s: not enough arguments\n", c.flags.Name())
+		c.flags.Usage()
+		return false
+	}
+	return true
+}
+
+// printError prints Lisp error message in Emacs batch mode style.
+func (c *command) printError(err error) {
+	if _, ok := err.(*bcode.LoadError); ok {
+		fmt.Fprintln(c.stderr, err)
+		return
+	}
+	fmt.Fprintln(c.stderr, bcode.ErrorMessage(err))
+}
+
+// load creates an Env and loads files into it.
+func (c *command) load(files []string) (*bcode.Env, error) {
+	master := bcode.NewMasterEnv()
+	master.Output = c.stdout
+	master.Optimize = c.optimize
+	master.DefineSubrs(c.ob)
+	env := master.NewEnv(0, 0)
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.Load(src, file, c.ob); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+func cmdRun(c *command) int {
+	funcName := c.flags.String("f", "", "call function `FUNC` after loading files")
+	c.optimizeFlag()
+	if !c.parse(1) {
+		return exitUsage
+	}
+	env, err := c.load(c.flags.Args())
+	if err != nil {
+		c.printError(err)
+		return exitLispError
+	}
+	if *funcName != "" {
+		if _, err := env.Funcall(c.ob.Intern(*funcName)); err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+	}
+	return exitOK
+}
+
+func cmdDisasm(c *command) int {
+	funcName := c.flags.String("f", "", "only disassemble function `FUNC`")
+	c.optimizeFlag()
+	if !c.parse(1) {
+		return exitUsage
+	}
+
+	found := false
+	for _, file := range c.flags.Args() {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+		funcs, err := bcode.ReadCompiledFuncs(src, file, c.ob, false)
+		if err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+		for _, f := range funcs {
+			if *funcName != "" && f.Name != *funcName {
+				continue
+			}
+			if found {
+				fmt.Fprintln(c.stdout)
+			}
+			found = true
+			fn := f.Func
+			if c.optimize {
+				if fn, err = bcode.Optimize(fn); err != nil {
+					c.printError(err)
+					return exitLispError
+				}
+			}
+			if err := bcode.Disassemble(c.stdout, f.Name, fn); err != nil {
+				c.printError(err)
+				return exitLispError
+			}
+		}
+	}
+	if *funcName != "" && !found {
+		fmt.Fprintf(c.stderr, "Symbol’s function definition is void: %s\n", *funcName)
+		return exitLispError
+	}
+	return exitOK
+}
+
+func cmdVerify(c *command) int {
+	verbose := c.flags.Bool("v", false, "print names of files that passed verification")
+	if !c.parse(1) {
+		return exitUsage
+	}
+
+	var files []string
+	for _, arg := range c.flags.Args() {
+		err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if path == arg || strings.HasSuffix(path, ".elc") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+	}
+
+	failed := 0
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err == nil {
+			_, err = bcode.ReadCompiledFuncs(src, file, c.ob, true)
+		}
+		if err != nil {
+			failed++
+			c.printError(err)
+			continue
+		}
+		if *verbose {
+			fmt.Fprintf(c.stdout, "%s: ok\n", file)
+		}
+	}
+	fmt.Fprintf(c.stdout, "%d files, %d failed\n", len(files), failed)
+	if failed != 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+func cmdBench(c *command) int {
+	funcName := c.flags.String("f", "", "benchmark function `FUNC`")
+	n := c.flags.Int("n", 0, "run exactly `N` calls instead of timing")
+	benchTime := c.flags.Duration("time", time.Second, "minimal benchmark `duration`")
+	c.optimizeFlag()
+	if !c.parse(1) {
+		return exitUsage
+	}
+	if *funcName == "" {
+		fmt.Fprintln(c.stderr, "elvm bench: -f flag is required")
+		c.flags.Usage()
+		return exitUsage
+	}
+	env, err := c.load(c.flags.Args())
+	if err != nil {
+		c.printError(err)
+		return exitLispError
+	}
+
+	fn := c.ob.Intern(*funcName)
+	var conses uint64
+	callN := func(n int) (time.Duration, error) {
+		allocated := env.AllocStats().Conses
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			if _, err := env.Funcall(fn); err != nil {
+				return 0, err
+			}
+		}
+		elapsed := time.Since(start)
+		conses = env.AllocStats().Conses - allocated
+		return elapsed, nil
+	}
+
+	iterations := *n
+	var elapsed time.Duration
+	if iterations != 0 {
+		elapsed, err = callN(iterations)
+	} else {
+		// Grow iterations count until the run is long enough,
+		// like testing package does.
+		iterations = 1
+		elapsed, err = callN(iterations)
+		for err == nil && elapsed < *benchTime && iterations < 1e9 {
+			prev := iterations
+			iterations = 100 * prev
+			if elapsed > 0 {
+				predicted := int(int64(*benchTime) * int64(prev) / int64(elapsed) * 6 / 5)
+				if predicted < iterations {
+					iterations = predicted
+				}
+			}
+			if iterations <= prev {
+				iterations = prev + 1
+			}
+			elapsed, err = callN(iterations)
+		}
+	}
+	if err != nil {
+		c.printError(err)
+		return exitLispError
+	}
+
+	fmt.Fprintf(c.stdout, "%s\t%d\t%d ns/op\t%d conses/op\n",
+		*funcName, iterations, elapsed.Nanoseconds()/int64(iterations),
+		conses/uint64(iterations))
+	return exitOK
+}
+
+func cmdProfile(c *command) int {
+	top := c.flags.Int("n", 20, "print `N` most common sequences, 0 prints all")
+	seqLen := c.flags.Int("len", 2, "count sequences of `L` instructions")
+	if !c.parse(1) {
+		return exitUsage
+	}
+	if *seqLen < 1 {
+		fmt.Fprintln(c.stderr, "elvm profile: -len must be positive")
+		c.flags.Usage()
+		return exitUsage
+	}
+
+	var funcs []*bcode.Func
+	for _, file := range c.flags.Args() {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+		compiled, err := bcode.ReadCompiledFuncs(src, file, c.ob, false)
+		if err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+		for _, f := range compiled {
+			funcs = append(funcs, f.Func)
+		}
+	}
+
+	seqs := bcode.ProfileSequences(funcs, *seqLen)
+	if *top > 0 && len(seqs) > *top {
+		seqs = seqs[:*top]
+	}
+	for _, seq := range seqs {
+		fmt.Fprintf(c.stdout, "%d\t%s\n", seq.Count, seq)
+	}
+	return exitOK
+}
+
+func cmdAOT(c *command) int {
+	pkg := c.flags.String("pkg", "aot", "generated package `NAME`")
+	out := c.flags.String("o", "", "write source to `OUT` instead of stdout")
+	c.optimizeFlag()
+	if !c.parse(1) {
+		return exitUsage
+	}
+
+	var funcs []bcode.NamedFunc
+	for _, file := range c.flags.Args() {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+		compiled, err := bcode.ReadCompiledFuncs(src, file, c.ob, true)
+		if err != nil {
+			c.printError(err)
+			return exitLispError
+		}
+		for _, f := range compiled {
+			if c.optimize {
+				if f.Func, err = bcode.Optimize(f.Func); err != nil {
+					c.printError(err)
+					return exitLispError
+				}
+			}
+			funcs = append(funcs, f)
+		}
+	}
+
+	var buf bytes.Buffer
+	skipped, err := bcode.GenerateGo(&buf, *pkg, funcs)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "elvm aot: %v\n", err)
+		return exitFailed
+	}
+	for _, e := range skipped {
+		fmt.Fprintf(c.stderr, "elvm aot: skipped %v\n", e)
+	}
+	if *out == "" {
+		_, err = c.stdout.Write(buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(*out, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "elvm aot: %v\n", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+func cmdRepl(c *command) int {
+	c.optimizeFlag()
+	if !c.parse(0) {
+		return exitUsage
+	}
+	env, err := c.load(c.flags.Args())
+	if err != nil {
+		c.printError(err)
+		return exitLispError
+	}
+	if err := repl.New(env, c.ob).Run(c.stdin, c.stdout); err != nil {
+		fmt.Fprintf(c.stderr, "elvm repl: %v\n", err)
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testElc = `;ELC
+;;; Compiled
+;;; in Emacs version 25.3.1
+
+(defalias 'inc #[257 "\211T\207" [] 2 "\n\n(fn X)"])
+(defalias 'f0 #[0 "\300\207" [42] 1])
+(defalias 'fail #[0 "\300 \207" [nope] 1])
+(defalias 'opt #[257 "\211\210\211\207" [] 2])
+(provide 'test)
+`
+
+// badElc declares stack depth that is too small.
+const badElc = `(defalias 'f #[0 "\300\211B\207" [1] 1])
+`
+
+// writeTestFiles creates test files inside a temporary
+// directory and returns directory name.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "elvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"test.elc":     testElc,
+		"sub/bad.elc":  badElc,
+		"sub/skip.el":  "not compiled",
+		"syntax.elc":   "(defalias 'f #[0 \"\\300\\207\" [1] 1]",
+		"toplevel.elc": "(message \"hi\")",
+	})
+	defer os.RemoveAll(dir)
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		status int
+		stdout string
+		stderr string
+	}{
+		{
+			args:   []string{"run", file("test.elc")},
+			status: exitOK,
+		},
+		{
+			args:   []string{"run", "-f", "f0", file("test.elc")},
+			status: exitOK,
+		},
+		{
+			args:   []string{"run", "-f", "fail", file("test.elc")},
+			status: exitLispError,
+			stderr: "Symbol’s function definition is void: nope\n",
+		},
+		{
+			args:   []string{"run", "-f", "undefined", file("test.elc")},
+			status: exitLispError,
+			stderr: "Symbol’s function definition is void: undefined\n",
+		},
+		{
+			args:   []string{"run", file("syntax.elc")},
+			status: exitLispError,
+			stderr: file("syntax.elc") + ":1: End of file during parsing\n",
+		},
+		{
+			args:   []string{"run", file("toplevel.elc")},
+			status: exitLispError,
+			stderr: file("toplevel.elc") + ":1: Symbol’s function definition is void: message\n",
+		},
+		{
+			args:   []string{"disasm", "-f", "inc", file("test.elc")},
+			status: exitOK,
+			stdout: "byte code for inc:\n" +
+				"0\tdup\n" +
+				"1\tadd1\n" +
+				"2\treturn\n",
+		},
+		{
+			args:   []string{"disasm", "-O", "-f", "opt", file("test.elc")},
+			status: exitOK,
+			stdout: "byte code for opt:\n" +
+				"0\treturn\n",
+		},
+		{
+			args:   []string{"run", "-O", "-f", "f0", file("test.elc")},
+			status: exitOK,
+		},
+		{
+			args:   []string{"disasm", "-f", "nope", file("test.elc")},
+			status: exitLispError,
+			stderr: "Symbol’s function definition is void: nope\n",
+		},
+		{
+			args:   []string{"verify", file("test.elc")},
+			status: exitOK,
+			stdout: "1 files, 0 failed\n",
+		},
+		{
+			args:   []string{"verify", "-v", file("test.elc")},
+			status: exitOK,
+			stdout: file("test.elc") + ": ok\n1 files, 0 failed\n",
+		},
+		{
+			args:   []string{"verify", dir},
+			status: exitFailed,
+			stdout: "4 files, 2 failed\n",
+		},
+		{
+			args:   []string{"bench", "-n", "10", "-f", "f0", file("test.elc")},
+			status: exitOK,
+		},
+		{
+			args:   []string{"bench", "-n", "10", "-f", "fail", file("test.elc")},
+			status: exitLispError,
+			stderr: "Symbol’s function definition is void: nope\n",
+		},
+		{
+			args:   []string{"profile", "-n", "3", file("test.elc")},
+			status: exitOK,
+			stdout: "1\tadd1 + return\n" +
+				"1\tcall + return\n" +
+				"1\tconstant + call\n",
+		},
+		{
+			args:   []string{"profile", "-len", "3", "-n", "0", file("test.elc")},
+			status: exitOK,
+			stdout: "1\tconstant + call + return\n" +
+				"1\tdiscard + dup + return\n" +
+				"1\tdup + add1 + return\n" +
+				"1\tdup + discard + dup\n",
+		},
+		{
+			args:   []string{"repl", file("test.elc")},
+			stdin:  "(inc (f0))\n(fail)\n",
+			status: exitOK,
+			stdout: "ELISP> 43\n" +
+				"ELISP> *** Eval error ***  Symbol’s function definition is void: nope\n" +
+				"  fail()\n" +
+				"ELISP> \n",
+		},
+		{
+			args:   []string{"repl", file("toplevel.elc")},
+			status: exitLispError,
+			stderr: file("toplevel.elc") + ":1: Symbol’s function definition is void: message\n",
+		},
+
+		{args: nil, status: exitUsage},
+		{args: []string{"frobnicate"}, status: exitUsage},
+		{args: []string{"run"}, status: exitUsage},
+		{args: []string{"run", "-x", file("test.elc")}, status: exitUsage},
+		{args: []string{"bench", file("test.elc")}, status: exitUsage},
+		{args: []string{"profile", "-len", "0", file("test.elc")}, status: exitUsage},
+		{args: []string{"aot"}, status: exitUsage},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		status := run(test.args, strings.NewReader(test.stdin), &stdout, &stderr)
+		if status != test.status {
+			t.Errorf("%q: status: have %d, want %d\nstderr:\n%s",
+				test.args, status, test.status, stderr.String())
+			continue
+		}
+		if status == exitUsage {
+			if stderr.Len() == 0 {
+				t.Errorf("%q: no usage message", test.args)
+			}
+			continue
+		}
+		if test.args[0] == "bench" && status == exitOK {
+			if !strings.HasPrefix(stdout.String(), "f0\t10\t") {
+				t.Errorf("%q: stdout: have %q", test.args, stdout.String())
+			}
+		} else if stdout.String() != test.stdout {
+			t.Errorf("%q: stdout:\nhave: %q\nwant: %q", test.args, stdout.String(), test.stdout)
+		}
+		if test.args[0] == "verify" {
+			// Failed files are reported to stderr with file names.
+			continue
+		}
+		if stderr.String() != test.stderr {
+			t.Errorf("%q: stderr:\nhave: %q\nwant: %q", test.args, stderr.String(), test.stderr)
+		}
+	}
+}
+
+func TestAOT(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"test.elc":  testElc,
+		"point.elc": "(defalias 'pt #[0 \"`\\207\" [] 1])\n",
+	})
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.go")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"aot", "-pkg", "gen", "-o", out,
+		filepath.Join(dir, "test.elc"), filepath.Join(dir, "point.elc")}
+	if status := run(args, strings.NewReader(""), &stdout, &stderr); status != exitOK {
+		t.Fatalf("status: have %d\nstderr:\n%s", status, stderr.String())
+	}
+	if want := "elvm aot: skipped pt: pc 0: point is not supported\n"; stderr.String() != want {
+		t.Errorf("stderr:\nhave: %q\nwant: %q", stderr.String(), want)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("stdout: have %q", stdout.String())
+	}
+
+	src, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), out, src, 0); err != nil {
+		t.Errorf("generated code: %v", err)
+	}
+	for _, want := range []string{"package gen\n", `{Name: "inc"`, `{Name: "fail"`, "func newF0("} {
+		if !bytes.Contains(src, []byte(want)) {
+			t.Errorf("generated code has no %q:\n%s", want, src)
+		}
+	}
+}
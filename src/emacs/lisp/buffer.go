@@ -0,0 +1,180 @@
+package lisp
+
+import (
+	"unsafe"
+)
+
+// Buffer is an editable text container.
+//
+// All positions are 1-based character indexes, like in Emacs:
+// position 1 is the beginning of the buffer and
+// position len(text)+1 is the end of the buffer.
+//
+// Issue#4
+type Buffer struct {
+	// Name is a unique buffer name, like "*scratch*".
+	Name string
+
+	// TabWidth is a buffer-local `tab-width` value.
+	// Values outside of [1, 1000] range are treated as 8.
+	TabWidth int
+
+	// IndentTabsMode is a buffer-local `indent-tabs-mode` value.
+	// If true, indentation commands can insert tabs.
+	IndentTabsMode bool
+
+	// SyntaxTable is a buffer syntax table.
+	// If nil, the standard syntax table is used.
+	SyntaxTable *SyntaxTable
+
+	// ParseSexpIgnoreComments is a buffer-local
+	// `parse-sexp-ignore-comments` value.
+	// If true, sexp scanning treats comments as whitespace.
+	ParseSexpIgnoreComments bool
+
+	// CaseFoldSearch is a buffer-local `case-fold-search` value.
+	// If true, searches and matches ignore letter case.
+	CaseFoldSearch bool
+
+	// text holds buffer contents.
+	text []rune
+
+	// pt is a point position.
+	// Always inside [begv, zv] range.
+	pt int
+
+	// begv and zv are accessible portion bounds.
+	// They differ from 1 and len(text)+1 only
+	// when buffer is narrowed.
+	begv int
+	zv   int
+}
+
+// NewBuffer returns a buffer Object with given name and empty contents.
+func NewBuffer(name string) Object {
+	buf := &Buffer{
+		Name: name,
+		pt:   1,
+		begv: 1,
+		zv:   1,
+	}
+	buf.KillLocalVariables()
+	return buf.Object()
+}
+
+// Object returns buf wrapped into Object.
+func (buf *Buffer) Object() Object {
+	return NewRef(TypeBuffer, unsafe.Pointer(buf))
+}
+
+// KillLocalVariables resets all buffer-local values
+// to their defaults, like `kill-all-local-variables`.
+func (buf *Buffer) KillLocalVariables() {
+	buf.TabWidth = 8
+	buf.IndentTabsMode = true
+	buf.SyntaxTable = nil
+	buf.ParseSexpIgnoreComments = false
+	buf.CaseFoldSearch = true
+}
+
+// Point returns current point position.
+func (buf *Buffer) Point() int { return buf.pt }
+
+// PointMin returns minimal accessible position.
+func (buf *Buffer) PointMin() int { return buf.begv }
+
+// PointMax returns maximal accessible position.
+func (buf *Buffer) PointMax() int { return buf.zv }
+
+// Size returns total number of chars inside buffer,
+// narrowing is ignored.
+func (buf *Buffer) Size() int { return len(buf.text) }
+
+// SetPoint moves point to pos.
+// Positions outside of accessible portion are clamped.
+func (buf *Buffer) SetPoint(pos int) {
+	buf.pt = buf.clamp(pos)
+}
+
+// CharAt returns char that follows pos.
+// UB if pos is not inside [1, Size()] range.
+func (buf *Buffer) CharAt(pos int) rune {
+	return buf.text[pos-1]
+}
+
+// Text returns buffer accessible portion contents.
+func (buf *Buffer) Text() string {
+	return string(buf.text[buf.begv-1 : buf.zv-1])
+}
+
+// Substring returns contents between start and end positions.
+// UB if start>end or positions are outside of buffer.
+func (buf *Buffer) Substring(start, end int) []rune {
+	return buf.text[start-1 : end-1]
+}
+
+// Insert inserts chars at point.
+// Point is moved after the inserted text.
+func (buf *Buffer) Insert(chars []rune) {
+	i := buf.pt - 1
+	buf.text = append(buf.text, chars...)
+	copy(buf.text[i+len(chars):], buf.text[i:])
+	copy(buf.text[i:], chars)
+	buf.pt += len(chars)
+	buf.zv += len(chars)
+}
+
+// Delete deletes text between start and end positions.
+// Point that was inside deleted region is moved to start.
+// UB if start>end or positions are outside of accessible portion.
+func (buf *Buffer) Delete(start, end int) {
+	n := end - start
+	buf.text = append(buf.text[:start-1], buf.text[end-1:]...)
+	switch {
+	case buf.pt >= end:
+		buf.pt -= n
+	case buf.pt > start:
+		buf.pt = start
+	}
+	buf.zv -= n
+}
+
+// Erase deletes entire buffer contents.
+// Any narrowing is removed.
+func (buf *Buffer) Erase() {
+	buf.text = buf.text[:0]
+	buf.pt = 1
+	buf.begv = 1
+	buf.zv = 1
+}
+
+// Narrow restricts accessible portion to [start, end].
+// Bounds are clamped to buffer size.
+func (buf *Buffer) Narrow(start, end int) {
+	if start > end {
+		start, end = end, start
+	}
+	buf.begv = 1
+	buf.zv = len(buf.text) + 1
+	buf.begv = buf.clamp(start)
+	buf.zv = buf.clamp(end)
+	buf.pt = buf.clamp(buf.pt)
+}
+
+// Widen removes any buffer narrowing.
+func (buf *Buffer) Widen() {
+	buf.begv = 1
+	buf.zv = len(buf.text) + 1
+}
+
+// clamp returns pos that is forced into [begv, zv] range.
+func (buf *Buffer) clamp(pos int) int {
+	switch {
+	case pos < buf.begv:
+		return buf.begv
+	case pos > buf.zv:
+		return buf.zv
+	default:
+		return pos
+	}
+}
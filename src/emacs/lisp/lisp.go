@@ -20,6 +20,10 @@ const (
 	TypeVector
 	TypeCons
 	TypeString
+	TypeBuffer
+	TypeSyntaxTable
+	TypeUserPtr
+	TypeFunc
 )
 
 // Object is universal Emacs Lisp value.
@@ -36,6 +40,10 @@ const (
 //   {Type: TypeVector, Ptr: *Vector}
 //   {Type: TypeCons, Ptr: *Cons}
 //   {Type: TypeString: Ptr: *String}
+//   {Type: TypeBuffer, Ptr: *Buffer}
+//   {Type: TypeSyntaxTable, Ptr: *SyntaxTable}
+//   {Type: TypeUserPtr, Ptr: *UserPtr}
+//   {Type: TypeFunc, Ptr: compiled function; opaque for this package}
 type Object struct {
 	// Warning: Num member should always be the first,
 	// because it is accessed via unsafe pointer at zero offset.
@@ -51,6 +59,15 @@ type Object struct {
 	Type Type
 }
 
+// NewRef returns an Object of reference type typ
+// that points to ptr.
+//
+// It is used for types whose values are opaque
+// for this package, like TypeFunc.
+func NewRef(typ Type, ptr unsafe.Pointer) Object {
+	return Object{Type: typ, Ptr: ptr}
+}
+
 // Int returns object integer value.
 // UB if o.Type is not TypeInt.
 func (o *Object) Int() int64 {
@@ -87,6 +104,24 @@ func (o *Object) String() *String {
 	return (*String)(o.Ptr)
 }
 
+// Buffer returns object buffer value.
+// UB if o.Type is not TypeBuffer.
+func (o *Object) Buffer() *Buffer {
+	return (*Buffer)(o.Ptr)
+}
+
+// SyntaxTable returns object syntax table value.
+// UB if o.Type is not TypeSyntaxTable.
+func (o *Object) SyntaxTable() *SyntaxTable {
+	return (*SyntaxTable)(o.Ptr)
+}
+
+// UserPtr returns object user-ptr value.
+// UB if o.Type is not TypeUserPtr.
+func (o *Object) UserPtr() *UserPtr {
+	return (*UserPtr)(o.Ptr)
+}
+
 // SetInt updates object integer value.
 // UB if o.Type is not TypeInt.
 func (o *Object) SetInt(val int64) {
@@ -99,10 +134,17 @@ func (o *Object) SetFloat(val float64) {
 	*(*float64)(unsafe.Pointer(o)) = val
 }
 
-// Symbol is env-local interned string.
+// Symbol is an interned string.
 // A symbol name is unique, no two symbols have same name.
+// Symbol values and function definitions are kept by the
+// environments, so a symbol can be shared by several of them.
 type Symbol struct {
-	Name   string
+	Name string
+
+	// FuncID locates the symbol function definition in
+	// environment function tables. It is unique in the
+	// process; zero means that the symbol was never
+	// bound to a function.
 	FuncID int
 }
 
@@ -155,34 +197,37 @@ func NewFloat(val float64) Object {
 // NewSymbol returns a newly allocated uninterned symbol for given name.
 // The symbol value is void.
 func NewSymbol(name string) Object {
-	return Object{
-		Type: TypeSymbol,
-		Ptr:  unsafe.Pointer(&Symbol{Name: name}),
-	}
+	return NewRef(TypeSymbol, unsafe.Pointer(&Symbol{Name: name}))
 }
 
 // NewVector returns a vector Object initialized with vals.
 func NewVector(vals []Object) Object {
-	return Object{
-		Type: TypeVector,
-		Ptr:  unsafe.Pointer(&Vector{Vals: vals}),
-	}
+	return NewRef(TypeVector, unsafe.Pointer(&Vector{Vals: vals}))
 }
 
 // NewCons returns a cons Object initialized with {car, cdr}.
 func NewCons(car, cdr Object) Object {
-	return Object{
-		Type: TypeCons,
-		Ptr:  unsafe.Pointer(&Cons{Car: car, Cdr: cdr}),
-	}
+	return (&Cons{Car: car, Cdr: cdr}).Object()
 }
 
 // NewString returns a string Object initialized with chars.
 func NewString(chars []byte) Object {
-	return Object{
-		Type: TypeString,
-		Ptr:  unsafe.Pointer(&String{Chars: chars}),
+	return NewRef(TypeString, unsafe.Pointer(&String{Chars: chars}))
+}
+
+// Object returns c wrapped into Object.
+func (c *Cons) Object() Object {
+	return NewRef(TypeCons, unsafe.Pointer(c))
+}
+
+// List returns a proper list Object that contains vals.
+// Returns Nil for empty vals.
+func List(vals ...Object) Object {
+	list := Nil
+	for i := len(vals) - 1; i >= 0; i-- {
+		list = NewCons(vals[i], list)
 	}
+	return list
 }
 
 // Bool maps Go boolean value to Emacs Lisp closest equivalents.
@@ -1,7 +1,9 @@
 package lisp
 
 import (
+	"math"
 	"math/rand"
+	"runtime"
 	"testing"
 )
 
@@ -95,3 +97,85 @@ func TestNull(t *testing.T) {
 		}
 	}
 }
+
+func TestObjectRepr(t *testing.T) {
+	tests := []struct {
+		object Object
+		typ    Type
+	}{
+		{Object{}, TypeInt},
+		{NewInt(0), TypeInt},
+		{NewInt(-1), TypeInt},
+		{NewInt(math.MaxInt64), TypeInt},
+		{NewFloat(0), TypeFloat},
+		{NewFloat(math.Inf(-1)), TypeFloat},
+		{NewSymbol("x"), TypeSymbol},
+		{Nil, TypeSymbol},
+		{NewVector(nil), TypeVector},
+		{NewCons(Nil, Nil), TypeCons},
+		{NewString(nil), TypeString},
+		{NewBuffer("b"), TypeBuffer},
+		{NewSyntaxTable(nil), TypeSyntaxTable},
+		{NewUserPtr(1, "", nil), TypeUserPtr},
+	}
+	for _, test := range tests {
+		if have := test.object.Type; have != test.typ {
+			t.Errorf("%s: have type %d, want %d", ObjectString(test.object), have, test.typ)
+		}
+	}
+
+	// Ints and floats with the same bits are different objects.
+	i := NewInt(int64(math.Float64bits(1.5)))
+	f := NewFloat(1.5)
+	if Eq(&i, &f) {
+		t.Errorf("%s and %s are eq", ObjectString(i), ObjectString(f))
+	}
+}
+
+func TestObjectGC(t *testing.T) {
+	// Referenced values survive collections.
+	const n = 1000
+	list := Nil
+	for i := 0; i < n; i++ {
+		list = NewCons(NewFloat(float64(i)), list)
+		list = NewCons(NewString([]byte("s")), list)
+	}
+	runtime.GC()
+	runtime.GC()
+	for i := n - 1; i >= 0; i-- {
+		cons := list.Cons()
+		if s := cons.Car.String(); string(s.Chars) != "s" {
+			t.Fatalf("string %d: have %q", i, s.Chars)
+		}
+		cons = cons.Cdr.Cons()
+		if cons.Car.Type != TypeFloat || cons.Car.Float() != float64(i) {
+			t.Fatalf("float %d: have %s", i, ObjectString(cons.Car))
+		}
+		list = cons.Cdr
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	vals := make([]Object, 100)
+	for i := range vals {
+		vals[i] = NewInt(int64(i))
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		List(vals...)
+	}
+}
+
+// vectorSink keeps benchmark results on the heap.
+var vectorSink Object
+
+func BenchmarkVector(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		vals := make([]Object, 100)
+		for j := range vals {
+			vals[j] = NewFloat(float64(j))
+		}
+		vectorSink = NewVector(vals)
+	}
+}
@@ -0,0 +1,61 @@
+package lisp
+
+import (
+	"sync"
+)
+
+// Obarray is a symbol table.
+// Symbols that are interned into the same obarray
+// under the same name are eq.
+//
+// It is safe to use Obarray from multiple goroutines.
+type Obarray struct {
+	mu      sync.Mutex
+	symbols map[string]Object
+}
+
+// NewObarray returns an obarray that contains
+// only Nil and T symbols.
+func NewObarray() *Obarray {
+	return &Obarray{
+		symbols: map[string]Object{
+			"nil": Nil,
+			"t":   T,
+		},
+	}
+}
+
+// Intern implements `intern`.
+// Returns a symbol named name, adding it to ob if necessary.
+func (ob *Obarray) Intern(name string) Object {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if sym, ok := ob.symbols[name]; ok {
+		return sym
+	}
+	sym := NewSymbol(name)
+	ob.symbols[name] = sym
+	return sym
+}
+
+// InternSoft implements `intern-soft`.
+// Returns a symbol named name and true if it is interned in ob.
+func (ob *Obarray) InternSoft(name string) (Object, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	sym, ok := ob.symbols[name]
+	return sym, ok
+}
+
+// Add interns existing symbol sym.
+// If there is another symbol with the same name, it is replaced.
+//
+// It is used to make predefined symbols readable.
+func (ob *Obarray) Add(sym Object) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.symbols[sym.Symbol().Name] = sym
+}
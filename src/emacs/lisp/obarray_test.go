@@ -0,0 +1,36 @@
+package lisp
+
+import (
+	"testing"
+)
+
+func TestObarray(t *testing.T) {
+	ob := NewObarray()
+
+	foo := ob.Intern("foo")
+	if foo.Type != TypeSymbol || foo.Symbol().Name != "foo" {
+		t.Fatalf("Intern(foo): have %s", ObjectString(foo))
+	}
+	if foo2 := ob.Intern("foo"); !Eq(&foo, &foo2) {
+		t.Errorf("Intern(foo): second call returned different symbol")
+	}
+	if nilSym := ob.Intern("nil"); !Eq(&nilSym, &Nil) {
+		t.Errorf("Intern(nil): not eq to Nil")
+	}
+	if tSym := ob.Intern("t"); !Eq(&tSym, &T) {
+		t.Errorf("Intern(t): not eq to T")
+	}
+
+	if _, ok := ob.InternSoft("bar"); ok {
+		t.Errorf("InternSoft(bar): found symbol that was not interned")
+	}
+	if sym, ok := ob.InternSoft("foo"); !ok || !Eq(&sym, &foo) {
+		t.Errorf("InternSoft(foo): have (%s, %v)", ObjectString(sym), ok)
+	}
+
+	bar := NewSymbol("bar")
+	ob.Add(bar)
+	if bar2 := ob.Intern("bar"); !Eq(&bar, &bar2) {
+		t.Errorf("Intern(bar): not eq to added symbol")
+	}
+}
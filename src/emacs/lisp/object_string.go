@@ -39,6 +39,21 @@ func ObjectString(o Object) string {
 	case TypeString:
 		return `"` + string(o.String().Chars) + `"`
 
+	case TypeBuffer:
+		return "#<buffer " + o.Buffer().Name + ">"
+
+	case TypeSyntaxTable:
+		return "#<syntax-table>"
+
+	case TypeUserPtr:
+		if name := o.UserPtr().TypeName; name != "" {
+			return "#<user-ptr " + name + ">"
+		}
+		return "#<user-ptr>"
+
+	case TypeFunc:
+		return "#<compiled-function>"
+
 	default:
 		return fmt.Sprint(o)
 	}
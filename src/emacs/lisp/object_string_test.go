@@ -75,6 +75,9 @@ func TestObjectString(t *testing.T) {
 			}),
 			`[(nil . t) ([t "abc" t] . [nil 7]) t]`,
 		},
+
+		27: {NewUserPtr(1, "", nil), "#<user-ptr>"},
+		28: {NewUserPtr(1, "sqlite-row", nil), "#<user-ptr sqlite-row>"},
 	}
 
 	for i, tt := range tests {
@@ -0,0 +1,196 @@
+package lisp
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Prin1String implements `prin1-to-string`.
+// Unlike ObjectString, it prints objects in the
+// Emacs Lisp read syntax whenever one exists:
+// lists are not dotted, strings and symbols are escaped.
+//
+// Circular objects are not detected.
+func Prin1String(o Object) string {
+	var buf bytes.Buffer
+	printObject(&buf, o, true)
+	return buf.String()
+}
+
+// PrincString returns the text that `princ` prints:
+// it is like Prin1String, but strings and symbols
+// are printed without quoting and escaping.
+func PrincString(o Object) string {
+	var buf bytes.Buffer
+	printObject(&buf, o, false)
+	return buf.String()
+}
+
+// quoteSyntax maps symbols that have reader shorthands
+// to their prefixes.
+var quoteSyntax = map[string]string{
+	"quote":    "'",
+	"function": "#'",
+	"`":        "`",
+	",":        ",",
+	",@":       ",@",
+}
+
+// printObject writes o to buf; escape selects
+// between the prin1 and princ representation.
+func printObject(buf *bytes.Buffer, o Object, escape bool) {
+	switch o.Type {
+	case TypeFloat:
+		buf.WriteString(formatFloat(o.Float()))
+
+	case TypeSymbol:
+		if !escape {
+			buf.WriteString(o.Symbol().Name)
+			break
+		}
+		printSymbol(buf, o.Symbol().Name)
+
+	case TypeString:
+		if !escape {
+			buf.Write(o.String().Chars)
+			break
+		}
+		buf.WriteByte('"')
+		for _, c := range o.String().Chars {
+			if c == '"' || c == '\\' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(c)
+		}
+		buf.WriteByte('"')
+
+	case TypeVector:
+		buf.WriteByte('[')
+		for i, x := range o.Vector().Vals {
+			if i != 0 {
+				buf.WriteByte(' ')
+			}
+			printObject(buf, x, escape)
+		}
+		buf.WriteByte(']')
+
+	case TypeCons:
+		cons := o.Cons()
+		// (quote x) is printed as 'x and so on.
+		if cons.Car.Type == TypeSymbol && cons.Cdr.Type == TypeCons {
+			rest := cons.Cdr.Cons()
+			prefix, ok := quoteSyntax[cons.Car.Symbol().Name]
+			if ok && Null(&rest.Cdr) {
+				buf.WriteString(prefix)
+				printObject(buf, rest.Car, escape)
+				return
+			}
+		}
+		buf.WriteByte('(')
+		printObject(buf, cons.Car, escape)
+		tail := cons.Cdr
+		for tail.Type == TypeCons {
+			buf.WriteByte(' ')
+			printObject(buf, tail.Cons().Car, escape)
+			tail = tail.Cons().Cdr
+		}
+		if !Null(&tail) {
+			buf.WriteString(" . ")
+			printObject(buf, tail, escape)
+		}
+		buf.WriteByte(')')
+
+	default:
+		buf.WriteString(ObjectString(o))
+	}
+}
+
+// formatFloat formats x like Emacs `float-to-string`:
+// the shortest representation that reads back as x,
+// always with a decimal point or an exponent.
+func formatFloat(x float64) string {
+	switch {
+	case math.IsInf(x, 1):
+		return "1.0e+INF"
+	case math.IsInf(x, -1):
+		return "-1.0e+INF"
+	case math.IsNaN(x):
+		return "0.0e+NaN"
+	}
+	// Count significant digits of the shortest
+	// representation, then format it as C %.Ng does.
+	e := strconv.FormatFloat(x, 'e', -1, 64)
+	digits := strings.IndexByte(e, 'e')
+	if strings.IndexByte(e[:digits], '.') != -1 {
+		digits--
+	}
+	if x < 0 {
+		digits--
+	}
+	s := strconv.FormatFloat(x, 'g', digits, 64)
+	if strings.IndexAny(s, ".e") == -1 {
+		s += ".0"
+	}
+	return s
+}
+
+// printSymbol writes symbol name, escaping characters
+// that would be read differently.
+func printSymbol(buf *bytes.Buffer, name string) {
+	if name == "" {
+		buf.WriteString("##")
+		return
+	}
+	if looksLikeNumber(name) || name[0] == '?' || name == "." {
+		buf.WriteByte('\\')
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch c {
+		case '"', '\\', '\'', ';', '#', '(', ')', ',', '`', '[', ']':
+			buf.WriteByte('\\')
+		default:
+			if c <= ' ' {
+				buf.WriteByte('\\')
+			}
+		}
+		buf.WriteByte(c)
+	}
+}
+
+// looksLikeNumber reports whether symbol name
+// would be read as a number if printed as is.
+func looksLikeNumber(name string) bool {
+	s := name
+	if s[0] == '-' || s[0] == '+' {
+		s = s[1:]
+	}
+	digits := func() int {
+		n := 0
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		s = s[n:]
+		return n
+	}
+	mantissa := digits()
+	if s != "" && s[0] == '.' {
+		s = s[1:]
+		mantissa += digits()
+	}
+	if mantissa == 0 {
+		return false
+	}
+	if s != "" && s[0] == 'e' {
+		s = s[1:]
+		if s != "" && (s[0] == '-' || s[0] == '+') {
+			s = s[1:]
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return s == ""
+}
@@ -0,0 +1,90 @@
+package lisp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPrin1String(t *testing.T) {
+	sym := NewSymbol
+	str := func(s string) Object {
+		return NewString([]byte(s))
+	}
+
+	tests := [...]struct {
+		object Object
+		want   string
+	}{
+		// Explicit indexes are useful when locating failed test.
+
+		0: {NewInt(-5), "-5"},
+		1: {NewFloat(1), "1.0"},
+		2: {NewFloat(0.5), "0.5"},
+		3: {NewFloat(-2.5), "-2.5"},
+		4: {NewFloat(123456789), "123456789.0"},
+		5: {NewFloat(1e20), "1e+20"},
+		6: {NewFloat(1.5e-7), "1.5e-07"},
+		7: {NewFloat(math.Inf(1)), "1.0e+INF"},
+		8: {NewFloat(math.Inf(-1)), "-1.0e+INF"},
+		9: {NewFloat(math.NaN()), "0.0e+NaN"},
+
+		10: {sym("foo"), "foo"},
+		11: {sym(""), "##"},
+		12: {sym("foo bar"), `foo\ bar`},
+		13: {sym("a(b)"), `a\(b\)`},
+		14: {sym("1"), `\1`},
+		15: {sym("-1.5"), `\-1.5`},
+		16: {sym("1+"), "1+"},
+		17: {sym("?a"), `\?a`},
+		18: {sym("-"), "-"},
+
+		19: {str(""), `""`},
+		20: {str(`a "b" \c`), `"a \"b\" \\c"`},
+		21: {str("a\nb"), "\"a\nb\""},
+
+		22: {Nil, "nil"},
+		23: {List(NewInt(1), NewInt(2)), "(1 2)"},
+		24: {NewCons(NewInt(1), NewInt(2)), "(1 . 2)"},
+		25: {NewCons(NewInt(1), NewCons(NewInt(2), NewInt(3))), "(1 2 . 3)"},
+		26: {List(List(), List(str("x"))), `(nil ("x"))`},
+		27: {List(sym("quote"), sym("x")), "'x"},
+		28: {List(sym("function"), sym("car")), "#'car"},
+		29: {
+			List(sym("`"), List(sym("a"), List(sym(","), sym("b")), List(sym(",@"), sym("c")))),
+			"`(a ,b ,@c)",
+		},
+		30: {List(sym("quote"), sym("x"), sym("y")), "(quote x y)"},
+		31: {List(sym("quote")), "(quote)"},
+
+		32: {NewVector(nil), "[]"},
+		33: {NewVector([]Object{str("a"), List(NewInt(1))}), `["a" (1)]`},
+	}
+
+	for i, test := range tests {
+		if have := Prin1String(test.object); have != test.want {
+			t.Errorf("tests[%d]:\nhave: %s\nwant: %s", i, have, test.want)
+		}
+	}
+}
+
+func TestPrincString(t *testing.T) {
+	str := func(s string) Object {
+		return NewString([]byte(s))
+	}
+
+	tests := [...]struct {
+		object Object
+		want   string
+	}{
+		0: {str(`a "b"`), `a "b"`},
+		1: {NewSymbol("foo bar"), "foo bar"},
+		2: {List(str("x"), NewSymbol("1"), NewFloat(1)), "(x 1 1.0)"},
+		3: {NewVector([]Object{str("a")}), "[a]"},
+	}
+
+	for i, test := range tests {
+		if have := PrincString(test.object); have != test.want {
+			t.Errorf("tests[%d]:\nhave: %s\nwant: %s", i, have, test.want)
+		}
+	}
+}
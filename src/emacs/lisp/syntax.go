@@ -0,0 +1,186 @@
+package lisp
+
+import (
+	"unicode/utf8"
+	"unsafe"
+)
+
+// SyntaxClass is a char syntax class code.
+// Values are identical to Emacs internal syntax codes.
+//
+// Issue#6
+type SyntaxClass uint8
+
+// All syntax classes.
+// Comments contain class designator chars used in syntax descriptors.
+const (
+	SyntaxWhitespace   SyntaxClass = iota // ` ` or `-`
+	SyntaxPunct                           // `.`
+	SyntaxWord                            // `w`
+	SyntaxSymbol                          // `_`
+	SyntaxOpen                            // `(`
+	SyntaxClose                           // `)`
+	SyntaxQuote                           // `'`
+	SyntaxString                          // `"`
+	SyntaxMath                            // `$`
+	SyntaxEscape                          // `\`
+	SyntaxCharQuote                       // `/`
+	SyntaxComment                         // `<`
+	SyntaxEndComment                      // `>`
+	SyntaxInherit                         // `@`
+	SyntaxCommentFence                    // `!`
+	SyntaxStringFence                     // `|`
+)
+
+// SyntaxFlags is a bit set of additional char syntax properties.
+type SyntaxFlags uint8
+
+// All syntax flags.
+// Comments contain flag chars used in syntax descriptors.
+const (
+	SyntaxComStartFirst  SyntaxFlags = 1 << iota // `1`
+	SyntaxComStartSecond                         // `2`
+	SyntaxComEndFirst                            // `3`
+	SyntaxComEndSecond                           // `4`
+	SyntaxPrefix                                 // `p`
+	SyntaxStyleB                                 // `b`
+	SyntaxNested                                 // `n`
+	SyntaxStyleC                                 // `c`
+)
+
+// SyntaxEntry describes syntax of a single char.
+type SyntaxEntry struct {
+	Class SyntaxClass
+	Flags SyntaxFlags
+
+	// Match is a matching char for parenthesis classes.
+	// Zero value means "no matching char".
+	Match rune
+}
+
+// Code returns entry class and flags combined in a way
+// that is compatible with Emacs raw syntax descriptors.
+func (e SyntaxEntry) Code() int64 {
+	return int64(e.Class) | int64(e.Flags)<<16
+}
+
+// SyntaxTable maps chars to their syntax entries.
+// Chars that have no entry inherit it from the Parent table.
+//
+// Issue#6
+type SyntaxTable struct {
+	// Parent is a table that provides entries for unset chars.
+	// Usually it is the standard syntax table.
+	Parent *SyntaxTable
+
+	// ascii is a fast path for most frequently looked up chars.
+	ascii [utf8.RuneSelf]syntaxSlot
+
+	// chars holds non-ASCII char entries.
+	chars map[rune]SyntaxEntry
+
+	// ranges holds entries for char ranges that are set
+	// as a whole, excluding ASCII part.
+	// Later ranges override earlier ones.
+	ranges []syntaxRange
+}
+
+type syntaxSlot struct {
+	entry SyntaxEntry
+	set   bool
+}
+
+type syntaxRange struct {
+	lo, hi rune
+	entry  SyntaxEntry
+}
+
+// NewSyntaxTable returns a syntax table Object with given parent.
+// All entries of created table are unset.
+func NewSyntaxTable(parent *SyntaxTable) Object {
+	st := &SyntaxTable{Parent: parent}
+	return st.Object()
+}
+
+// Object returns st wrapped into Object.
+func (st *SyntaxTable) Object() Object {
+	return NewRef(TypeSyntaxTable, unsafe.Pointer(st))
+}
+
+// Entry returns syntax entry for c.
+// Chars without entry anywhere in the parent chain
+// are treated as whitespace.
+func (st *SyntaxTable) Entry(c rune) SyntaxEntry {
+	for ; st != nil; st = st.Parent {
+		if e, ok := st.lookup(c); ok && e.Class != SyntaxInherit {
+			return e
+		}
+	}
+	return SyntaxEntry{Class: SyntaxWhitespace}
+}
+
+// Class returns syntax class of c.
+func (st *SyntaxTable) Class(c rune) SyntaxClass {
+	return st.Entry(c).Class
+}
+
+// SetEntry sets c syntax entry.
+func (st *SyntaxTable) SetEntry(c rune, e SyntaxEntry) {
+	if c >= 0 && c < utf8.RuneSelf {
+		st.ascii[c] = syntaxSlot{entry: e, set: true}
+		return
+	}
+	if st.chars == nil {
+		st.chars = make(map[rune]SyntaxEntry)
+	}
+	st.chars[c] = e
+}
+
+// SetRange sets syntax entry for every char inside [lo, hi].
+func (st *SyntaxTable) SetRange(lo, hi rune, e SyntaxEntry) {
+	for ; lo <= hi && lo < utf8.RuneSelf; lo++ {
+		st.SetEntry(lo, e)
+	}
+	if lo > hi {
+		return
+	}
+	for c := range st.chars {
+		if c >= lo && c <= hi {
+			delete(st.chars, c)
+		}
+	}
+	st.ranges = append(st.ranges, syntaxRange{lo: lo, hi: hi, entry: e})
+}
+
+// Copy returns a new syntax table with the same entries and parent.
+func (st *SyntaxTable) Copy() *SyntaxTable {
+	dst := &SyntaxTable{
+		Parent: st.Parent,
+		ascii:  st.ascii,
+		ranges: append([]syntaxRange(nil), st.ranges...),
+	}
+	if st.chars != nil {
+		dst.chars = make(map[rune]SyntaxEntry, len(st.chars))
+		for c, e := range st.chars {
+			dst.chars[c] = e
+		}
+	}
+	return dst
+}
+
+// lookup returns entry for c that is set in st itself.
+func (st *SyntaxTable) lookup(c rune) (SyntaxEntry, bool) {
+	if c >= 0 && c < utf8.RuneSelf {
+		slot := st.ascii[c]
+		return slot.entry, slot.set
+	}
+	if e, ok := st.chars[c]; ok {
+		return e, true
+	}
+	for i := len(st.ranges) - 1; i >= 0; i-- {
+		if r := st.ranges[i]; c >= r.lo && c <= r.hi {
+			return r.entry, true
+		}
+	}
+	return SyntaxEntry{}, false
+}
@@ -0,0 +1,40 @@
+package lisp
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// UserPtr is an opaque Go value that can be passed
+// through Lisp code, like Emacs module user-ptr objects.
+//
+// Lisp code can only pass it around; Go functions
+// extract the wrapped value with type assertions.
+type UserPtr struct {
+	// Value is the wrapped Go value.
+	Value interface{}
+
+	// TypeName describes Value for the humans.
+	// It is used for printing and typed extraction.
+	TypeName string
+}
+
+// NewUserPtr returns a user-ptr Object that wraps val.
+//
+// If finalizer is not nil, it is called with val after
+// the object becomes unreachable, so resources like
+// file handles can be released.
+func NewUserPtr(val interface{}, typeName string, finalizer func(val interface{})) Object {
+	p := &UserPtr{Value: val, TypeName: typeName}
+	if finalizer != nil {
+		runtime.SetFinalizer(p, func(p *UserPtr) {
+			finalizer(p.Value)
+		})
+	}
+	return p.Object()
+}
+
+// Object returns p wrapped into Object.
+func (p *UserPtr) Object() Object {
+	return NewRef(TypeUserPtr, unsafe.Pointer(p))
+}
@@ -0,0 +1,44 @@
+package lisp
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestUserPtr(t *testing.T) {
+	val := &struct{ x int }{x: 1}
+	o := NewUserPtr(val, "handle", nil)
+	if o.Type != TypeUserPtr {
+		t.Fatalf("Type: have %v, want %v", o.Type, TypeUserPtr)
+	}
+	p := o.UserPtr()
+	if p.Value != val || p.TypeName != "handle" {
+		t.Errorf("UserPtr(): have {%v %q}, want {%v %q}", p.Value, p.TypeName, val, "handle")
+	}
+	if o2 := p.Object(); !Eq(&o, &o2) {
+		t.Errorf("Object() is not eq to the original object")
+	}
+}
+
+func TestUserPtrFinalizer(t *testing.T) {
+	finalized := make(chan interface{}, 1)
+	func() {
+		NewUserPtr(10, "", func(val interface{}) {
+			finalized <- val
+		})
+	}()
+
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		select {
+		case val := <-finalized:
+			if val != 10 {
+				t.Errorf("finalizer argument: have %v, want 10", val)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Errorf("finalizer is not called")
+}
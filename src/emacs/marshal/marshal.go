@@ -0,0 +1,471 @@
+// Package marshal converts Go values to Emacs Lisp objects and back.
+//
+// Mapping is similar to the one that is used by encoding/json:
+//
+//	bool - t or nil
+//	integers and floats - integer and float
+//	string, []byte - string
+//	slices and arrays - list (or vector, see below)
+//	maps - alist; keys are symbols for string keys
+//	structs - plist with keyword keys (or alist, see below)
+//	time.Time - Lisp time value (HIGH LOW USEC PSEC)
+//	lisp.Object - as is
+//	*lisp.Buffer - buffer
+//	*lisp.UserPtr - user-ptr
+//	pointers and interfaces - value they point to, nil for nil
+//
+// Struct fields are encoded under names that are derived from
+// Go field names by converting them to lower-case-with-dashes:
+// FillColumn becomes fill-column.
+// Field tag can override the name and specify options:
+//
+//	// Encoded as :width key.
+//	Size int `lisp:"width"`
+//	// Omitted if it has zero value.
+//	Tabs bool `lisp:",omitempty"`
+//	// Slice is encoded as vector.
+//	Items []int `lisp:",vector"`
+//	// Struct is encoded as alist.
+//	Face Face `lisp:",alist"`
+//	// Skipped.
+//	Cache []byte `lisp:"-"`
+//
+// Anonymous struct fields are flattened.
+//
+// Marshal interns symbols into the given obarray;
+// during decoding, symbols are compared by their names.
+// Values that refer to themselves can't be encoded.
+// Types that need custom mapping may implement
+// Marshaler and Unmarshaler interfaces.
+package marshal
+
+import (
+	"emacs/lisp"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Marshaler is implemented by types that can convert
+// themselves into Lisp objects.
+type Marshaler interface {
+	MarshalLisp() (lisp.Object, error)
+}
+
+// Unmarshaler is implemented by types that can initialize
+// themselves from Lisp objects.
+type Unmarshaler interface {
+	UnmarshalLisp(o lisp.Object) error
+}
+
+// UnsupportedTypeError is returned by Marshal when value
+// of unsupported type is encountered.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "marshal: unsupported type: " + e.Type.String()
+}
+
+// UnsupportedValueError is returned by Marshal when value
+// can not be represented in Lisp.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "marshal: unsupported value: " + e.Str
+}
+
+// Marshal returns Lisp representation of v.
+// Symbols are interned into ob.
+func Marshal(v interface{}, ob *lisp.Obarray) (lisp.Object, error) {
+	e := encoder{ob: ob, visiting: make(map[visitKey]bool)}
+	return e.encode(reflect.ValueOf(v), fieldOpts{})
+}
+
+var (
+	objectType      = reflect.TypeOf(lisp.Object{})
+	bufferType      = reflect.TypeOf((*lisp.Buffer)(nil))
+	userPtrType     = reflect.TypeOf((*lisp.UserPtr)(nil))
+	timeType        = reflect.TypeOf(time.Time{})
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// encoder is a Marshal implementation helper.
+type encoder struct {
+	ob *lisp.Obarray
+
+	// visiting holds pointers, maps and slices that
+	// are being encoded; it is used to detect cycles.
+	visiting map[visitKey]bool
+}
+
+// visitKey identifies pointer, map or slice value.
+// Slices that share the array, but have different
+// lengths are different values.
+type visitKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// encode returns Lisp representation of v.
+// opts are taken from the struct field tag that holds v.
+func (e *encoder) encode(v reflect.Value, opts fieldOpts) (lisp.Object, error) {
+	if !v.IsValid() {
+		return lisp.Nil, nil
+	}
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return lisp.Nil, nil
+		}
+		return v.Interface().(Marshaler).MarshalLisp()
+	}
+
+	switch v.Type() {
+	case objectType:
+		return v.Interface().(lisp.Object), nil
+	case bufferType:
+		if v.IsNil() {
+			return lisp.Nil, nil
+		}
+		return v.Interface().(*lisp.Buffer).Object(), nil
+	case userPtrType:
+		if v.IsNil() {
+			return lisp.Nil, nil
+		}
+		return v.Interface().(*lisp.UserPtr).Object(), nil
+	case timeType:
+		return encodeTime(v.Interface().(time.Time)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return lisp.Bool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lisp.NewInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x := v.Uint()
+		if int64(x) < 0 {
+			return lisp.Nil, &UnsupportedValueError{v, strconv.FormatUint(x, 10)}
+		}
+		return lisp.NewInt(int64(x)), nil
+	case reflect.Float32, reflect.Float64:
+		return lisp.NewFloat(v.Float()), nil
+	case reflect.String:
+		return lisp.NewString([]byte(v.String())), nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return lisp.Nil, nil
+		}
+		return e.encode(v.Elem(), opts)
+	case reflect.Ptr:
+		return e.visit(v, func() (lisp.Object, error) {
+			return e.encode(v.Elem(), opts)
+		})
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return lisp.NewString(append([]byte(nil), v.Bytes()...)), nil
+		}
+		return e.visit(v, func() (lisp.Object, error) {
+			return e.encodeSeq(v, opts)
+		})
+	case reflect.Array:
+		return e.encodeSeq(v, opts)
+	case reflect.Map:
+		return e.visit(v, func() (lisp.Object, error) {
+			return e.encodeMap(v)
+		})
+	case reflect.Struct:
+		return e.encodeStruct(v, opts)
+	}
+
+	return lisp.Nil, &UnsupportedTypeError{v.Type()}
+}
+
+// visit calls encode for pointer, map or slice v.
+// Returns nil for nil v and an error if v is
+// already being encoded.
+func (e *encoder) visit(v reflect.Value, encode func() (lisp.Object, error)) (lisp.Object, error) {
+	if v.IsNil() {
+		return lisp.Nil, nil
+	}
+	key := visitKey{typ: v.Type(), ptr: v.Pointer()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if e.visiting[key] {
+		return lisp.Nil, &UnsupportedValueError{v, "encountered a cycle via " + v.Type().String()}
+	}
+	e.visiting[key] = true
+	o, err := encode()
+	delete(e.visiting, key)
+	return o, err
+}
+
+// encodeSeq returns slice or array v as list or vector.
+func (e *encoder) encodeSeq(v reflect.Value, opts fieldOpts) (lisp.Object, error) {
+	vals := make([]lisp.Object, v.Len())
+	for i := range vals {
+		val, err := e.encode(v.Index(i), fieldOpts{})
+		if err != nil {
+			return lisp.Nil, err
+		}
+		vals[i] = val
+	}
+	if opts.vector {
+		return lisp.NewVector(vals), nil
+	}
+	return lisp.List(vals...), nil
+}
+
+// encodeMap returns map v as alist.
+// Entries are sorted by keys to make output deterministic.
+func (e *encoder) encodeMap(v reflect.Value) (lisp.Object, error) {
+	keys := v.MapKeys()
+	switch v.Type().Key().Kind() {
+	case reflect.String:
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Int() < keys[j].Int()
+		})
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Uint() < keys[j].Uint()
+		})
+	default:
+		return lisp.Nil, &UnsupportedTypeError{v.Type()}
+	}
+
+	alist := make([]lisp.Object, len(keys))
+	for i, k := range keys {
+		var key lisp.Object
+		if k.Kind() == reflect.String {
+			key = e.ob.Intern(k.String())
+		} else {
+			var err error
+			if key, err = e.encode(k, fieldOpts{}); err != nil {
+				return lisp.Nil, err
+			}
+		}
+		val, err := e.encode(v.MapIndex(k), fieldOpts{})
+		if err != nil {
+			return lisp.Nil, err
+		}
+		alist[i] = lisp.NewCons(key, val)
+	}
+	return lisp.List(alist...), nil
+}
+
+// encodeStruct returns struct v as plist or alist.
+func (e *encoder) encodeStruct(v reflect.Value, opts fieldOpts) (lisp.Object, error) {
+	var vals []lisp.Object
+	for _, f := range structFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		val, err := e.encode(fv, f.fieldOpts)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		if opts.alist {
+			vals = append(vals, lisp.NewCons(e.ob.Intern(f.name), val))
+		} else {
+			vals = append(vals, e.ob.Intern(":"+f.name), val)
+		}
+	}
+	return lisp.List(vals...), nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but
+// reports false instead of panic if nil embedded pointer
+// is encountered.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue reports whether v is a zero value for omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+		if v.Type() == objectType {
+			o := v.Interface().(lisp.Object)
+			return lisp.Null(&o)
+		}
+	}
+	return false
+}
+
+// encodeTime returns t as (HIGH LOW USEC PSEC) list.
+func encodeTime(t time.Time) lisp.Object {
+	sec := t.Unix()
+	nsec := int64(t.Nanosecond())
+	return lisp.List(
+		lisp.NewInt(sec>>16),
+		lisp.NewInt(sec&0xFFFF),
+		lisp.NewInt(nsec/1000),
+		lisp.NewInt(nsec%1000*1000),
+	)
+}
+
+// fieldOpts are struct field tag options.
+type fieldOpts struct {
+	omitEmpty bool
+	vector    bool
+	alist     bool
+}
+
+// field describes single encoded struct field.
+type field struct {
+	fieldOpts
+
+	// name is a Lisp name of the field.
+	name string
+
+	// index is a reflect.Value.FieldByIndex argument.
+	index []int
+}
+
+var fieldCache struct {
+	sync.Mutex
+	m map[reflect.Type][]field
+}
+
+// structFields returns encoded fields of struct typ.
+func structFields(typ reflect.Type) []field {
+	fieldCache.Lock()
+	defer fieldCache.Unlock()
+
+	if fields, ok := fieldCache.m[typ]; ok {
+		return fields
+	}
+	fields := collectFields(typ, nil)
+	if fieldCache.m == nil {
+		fieldCache.m = make(map[reflect.Type][]field)
+	}
+	fieldCache.m[typ] = fields
+	return fields
+}
+
+// collectFields returns fields of typ;
+// fields of anonymous struct fields are included.
+// Fields of outer structs hide fields of embedded ones.
+func collectFields(typ reflect.Type, index []int) []field {
+	var fields []field
+	var embedded []field
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag := sf.Tag.Get("lisp")
+		if tag == "-" {
+			continue
+		}
+		name, opts := parseTag(tag)
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				embedded = append(embedded, collectFields(ft, fieldIndex)...)
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue // Unexported
+		}
+		if name == "" {
+			name = lispName(sf.Name)
+		}
+		fields = append(fields, field{fieldOpts: opts, name: name, index: fieldIndex})
+	}
+
+	for _, f := range embedded {
+		if findField(fields, f.name) == nil {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// findField returns a field with given name or nil.
+func findField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// parseTag splits `lisp` tag into name and options.
+func parseTag(tag string) (string, fieldOpts) {
+	var opts fieldOpts
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "omitempty":
+			opts.omitEmpty = true
+		case "vector":
+			opts.vector = true
+		case "alist":
+			opts.alist = true
+		}
+	}
+	return parts[0], opts
+}
+
+// lispName converts Go identifier to Lisp naming convention:
+// FillColumn => fill-column, URLPath => url-path.
+func lispName(name string) string {
+	runes := []rune(name)
+	var buf []rune
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(prev) || nextLower {
+				buf = append(buf, '-')
+			}
+		}
+		buf = append(buf, unicode.ToLower(r))
+	}
+	return string(buf)
+}
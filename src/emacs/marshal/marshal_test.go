@@ -0,0 +1,330 @@
+package marshal
+
+import (
+	"emacs/lisp"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testFace struct {
+	Family string
+	Height float64 `lisp:",omitempty"`
+}
+
+type testBase struct {
+	Name string
+	Mode string
+}
+
+type testConfig struct {
+	testBase
+	Mode       string `lisp:"major-mode"`
+	FillColumn int
+	TabWidth   *int     `lisp:",omitempty"`
+	Tabs       bool     `lisp:"indent-tabs-mode"`
+	Hooks      []string `lisp:",vector"`
+	Face       testFace `lisp:",alist"`
+	Keys       map[string]int
+	Saved      time.Time `lisp:",omitempty"`
+	Extra      lisp.Object
+	cache      int
+	Ignored    int `lisp:"-"`
+}
+
+// testDistance implements Marshaler and Unmarshaler.
+type testDistance int
+
+func (d testDistance) MarshalLisp() (lisp.Object, error) {
+	return lisp.List(lisp.NewInt(int64(d)), lisp.NewSymbol("km")), nil
+}
+
+func (d *testDistance) UnmarshalLisp(o lisp.Object) error {
+	*d = testDistance(o.Cons().Car.Int())
+	return nil
+}
+
+func TestMarshal(t *testing.T) {
+	width := 4
+	userPtr := lisp.NewUserPtr(1, "x", nil)
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "nil"},
+		{true, "t"},
+		{false, "nil"},
+		{int8(-5), "-5"},
+		{uint16(5), "5"},
+		{1.5, "1.5"},
+		{"abc", `"abc"`},
+		{[]byte("abc"), `"abc"`},
+		{[]int{1, 2}, "(1 . (2 . nil))"},
+		{[]int(nil), "nil"},
+		{[2]string{"a", "b"}, `("a" . ("b" . nil))`},
+		{&width, "4"},
+		{(*int)(nil), "nil"},
+		{[]interface{}{1, "a", nil}, `(1 . ("a" . (nil . nil)))`},
+		{map[string]int{"b": 2, "a": 1}, "((a . 1) . ((b . 2) . nil))"},
+		{map[int]bool{2: true, 1: false}, "((1 . nil) . ((2 . t) . nil))"},
+		{time.Unix(1500000000, 123456789), "(22888 . (12032 . (123456 . (789000 . nil))))"},
+		{testDistance(10), "(10 . (km . nil))"},
+		{lisp.NewSymbol("foo"), "foo"},
+		{userPtr.UserPtr(), "#<user-ptr x>"},
+		{
+			testFace{Family: "Mono"},
+			`(:family . ("Mono" . nil))`,
+		},
+		{
+			testConfig{
+				testBase:   testBase{Name: "a.go", Mode: "hidden"},
+				Mode:       "go-mode",
+				FillColumn: 80,
+				TabWidth:   &width,
+				Hooks:      []string{"gofmt"},
+				Face:       testFace{Family: "Mono", Height: 1.5},
+				Extra:      lisp.Nil,
+				cache:      1,
+				Ignored:    1,
+			},
+			`(:major-mode . ("go-mode" . (:fill-column . (80 . (:tab-width . (4 . ` +
+				`(:indent-tabs-mode . (nil . (:hooks . (["gofmt"] . ` +
+				`(:face . (((family . "Mono") . ((height . 1.5) . nil)) . ` +
+				`(:keys . (nil . (:extra . (nil . (:name . ("a.go" . (:mode . ("hidden" . nil))))))))))))))))))))`,
+		},
+	}
+	for _, tt := range tests {
+		o, err := Marshal(tt.v, lisp.NewObarray())
+		if err != nil {
+			t.Errorf("Marshal(%#v): unexpected error: %v", tt.v, err)
+			continue
+		}
+		if have := lisp.ObjectString(o); have != tt.want {
+			t.Errorf("Marshal(%#v):\nhave: %s\nwant: %s", tt.v, have, tt.want)
+		}
+	}
+}
+
+type testNode struct {
+	Next *testNode
+}
+
+var (
+	cyclicNode  = &testNode{}
+	cyclicMap   = map[string]interface{}{}
+	cyclicSlice = []interface{}{nil}
+)
+
+func init() {
+	cyclicNode.Next = cyclicNode
+	cyclicMap["self"] = cyclicMap
+	cyclicSlice[0] = cyclicSlice
+}
+
+func TestMarshalInterns(t *testing.T) {
+	ob := lisp.NewObarray()
+	o, err := Marshal([]interface{}{
+		testFace{Family: "Mono"},
+		map[string]int{"width": 1},
+	}, ob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plist := o.Cons().Car
+	alist := o.Cons().Cdr.Cons().Car
+	if key, want := plist.Cons().Car, ob.Intern(":family"); !lisp.Eq(&key, &want) {
+		t.Errorf("plist key %s is not interned", lisp.ObjectString(key))
+	}
+	if key, want := alist.Cons().Car.Cons().Car, ob.Intern("width"); !lisp.Eq(&key, &want) {
+		t.Errorf("alist key %s is not interned", lisp.ObjectString(key))
+	}
+
+	// Shared values are not cycles.
+	width := 4
+	shared := []*int{&width, &width}
+	if _, err := Marshal(shared, ob); err != nil {
+		t.Errorf("Marshal(%#v): unexpected error: %v", shared, err)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{make(chan int), "marshal: unsupported type: chan int"},
+		{[]func(){nil}, "marshal: unsupported type: func()"},
+		{map[bool]int{true: 1}, "marshal: unsupported type: map[bool]int"},
+		{uint64(1 << 63), "marshal: unsupported value: 9223372036854775808"},
+		{cyclicNode, "marshal: unsupported value: encountered a cycle via *marshal.testNode"},
+		{cyclicMap, "marshal: unsupported value: encountered a cycle via map[string]interface {}"},
+		{cyclicSlice, "marshal: unsupported value: encountered a cycle via []interface {}"},
+	}
+	for _, tt := range tests {
+		_, err := Marshal(tt.v, lisp.NewObarray())
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Marshal(%#v):\nhave error: %v\nwant error: %s", tt.v, err, tt.want)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	sym := lisp.NewSymbol
+	str := func(s string) lisp.Object { return lisp.NewString([]byte(s)) }
+	num := func(x int64) lisp.Object { return lisp.NewInt(x) }
+	width := 4
+	x := sym("x")
+
+	tests := []struct {
+		o    lisp.Object
+		ptr  interface{}
+		want interface{}
+	}{
+		{lisp.T, new(bool), true},
+		{lisp.Nil, new(bool), false},
+		{num(-5), new(int8), int8(-5)},
+		{num(5), new(uint), uint(5)},
+		{num(2), new(float64), 2.0},
+		{lisp.NewFloat(0.5), new(float32), float32(0.5)},
+		{str("abc"), new(string), "abc"},
+		{str("abc"), new([]byte), []byte("abc")},
+		{lisp.List(num(1), num(2)), new([]int), []int{1, 2}},
+		{lisp.NewVector([]lisp.Object{num(1), num(2)}), new([]int), []int{1, 2}},
+		{lisp.Nil, new([]int), []int(nil)},
+		{lisp.List(num(1)), new([2]int), [2]int{1, 0}},
+		{num(4), new(*int), &width},
+		{lisp.Nil, new(*int), (*int)(nil)},
+		{
+			lisp.List(num(1), lisp.NewFloat(1.5), str("a"), lisp.T, lisp.Nil, lisp.List(num(2)), x),
+			new(interface{}),
+			[]interface{}{int64(1), 1.5, "a", true, nil, []interface{}{int64(2)}, x},
+		},
+		{
+			lisp.List(lisp.NewCons(sym("a"), num(1)), lisp.NewCons(str("b"), num(2))),
+			new(map[string]int),
+			map[string]int{"a": 1, "b": 2},
+		},
+		{
+			lisp.List(lisp.NewCons(num(1), lisp.T)),
+			new(map[int]bool),
+			map[int]bool{1: true},
+		},
+		{lisp.List(num(22888), num(12032), num(123456), num(789000)), new(time.Time), time.Unix(1500000000, 123456789)},
+		{lisp.List(num(22888), num(12032)), new(time.Time), time.Unix(1500000000, 0)},
+		{lisp.NewCons(num(3000000001), num(2)), new(time.Time), time.Unix(1500000000, 500000000)},
+		{num(1500000000), new(time.Time), time.Unix(1500000000, 0)},
+		{lisp.NewFloat(1.5), new(time.Time), time.Unix(1, 500000000)},
+		{lisp.List(num(10), sym("km")), new(testDistance), testDistance(10)},
+		{
+			lisp.List(sym(":family"), str("Mono"), sym(":unknown"), num(1)),
+			new(testFace),
+			testFace{Family: "Mono"},
+		},
+		{
+			lisp.List(lisp.NewCons(sym("family"), str("Mono")), lisp.NewCons(str("Height"), num(2))),
+			new(testFace),
+			testFace{Family: "Mono", Height: 2},
+		},
+		{
+			lisp.List(
+				sym(":name"), str("a.go"),
+				sym(":major-mode"), str("go-mode"),
+				sym(":fill-column"), num(80),
+				sym(":tab-width"), num(4),
+				sym(":indent-tabs-mode"), lisp.T,
+				sym(":hooks"), lisp.NewVector([]lisp.Object{str("gofmt")}),
+				sym(":face"), lisp.List(lisp.NewCons(sym("family"), str("Mono"))),
+				sym(":keys"), lisp.List(lisp.NewCons(sym("C-c"), num(1))),
+				sym(":extra"), num(7),
+				sym(":ignored"), num(1),
+			),
+			new(testConfig),
+			testConfig{
+				testBase:   testBase{Name: "a.go"},
+				Mode:       "go-mode",
+				FillColumn: 80,
+				TabWidth:   &width,
+				Tabs:       true,
+				Hooks:      []string{"gofmt"},
+				Face:       testFace{Family: "Mono"},
+				Keys:       map[string]int{"C-c": 1},
+				Extra:      num(7),
+			},
+		},
+	}
+	for _, tt := range tests {
+		if err := Unmarshal(tt.o, tt.ptr); err != nil {
+			t.Errorf("Unmarshal(%s, %T): unexpected error: %v", lisp.ObjectString(tt.o), tt.ptr, err)
+			continue
+		}
+		have := reflect.ValueOf(tt.ptr).Elem().Interface()
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("Unmarshal(%s, %T):\nhave: %#v\nwant: %#v",
+				lisp.ObjectString(tt.o), tt.ptr, have, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	sym := lisp.NewSymbol
+	str := func(s string) lisp.Object { return lisp.NewString([]byte(s)) }
+	num := func(x int64) lisp.Object { return lisp.NewInt(x) }
+
+	tests := []struct {
+		o    lisp.Object
+		ptr  interface{}
+		want string
+	}{
+		{num(1), nil, "Unmarshal(nil)"},
+		{num(1), 0, "Unmarshal(non-pointer int)"},
+		{num(1), (*int)(nil), "Unmarshal(nil *int)"},
+		{str("1"), new(int), "cannot unmarshal \"1\" into Go value of type int"},
+		{num(300), new(int8), "cannot unmarshal 300 into Go value of type int8"},
+		{num(-1), new(uint), "cannot unmarshal -1 into Go value of type uint"},
+		{sym("x"), new(float64), "cannot unmarshal x into Go value of type float64"},
+		{num(1), new([]int), "cannot unmarshal 1 into Go value of type []int"},
+		{lisp.NewCons(num(1), num(2)), new([]int), "cannot unmarshal (1 . 2) into Go value of type []int"},
+		{lisp.List(num(1)), new(map[string]int), "cannot unmarshal (1 . nil) into Go value of type map[string]int"},
+		{str("ptr"), new(*lisp.UserPtr), "cannot unmarshal \"ptr\" into Go value of type *lisp.UserPtr"},
+		{str("now"), new(time.Time), "cannot unmarshal \"now\" into Go value of type time.Time"},
+		{num(1), new(error), "cannot unmarshal 1 into Go value of type error"},
+		{lisp.List(sym(":family")), new(testFace), "cannot unmarshal (:family . nil) into Go value of type marshal.testFace"},
+		{
+			lisp.List(sym(":family"), num(1)),
+			new(testFace),
+			"cannot unmarshal 1 into Go struct field testFace.Family of type string",
+		},
+		{
+			lisp.List(sym(":face"), lisp.List(sym(":height"), str("big"))),
+			new(testConfig),
+			"cannot unmarshal \"big\" into Go struct field testConfig.Face.Height of type float64",
+		},
+	}
+	for _, tt := range tests {
+		err := Unmarshal(tt.o, tt.ptr)
+		want := "marshal: " + tt.want
+		if err == nil || err.Error() != want {
+			t.Errorf("Unmarshal(%s, %T):\nhave error: %v\nwant error: %s",
+				lisp.ObjectString(tt.o), tt.ptr, err, want)
+		}
+	}
+}
+
+func TestLispName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Name", "name"},
+		{"FillColumn", "fill-column"},
+		{"URLPath", "url-path"},
+		{"ID", "id"},
+		{"Utf8Mode", "utf8-mode"},
+		{"X", "x"},
+	}
+	for _, tt := range tests {
+		if have := lispName(tt.name); have != tt.want {
+			t.Errorf("lispName(%q): have %q, want %q", tt.name, have, tt.want)
+		}
+	}
+}
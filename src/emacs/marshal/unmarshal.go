@@ -0,0 +1,431 @@
+package marshal
+
+import (
+	"emacs/lisp"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// InvalidUnmarshalError is returned by Unmarshal when
+// its argument is not a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "marshal: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "marshal: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "marshal: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// UnmarshalTypeError describes Lisp value that can not
+// be stored into Go value of specific type.
+type UnmarshalTypeError struct {
+	Value  lisp.Object  // Offending Lisp value
+	Type   reflect.Type // Go type it could not be assigned to
+	Struct string       // Name of the outermost struct type
+	Field  string       // Path to the field from Struct
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	val := lisp.ObjectString(e.Value)
+	if e.Struct != "" || e.Field != "" {
+		return "marshal: cannot unmarshal " + val + " into Go struct field " +
+			e.Struct + "." + e.Field + " of type " + e.Type.String()
+	}
+	return "marshal: cannot unmarshal " + val + " into Go value of type " + e.Type.String()
+}
+
+// Unmarshal stores Lisp object o into the value pointed to by v.
+//
+// It reverses Marshal mapping. In addition:
+//
+//	structs can be decoded from both plists and alists;
+//	keys may be symbols (with or without leading colon) or strings;
+//	field names are matched case-insensitively if there is no exact match;
+//	unknown keys are ignored.
+//
+//	slices and arrays can be decoded from lists and vectors.
+//
+//	time.Time can be decoded from any Lisp time value:
+//	(HIGH LOW USEC PSEC) list and its prefixes, (TICKS . HZ) pair,
+//	integer or float number of seconds.
+//
+//	empty interface receives int64, float64, string, bool (for t),
+//	[]interface{} (for lists and vectors) or nil;
+//	other objects are stored as lisp.Object.
+//
+// Nil value stores Go zero value into pointers,
+// interfaces, maps and slices.
+func Unmarshal(o lisp.Object, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	return decode(o, rv.Elem())
+}
+
+// decode stores o into v, which must be settable.
+func decode(o lisp.Object, v reflect.Value) error {
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		return v.Addr().Interface().(Unmarshaler).UnmarshalLisp(o)
+	}
+
+	switch v.Type() {
+	case objectType:
+		v.Set(reflect.ValueOf(o))
+		return nil
+	case bufferType:
+		switch {
+		case lisp.Null(&o):
+			v.Set(reflect.Zero(bufferType))
+		case o.Type == lisp.TypeBuffer:
+			v.Set(reflect.ValueOf(o.Buffer()))
+		default:
+			return typeError(o, v)
+		}
+		return nil
+	case userPtrType:
+		switch {
+		case lisp.Null(&o):
+			v.Set(reflect.Zero(userPtrType))
+		case o.Type == lisp.TypeUserPtr:
+			v.Set(reflect.ValueOf(o.UserPtr()))
+		default:
+			return typeError(o, v)
+		}
+		return nil
+	case timeType:
+		t, ok := decodeTime(o)
+		if !ok {
+			return typeError(o, v)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if lisp.Null(&o) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decode(o, v.Elem())
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return typeError(o, v)
+		}
+		if x := decodeAny(o); x != nil {
+			v.Set(reflect.ValueOf(x))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+
+	case reflect.Bool:
+		v.SetBool(!lisp.Null(&o))
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if o.Type != lisp.TypeInt || v.OverflowInt(o.Int()) {
+			return typeError(o, v)
+		}
+		v.SetInt(o.Int())
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if o.Type != lisp.TypeInt || o.Int() < 0 || v.OverflowUint(uint64(o.Int())) {
+			return typeError(o, v)
+		}
+		v.SetUint(uint64(o.Int()))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		switch o.Type {
+		case lisp.TypeInt:
+			v.SetFloat(float64(o.Int()))
+		case lisp.TypeFloat:
+			v.SetFloat(o.Float())
+		default:
+			return typeError(o, v)
+		}
+		return nil
+
+	case reflect.String:
+		if o.Type != lisp.TypeString {
+			return typeError(o, v)
+		}
+		v.SetString(string(o.String().Chars))
+		return nil
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && o.Type == lisp.TypeString {
+			v.SetBytes(append([]byte(nil), o.String().Chars...))
+			return nil
+		}
+		if lisp.Null(&o) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elems, ok := seqElems(o)
+		if !ok {
+			return typeError(o, v)
+		}
+		s := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+		for i := range elems {
+			if err := decode(elems[i], s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+
+	case reflect.Array:
+		elems, ok := seqElems(o)
+		if !ok {
+			return typeError(o, v)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if i >= len(elems) {
+				v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+				continue
+			}
+			if err := decode(elems[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		return decodeMap(o, v)
+
+	case reflect.Struct:
+		return decodeStruct(o, v)
+	}
+
+	return typeError(o, v)
+}
+
+// decodeMap stores alist o into map v.
+func decodeMap(o lisp.Object, v reflect.Value) error {
+	if lisp.Null(&o) {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	elems, ok := listElems(o)
+	if !ok {
+		return typeError(o, v)
+	}
+	typ := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(typ))
+	}
+	for _, entry := range elems {
+		if entry.Type != lisp.TypeCons {
+			return typeError(o, v)
+		}
+		key := reflect.New(typ.Key()).Elem()
+		if name, ok := keyName(entry.Cons().Car); ok && key.Kind() == reflect.String {
+			key.SetString(name)
+		} else if err := decode(entry.Cons().Car, key); err != nil {
+			return err
+		}
+		val := reflect.New(typ.Elem()).Elem()
+		if err := decode(entry.Cons().Cdr, val); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, val)
+	}
+	return nil
+}
+
+// decodeStruct stores plist or alist o into struct v.
+func decodeStruct(o lisp.Object, v reflect.Value) error {
+	elems, ok := listElems(o)
+	if !ok {
+		return typeError(o, v)
+	}
+
+	// Convert both forms to a flat key/value list.
+	var kvs []lisp.Object
+	if len(elems) != 0 && elems[0].Type == lisp.TypeCons {
+		for _, entry := range elems {
+			if entry.Type != lisp.TypeCons {
+				return typeError(o, v)
+			}
+			kvs = append(kvs, entry.Cons().Car, entry.Cons().Cdr)
+		}
+	} else {
+		if len(elems)%2 != 0 {
+			return typeError(o, v)
+		}
+		kvs = elems
+	}
+
+	fields := structFields(v.Type())
+	for i := 0; i < len(kvs); i += 2 {
+		name, ok := keyName(kvs[i])
+		if !ok {
+			return typeError(o, v)
+		}
+		f := lookupField(fields, strings.TrimPrefix(name, ":"))
+		if f == nil {
+			continue
+		}
+		fv := fieldByIndexAlloc(v, f.index)
+		if err := decode(kvs[i+1], fv); err != nil {
+			if e, ok := err.(*UnmarshalTypeError); ok {
+				fieldName := v.Type().FieldByIndex(f.index).Name
+				if e.Field != "" {
+					fieldName += "." + e.Field
+				}
+				e.Struct = v.Type().Name()
+				e.Field = fieldName
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupField returns a field named name.
+// If there is no exact match, case-insensitive match is used.
+func lookupField(fields []field, name string) *field {
+	if f := findField(fields, name); f != nil {
+		return f
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// fieldByIndexAlloc is like reflect.Value.FieldByIndex, but
+// allocates nil embedded pointers.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// decodeTime converts Lisp time value to time.Time.
+func decodeTime(o lisp.Object) (time.Time, bool) {
+	switch o.Type {
+	case lisp.TypeInt:
+		return time.Unix(o.Int(), 0), true
+	case lisp.TypeFloat:
+		sec, frac := math.Modf(o.Float())
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	case lisp.TypeCons:
+		// (TICKS . HZ)
+		if cons := o.Cons(); cons.Car.Type == lisp.TypeInt && cons.Cdr.Type == lisp.TypeInt {
+			ticks, hz := cons.Car.Int(), cons.Cdr.Int()
+			if hz <= 0 {
+				return time.Time{}, false
+			}
+			sec := ticks / hz
+			nsec := float64(ticks%hz) * 1e9 / float64(hz)
+			return time.Unix(sec, int64(nsec)), true
+		}
+	}
+
+	// (HIGH LOW USEC PSEC) or its prefix.
+	elems, ok := listElems(o)
+	if !ok || len(elems) < 2 || len(elems) > 4 {
+		return time.Time{}, false
+	}
+	var parts [4]int64
+	for i, x := range elems {
+		if x.Type != lisp.TypeInt {
+			return time.Time{}, false
+		}
+		parts[i] = x.Int()
+	}
+	sec := parts[0]<<16 + parts[1]
+	nsec := parts[2]*1000 + parts[3]/1000
+	return time.Unix(sec, nsec), true
+}
+
+// decodeAny converts o to a value that is stored in interface{}.
+func decodeAny(o lisp.Object) interface{} {
+	switch {
+	case lisp.Null(&o):
+		return nil
+	case lisp.Eq(&o, &lisp.T):
+		return true
+	}
+	switch o.Type {
+	case lisp.TypeInt:
+		return o.Int()
+	case lisp.TypeFloat:
+		return o.Float()
+	case lisp.TypeString:
+		return string(o.String().Chars)
+	case lisp.TypeCons, lisp.TypeVector:
+		if elems, ok := seqElems(o); ok {
+			xs := make([]interface{}, len(elems))
+			for i := range elems {
+				xs[i] = decodeAny(elems[i])
+			}
+			return xs
+		}
+	}
+	return o
+}
+
+// keyName returns a name of symbol or string key.
+func keyName(o lisp.Object) (string, bool) {
+	switch o.Type {
+	case lisp.TypeSymbol:
+		return o.Symbol().Name, true
+	case lisp.TypeString:
+		return string(o.String().Chars), true
+	default:
+		return "", false
+	}
+}
+
+// seqElems returns elements of a proper list or vector o.
+func seqElems(o lisp.Object) ([]lisp.Object, bool) {
+	if o.Type == lisp.TypeVector {
+		return o.Vector().Vals, true
+	}
+	return listElems(o)
+}
+
+// listElems returns elements of a proper list o.
+func listElems(o lisp.Object) ([]lisp.Object, bool) {
+	var elems []lisp.Object
+	for !lisp.Null(&o) {
+		if o.Type != lisp.TypeCons {
+			return nil, false
+		}
+		elems = append(elems, o.Cons().Car)
+		o = o.Cons().Cdr
+	}
+	return elems, true
+}
+
+// typeError returns an error that reports that o can't be stored into v.
+func typeError(o lisp.Object, v reflect.Value) error {
+	return &UnmarshalTypeError{Value: o, Type: v.Type()}
+}
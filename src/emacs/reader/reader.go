@@ -0,0 +1,681 @@
+// Package reader implements Emacs Lisp reader.
+//
+// Reader converts textual representation of Lisp objects into
+// lisp.Object values, like `read` function does.
+// Symbols are interned into the provided obarray.
+package reader
+
+import (
+	"emacs/lisp"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is a syntax error, like Emacs `invalid-read-syntax`.
+type Error struct {
+	// Line is 1-based line number where error was detected.
+	Line int
+
+	// Msg is invalid-read-syntax data, like ")" or "#".
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": invalid-read-syntax: " + e.Msg
+}
+
+// Reader reads Lisp objects from the source text one by one.
+type Reader struct {
+	// ByteCode constructs compiled function object from
+	// #[ARGS CODE CONSTANTS DEPTH ...] elements.
+	// If nil, byte code literals are syntax errors.
+	ByteCode func(elems []lisp.Object) (lisp.Object, error)
+
+	// FileName is a value of #$ syntax, the name of
+	// the file that is being loaded.
+	FileName string
+
+	src  []byte
+	pos  int
+	line int
+	ob   *lisp.Obarray
+}
+
+// New returns a reader for src that interns symbols into ob.
+func New(src []byte, ob *lisp.Obarray) *Reader {
+	return &Reader{src: src, line: 1, ob: ob}
+}
+
+// ReadString reads a single object from s.
+// Text after the object is ignored.
+func ReadString(s string, ob *lisp.Obarray) (lisp.Object, error) {
+	return New([]byte(s), ob).Read()
+}
+
+// Read returns the next object.
+//
+// Returns io.EOF if there are no more objects and
+// io.ErrUnexpectedEOF if the input ends in the middle of an object.
+func (r *Reader) Read() (lisp.Object, error) {
+	r.skipSpace()
+	if r.pos == len(r.src) {
+		return lisp.Nil, io.EOF
+	}
+	o, err := r.read()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return o, err
+}
+
+// Line returns the line number where the next object starts.
+// Whitespace and comments before the object are consumed.
+func (r *Reader) Line() int {
+	r.skipSpace()
+	return r.line
+}
+
+// Offset returns current byte offset inside the source.
+func (r *Reader) Offset() int {
+	return r.pos
+}
+
+// read is Read without the EOF conversion.
+func (r *Reader) read() (lisp.Object, error) {
+	r.skipSpace()
+	if r.pos == len(r.src) {
+		return lisp.Nil, io.EOF
+	}
+
+	c := r.src[r.pos]
+	switch c {
+	case '(':
+		r.pos++
+		return r.readList(')')
+	case '[':
+		r.pos++
+		elems, err := r.readSeq(']')
+		if err != nil {
+			return lisp.Nil, err
+		}
+		return lisp.NewVector(elems), nil
+	case ')', ']':
+		r.pos++
+		return lisp.Nil, r.errorf(string(c))
+	case '"':
+		r.pos++
+		return r.readString()
+	case '?':
+		r.pos++
+		ch, err := r.readChar(true)
+		if err != nil {
+			return lisp.Nil, err
+		}
+		return lisp.NewInt(int64(ch)), nil
+	case '\'':
+		r.pos++
+		return r.readQuoted("quote")
+	case '`':
+		r.pos++
+		return r.readQuoted("`")
+	case ',':
+		r.pos++
+		if r.pos < len(r.src) && r.src[r.pos] == '@' {
+			r.pos++
+			return r.readQuoted(",@")
+		}
+		return r.readQuoted(",")
+	case '#':
+		r.pos++
+		return r.readHash()
+	}
+
+	return r.readAtom()
+}
+
+// readQuoted reads an object and wraps it into (sym object) list.
+func (r *Reader) readQuoted(sym string) (lisp.Object, error) {
+	o, err := r.read()
+	if err != nil {
+		return lisp.Nil, err
+	}
+	return lisp.List(r.ob.Intern(sym), o), nil
+}
+
+// readList reads list elements until closing paren.
+// Opening paren is already consumed.
+func (r *Reader) readList(end byte) (lisp.Object, error) {
+	var elems []lisp.Object
+	tail := lisp.Nil
+	for {
+		r.skipSpace()
+		if r.pos == len(r.src) {
+			return lisp.Nil, io.EOF
+		}
+		if r.src[r.pos] == end {
+			r.pos++
+			break
+		}
+		if r.isDot() {
+			if len(elems) == 0 {
+				return lisp.Nil, r.errorf(".")
+			}
+			r.pos++
+			o, err := r.read()
+			if err != nil {
+				return lisp.Nil, err
+			}
+			tail = o
+			r.skipSpace()
+			if r.pos == len(r.src) {
+				return lisp.Nil, io.EOF
+			}
+			if r.src[r.pos] != end {
+				return lisp.Nil, r.errorf(". in wrong context")
+			}
+			r.pos++
+			break
+		}
+		o, err := r.read()
+		if err != nil {
+			return lisp.Nil, err
+		}
+		elems = append(elems, o)
+	}
+
+	for i := len(elems) - 1; i >= 0; i-- {
+		tail = lisp.NewCons(elems[i], tail)
+	}
+	return tail, nil
+}
+
+// readSeq reads elements until end char.
+// Opening char is already consumed.
+func (r *Reader) readSeq(end byte) ([]lisp.Object, error) {
+	elems := []lisp.Object{}
+	for {
+		r.skipSpace()
+		if r.pos == len(r.src) {
+			return nil, io.EOF
+		}
+		if r.src[r.pos] == end {
+			r.pos++
+			return elems, nil
+		}
+		o, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, o)
+	}
+}
+
+// isDot reports whether reader is positioned at
+// the dotted pair separator.
+func (r *Reader) isDot() bool {
+	if r.src[r.pos] != '.' {
+		return false
+	}
+	return r.pos+1 == len(r.src) || isDelimiter(r.src[r.pos+1])
+}
+
+// readHash reads # syntax. The '#' is already consumed.
+func (r *Reader) readHash() (lisp.Object, error) {
+	if r.pos == len(r.src) {
+		return lisp.Nil, io.EOF
+	}
+	c := r.src[r.pos]
+	switch c {
+	case '\'':
+		r.pos++
+		return r.readQuoted("function")
+	case '#':
+		r.pos++
+		return lisp.NewSymbol(""), nil
+	case '[':
+		r.pos++
+		return r.readByteCode()
+	case '@':
+		// Well-formed #@NUMBER is skipped by skipSpace.
+		return lisp.Nil, r.errorf("#@")
+	case '$':
+		r.pos++
+		if r.FileName == "" {
+			return lisp.Nil, nil
+		}
+		return lisp.NewString([]byte(r.FileName)), nil
+	case ':':
+		r.pos++
+		name, err := r.readToken()
+		if err != nil {
+			return lisp.Nil, err
+		}
+		return lisp.NewSymbol(name), nil
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		r.pos++
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[c|0x20]
+		tok, err := r.readToken()
+		if err != nil {
+			return lisp.Nil, err
+		}
+		x, err := strconv.ParseInt(tok, base, 64)
+		if err != nil {
+			return lisp.Nil, r.errorf("integer, radix " + strconv.Itoa(base))
+		}
+		return lisp.NewInt(x), nil
+	}
+	return lisp.Nil, r.errorf("#")
+}
+
+// readByteCode reads #[...] compiled function literal.
+// The "#[" is already consumed.
+func (r *Reader) readByteCode() (lisp.Object, error) {
+	line := r.line
+	elems, err := r.readSeq(']')
+	if err != nil {
+		return lisp.Nil, err
+	}
+	if r.ByteCode == nil || len(elems) < 4 {
+		return lisp.Nil, &Error{Line: line, Msg: "#["}
+	}
+	return r.ByteCode(elems)
+}
+
+// skipDoc implements #@NUMBER syntax: it skips NUMBER bytes
+// that follow the number, counting the delimiter after it.
+// "#@00" skips the rest of the input.
+// It is used in .elc files to hide doc strings from the reader.
+//
+// Reader must be positioned at '#'.
+// Returns false if there is no valid #@NUMBER.
+func (r *Reader) skipDoc() bool {
+	if r.pos+2 >= len(r.src) || r.src[r.pos+1] != '@' {
+		return false
+	}
+	pos := r.pos + 2
+	for pos < len(r.src) && r.src[pos] >= '0' && r.src[pos] <= '9' {
+		pos++
+	}
+	digits := string(r.src[r.pos+2 : pos])
+	if digits == "00" {
+		r.pos = len(r.src)
+		return true
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return false
+	}
+	end := pos + n
+	if end > len(r.src) {
+		end = len(r.src)
+	}
+	for r.pos = pos; r.pos < end; r.pos++ {
+		if r.src[r.pos] == '\n' {
+			r.line++
+		}
+	}
+	return true
+}
+
+// readAtom reads a number or a symbol.
+func (r *Reader) readAtom() (lisp.Object, error) {
+	start := r.pos
+	tok, err := r.readToken()
+	if err != nil {
+		return lisp.Nil, err
+	}
+	// Escaped chars make token a symbol: \1 is a symbol.
+	escaped := strings.IndexByte(string(r.src[start:r.pos]), '\\') != -1
+	if !escaped {
+		if o, ok := parseNumber(tok); ok {
+			return o, nil
+		}
+	}
+	return r.ob.Intern(tok), nil
+}
+
+// readToken reads symbol name, resolving backslash escapes.
+func (r *Reader) readToken() (string, error) {
+	var buf []byte
+	for r.pos < len(r.src) && !isDelimiter(r.src[r.pos]) {
+		c := r.src[r.pos]
+		if c == '\\' {
+			r.pos++
+			if r.pos == len(r.src) {
+				return "", io.EOF
+			}
+			c = r.src[r.pos]
+		}
+		buf = append(buf, c)
+		r.pos++
+	}
+	return string(buf), nil
+}
+
+// parseNumber converts tok to integer or float Object.
+func parseNumber(tok string) (lisp.Object, bool) {
+	// Integer, possibly with trailing dot: "1.".
+	s := strings.TrimSuffix(tok, ".")
+	if isInteger(s) {
+		x, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return lisp.Nil, false
+		}
+		return lisp.NewInt(x), true
+	}
+
+	switch strings.TrimLeft(tok, "+-") {
+	case "1.0e+INF":
+		if tok[0] == '-' {
+			return lisp.NewFloat(math.Inf(-1)), true
+		}
+		return lisp.NewFloat(math.Inf(1)), true
+	case "0.0e+NaN":
+		return lisp.NewFloat(math.NaN()), true
+	}
+	if !isFloat(tok) {
+		return lisp.Nil, false
+	}
+	x, err := strconv.ParseFloat(tok, 64)
+	if err != nil {
+		return lisp.Nil, false
+	}
+	return lisp.NewFloat(x), true
+}
+
+// isInteger reports whether s matches [+-]?[0-9]+.
+func isInteger(s string) bool {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// isFloat reports whether s matches Emacs float syntax:
+// [+-]?[0-9]*.[0-9]+ or [+-]?[0-9]+(.[0-9]*)?e[+-]?[0-9]+.
+func isFloat(s string) bool {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	mant, exp := s, ""
+	if i := strings.IndexAny(s, "eE"); i != -1 {
+		mant, exp = s[:i], s[i+1:]
+		if !isInteger(exp) {
+			return false
+		}
+	}
+	i := strings.IndexByte(mant, '.')
+	if i == -1 {
+		return exp != "" && isInteger(mant) && mant[0] != '+' && mant[0] != '-'
+	}
+	intPart, frac := mant[:i], mant[i+1:]
+	if strings.Trim(intPart, "0123456789") != "" || strings.Trim(frac, "0123456789") != "" {
+		return false
+	}
+	if exp == "" {
+		return frac != ""
+	}
+	return intPart != "" || frac != ""
+}
+
+// readString reads string literal. Opening quote is already consumed.
+func (r *Reader) readString() (lisp.Object, error) {
+	var buf []byte
+	for {
+		if r.pos == len(r.src) {
+			return lisp.Nil, io.EOF
+		}
+		c := r.src[r.pos]
+		r.pos++
+		switch c {
+		case '"':
+			return lisp.NewString(buf), nil
+		case '\n':
+			r.line++
+		case '\\':
+			if r.pos == len(r.src) {
+				return lisp.Nil, io.EOF
+			}
+			switch r.src[r.pos] {
+			case '\n':
+				// Escaped newline is ignored.
+				r.pos++
+				r.line++
+				continue
+			case ' ':
+				r.pos++
+				continue
+			}
+			ch, err := r.readEscape(false)
+			if err != nil {
+				return lisp.Nil, err
+			}
+			buf = appendRune(buf, ch)
+			continue
+		}
+		buf = append(buf, c)
+	}
+}
+
+// appendRune appends UTF-8 encoded ch to buf.
+// Raw bytes (chars in 0x3FFF80..0x3FFFFF range) are appended as is.
+func appendRune(buf []byte, ch rune) []byte {
+	if ch >= 0x3FFF80 {
+		return append(buf, byte(ch-0x3FFF00))
+	}
+	var tmp [utf8.UTFMax]byte
+	n := utf8.EncodeRune(tmp[:], ch)
+	return append(buf, tmp[:n]...)
+}
+
+// Character modifier bits.
+const (
+	modAlt   = 0x0400000
+	modSuper = 0x0800000
+	modHyper = 0x1000000
+	modShift = 0x2000000
+	modCtrl  = 0x4000000
+	modMeta  = 0x8000000
+)
+
+// readChar reads character literal. Question mark is already consumed.
+func (r *Reader) readChar(top bool) (rune, error) {
+	if r.pos == len(r.src) {
+		return 0, io.EOF
+	}
+	var ch rune
+	if r.src[r.pos] == '\\' {
+		r.pos++
+		var err error
+		if ch, err = r.readEscape(true); err != nil {
+			return 0, err
+		}
+	} else {
+		var size int
+		ch, size = utf8.DecodeRune(r.src[r.pos:])
+		r.pos += size
+		if ch == '\n' {
+			r.line++
+		}
+	}
+	if top && r.pos < len(r.src) && !isDelimiter(r.src[r.pos]) && r.src[r.pos] != '?' {
+		return 0, r.errorf("?")
+	}
+	return ch, nil
+}
+
+// readEscape reads a char after backslash, which is already consumed.
+// Modifier prefixes like \C- are only allowed in char literals.
+func (r *Reader) readEscape(inChar bool) (rune, error) {
+	if r.pos == len(r.src) {
+		return 0, io.EOF
+	}
+	c := r.src[r.pos]
+	r.pos++
+
+	// Modifier keys: \C-x, \M-x and so on.
+	if inChar && r.pos < len(r.src) && r.src[r.pos] == '-' {
+		mod := rune(0)
+		switch c {
+		case 'C':
+			mod = modCtrl
+		case 'M':
+			mod = modMeta
+		case 'S':
+			mod = modShift
+		case 'H':
+			mod = modHyper
+		case 'A':
+			mod = modAlt
+		case 's':
+			mod = modSuper
+		}
+		if mod != 0 {
+			r.pos++
+			ch, err := r.readChar(false)
+			if err != nil {
+				return 0, err
+			}
+			if mod == modCtrl {
+				return ctrlChar(ch), nil
+			}
+			return ch | mod, nil
+		}
+	}
+
+	switch c {
+	case 'a':
+		return 7, nil
+	case 'b':
+		return '\b', nil
+	case 'd':
+		return 127, nil
+	case 'e':
+		return 27, nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'v':
+		return '\v', nil
+	case 's':
+		return ' ', nil
+	case '^':
+		ch, err := r.readChar(false)
+		if err != nil {
+			return 0, err
+		}
+		return ctrlChar(ch), nil
+	case 'x':
+		return r.readHexEscape(-1)
+	case 'u':
+		return r.readHexEscape(4)
+	case 'U':
+		return r.readHexEscape(8)
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		ch := rune(c - '0')
+		for i := 0; i < 2 && r.pos < len(r.src); i++ {
+			d := r.src[r.pos]
+			if d < '0' || d > '7' {
+				break
+			}
+			ch = ch*8 + rune(d-'0')
+			r.pos++
+		}
+		if !inChar && ch >= 0x80 && ch < 0x100 {
+			// Octal escapes denote raw bytes in strings.
+			ch += 0x3FFF00
+		}
+		return ch, nil
+	}
+
+	// Other chars are escaped literally.
+	r.pos--
+	ch, size := utf8.DecodeRune(r.src[r.pos:])
+	r.pos += size
+	if ch == '\n' {
+		r.line++
+	}
+	return ch, nil
+}
+
+// readHexEscape reads hex digits of \x, \u or \U escape.
+// If n is -1, any number of digits is read.
+func (r *Reader) readHexEscape(n int) (rune, error) {
+	start := r.pos
+	for r.pos < len(r.src) && (n == -1 || r.pos-start < n) {
+		c := r.src[r.pos] | 0x20
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			break
+		}
+		r.pos++
+	}
+	digits := string(r.src[start:r.pos])
+	if digits == "" || (n != -1 && len(digits) != n) {
+		return 0, r.errorf("\\" + digits)
+	}
+	x, err := strconv.ParseInt(digits, 16, 32)
+	if err != nil || x > 0x3FFFFF {
+		return 0, r.errorf("\\" + digits)
+	}
+	return rune(x), nil
+}
+
+// ctrlChar applies control modifier to ch.
+func ctrlChar(ch rune) rune {
+	switch {
+	case ch == '?':
+		return 127
+	case ch >= '@' && ch <= '_':
+		return ch - '@'
+	case ch >= 'a' && ch <= 'z':
+		return ch - 'a' + 1
+	default:
+		return ch | modCtrl
+	}
+}
+
+// skipSpace skips whitespace and comments.
+func (r *Reader) skipSpace() {
+	for r.pos < len(r.src) {
+		switch r.src[r.pos] {
+		case '\n':
+			r.line++
+		case ' ', '\t', '\r', '\f':
+		case ';':
+			for r.pos < len(r.src) && r.src[r.pos] != '\n' {
+				r.pos++
+			}
+			continue
+		case '#':
+			if !r.skipDoc() {
+				return
+			}
+			continue
+		default:
+			return
+		}
+		r.pos++
+	}
+}
+
+// isDelimiter reports whether c terminates a symbol or a number.
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\f', '(', ')', '[', ']', '"', ';', '\'', '`', ',':
+		return true
+	}
+	return false
+}
+
+// errorf returns a syntax error for the current line.
+func (r *Reader) errorf(msg string) error {
+	return &Error{Line: r.line, Msg: msg}
+}
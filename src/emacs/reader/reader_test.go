@@ -0,0 +1,211 @@
+package reader
+
+import (
+	"emacs/lisp"
+	"io"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := [...]struct {
+		src  string
+		want string
+	}{
+		// Explicit indexes are useful when locating failed test.
+
+		0:  {"0", "0"},
+		1:  {"-15", "-15"},
+		2:  {"+7", "7"},
+		3:  {"1.", "1"},
+		4:  {"1.5", "1.5"},
+		5:  {".5", "0.5"},
+		6:  {"-2.5e3", "-2500.0"},
+		7:  {"1e3", "1000.0"},
+		8:  {"#x1F", "31"},
+		9:  {"#o17", "15"},
+		10: {"#b101", "5"},
+
+		11: {"foo", "foo"},
+		12: {"1+", "1+"},
+		13: {`foo\ bar`, "foo bar"},
+		14: {`\1`, "1"},
+		15: {"nil", "nil"},
+		16: {"##", "##"},
+		17: {"-", "-"},
+		18: {"1.5.2", "1.5.2"},
+
+		19: {`"abc"`, `"abc"`},
+		20: {`"a\nb\t\"c\\"`, "\"a\nb\t\"c\\\""},
+		21: {`"\x41\u00e9\101"`, `"Aé` + `A"`},
+		22: {`"a\
+b\ c"`, `"abc"`},
+
+		23: {"?a", "97"},
+		24: {`?\n`, "10"},
+		25: {`?\C-a`, "1"},
+		26: {`?\^?`, "127"},
+		27: {`?\M-a`, "134217825"},
+		28: {`?\(`, "40"},
+		29: {`?\s`, "32"},
+		30: {`?\x41`, "65"},
+		31: {"?é", "233"},
+
+		32: {"()", "nil"},
+		33: {"(1 2)", "(1 . (2 . nil))"},
+		34: {"(1 . 2)", "(1 . 2)"},
+		35: {"(a (b) . c)", "(a . ((b . nil) . c))"},
+		36: {"[1 [2] ()]", "[1 [2] nil]"},
+		37: {"'x", "(quote . (x . nil))"},
+		38: {"#'car", "(function . (car . nil))"},
+		39: {"`(a ,b ,@c)", "(` . ((a . ((, . (b . nil)) . ((,@ . (c . nil)) . nil))) . nil))"},
+
+		40: {"  ; comment\n  x ; more", "x"},
+		41: {"(a;comment\nb)", "(a . (b . nil))"},
+		42: {"(a .b)", "(a . (.b . nil))"},
+
+		43: {`"\^Ab"`, "\"\x01b\""},
+		44: {"#@5 skip(a)", "(a . nil)"},
+		45: {"(a #@3\nxy b)", "(a . (b . nil))"},
+		46: {"#$", "nil"},
+	}
+
+	for i, test := range tests {
+		o, err := ReadString(test.src, lisp.NewObarray())
+		if err != nil {
+			t.Errorf("tests[%d]: read %q: %v", i, test.src, err)
+			continue
+		}
+		if have := lisp.ObjectString(o); have != test.want {
+			t.Errorf("tests[%d]: read %q:\nhave: %s\nwant: %s", i, test.src, have, test.want)
+		}
+	}
+}
+
+func TestReadInterning(t *testing.T) {
+	ob := lisp.NewObarray()
+	o, err := ReadString("(foo foo nil #:foo)", ob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xs := []lisp.Object{}
+	for tail := o; !lisp.Null(&tail); tail = tail.Cons().Cdr {
+		xs = append(xs, tail.Cons().Car)
+	}
+	foo := ob.Intern("foo")
+	if !lisp.Eq(&xs[0], &foo) || !lisp.Eq(&xs[1], &foo) {
+		t.Errorf("foo is not interned")
+	}
+	if !lisp.Eq(&xs[2], &lisp.Nil) {
+		t.Errorf("nil is not eq to lisp.Nil")
+	}
+	if lisp.Eq(&xs[3], &foo) {
+		t.Errorf("#:foo is interned")
+	}
+}
+
+func TestReadSequence(t *testing.T) {
+	r := New([]byte("a (b\n c)\n\n d"), lisp.NewObarray())
+	var have []string
+	var lines []int
+	for {
+		line := r.Line()
+		o, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		have = append(have, lisp.ObjectString(o))
+		lines = append(lines, line)
+	}
+	want := []string{"a", "(b . (c . nil))", "d"}
+	wantLines := []int{1, 1, 4}
+	if len(have) != len(want) {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	for i := range want {
+		if have[i] != want[i] || lines[i] != wantLines[i] {
+			t.Errorf("object %d: have %s at line %d, want %s at line %d",
+				i, have[i], lines[i], want[i], wantLines[i])
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err error
+	}{
+		{"(1 2", io.ErrUnexpectedEOF},
+		{`"abc`, io.ErrUnexpectedEOF},
+		{"[1", io.ErrUnexpectedEOF},
+		{"'", io.ErrUnexpectedEOF},
+		{"", io.EOF},
+		{"; only comment", io.EOF},
+		{")", &Error{Line: 1, Msg: ")"}},
+		{"\n]", &Error{Line: 2, Msg: "]"}},
+		{"( . 1)", &Error{Line: 1, Msg: "."}},
+		{"(1 . 2 3)", &Error{Line: 1, Msg: ". in wrong context"}},
+		{"#<buffer>", &Error{Line: 1, Msg: "#"}},
+		{"?ab", &Error{Line: 1, Msg: "?"}},
+		{"#xZZ", &Error{Line: 1, Msg: "integer, radix 16"}},
+		{"#[1 2 3 4]", &Error{Line: 1, Msg: "#["}},
+		{"#@x", &Error{Line: 1, Msg: "#@"}},
+		{"#@00 (1 2", io.EOF},
+	}
+
+	for _, test := range tests {
+		_, err := ReadString(test.src, lisp.NewObarray())
+		want, ok := test.err.(*Error)
+		if !ok {
+			if err != test.err {
+				t.Errorf("read %q: have error %v, want %v", test.src, err, test.err)
+			}
+			continue
+		}
+		have, ok := err.(*Error)
+		if !ok || *have != *want {
+			t.Errorf("read %q: have error %v, want %v", test.src, err, want)
+		}
+	}
+}
+
+func TestReadByteCode(t *testing.T) {
+	r := New([]byte("#[257 \"\\211T\\207\" [] 2] #[1 2] #$"), lisp.NewObarray())
+	r.FileName = "test.elc"
+	var got [][]lisp.Object
+	r.ByteCode = func(elems []lisp.Object) (lisp.Object, error) {
+		got = append(got, elems)
+		return lisp.NewInt(int64(len(elems))), nil
+	}
+
+	o, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := lisp.ObjectString(o); have != "4" {
+		t.Errorf("ByteCode hook result: have %s, want 4", have)
+	}
+	if len(got) != 1 {
+		t.Fatalf("ByteCode hook is called %d times, want 1", len(got))
+	}
+	have := lisp.ObjectString(lisp.NewVector(got[0]))
+	if want := "[257 \"\x89T\x87\" [] 2]"; have != want {
+		t.Errorf("ByteCode elements:\nhave: %s\nwant: %s", have, want)
+	}
+
+	// Too short literal is an error even with a hook.
+	_, err = r.Read()
+	if e, ok := err.(*Error); !ok || e.Msg != "#[" {
+		t.Errorf("read #[1 2]: have error %v, want #[", err)
+	}
+
+	o, err = r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := lisp.ObjectString(o); have != `"test.elc"` {
+		t.Errorf("read #$: have %s, want \"test.elc\"", have)
+	}
+}
@@ -0,0 +1,166 @@
+// Package repl implements an interactive Emacs Lisp
+// read-eval-print loop, similar to Emacs `ielm`.
+//
+// Every complete form that is read is evaluated and its
+// value is printed with prin1. Forms may span several lines:
+// while input is incomplete, continuation prompt is shown.
+//
+// Like in ielm, variables `*`, `**` and `***` hold
+// the last three values.
+//
+// Errors are printed with the backtrace of the calls that
+// were active when the error was signalled; they do not
+// end the session.
+package repl
+
+import (
+	"bufio"
+	"emacs/bcode"
+	"emacs/lisp"
+	"emacs/reader"
+	"fmt"
+	"io"
+)
+
+const (
+	// prompt is printed before a new form.
+	prompt = "ELISP> "
+
+	// contPrompt is printed when the form is incomplete.
+	contPrompt = "  ...> "
+)
+
+// REPL is a read-eval-print loop that
+// evaluates forms in a single Env.
+type REPL struct {
+	// Eval evaluates a single form.
+	// New sets it to env.Eval with lexical binding.
+	Eval func(form lisp.Object) (lisp.Object, error)
+
+	env *bcode.Env
+
+	// ob is used to intern symbols that are read.
+	ob *lisp.Obarray
+
+	// history holds `*`, `**` and `***` symbols.
+	history [3]lisp.Object
+}
+
+// New returns REPL that evaluates forms in env.
+// Symbols are interned into ob.
+func New(env *bcode.Env, ob *lisp.Obarray) *REPL {
+	r := &REPL{
+		Eval: func(form lisp.Object) (lisp.Object, error) {
+			return env.Eval(form, lisp.T)
+		},
+		env: env,
+		ob:  ob,
+		history: [3]lisp.Object{
+			ob.Intern("*"),
+			ob.Intern("**"),
+			ob.Intern("***"),
+		},
+	}
+	for _, sym := range r.history {
+		env.SetSymbolValue(sym, lisp.Nil)
+	}
+	return r
+}
+
+// Run reads input lines from in until EOF and
+// writes prompts, results and errors to out.
+// Returns an error only if reading or writing fails.
+func (r *REPL) Run(in io.Reader, out io.Writer) error {
+	br := bufio.NewReader(in)
+	var input []byte
+	for {
+		p := prompt
+		if len(input) != 0 {
+			p = contPrompt
+		}
+		if _, err := io.WriteString(out, p); err != nil {
+			return err
+		}
+
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) != 0 {
+			input = r.evalInput(out, append(input, line...))
+		}
+		if err == io.EOF {
+			if len(line) == 0 {
+				// Finish the prompt line.
+				fmt.Fprintln(out)
+			}
+			if len(input) != 0 {
+				r.printError(out, "Read error", bcode.NewSignal(bcode.SymEndOfFile))
+			}
+			return nil
+		}
+	}
+}
+
+// evalInput evaluates all complete forms of input.
+// Returns the incomplete form text that remains.
+func (r *REPL) evalInput(w io.Writer, input []byte) []byte {
+	rd := reader.New(input, r.ob)
+	for {
+		offset := rd.Offset()
+		form, err := rd.Read()
+		switch err {
+		case nil:
+			r.evalForm(w, form)
+			continue
+		case io.EOF:
+			return nil
+		case io.ErrUnexpectedEOF:
+			return input[offset:]
+		}
+		if e, ok := err.(*reader.Error); ok {
+			err = bcode.NewSignal(bcode.SymInvalidReadSyntax, lisp.NewString([]byte(e.Msg)))
+		}
+		// The rest of the input is discarded.
+		r.printError(w, "Read error", err)
+		return nil
+	}
+}
+
+// evalForm evaluates form and prints its value.
+func (r *REPL) evalForm(w io.Writer, form lisp.Object) {
+	val, err := r.eval(form)
+	if err != nil {
+		r.printError(w, "Eval error", err)
+		for _, frame := range r.env.Backtrace(err) {
+			fmt.Fprintf(w, "  %s\n", frame)
+		}
+		return
+	}
+	fmt.Fprintln(w, lisp.Prin1String(val))
+
+	// Shift history: *** = **, ** = *, * = val.
+	for i := len(r.history) - 1; i > 0; i-- {
+		prev, _ := r.env.SymbolValue(r.history[i-1])
+		r.env.SetSymbolValue(r.history[i], prev)
+	}
+	r.env.SetSymbolValue(r.history[0], val)
+}
+
+// eval calls r.Eval, converting Go panics to errors,
+// so bugs in evaluated code do not end the session.
+// The state that the panic left in env is reset.
+func (r *REPL) eval(form lisp.Object) (val lisp.Object, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			r.env.Reset()
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return r.Eval(form)
+}
+
+// printError prints err like ielm does.
+func (r *REPL) printError(w io.Writer, kind string, err error) {
+	fmt.Fprintf(w, "*** %s ***  %s\n", kind, bcode.ErrorMessage(err))
+}
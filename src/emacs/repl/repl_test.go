@@ -0,0 +1,128 @@
+package repl
+
+import (
+	"bytes"
+	"emacs/bcode"
+	"emacs/lisp"
+	"strings"
+	"testing"
+)
+
+const testElc = `
+(defalias 'inc #[257 "\211T\207" [] 2])
+(defalias 'inner #[257 "\300\1!\207" [nope] 3])
+(defalias 'outer #[0 "\300\301!\207" [inner 5] 2])
+`
+
+func newTestREPL(t *testing.T) *REPL {
+	master := bcode.NewMasterEnv()
+	env := master.NewEnv(0, 0)
+	ob := lisp.NewObarray()
+	bcode.AddSymbols(ob)
+	master.DefineSubrs(ob)
+	if err := env.Load([]byte(testElc), "test.elc", ob); err != nil {
+		t.Fatal(err)
+	}
+	return New(env, ob)
+}
+
+func TestREPL(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			input: "(inc 1)\n",
+			want:  "ELISP> 2\nELISP> \n",
+		},
+		{
+			input: "",
+			want:  "ELISP> \n",
+		},
+		{
+			input: "'a 'b\n'c",
+			want:  "ELISP> a\nb\nELISP> c\n",
+		},
+		{
+			// History variables.
+			input: "1\n2\n3\n(list * ** ***)\n*\n",
+			want: "ELISP> 1\nELISP> 2\nELISP> 3\n" +
+				"ELISP> (3 2 1)\n" +
+				"ELISP> (3 2 1)\n" +
+				"ELISP> \n",
+		},
+		{
+			// Multi-line input.
+			input: "(inc\n\n  41) (list\n'a ; comment\n)\n",
+			want:  "ELISP>   ...>   ...> 42\n  ...>   ...> (a)\nELISP> \n",
+		},
+		{
+			// Errors do not end the session and keep history.
+			input: "1\n(outer)\nx\n*\n",
+			want: "ELISP> 1\n" +
+				"ELISP> *** Eval error ***  Symbol’s function definition is void: nope\n" +
+				"  inner(5)\n" +
+				"  outer()\n" +
+				"ELISP> *** Eval error ***  Symbol’s value as variable is void: x\n" +
+				"ELISP> 1\n" +
+				"ELISP> \n",
+		},
+		{
+			// Read errors discard the rest of the line.
+			input: ") 1\n2\n",
+			want: "ELISP> *** Read error ***  Invalid read syntax: \")\"\n" +
+				"ELISP> 2\nELISP> \n",
+		},
+		{
+			input: "(inc\n",
+			want:  "ELISP>   ...> \n*** Read error ***  End of file during parsing\n",
+		},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		r := newTestREPL(t)
+		if err := r.Run(strings.NewReader(test.input), &out); err != nil {
+			t.Errorf("%q: %v", test.input, err)
+			continue
+		}
+		if have := out.String(); have != test.want {
+			t.Errorf("%q:\nhave:\n%s\nwant:\n%s", test.input, have, test.want)
+		}
+	}
+}
+
+func TestREPLPanic(t *testing.T) {
+	master := bcode.NewMasterEnv()
+	env := master.NewEnv(0, 0)
+	ob := lisp.NewObarray()
+	bcode.AddSymbols(ob)
+	master.DefineSubrs(ob)
+	master.DefineFunc(ob.Intern("boom"), func() { panic("boom") })
+	r := New(env, ob)
+
+	// A panic inside compiled code leaves bindings,
+	// catch tags and handlers that must not outlive it.
+	input := "(defvar v 'global)\n" +
+		"(defun f () (let ((v 'bound)) (catch 'tag (boom))))\n" +
+		"(byte-compile 'f)\n" +
+		"(f)\n" +
+		"v\n" +
+		"(condition-case nil (throw 'tag 1) (no-catch 'no-catch))\n" +
+		"(catch 'other (throw 'other 2))\n"
+	want := "ELISP> v\n" +
+		"ELISP> f\n" +
+		"ELISP> #<compiled-function>\n" +
+		"ELISP> *** Eval error ***  panic: boom\n" +
+		"ELISP> global\n" +
+		"ELISP> no-catch\n" +
+		"ELISP> 2\n" +
+		"ELISP> \n"
+	var out bytes.Buffer
+	if err := r.Run(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	if have := out.String(); have != want {
+		t.Errorf("have:\n%s\nwant:\n%s", have, want)
+	}
+}